@@ -0,0 +1,285 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package authenticode implements analyzer verifying Authenticode signatures of PE files, both
+// embedded in the files and in catalog files.
+package authenticode
+
+import (
+	"context"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/google/hashr/analyzers/filetype"
+	"github.com/google/hashr/common"
+)
+
+const (
+	// Name contains name of the analyzer.
+	Name = "authenticode"
+	// maxCatalogSize limits the size of catalog files read into memory.
+	maxCatalogSize = 256 << 20
+)
+
+// Analyzer is an instance of Authenticode analyzer.
+type Analyzer struct {
+}
+
+// New returns new Authenticode analyzer instance.
+func New() *Analyzer {
+	return &Analyzer{}
+}
+
+// Name returns analyzer name.
+func (a *Analyzer) Name() string {
+	return Name
+}
+
+// Analyze verifies signatures of PE samples flagged for upload. Besides embedded signatures, the
+// samples are looked up in catalog files (.cat) extracted from the same source. Errors of
+// individual samples are logged.
+func (a *Analyzer) Analyze(ctx context.Context, samples []common.Sample) error {
+	catalogs := NewCatalogs()
+	for _, sample := range samples {
+		samplePath, _, err := sample.FirstValidPath()
+		if err != nil || !strings.EqualFold(filepath.Ext(samplePath), ".cat") {
+			continue
+		}
+		if err := catalogs.Add(samplePath); err != nil {
+			glog.Warningf("Could not parse catalog %s: %v", samplePath, err)
+		}
+	}
+
+	for i := range samples {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !samples[i].Upload {
+			continue
+		}
+
+		samplePath, _, err := samples[i].FirstValidPath()
+		if err != nil {
+			continue
+		}
+
+		signatures, err := VerifyFile(samplePath, catalogs)
+		if err != nil {
+			glog.Warningf("Could not verify Authenticode signatures of %s: %v", samplePath, err)
+			continue
+		}
+		samples[i].Signatures = signatures
+	}
+
+	return nil
+}
+
+// Catalogs holds hashes of files signed by catalog files.
+type Catalogs struct {
+	// members maps digest algorithm name and hex encoded hash, separated by colon, to the catalog
+	// signing the file.
+	members map[string]*catalog
+	algos   map[string]bool
+}
+
+// NewCatalogs returns an empty set of catalogs.
+func NewCatalogs() *Catalogs {
+	return &Catalogs{members: make(map[string]*catalog), algos: make(map[string]bool)}
+}
+
+// Add parses a catalog file and adds its members. Signature of the catalog is recorded with its
+// path relative to the extraction root.
+func (c *Catalogs) Add(catalogPath string) error {
+	fi, err := os.Stat(catalogPath)
+	if err != nil {
+		return err
+	}
+	if fi.Size() > maxCatalogSize {
+		return fmt.Errorf("catalog is too big: %d bytes", fi.Size())
+	}
+
+	der, err := ioutil.ReadFile(catalogPath)
+	if err != nil {
+		return err
+	}
+
+	relPath, _ := common.TrimExtractionRoot(catalogPath)
+	cat, err := parseCatalog(der, relPath)
+	if err != nil {
+		return err
+	}
+
+	for digest, algo := range cat.hashes {
+		key := algo + ":" + digest
+		// Files listed in more than one catalog keep the first one with a valid signature.
+		if existing, ok := c.members[key]; ok && (existing.signature.Verified || !cat.signature.Verified) {
+			continue
+		}
+		c.members[key] = cat
+		c.algos[algo] = true
+	}
+
+	return nil
+}
+
+// lookup returns signatures of catalogs listing a file with given hashes.
+func (c *Catalogs) lookup(hashes map[string][]byte) []*common.Signature {
+	var signatures []*common.Signature
+	for algo, digest := range hashes {
+		cat, ok := c.members[algo+":"+hex.EncodeToString(digest)]
+		if !ok {
+			continue
+		}
+		sig := cat.signature
+		sig.DigestAlgorithm = algo
+		sig.Digest = hex.EncodeToString(digest)
+		signatures = append(signatures, &sig)
+	}
+
+	sort.Slice(signatures, func(i, j int) bool {
+		return signatures[i].DigestAlgorithm < signatures[j].DigestAlgorithm
+	})
+
+	return signatures
+}
+
+// VerifyFile returns Authenticode signatures of a PE file: signatures embedded in the file,
+// including nested ones, followed by signatures of catalogs listing the file. Nil is returned for
+// files that are not signed and for other file types.
+func VerifyFile(filePath string, catalogs *Catalogs) ([]*common.Signature, error) {
+	info, err := filetype.IdentifyFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.Format != "PE32" && info.Format != "PE32+" {
+		return nil, nil
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := parsePE(f, fi.Size())
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing PE file: %v", err)
+	}
+
+	blobs, err := p.signatures()
+	if err != nil {
+		return nil, err
+	}
+
+	var signatures []*common.Signature
+	for _, der := range blobs {
+		signatures = append(signatures, embeddedSignatures(p, der, 0)...)
+	}
+
+	if catalogs != nil && len(catalogs.algos) > 0 {
+		hashes := make(map[string][]byte)
+		for _, algo := range hashAlgorithms {
+			if !catalogs.algos[algo.name] {
+				continue
+			}
+			digest, err := p.hash(algo.hash)
+			if err != nil {
+				return nil, err
+			}
+			hashes[algo.name] = digest
+		}
+		signatures = append(signatures, catalogs.lookup(hashes)...)
+	}
+
+	return signatures, nil
+}
+
+// embeddedSignatures verifies a signature stored in the certificate table and signatures nested
+// in it.
+func embeddedSignatures(p *peFile, der []byte, depth int) []*common.Signature {
+	sig := &common.Signature{}
+	signatures := []*common.Signature{sig}
+
+	sd, err := parseSignedData(der)
+	if err != nil {
+		sig.Error = err.Error()
+		return signatures
+	}
+	if !sd.ContentInfo.ContentType.Equal(oidSpcIndirectData) {
+		sig.Error = fmt.Sprintf("unexpected content type: %v", sd.ContentInfo.ContentType)
+		return signatures
+	}
+
+	if depth < maxNestingDepth {
+		for _, nested := range nestedSignatures(sd) {
+			signatures = append(signatures, embeddedSignatures(p, nested, depth+1)...)
+		}
+	}
+
+	content, err := sd.ContentInfo.content()
+	if err != nil {
+		sig.Error = err.Error()
+		return signatures
+	}
+	var idc spcIndirectDataContent
+	if _, err := asn1.Unmarshal(content.FullBytes, &idc); err != nil {
+		sig.Error = fmt.Sprintf("could not parse indirect data: %v", err)
+		return signatures
+	}
+	sig.Digest = hex.EncodeToString(idc.MessageDigest.Digest)
+
+	verified := verify(sd, content.Bytes)
+	sig.Certificates = certificates(verified.chain)
+	if !verified.signingTime.IsZero() {
+		sig.SigningTime = verified.signingTime.Unix()
+	}
+
+	hash, name, err := hashAlgorithm(idc.MessageDigest.Algorithm.Algorithm)
+	if err != nil {
+		sig.Error = err.Error()
+		return signatures
+	}
+	sig.DigestAlgorithm = name
+
+	digest, err := p.hash(hash)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("could not hash file: %v", err)
+	case hex.EncodeToString(digest) != sig.Digest:
+		err = errors.New("signed digest does not match the file")
+	default:
+		err = verified.err
+	}
+	if err != nil {
+		sig.Error = err.Error()
+		return signatures
+	}
+	sig.Verified = true
+
+	return signatures
+}
@@ -0,0 +1,170 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authenticode
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/google/hashr/common"
+)
+
+// signed.exe is ev-signed-file.exe from golang.org/x/sys/windows testdata, signed with an EV
+// certificate and timestamped using RFC 3161 timestamp.
+var signedExeSignature = &common.Signature{
+	DigestAlgorithm: "sha256",
+	Digest:          "338540aca4a45d4a9951a2b20a87d30d332945988fd5fac8f5af5aac6297e5d7",
+	Verified:        true,
+	SigningTime:     1637687066,
+	Certificates: []*common.Certificate{
+		{
+			Subject:      "SERIALNUMBER=4227913,CN=WireGuard LLC,O=WireGuard LLC,L=Boulder,ST=Colorado,C=US,2.5.4.15=Private Organization,1.3.6.1.4.1.311.60.2.1.2=Ohio,1.3.6.1.4.1.311.60.2.1.3=US",
+			Issuer:       "CN=DigiCert EV Code Signing CA (SHA2),OU=www.digicert.com,O=DigiCert Inc,C=US",
+			SerialNumber: "0663d5fca728882f36ff1bdf5d85f0ba",
+			NotBefore:    1544400000,
+			NotAfter:     1639483200,
+			Sha256:       "c9e1b3127c2f1312056d49a93ac4bd700393fd323d2bf3b2235aff52bea8d136",
+		},
+		{
+			Subject:      "CN=DigiCert EV Code Signing CA (SHA2),OU=www.digicert.com,O=DigiCert Inc,C=US",
+			Issuer:       "CN=DigiCert High Assurance EV Root CA,OU=www.digicert.com,O=DigiCert Inc,C=US",
+			SerialNumber: "03f1b4e15f3a82f1149678b3d7d8475c",
+			NotBefore:    1334750400,
+			NotAfter:     1808049600,
+			Sha256:       "c7460b0edda1b44c8e2164b234ebecc3962a6a37a936b74a6e7d46682938f084",
+		},
+	},
+}
+
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyFile(t *testing.T) {
+	tampered := filepath.Join(t.TempDir(), "tampered.exe")
+	copyFile(t, "testdata/signed.exe", tampered)
+	data, err := os.ReadFile(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Modify the code of the signed file.
+	data[0x400] ^= 0xff
+	if err := os.WriteFile(tampered, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tamperedSignature := *signedExeSignature
+	tamperedSignature.Verified = false
+	tamperedSignature.Error = "signed digest does not match the file"
+
+	for _, tc := range []struct {
+		path string
+		want []*common.Signature
+	}{
+		{
+			path: "testdata/signed.exe",
+			want: []*common.Signature{signedExeSignature},
+		},
+		{
+			path: tampered,
+			want: []*common.Signature{&tamperedSignature},
+		},
+		{
+			// Catalog signed file without catalogs.
+			path: "testdata/hello.dll",
+			want: nil,
+		},
+		{
+			path: "testdata/hello.cat",
+			want: nil,
+		},
+	} {
+		t.Run(filepath.Base(tc.path), func(t *testing.T) {
+			got, err := VerifyFile(tc.path, nil)
+			if err != nil {
+				t.Fatalf("unexpected error while running VerifyFile(): %v", err)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("VerifyFile() unexpected diff (-want/+got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "extracted")
+	catalogPath := filepath.Join(root, "Windows/System32/CatRoot/hello.cat")
+	dllPath := filepath.Join(root, "Windows/System32/hello.dll")
+	exePath := filepath.Join(root, "Program Files/signed.exe")
+	copyFile(t, "testdata/hello.cat", catalogPath)
+	copyFile(t, "testdata/hello.dll", dllPath)
+	copyFile(t, "testdata/signed.exe", exePath)
+
+	samples := []common.Sample{
+		// Catalog was already exported, but it's still used to verify other samples.
+		{Sha256: "aaaa", Paths: []string{catalogPath}},
+		{Sha256: "bbbb", Paths: []string{dllPath}, Upload: true},
+		{Sha256: "cccc", Paths: []string{exePath}, Upload: true},
+	}
+
+	if err := New().Analyze(context.Background(), samples); err != nil {
+		t.Fatalf("unexpected error while running Analyze(): %v", err)
+	}
+
+	// Certificates of the catalog were generated together with the fixture, only their subjects
+	// are checked.
+	catalogSignature := common.Signature{
+		Catalog:     "Windows/System32/CatRoot/hello.cat",
+		Verified:    true,
+		SigningTime: 1641092645,
+		Certificates: []*common.Certificate{
+			{Subject: "CN=HashR Test Catalog Signer,O=Example Corp.", Issuer: "CN=HashR Test Root CA"},
+			{Subject: "CN=HashR Test Root CA", Issuer: "CN=HashR Test Root CA"},
+		},
+	}
+	sha1Signature := catalogSignature
+	sha1Signature.DigestAlgorithm = "sha1"
+	sha1Signature.Digest = "a69bab90cf7d033a5a5f4e2e547b5351bf93f546"
+	sha256Signature := catalogSignature
+	sha256Signature.DigestAlgorithm = "sha256"
+	sha256Signature.Digest = "46861b100c90416a5ebfd1f2b9f57bb3a84dff3cdb9585c008fade31eacf9e00"
+
+	opts := cmpopts.IgnoreFields(common.Certificate{}, "SerialNumber", "NotBefore", "NotAfter", "Sha256")
+	if samples[0].Signatures != nil {
+		t.Errorf("Analyze() verified sample that is not flagged for upload: %+v", samples[0].Signatures)
+	}
+	if diff := cmp.Diff([]*common.Signature{&sha1Signature, &sha256Signature}, samples[1].Signatures, opts); diff != "" {
+		t.Errorf("Analyze() unexpected catalog signatures (-want/+got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]*common.Signature{signedExeSignature}, samples[2].Signatures); diff != "" {
+		t.Errorf("Analyze() unexpected embedded signatures (-want/+got):\n%s", diff)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authenticode
+
+import (
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/google/hashr/common"
+)
+
+// catalog holds the signature of a catalog file and hashes of the files it signs.
+type catalog struct {
+	// signature is shared by all member files, without their digest.
+	signature common.Signature
+	// hashes maps hex encoded Authenticode hashes of member files to the name of the digest
+	// algorithm.
+	hashes map[string]string
+}
+
+type trustedSubject struct {
+	SubjectIdentifier []byte
+	Attributes        []attribute `asn1:"set,optional"`
+}
+
+// parseCatalog parses a catalog file, which is a PKCS#7 signed certificate trust list (CTL) with
+// an entry for every member file.
+func parseCatalog(der []byte, path string) (*catalog, error) {
+	sd, err := parseSignedData(der)
+	if err != nil {
+		return nil, err
+	}
+	if !sd.ContentInfo.ContentType.Equal(oidCTL) {
+		return nil, fmt.Errorf("unexpected content type: %v", sd.ContentInfo.ContentType)
+	}
+
+	ctl, err := sd.ContentInfo.content()
+	if err != nil {
+		return nil, err
+	}
+	subjects, err := trustedSubjects(ctl.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &catalog{hashes: make(map[string]string)}
+	for _, subject := range subjects {
+		algo, digest, ok := subjectHash(subject)
+		if ok {
+			c.hashes[hex.EncodeToString(digest)] = algo
+		}
+	}
+
+	sig := verify(sd, ctl.Bytes)
+	c.signature = common.Signature{Catalog: path, Verified: sig.err == nil, Certificates: certificates(sig.chain)}
+	if sig.err != nil {
+		c.signature.Error = sig.err.Error()
+	}
+	if !sig.signingTime.IsZero() {
+		c.signature.SigningTime = sig.signingTime.Unix()
+	}
+
+	return c, nil
+}
+
+// trustedSubjects returns entries of a CTL. CTL starts with optional fields that don't have
+// distinct tags, so elements are counted instead: trusted subjects are the third SEQUENCE, after
+// subject usage and subject algorithm.
+func trustedSubjects(ctl []byte) ([]trustedSubject, error) {
+	sequences := 0
+	for rest := ctl; len(rest) > 0; {
+		var element asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &element)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse certificate trust list: %v", err)
+		}
+		if element.Class != asn1.ClassUniversal || element.Tag != asn1.TagSequence {
+			continue
+		}
+
+		sequences++
+		if sequences < 3 {
+			continue
+		}
+
+		var subjects []trustedSubject
+		for entries := element.Bytes; len(entries) > 0; {
+			var subject trustedSubject
+			entries, err = asn1.Unmarshal(entries, &subject)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse catalog entry: %v", err)
+			}
+			subjects = append(subjects, subject)
+		}
+		return subjects, nil
+	}
+
+	return nil, nil
+}
+
+// subjectHash returns the hash of a catalog member. It's taken from the indirect data attribute
+// if present, otherwise from the subject identifier, which holds the hash either as raw bytes or
+// as a hex encoded UTF-16 string.
+func subjectHash(subject trustedSubject) (string, []byte, bool) {
+	if values := findAttribute(subject.Attributes, oidSpcIndirectData); len(values) > 0 {
+		var idc spcIndirectDataContent
+		if _, err := asn1.Unmarshal(values[0].FullBytes, &idc); err == nil {
+			if _, name, err := hashAlgorithm(idc.MessageDigest.Algorithm.Algorithm); err == nil {
+				return name, idc.MessageDigest.Digest, true
+			}
+		}
+	}
+
+	id := subject.SubjectIdentifier
+	if len(id)%2 == 0 && len(id) > 0 {
+		chars := make([]uint16, 0, len(id)/2)
+		for i := 0; i < len(id); i += 2 {
+			chars = append(chars, uint16(id[i])|uint16(id[i+1])<<8)
+		}
+		s := strings.TrimRight(string(utf16.Decode(chars)), "\x00")
+		if digest, err := hex.DecodeString(s); err == nil {
+			id = digest
+		}
+	}
+
+	switch len(id) {
+	case 20:
+		return "sha1", id, true
+	case 32:
+		return "sha256", id, true
+	}
+
+	return "", nil, false
+}
@@ -0,0 +1,150 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authenticode
+
+import (
+	"crypto"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// winCertTypePKCSSignedData is the type of WIN_CERTIFICATE entries holding PKCS#7 signed data.
+	winCertTypePKCSSignedData = 2
+	// maxCertTableSize limits the size of the certificate table read into memory.
+	maxCertTableSize = 64 << 20
+)
+
+// peFile holds the locations needed to calculate the Authenticode hash of a PE file.
+type peFile struct {
+	r    io.ReaderAt
+	size int64
+	// checksumOffset and certDirOffset are file offsets of the CheckSum field and of the
+	// certificate table entry of the data directory, both are excluded from the hash.
+	checksumOffset int64
+	certDirOffset  int64
+	// certTable is the location of the certificate table, which is not hashed either.
+	certTable pe.DataDirectory
+}
+
+func parsePE(r io.ReaderAt, size int64) (*peFile, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lfanew [4]byte
+	if _, err := r.ReadAt(lfanew[:], 0x3c); err != nil {
+		return nil, err
+	}
+	// Optional header follows the PE signature and the file header.
+	optionalHeader := int64(binary.LittleEndian.Uint32(lfanew[:])) + 4 + 20
+
+	p := &peFile{r: r, size: size, checksumOffset: optionalHeader + 64}
+	var dirs []pe.DataDirectory
+	var dirCount uint32
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		p.certDirOffset = optionalHeader + 96 + pe.IMAGE_DIRECTORY_ENTRY_SECURITY*8
+		dirs, dirCount = oh.DataDirectory[:], oh.NumberOfRvaAndSizes
+	case *pe.OptionalHeader64:
+		p.certDirOffset = optionalHeader + 112 + pe.IMAGE_DIRECTORY_ENTRY_SECURITY*8
+		dirs, dirCount = oh.DataDirectory[:], oh.NumberOfRvaAndSizes
+	default:
+		return nil, fmt.Errorf("missing optional header")
+	}
+
+	if dirCount > pe.IMAGE_DIRECTORY_ENTRY_SECURITY {
+		p.certTable = dirs[pe.IMAGE_DIRECTORY_ENTRY_SECURITY]
+	}
+	if p.certTable.Size == 0 {
+		p.certTable = pe.DataDirectory{}
+	}
+	// Certificate table holds a file offset, not a relative virtual address.
+	if int64(p.certTable.VirtualAddress)+int64(p.certTable.Size) > size {
+		return nil, fmt.Errorf("certificate table at 0x%x is outside of the file", p.certTable.VirtualAddress)
+	}
+
+	return p, nil
+}
+
+// signatures returns PKCS#7 signed data stored in the certificate table.
+func (p *peFile) signatures() ([][]byte, error) {
+	if p.certTable.Size == 0 {
+		return nil, nil
+	}
+	if p.certTable.Size > maxCertTableSize {
+		return nil, fmt.Errorf("certificate table is too big: %d bytes", p.certTable.Size)
+	}
+
+	table := make([]byte, p.certTable.Size)
+	if _, err := p.r.ReadAt(table, int64(p.certTable.VirtualAddress)); err != nil {
+		return nil, fmt.Errorf("could not read certificate table: %v", err)
+	}
+
+	var signatures [][]byte
+	// Each WIN_CERTIFICATE starts with its length, revision and type. Entries are 8-byte aligned.
+	for len(table) >= 8 {
+		length := binary.LittleEndian.Uint32(table)
+		certType := binary.LittleEndian.Uint16(table[6:])
+		if length < 8 || int64(length) > int64(len(table)) {
+			return nil, fmt.Errorf("invalid certificate table entry length: %d", length)
+		}
+		if certType == winCertTypePKCSSignedData {
+			signatures = append(signatures, table[8:length])
+		}
+
+		next := (int64(length) + 7) &^ 7
+		if next >= int64(len(table)) {
+			break
+		}
+		table = table[next:]
+	}
+
+	return signatures, nil
+}
+
+// hash returns the Authenticode hash of the file, which covers the whole file except the checksum,
+// the certificate table entry of the data directory and the certificate table itself.
+func (p *peFile) hash(hash crypto.Hash) ([]byte, error) {
+	h := hash.New()
+
+	end := p.size
+	if p.certTable.Size > 0 {
+		end = int64(p.certTable.VirtualAddress)
+	}
+	ranges := [][2]int64{
+		{0, p.checksumOffset},
+		{p.checksumOffset + 4, p.certDirOffset},
+		{p.certDirOffset + 8, end},
+	}
+	if p.certTable.Size > 0 {
+		ranges = append(ranges, [2]int64{end + int64(p.certTable.Size), p.size})
+	}
+
+	for _, rng := range ranges {
+		if rng[1] <= rng[0] {
+			continue
+		}
+		if _, err := io.Copy(h, io.NewSectionReader(p.r, rng[0], rng[1]-rng[0])); err != nil {
+			return nil, err
+		}
+	}
+
+	return h.Sum(nil), nil
+}
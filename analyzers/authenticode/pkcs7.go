@@ -0,0 +1,409 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authenticode
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	// Hash functions used by Authenticode signatures need to be linked in.
+	_ "crypto/md5"
+	_ "crypto/sha1"
+	_ "crypto/sha512"
+
+	"github.com/google/hashr/common"
+)
+
+var (
+	oidSignedData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidSpcIndirectData  = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+	oidCTL              = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 10, 1}
+	oidTSTInfo          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidMessageDigest    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidCounterSignature = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 6}
+	oidRFC3161Timestamp = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 3, 3, 1}
+	oidNestedSignature  = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 4, 1}
+	oidMD5              = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 5}
+	oidSHA1             = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+// maxNestingDepth limits the depth of nested signatures.
+const maxNestingDepth = 4
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	// Content is explicitly tagged, RawValue holds the tagged element.
+	Content asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// content returns the element wrapped in the explicitly tagged content.
+func (ci contentInfo) content() (asn1.RawValue, error) {
+	var content asn1.RawValue
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &content); err != nil {
+		return asn1.RawValue{}, fmt.Errorf("could not parse content: %v", err)
+	}
+
+	return content, nil
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerialNumber
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type spcIndirectDataContent struct {
+	Data          asn1.RawValue
+	MessageDigest digestInfo
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint digestInfo
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+}
+
+// hashAlgorithms maps digest algorithm OIDs to hash functions and their names.
+var hashAlgorithms = []struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+	name string
+}{
+	{oidMD5, crypto.MD5, "md5"},
+	{oidSHA1, crypto.SHA1, "sha1"},
+	{oidSHA256, crypto.SHA256, "sha256"},
+	{oidSHA384, crypto.SHA384, "sha384"},
+	{oidSHA512, crypto.SHA512, "sha512"},
+}
+
+func hashAlgorithm(oid asn1.ObjectIdentifier) (crypto.Hash, string, error) {
+	for _, algo := range hashAlgorithms {
+		if algo.oid.Equal(oid) {
+			return algo.hash, algo.name, nil
+		}
+	}
+
+	return 0, "", fmt.Errorf("unsupported digest algorithm: %v", oid)
+}
+
+// parseSignedData parses PKCS#7 ContentInfo holding SignedData.
+func parseSignedData(der []byte) (*signedData, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, fmt.Errorf("could not parse content info: %v", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("unexpected content type: %v", ci.ContentType)
+	}
+
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("could not parse signed data: %v", err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, fmt.Errorf("signed data has %d signers, want 1", len(sd.SignerInfos))
+	}
+
+	return &sd, nil
+}
+
+// parseAttributes parses a SET OF Attribute, tagged implicitly.
+func parseAttributes(raw asn1.RawValue) ([]attribute, error) {
+	var attrs []attribute
+	for rest := raw.Bytes; len(rest) > 0; {
+		var attr attribute
+		var err error
+		rest, err = asn1.Unmarshal(rest, &attr)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse attribute: %v", err)
+		}
+		attrs = append(attrs, attr)
+	}
+
+	return attrs, nil
+}
+
+func findAttribute(attrs []attribute, oid asn1.ObjectIdentifier) []asn1.RawValue {
+	for _, attr := range attrs {
+		if attr.Type.Equal(oid) {
+			return attr.Values
+		}
+	}
+
+	return nil
+}
+
+// signature is a verified (or not) PKCS#7 signature of some content.
+type signature struct {
+	signer      *x509.Certificate
+	chain       []*x509.Certificate
+	signingTime time.Time
+	err         error
+}
+
+// verify checks the signature of the single signer of sd over content, which is the content of
+// the encapsulated SEQUENCE (without its tag and length). Failed checks are recorded in err of the
+// returned signature, other fields are set whenever possible.
+func verify(sd *signedData, content []byte) *signature {
+	sig := &signature{}
+	si := sd.SignerInfos[0]
+
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		sig.err = fmt.Errorf("could not parse certificates: %v", err)
+		return sig
+	}
+
+	for _, cert := range certs {
+		if cert.SerialNumber.Cmp(si.IssuerAndSerialNumber.SerialNumber) == 0 && bytes.Equal(cert.RawIssuer, si.IssuerAndSerialNumber.Issuer.FullBytes) {
+			sig.signer = cert
+			break
+		}
+	}
+	if sig.signer == nil {
+		sig.err = errors.New("signer certificate not found")
+		return sig
+	}
+
+	sig.chain, err = buildChain(sig.signer, certs)
+	if err != nil {
+		sig.err = err
+	}
+
+	attrs, err := parseAttributes(si.AuthenticatedAttributes)
+	if err != nil {
+		sig.err = err
+		return sig
+	}
+	if values := findAttribute(attrs, oidSigningTime); len(values) > 0 {
+		var t time.Time
+		if _, err := asn1.Unmarshal(values[0].FullBytes, &t); err == nil {
+			sig.signingTime = t
+		}
+	}
+	if ts, ok := timestamp(si); ok {
+		sig.signingTime = ts
+	}
+
+	if err := verifySigner(si, sig.signer, attrs, content); err != nil {
+		sig.err = err
+	}
+
+	return sig
+}
+
+// verifySigner checks that the message digest attribute matches the content and that the
+// signature of authenticated attributes was made by the signer certificate.
+func verifySigner(si signerInfo, signer *x509.Certificate, attrs []attribute, content []byte) error {
+	hash, _, err := hashAlgorithm(si.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	values := findAttribute(attrs, oidMessageDigest)
+	if len(values) == 0 {
+		return errors.New("message digest attribute not found")
+	}
+	var messageDigest []byte
+	if _, err := asn1.Unmarshal(values[0].FullBytes, &messageDigest); err != nil {
+		return fmt.Errorf("could not parse message digest: %v", err)
+	}
+
+	h := hash.New()
+	h.Write(content)
+	if !bytes.Equal(h.Sum(nil), messageDigest) {
+		return errors.New("message digest does not match signed content")
+	}
+
+	// Signature is calculated over DER encoding of attributes, which uses SET tag instead of the
+	// implicit [0] tag.
+	signed := append([]byte{0x31}, si.AuthenticatedAttributes.FullBytes[1:]...)
+	h = hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch pub := signer.PublicKey.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(pub, hash, digest, si.EncryptedDigest)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, si.EncryptedDigest) {
+			err = errors.New("ECDSA verification failure")
+		}
+	default:
+		err = fmt.Errorf("unsupported public key type %T", pub)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+
+	return nil
+}
+
+// buildChain returns the chain of certificates from cert to the last issuer present in certs. An
+// error is returned if one of the certificates was not signed by its issuer.
+func buildChain(cert *x509.Certificate, certs []*x509.Certificate) ([]*x509.Certificate, error) {
+	chain := []*x509.Certificate{cert}
+	for len(chain) <= len(certs) {
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			break
+		}
+
+		var issuer *x509.Certificate
+		for _, c := range certs {
+			if bytes.Equal(c.RawSubject, cert.RawIssuer) && c != cert {
+				issuer = c
+				break
+			}
+		}
+		if issuer == nil {
+			break
+		}
+
+		// CheckSignatureFrom refuses SHA1 signatures, which are still used by older chains.
+		if err := issuer.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+			return chain, fmt.Errorf("certificate %q was not signed by its issuer: %v", cert.Subject, err)
+		}
+		chain = append(chain, issuer)
+		cert = issuer
+	}
+
+	return chain, nil
+}
+
+// timestamp returns the signing time from the timestamp countersignature of a signer. The
+// countersignature itself is not verified.
+func timestamp(si signerInfo) (time.Time, bool) {
+	attrs, err := parseAttributes(si.UnauthenticatedAttributes)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	// RFC 3161 timestamps are stored as signed data holding TSTInfo.
+	if values := findAttribute(attrs, oidRFC3161Timestamp); len(values) > 0 {
+		sd, err := parseSignedData(values[0].FullBytes)
+		if err != nil || !sd.ContentInfo.ContentType.Equal(oidTSTInfo) {
+			return time.Time{}, false
+		}
+		// TSTInfo is DER encoded in an OCTET STRING.
+		content, err := sd.ContentInfo.content()
+		if err != nil {
+			return time.Time{}, false
+		}
+		var info tstInfo
+		if _, err := asn1.Unmarshal(content.Bytes, &info); err != nil {
+			return time.Time{}, false
+		}
+		return info.GenTime, true
+	}
+
+	// Legacy timestamps are countersignatures holding the signing-time attribute.
+	if values := findAttribute(attrs, oidCounterSignature); len(values) > 0 {
+		var cs signerInfo
+		if _, err := asn1.Unmarshal(values[0].FullBytes, &cs); err != nil {
+			return time.Time{}, false
+		}
+		csAttrs, err := parseAttributes(cs.AuthenticatedAttributes)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if values := findAttribute(csAttrs, oidSigningTime); len(values) > 0 {
+			var t time.Time
+			if _, err := asn1.Unmarshal(values[0].FullBytes, &t); err == nil {
+				return t, true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// nestedSignatures returns signed data of signatures nested in the unauthenticated attributes of
+// a signer, used e.g. for dual SHA1 and SHA256 signing.
+func nestedSignatures(sd *signedData) [][]byte {
+	attrs, err := parseAttributes(sd.SignerInfos[0].UnauthenticatedAttributes)
+	if err != nil {
+		return nil
+	}
+
+	var nested [][]byte
+	for _, value := range findAttribute(attrs, oidNestedSignature) {
+		nested = append(nested, value.FullBytes)
+	}
+
+	return nested
+}
+
+// certificates converts a certificate chain to its common representation.
+func certificates(chain []*x509.Certificate) []*common.Certificate {
+	var certs []*common.Certificate
+	for _, cert := range chain {
+		fingerprint := sha256.Sum256(cert.Raw)
+		certs = append(certs, &common.Certificate{
+			Subject:      cert.Subject.String(),
+			Issuer:       cert.Issuer.String(),
+			SerialNumber: hex.EncodeToString(cert.SerialNumber.Bytes()),
+			NotBefore:    cert.NotBefore.Unix(),
+			NotAfter:     cert.NotAfter.Unix(),
+			Sha256:       hex.EncodeToString(fingerprint[:]),
+		})
+	}
+
+	return certs
+}
@@ -0,0 +1,110 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executable
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+
+	"github.com/google/hashr/common"
+)
+
+// ntGNUBuildID is the type of the note holding GNU build ID.
+const ntGNUBuildID = 3
+
+func elfMetadata(r io.ReaderAt) (*common.ExecutableMetadata, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	md := &common.ExecutableMetadata{Stripped: f.Section(".symtab") == nil}
+	md.BuildID = elfBuildID(f)
+
+	// Files without dynamic section (e.g. static executables or object files) return an error.
+	if sonames, err := f.DynString(elf.DT_SONAME); err == nil && len(sonames) > 0 {
+		md.Soname = sonames[0]
+	}
+	if needed, err := f.ImportedLibraries(); err == nil {
+		md.Needed = needed
+	}
+
+	return md, nil
+}
+
+// elfBuildID returns GNU build ID stored in note sections or, if section headers were stripped,
+// note segments.
+func elfBuildID(f *elf.File) string {
+	var notes [][]byte
+	for _, s := range f.Sections {
+		if s.Type != elf.SHT_NOTE {
+			continue
+		}
+		if data, err := s.Data(); err == nil {
+			notes = append(notes, data)
+		}
+	}
+	if len(notes) == 0 {
+		for _, p := range f.Progs {
+			if p.Type != elf.PT_NOTE {
+				continue
+			}
+			if data, err := io.ReadAll(p.Open()); err == nil {
+				notes = append(notes, data)
+			}
+		}
+	}
+
+	for _, data := range notes {
+		if id := noteBuildID(data, f.ByteOrder); id != "" {
+			return id
+		}
+	}
+
+	return ""
+}
+
+// noteBuildID parses ELF notes and returns the content of NT_GNU_BUILD_ID note.
+func noteBuildID(data []byte, order binary.ByteOrder) string {
+	align := func(n uint32) int { return int((n + 3) &^ 3) }
+
+	for len(data) >= 12 {
+		nameSize, descSize, noteType := order.Uint32(data), order.Uint32(data[4:]), order.Uint32(data[8:])
+		data = data[12:]
+		if align(nameSize) > len(data) {
+			return ""
+		}
+		name := data[:nameSize]
+		data = data[align(nameSize):]
+		if align(descSize) > len(data) && int(descSize) > len(data) {
+			return ""
+		}
+		desc := data[:descSize]
+		if align(descSize) <= len(data) {
+			data = data[align(descSize):]
+		} else {
+			data = nil
+		}
+
+		if noteType == ntGNUBuildID && string(name) == "GNU\x00" {
+			return hex.EncodeToString(desc)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,110 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package executable implements analyzer extracting metadata of ELF, PE and Mach-O executables.
+package executable
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/golang/glog"
+
+	"github.com/google/hashr/analyzers/filetype"
+	"github.com/google/hashr/common"
+)
+
+const (
+	// Name contains name of the analyzer.
+	Name = "executable"
+)
+
+// Analyzer is an instance of executable analyzer.
+type Analyzer struct {
+}
+
+// New returns new executable analyzer instance.
+func New() *Analyzer {
+	return &Analyzer{}
+}
+
+// Name returns analyzer name.
+func (a *Analyzer) Name() string {
+	return Name
+}
+
+// Analyze extracts metadata of executable samples flagged for upload, samples that were already
+// exported are skipped. Errors of individual samples are logged.
+func (a *Analyzer) Analyze(ctx context.Context, samples []common.Sample) error {
+	for i := range samples {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !samples[i].Upload {
+			continue
+		}
+
+		samplePath, _, err := samples[i].FirstValidPath()
+		if err != nil {
+			continue
+		}
+
+		md, err := Extract(samplePath)
+		if err != nil {
+			glog.Warningf("Could not extract executable metadata of %s: %v", samplePath, err)
+			continue
+		}
+		samples[i].Executable = md
+	}
+
+	return nil
+}
+
+// Extract returns metadata of an executable file, nil is returned for other files.
+func Extract(filePath string) (*common.ExecutableMetadata, error) {
+	info, err := filetype.IdentifyFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var md *common.ExecutableMetadata
+	switch info.Format {
+	case "ELF":
+		md, err = elfMetadata(f)
+	case "PE32", "PE32+":
+		md, err = peMetadata(f)
+	case "Mach-O":
+		md, err = machoMetadata(f)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing %s file: %v", info.Format, err)
+	}
+
+	md.Format = info.Format
+	md.Bits = info.Bits
+	if len(md.Archs) == 0 && info.Arch != "" {
+		md.Archs = []string{info.Arch}
+	}
+
+	return md, nil
+}
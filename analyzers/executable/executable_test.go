@@ -0,0 +1,145 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executable
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/hashr/common"
+)
+
+func TestExtract(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		want *common.ExecutableMetadata
+	}{
+		{
+			path: "testdata/libhello.so",
+			want: &common.ExecutableMetadata{
+				Format:  "ELF",
+				Archs:   []string{"x86-64"},
+				Bits:    64,
+				BuildID: "0afdf8f940af810eab52ea57f5a61fa2489ed833",
+				Soname:  "libhello.so.1",
+				Needed:  []string{"libm.so.6"},
+			},
+		},
+		{
+			path: "testdata/libhello_stripped.so",
+			want: &common.ExecutableMetadata{
+				Format:   "ELF",
+				Archs:    []string{"x86-64"},
+				Bits:     64,
+				BuildID:  "0afdf8f940af810eab52ea57f5a61fa2489ed833",
+				Soname:   "libhello.so.1",
+				Needed:   []string{"libm.so.6"},
+				Stripped: true,
+			},
+		},
+		{
+			path: "testdata/hello.dll",
+			want: &common.ExecutableMetadata{
+				Format:           "PE32+",
+				Archs:            []string{"x86-64"},
+				Bits:             64,
+				CompileTimestamp: 1600000000,
+				Imphash:          "f27781d485fb284685652745f785f57f",
+				VersionInfo: map[string]string{
+					"CompanyName":      "Example Corp.",
+					"FileDescription":  "Hello library",
+					"FileVersion":      "1.2.3.4",
+					"InternalName":     "hello",
+					"OriginalFilename": "hello.dll",
+					"ProductName":      "Hello",
+					"ProductVersion":   "1.2.3",
+				},
+			},
+		},
+		{
+			path: "testdata/hello_arm64",
+			want: &common.ExecutableMetadata{
+				Format: "Mach-O",
+				Archs:  []string{"arm64"},
+				Bits:   64,
+				UUIDs:  []string{"01234567-89AB-CDEF-0123-456789ABCDEF"},
+			},
+		},
+		{
+			path: "testdata/hello_universal",
+			want: &common.ExecutableMetadata{
+				Format: "Mach-O",
+				Archs:  []string{"x86-64", "arm64"},
+				Bits:   64,
+				UUIDs:  []string{"FEDCBA98-7654-3210-FEDC-BA9876543210", "01234567-89AB-CDEF-0123-456789ABCDEF"},
+			},
+		},
+		{
+			path: "testdata/hello.txt",
+			want: nil,
+		},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			got, err := Extract(tc.path)
+			if err != nil {
+				t.Fatalf("unexpected error while running Extract(): %v", err)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Extract() unexpected diff (-want/+got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestImphash(t *testing.T) {
+	// Functions imported by ordinal from ws2_32.dll, wsock32.dll and oleaut32.dll are named the
+	// way pefile names them, expected hash is pefile's get_imphash() of these imports.
+	imports := []peImport{
+		{dll: "KERNEL32.dll", name: "ExitProcess"},
+		{dll: "WS2_32.dll", ordinal: 115, byOrdinal: true},
+		{dll: "WS2_32.dll", ordinal: 1000, byOrdinal: true},
+		{dll: "WSOCK32.dll", ordinal: 3, byOrdinal: true},
+		{dll: "OLEAUT32.dll", ordinal: 2, byOrdinal: true},
+	}
+
+	if got, want := imphash(imports), "f9d8cf47f0461ffa0156ca77e56719b2"; got != want {
+		t.Errorf("imphash() = %s, want %s", got, want)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	samples := []common.Sample{
+		{Sha256: "aaaa", Paths: []string{"testdata/does_not_exist", "testdata/libhello.so"}, Upload: true},
+		{Sha256: "bbbb", Paths: []string{"testdata/hello.dll"}},
+		{Sha256: "cccc", Paths: []string{"testdata/hello.txt"}, Upload: true},
+	}
+
+	if err := New().Analyze(context.Background(), samples); err != nil {
+		t.Fatalf("unexpected error while running Analyze(): %v", err)
+	}
+
+	if samples[0].Executable == nil || samples[0].Executable.Soname != "libhello.so.1" {
+		t.Errorf("Analyze() unexpected metadata of ELF sample: %+v", samples[0].Executable)
+	}
+	if samples[1].Executable != nil {
+		t.Errorf("Analyze() analyzed sample that is not flagged for upload: %+v", samples[1].Executable)
+	}
+	if samples[2].Executable != nil {
+		t.Errorf("Analyze() set executable metadata of text file: %+v", samples[2].Executable)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executable
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/google/hashr/analyzers/filetype"
+	"github.com/google/hashr/common"
+)
+
+// lcUUID is the load command holding UUID of a Mach-O image.
+const lcUUID = 0x1b
+
+func machoMetadata(r io.ReaderAt) (*common.ExecutableMetadata, error) {
+	md := &common.ExecutableMetadata{}
+
+	var header [4]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil {
+		return nil, err
+	}
+
+	if binary.BigEndian.Uint32(header[:]) == macho.MagicFat {
+		f, err := macho.NewFatFile(r)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		for _, arch := range f.Arches {
+			md.Archs = append(md.Archs, filetype.MachOArch(arch.Cpu))
+			md.UUIDs = append(md.UUIDs, machoUUID(arch.File))
+		}
+		return md, nil
+	}
+
+	f, err := macho.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	md.Archs = []string{filetype.MachOArch(f.Cpu)}
+	md.UUIDs = []string{machoUUID(f)}
+
+	return md, nil
+}
+
+// machoUUID returns UUID of a Mach-O image, empty if it has no LC_UUID load command.
+func machoUUID(f *macho.File) string {
+	for _, load := range f.Loads {
+		raw := load.Raw()
+		if len(raw) < 24 || f.ByteOrder.Uint32(raw) != lcUUID {
+			continue
+		}
+		u := raw[8:24]
+		return fmt.Sprintf("%X-%X-%X-%X-%X", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+	}
+
+	return ""
+}
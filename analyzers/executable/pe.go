@@ -0,0 +1,373 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package executable
+
+import (
+	"bytes"
+	"crypto/md5"
+	"debug/pe"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/google/hashr/common"
+)
+
+const (
+	// rtVersion is the resource type of version-info resources.
+	rtVersion = 16
+	// maxImports limits the number of imports read from a single file, to avoid looping over
+	// corrupted import tables.
+	maxImports = 65536
+)
+
+// peImport is a single function imported by a PE file.
+type peImport struct {
+	dll       string
+	name      string
+	ordinal   uint16
+	byOrdinal bool
+}
+
+func peMetadata(r io.ReaderAt) (*common.ExecutableMetadata, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	md := &common.ExecutableMetadata{CompileTimestamp: int64(f.TimeDateStamp)}
+
+	var is64 bool
+	var dirs []pe.DataDirectory
+	var dirCount uint32
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		dirs, dirCount = oh.DataDirectory[:], oh.NumberOfRvaAndSizes
+	case *pe.OptionalHeader64:
+		is64 = true
+		dirs, dirCount = oh.DataDirectory[:], oh.NumberOfRvaAndSizes
+	}
+	if dirCount < uint32(len(dirs)) {
+		dirs = dirs[:dirCount]
+	}
+
+	if len(dirs) > pe.IMAGE_DIRECTORY_ENTRY_IMPORT {
+		imports, err := peImports(f, dirs[pe.IMAGE_DIRECTORY_ENTRY_IMPORT], is64)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading imports: %v", err)
+		}
+		md.Imphash = imphash(imports)
+	}
+
+	if len(dirs) > pe.IMAGE_DIRECTORY_ENTRY_RESOURCE {
+		md.VersionInfo = peVersionInfo(f, dirs[pe.IMAGE_DIRECTORY_ENTRY_RESOURCE])
+	}
+
+	return md, nil
+}
+
+// rvaData returns data of the section containing a given relative virtual address, starting at
+// that address.
+func rvaData(f *pe.File, rva uint32) ([]byte, bool) {
+	for _, s := range f.Sections {
+		size := s.VirtualSize
+		if size < s.Size {
+			size = s.Size
+		}
+		if rva < s.VirtualAddress || rva >= s.VirtualAddress+size {
+			continue
+		}
+
+		data, err := s.Data()
+		if err != nil {
+			return nil, false
+		}
+		offset := rva - s.VirtualAddress
+		if offset >= uint32(len(data)) {
+			return nil, false
+		}
+		return data[offset:], true
+	}
+
+	return nil, false
+}
+
+// cString returns a NUL terminated string stored at a given relative virtual address.
+func cString(f *pe.File, rva uint32) (string, bool) {
+	data, ok := rvaData(f, rva)
+	if !ok {
+		return "", false
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	return string(data), true
+}
+
+// peImports returns functions imported using the import directory.
+func peImports(f *pe.File, dir pe.DataDirectory, is64 bool) ([]peImport, error) {
+	if dir.VirtualAddress == 0 {
+		return nil, nil
+	}
+
+	descriptors, ok := rvaData(f, dir.VirtualAddress)
+	if !ok {
+		return nil, fmt.Errorf("import directory at 0x%x is outside of sections", dir.VirtualAddress)
+	}
+
+	thunkSize := 4
+	ordinalFlag := uint64(1) << 31
+	if is64 {
+		thunkSize = 8
+		ordinalFlag = uint64(1) << 63
+	}
+
+	var imports []peImport
+	for len(descriptors) >= 20 {
+		originalFirstThunk := binary.LittleEndian.Uint32(descriptors[0:])
+		nameRVA := binary.LittleEndian.Uint32(descriptors[12:])
+		firstThunk := binary.LittleEndian.Uint32(descriptors[16:])
+		descriptors = descriptors[20:]
+		if originalFirstThunk == 0 && nameRVA == 0 && firstThunk == 0 {
+			break
+		}
+
+		dll, ok := cString(f, nameRVA)
+		if !ok {
+			continue
+		}
+
+		thunkRVA := originalFirstThunk
+		if thunkRVA == 0 {
+			thunkRVA = firstThunk
+		}
+		thunks, ok := rvaData(f, thunkRVA)
+		if !ok {
+			continue
+		}
+
+		for ; len(thunks) >= thunkSize; thunks = thunks[thunkSize:] {
+			var thunk uint64
+			if is64 {
+				thunk = binary.LittleEndian.Uint64(thunks)
+			} else {
+				thunk = uint64(binary.LittleEndian.Uint32(thunks))
+			}
+			if thunk == 0 {
+				break
+			}
+			if len(imports) >= maxImports {
+				return nil, fmt.Errorf("more than %d imports", maxImports)
+			}
+
+			if thunk&ordinalFlag != 0 {
+				imports = append(imports, peImport{dll: dll, ordinal: uint16(thunk), byOrdinal: true})
+				continue
+			}
+
+			// Hint/name entry starts with 2 bytes of hint.
+			name, ok := cString(f, uint32(thunk)+2)
+			if !ok {
+				continue
+			}
+			imports = append(imports, peImport{dll: dll, name: name})
+		}
+	}
+
+	return imports, nil
+}
+
+// imphash returns import hash of a PE file, following pefile implementation. Functions imported by
+// ordinal are named ord<N>, unless their name is known from ordNames.
+func imphash(imports []peImport) string {
+	if len(imports) == 0 {
+		return ""
+	}
+
+	var names []string
+	for _, imp := range imports {
+		dll := strings.ToLower(imp.dll)
+		ordinals := ordNames[dll]
+		if i := strings.LastIndexByte(dll, '.'); i >= 0 {
+			switch dll[i+1:] {
+			case "dll", "ocx", "sys":
+				dll = dll[:i]
+			}
+		}
+
+		name := imp.name
+		if imp.byOrdinal {
+			var ok bool
+			if name, ok = ordinals[imp.ordinal]; !ok {
+				name = fmt.Sprintf("ord%d", imp.ordinal)
+			}
+		}
+		names = append(names, dll+"."+strings.ToLower(name))
+	}
+
+	sum := md5.Sum([]byte(strings.Join(names, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+// peVersionInfo returns strings of the first version-info resource.
+func peVersionInfo(f *pe.File, dir pe.DataDirectory) map[string]string {
+	if dir.VirtualAddress == 0 {
+		return nil
+	}
+
+	rsrc, ok := rvaData(f, dir.VirtualAddress)
+	if !ok {
+		return nil
+	}
+
+	// Resource tree has three levels: type, name and language, leaves are data entries.
+	var offset uint32
+	for level := 0; level < 3; level++ {
+		child, ok := resourceEntry(rsrc, offset, rtVersion, level == 0)
+		if !ok {
+			return nil
+		}
+		if isDir := child&0x80000000 != 0; isDir != (level < 2) {
+			return nil
+		}
+		offset = child &^ 0x80000000
+	}
+	if int(offset)+16 > len(rsrc) {
+		return nil
+	}
+
+	dataRVA := binary.LittleEndian.Uint32(rsrc[offset:])
+	dataSize := binary.LittleEndian.Uint32(rsrc[offset+4:])
+	data, ok := rvaData(f, dataRVA)
+	if !ok {
+		return nil
+	}
+	if uint32(len(data)) > dataSize {
+		data = data[:dataSize]
+	}
+
+	strs := make(map[string]string)
+	parseVersionInfo(data, 0, strs)
+	if len(strs) == 0 {
+		return nil
+	}
+
+	return strs
+}
+
+// resourceEntry returns offset of the child of a resource directory at a given offset. If byID is
+// true the child with a given ID is returned, otherwise the first child is returned. Offsets of
+// subdirectories have the high bit set.
+func resourceEntry(rsrc []byte, offset, id uint32, byID bool) (uint32, bool) {
+	if int(offset)+16 > len(rsrc) {
+		return 0, false
+	}
+	named := uint32(binary.LittleEndian.Uint16(rsrc[offset+12:]))
+	ids := uint32(binary.LittleEndian.Uint16(rsrc[offset+14:]))
+
+	for i := uint32(0); i < named+ids; i++ {
+		entry := offset + 16 + i*8
+		if int(entry)+8 > len(rsrc) {
+			return 0, false
+		}
+		name := binary.LittleEndian.Uint32(rsrc[entry:])
+		child := binary.LittleEndian.Uint32(rsrc[entry+4:])
+		if byID && (name&0x80000000 != 0 || name != id) {
+			continue
+		}
+		return child, true
+	}
+
+	return 0, false
+}
+
+// parseVersionInfo parses a VS_VERSIONINFO structure (or one of its children) and adds key/value
+// pairs of String structures to strs, keeping values seen first. depth is 0 for VS_VERSIONINFO, 1
+// for StringFileInfo, 2 for StringTable and 3 for String.
+func parseVersionInfo(data []byte, depth int, strs map[string]string) {
+	if len(data) < 6 || depth > 3 {
+		return
+	}
+	length := int(binary.LittleEndian.Uint16(data))
+	valueLength := int(binary.LittleEndian.Uint16(data[2:]))
+	valueType := binary.LittleEndian.Uint16(data[4:])
+	if length < 6 || length > len(data) {
+		return
+	}
+	data = data[:length]
+
+	key, keyEnd := utf16String(data[6:])
+	offset := align4(6 + keyEnd)
+	if depth == 1 && key != "StringFileInfo" {
+		return
+	}
+
+	// Text values have their length in words.
+	if valueType == 1 {
+		valueLength *= 2
+	}
+	if offset+valueLength > len(data) {
+		valueLength = len(data) - offset
+	}
+	if valueLength < 0 {
+		return
+	}
+
+	if depth == 3 {
+		value, _ := utf16String(data[offset : offset+valueLength])
+		if _, ok := strs[key]; !ok {
+			strs[key] = strings.TrimSpace(value)
+		}
+		return
+	}
+
+	for children := align4(offset + valueLength); children+6 <= len(data); {
+		childLength := int(binary.LittleEndian.Uint16(data[children:]))
+		if childLength < 6 {
+			return
+		}
+		end := children + childLength
+		if end > len(data) {
+			end = len(data)
+		}
+		parseVersionInfo(data[children:end], depth+1, strs)
+		children = align4(children + childLength)
+	}
+}
+
+// utf16String decodes NUL terminated UTF-16LE string and returns it together with the number of
+// bytes read, including the terminator.
+func utf16String(data []byte) (string, int) {
+	var chars []uint16
+	n := 0
+	for ; n+1 < len(data); n += 2 {
+		c := binary.LittleEndian.Uint16(data[n:])
+		if c == 0 {
+			n += 2
+			break
+		}
+		chars = append(chars, c)
+	}
+
+	return string(utf16.Decode(chars)), n
+}
+
+func align4(n int) int {
+	return (n + 3) &^ 3
+}
@@ -0,0 +1,620 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package filetype identifies file types in-process, without relying on file(1) or libmagic.
+package filetype
+
+import (
+	"archive/zip"
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// headerSize is the number of bytes read from the beginning of a file to match magic numbers.
+const headerSize = 4096
+
+// Info holds information about the type of a file.
+type Info struct {
+	// Format is a short name of the file format, e.g. "ELF", "PE32+" or "gzip".
+	Format string
+	// MimeType is the media type of the file.
+	MimeType string
+	// Arch is the CPU architecture of executables, e.g. "x86-64". Universal Mach-O binaries hold
+	// comma separated architectures of all their images.
+	Arch string
+	// Bits is 32 or 64 for executables, 0 if it's not known or not applicable.
+	Bits int
+	// Description is a human readable description of the file, similar to the output of file(1).
+	Description string
+}
+
+// IdentifyFile returns information about the type of a given file.
+func IdentifyFile(filePath string) (*Info, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return Identify(f, fi.Size())
+}
+
+// Identify returns information about the type of a file of a given size read from r.
+func Identify(r io.ReaderAt, size int64) (*Info, error) {
+	header := make([]byte, headerSize)
+	n, err := r.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error while reading file header: %v", err)
+	}
+	header = header[:n]
+
+	if len(header) == 0 {
+		return &Info{Format: "empty", MimeType: "inode/x-empty", Description: "empty"}, nil
+	}
+
+	sr := io.NewSectionReader(r, 0, size)
+	for _, identify := range []func(*io.SectionReader, []byte) *Info{
+		identifyELF,
+		identifyPE,
+		identifyMachO,
+		identifyScript,
+		identifyZip,
+		identifyArchive,
+		identifyImage,
+		identifyDocument,
+	} {
+		if info := identify(sr, header); info != nil {
+			return info, nil
+		}
+	}
+
+	return identifyText(header), nil
+}
+
+var elfArchs = map[elf.Machine]string{
+	elf.EM_386:     "i386",
+	elf.EM_X86_64:  "x86-64",
+	elf.EM_ARM:     "arm",
+	elf.EM_AARCH64: "aarch64",
+	elf.EM_MIPS:    "mips",
+	elf.EM_PPC:     "ppc",
+	elf.EM_PPC64:   "ppc64",
+	elf.EM_S390:    "s390",
+	elf.EM_SPARC:   "sparc",
+	elf.EM_SPARCV9: "sparcv9",
+	elf.EM_IA_64:   "ia64",
+	elf.EM_RISCV:   "riscv",
+}
+
+var elfTypes = map[elf.Type]struct{ name, mimeType string }{
+	elf.ET_REL:  {"relocatable", "application/x-object"},
+	elf.ET_EXEC: {"executable", "application/x-executable"},
+	elf.ET_DYN:  {"shared object", "application/x-sharedlib"},
+	elf.ET_CORE: {"core file", "application/x-coredump"},
+}
+
+func identifyELF(r *io.SectionReader, header []byte) *Info {
+	if !bytes.HasPrefix(header, []byte(elf.ELFMAG)) {
+		return nil
+	}
+
+	info := &Info{Format: "ELF", MimeType: "application/x-executable"}
+	if len(header) > elf.EI_CLASS {
+		switch elf.Class(header[elf.EI_CLASS]) {
+		case elf.ELFCLASS32:
+			info.Bits = 32
+		case elf.ELFCLASS64:
+			info.Bits = 64
+		}
+	}
+
+	f, err := elf.NewFile(r)
+	if err != nil {
+		info.Description = fmt.Sprintf("ELF %d-bit, corrupted", info.Bits)
+		return info
+	}
+
+	order := "LSB"
+	if f.Data == elf.ELFDATA2MSB {
+		order = "MSB"
+	}
+
+	fileType := elfTypes[f.Type]
+	if fileType.name == "" {
+		fileType.name = f.Type.String()
+	} else {
+		info.MimeType = fileType.mimeType
+	}
+
+	info.Arch = elfArchs[f.Machine]
+	if info.Arch == "" {
+		info.Arch = strings.ToLower(strings.TrimPrefix(f.Machine.String(), "EM_"))
+	}
+
+	info.Description = fmt.Sprintf("ELF %d-bit %s %s, %s", info.Bits, order, fileType.name, info.Arch)
+	return info
+}
+
+var peArchs = map[uint16]string{
+	pe.IMAGE_FILE_MACHINE_I386:  "i386",
+	pe.IMAGE_FILE_MACHINE_AMD64: "x86-64",
+	pe.IMAGE_FILE_MACHINE_ARM:   "arm",
+	pe.IMAGE_FILE_MACHINE_ARMNT: "arm",
+	pe.IMAGE_FILE_MACHINE_ARM64: "aarch64",
+	pe.IMAGE_FILE_MACHINE_IA64:  "ia64",
+}
+
+var peSubsystems = map[uint16]string{
+	pe.IMAGE_SUBSYSTEM_NATIVE:                  "native",
+	pe.IMAGE_SUBSYSTEM_WINDOWS_GUI:             "GUI",
+	pe.IMAGE_SUBSYSTEM_WINDOWS_CUI:             "console",
+	pe.IMAGE_SUBSYSTEM_EFI_APPLICATION:         "EFI application",
+	pe.IMAGE_SUBSYSTEM_EFI_BOOT_SERVICE_DRIVER: "EFI boot service driver",
+	pe.IMAGE_SUBSYSTEM_EFI_RUNTIME_DRIVER:      "EFI runtime driver",
+}
+
+func identifyPE(r *io.SectionReader, header []byte) *Info {
+	if !bytes.HasPrefix(header, []byte("MZ")) {
+		return nil
+	}
+
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return &Info{Format: "MS-DOS", MimeType: "application/x-dosexec", Bits: 16, Arch: "i8086", Description: "MS-DOS executable"}
+	}
+
+	info := &Info{MimeType: "application/vnd.microsoft.portable-executable"}
+	var subsystem uint16
+	var clrHeader pe.DataDirectory
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		info.Format, info.Bits, subsystem = "PE32", 32, oh.Subsystem
+		if len(oh.DataDirectory) > pe.IMAGE_DIRECTORY_ENTRY_COM_DESCRIPTOR {
+			clrHeader = oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_COM_DESCRIPTOR]
+		}
+	case *pe.OptionalHeader64:
+		info.Format, info.Bits, subsystem = "PE32+", 64, oh.Subsystem
+		if len(oh.DataDirectory) > pe.IMAGE_DIRECTORY_ENTRY_COM_DESCRIPTOR {
+			clrHeader = oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_COM_DESCRIPTOR]
+		}
+	default:
+		info.Format = "COFF"
+	}
+
+	info.Arch = peArchs[f.Machine]
+	if info.Arch == "" {
+		info.Arch = fmt.Sprintf("machine 0x%x", f.Machine)
+	}
+
+	description := []string{info.Format, "executable"}
+	if f.Characteristics&pe.IMAGE_FILE_DLL != 0 {
+		description = append(description, "(DLL)")
+	}
+	if s, ok := peSubsystems[subsystem]; ok {
+		description = append(description, fmt.Sprintf("(%s)", s))
+	}
+	description = append(description, info.Arch)
+	if clrHeader.VirtualAddress != 0 {
+		description = append(description, "Mono/.Net assembly")
+	}
+	info.Description = strings.Join(description, " ") + ", for MS Windows"
+
+	return info
+}
+
+var machoArchs = map[macho.Cpu]string{
+	macho.Cpu386:   "i386",
+	macho.CpuAmd64: "x86-64",
+	macho.CpuArm:   "arm",
+	macho.CpuArm64: "arm64",
+	macho.CpuPpc:   "ppc",
+	macho.CpuPpc64: "ppc64",
+}
+
+var machoTypes = map[macho.Type]string{
+	macho.TypeObj:    "object",
+	macho.TypeExec:   "executable",
+	macho.TypeDylib:  "dynamically linked shared library",
+	macho.TypeBundle: "bundle",
+}
+
+// maxFatArchs is the largest number of images in a universal Mach-O binary. Java class files
+// share the magic number, but have version numbers of at least 45 at the same offset.
+const maxFatArchs = 20
+
+func identifyMachO(r *io.SectionReader, header []byte) *Info {
+	if len(header) < 8 {
+		return nil
+	}
+
+	switch binary.BigEndian.Uint32(header) {
+	case macho.Magic32, macho.Magic64:
+	case 0xcefaedfe, 0xcffaedfe:
+	case macho.MagicFat:
+		return identifyFat(r, header)
+	default:
+		return nil
+	}
+
+	f, err := macho.NewFile(r)
+	if err != nil {
+		return &Info{Format: "Mach-O", MimeType: "application/x-mach-binary", Description: "Mach-O, corrupted"}
+	}
+
+	info := &Info{Format: "Mach-O", MimeType: "application/x-mach-binary", Arch: MachOArch(f.Cpu), Bits: 32}
+	if f.Magic == macho.Magic64 {
+		info.Bits = 64
+	}
+
+	fileType, ok := machoTypes[f.Type]
+	if !ok {
+		fileType = fmt.Sprintf("filetype=%d", f.Type)
+	}
+	info.Description = fmt.Sprintf("Mach-O %d-bit %s %s", info.Bits, info.Arch, fileType)
+
+	return info
+}
+
+func identifyFat(r *io.SectionReader, header []byte) *Info {
+	if binary.BigEndian.Uint32(header[4:]) > maxFatArchs {
+		return &Info{Format: "Java class", MimeType: "application/x-java-applet", Description: "compiled Java class data"}
+	}
+
+	f, err := macho.NewFatFile(r)
+	if err != nil {
+		return &Info{Format: "Mach-O", MimeType: "application/x-mach-binary", Description: "Mach-O universal binary, corrupted"}
+	}
+
+	info := &Info{Format: "Mach-O", MimeType: "application/x-mach-binary"}
+	var archs []string
+	for i, arch := range f.Arches {
+		archs = append(archs, MachOArch(arch.Cpu))
+		bits := 32
+		if arch.Magic == macho.Magic64 {
+			bits = 64
+		}
+		if i == 0 {
+			info.Bits = bits
+		} else if info.Bits != bits {
+			info.Bits = 0
+		}
+	}
+	info.Arch = strings.Join(archs, ",")
+	info.Description = fmt.Sprintf("Mach-O universal binary with %d architectures: [%s]", len(archs), strings.Join(archs, ", "))
+
+	return info
+}
+
+// MachOArch returns name of a Mach-O CPU type, as used in Arch.
+func MachOArch(cpu macho.Cpu) string {
+	if arch, ok := machoArchs[cpu]; ok {
+		return arch
+	}
+	return strings.ToLower(cpu.String())
+}
+
+var interpreters = []struct {
+	prefix, name, mimeType string
+}{
+	{"bash", "Bourne-Again shell script", "text/x-shellscript"},
+	{"sh", "POSIX shell script", "text/x-shellscript"},
+	{"dash", "POSIX shell script", "text/x-shellscript"},
+	{"zsh", "Paul Falstad's zsh script", "text/x-shellscript"},
+	{"ksh", "Korn shell script", "text/x-shellscript"},
+	{"python", "Python script", "text/x-script.python"},
+	{"perl", "Perl script", "text/x-perl"},
+	{"ruby", "Ruby script", "text/x-ruby"},
+	{"node", "Node.js script", "application/javascript"},
+	{"php", "PHP script", "text/x-php"},
+	{"awk", "awk script", "text/x-awk"},
+	{"gawk", "awk script", "text/x-awk"},
+	{"tclsh", "Tcl script", "text/x-tcl"},
+	{"lua", "Lua script", "text/x-lua"},
+}
+
+func identifyScript(r *io.SectionReader, header []byte) *Info {
+	if !bytes.HasPrefix(header, []byte("#!")) {
+		return nil
+	}
+
+	line := string(header[2:])
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	interpreter := path.Base(fields[0])
+	if interpreter == "env" {
+		interpreter = ""
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "-") && !strings.Contains(field, "=") {
+				interpreter = path.Base(field)
+				break
+			}
+		}
+		if interpreter == "" {
+			return nil
+		}
+	}
+
+	info := &Info{Format: "script", MimeType: "text/plain", Description: fmt.Sprintf("a %s script", interpreter)}
+	for _, i := range interpreters {
+		if interpreter == i.prefix || (strings.HasPrefix(interpreter, i.prefix) && strings.Trim(interpreter[len(i.prefix):], "0123456789.") == "") {
+			info.MimeType = i.mimeType
+			info.Description = i.name
+			break
+		}
+	}
+	info.Description += ", " + textEncoding(header) + " text executable"
+
+	return info
+}
+
+// zipFormats lists files that identify formats based on Zip archives, in order of precedence.
+var zipFormats = []struct {
+	file, format, mimeType, description string
+}{
+	{"AndroidManifest.xml", "APK", "application/vnd.android.package-archive", "Android package (APK)"},
+	{"META-INF/MANIFEST.MF", "JAR", "application/java-archive", "Java archive data (JAR)"},
+	{"word/document.xml", "DOCX", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", "Microsoft Word 2007+"},
+	{"xl/workbook.xml", "XLSX", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "Microsoft Excel 2007+"},
+	{"ppt/presentation.xml", "PPTX", "application/vnd.openxmlformats-officedocument.presentationml.presentation", "Microsoft PowerPoint 2007+"},
+}
+
+func identifyZip(r *io.SectionReader, header []byte) *Info {
+	if !bytes.HasPrefix(header, []byte("PK\x03\x04")) && !bytes.HasPrefix(header, []byte("PK\x05\x06")) {
+		return nil
+	}
+
+	info := &Info{Format: "Zip", MimeType: "application/zip", Description: "Zip archive data"}
+	zr, err := zip.NewReader(r, r.Size())
+	if err != nil {
+		return info
+	}
+
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	// OpenDocument files hold their media type in the first, uncompressed, entry.
+	if len(zr.File) > 0 && zr.File[0].Name == "mimetype" && zr.File[0].Method == zip.Store && zr.File[0].UncompressedSize64 < 128 {
+		if rc, err := zr.File[0].Open(); err == nil {
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err == nil && strings.HasPrefix(string(data), "application/vnd.oasis.opendocument.") {
+				return &Info{Format: "OpenDocument", MimeType: string(data), Description: "OpenDocument " + strings.TrimPrefix(string(data), "application/vnd.oasis.opendocument.")}
+			}
+		}
+	}
+
+	for _, format := range zipFormats {
+		if _, ok := files[format.file]; ok {
+			return &Info{Format: format.format, MimeType: format.mimeType, Description: format.description}
+		}
+	}
+
+	return info
+}
+
+// magic describes a format identified by a fixed sequence of bytes at a given offset.
+type magic struct {
+	offset                        int
+	magic                         string
+	format, mimeType, description string
+}
+
+var archiveMagics = []magic{
+	{0, "\x1f\x8b", "gzip", "application/gzip", "gzip compressed data"},
+	{0, "BZh", "bzip2", "application/x-bzip2", "bzip2 compressed data"},
+	{0, "\xfd7zXZ\x00", "xz", "application/x-xz", "XZ compressed data"},
+	{0, "\x28\xb5\x2f\xfd", "zstd", "application/zstd", "Zstandard compressed data"},
+	{0, "7z\xbc\xaf\x27\x1c", "7-zip", "application/x-7z-compressed", "7-zip archive data"},
+	{0, "Rar!\x1a\x07", "RAR", "application/vnd.rar", "RAR archive data"},
+	{0, "!<arch>\ndebian-binary", "deb", "application/vnd.debian.binary-package", "Debian binary package"},
+	{0, "!<arch>\n", "ar", "application/x-archive", "current ar archive"},
+	{0, "\xed\xab\xee\xdb", "RPM", "application/x-rpm", "RPM"},
+	{0, "MSCF\x00\x00\x00\x00", "CAB", "application/vnd.ms-cab-compressed", "Microsoft Cabinet archive data"},
+	{0, "MSWIM\x00\x00\x00", "WIM", "application/x-ms-wim", "Windows imaging (WIM) image"},
+	{0, "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1", "OLE2", "application/x-ole-storage", "Composite Document File V2 Document"},
+	{0, "070701", "cpio", "application/x-cpio", "ASCII cpio archive (SVR4 with no CRC)"},
+	{0, "070702", "cpio", "application/x-cpio", "ASCII cpio archive (SVR4 with CRC)"},
+	{0, "070707", "cpio", "application/x-cpio", "ASCII cpio archive (pre-SVR4 or odc)"},
+	{0, "SQLite format 3\x00", "SQLite", "application/vnd.sqlite3", "SQLite 3.x database"},
+	{0, "\x00asm", "WebAssembly", "application/wasm", "WebAssembly (wasm) binary module"},
+	{257, "ustar\x0000", "tar", "application/x-tar", "POSIX tar archive"},
+	{257, "ustar  \x00", "tar", "application/x-tar", "POSIX tar archive (GNU)"},
+	{0x8001, "CD001", "ISO 9660", "application/x-iso9660-image", "ISO 9660 CD-ROM filesystem data"},
+}
+
+func identifyArchive(r *io.SectionReader, header []byte) *Info {
+	return matchMagics(r, header, archiveMagics)
+}
+
+var imageMagics = []magic{
+	{0, "\x89PNG\r\n\x1a\n", "PNG", "image/png", "PNG image data"},
+	{0, "\xff\xd8\xff", "JPEG", "image/jpeg", "JPEG image data"},
+	{0, "GIF87a", "GIF", "image/gif", "GIF image data, version 87a"},
+	{0, "GIF89a", "GIF", "image/gif", "GIF image data, version 89a"},
+	{0, "II*\x00", "TIFF", "image/tiff", "TIFF image data, little-endian"},
+	{0, "MM\x00*", "TIFF", "image/tiff", "TIFF image data, big-endian"},
+	{0, "\x00\x00\x01\x00", "ICO", "image/vnd.microsoft.icon", "MS Windows icon resource"},
+	{8, "WEBP", "WebP", "image/webp", "RIFF (little-endian) data, Web/P image"},
+}
+
+func identifyImage(r *io.SectionReader, header []byte) *Info {
+	info := matchMagics(r, header, imageMagics)
+	if info == nil {
+		if bytes.HasPrefix(header, []byte("BM")) && len(header) >= 26 && binary.LittleEndian.Uint32(header[14:]) <= 124 {
+			width, height := int32(binary.LittleEndian.Uint32(header[18:])), int32(binary.LittleEndian.Uint32(header[22:]))
+			return &Info{Format: "BMP", MimeType: "image/bmp", Description: fmt.Sprintf("PC bitmap, %d x %d", width, abs(height))}
+		}
+		if isSVG(header) {
+			return &Info{Format: "SVG", MimeType: "image/svg+xml", Description: "SVG Scalable Vector Graphics image"}
+		}
+		return nil
+	}
+
+	switch info.Format {
+	case "PNG":
+		if len(header) >= 24 && string(header[12:16]) == "IHDR" {
+			info.Description += fmt.Sprintf(", %d x %d", binary.BigEndian.Uint32(header[16:]), binary.BigEndian.Uint32(header[20:]))
+		}
+	case "GIF":
+		if len(header) >= 10 {
+			info.Description += fmt.Sprintf(", %d x %d", binary.LittleEndian.Uint16(header[6:]), binary.LittleEndian.Uint16(header[8:]))
+		}
+	case "WebP":
+		if !bytes.HasPrefix(header, []byte("RIFF")) {
+			return nil
+		}
+	}
+
+	return info
+}
+
+func identifyDocument(r *io.SectionReader, header []byte) *Info {
+	switch {
+	case bytes.HasPrefix(header, []byte("%PDF-")):
+		description := "PDF document"
+		if version := pdfVersion(header); version != "" {
+			description += ", version " + version
+		}
+		return &Info{Format: "PDF", MimeType: "application/pdf", Description: description}
+	case bytes.HasPrefix(header, []byte("{\\rtf")):
+		return &Info{Format: "RTF", MimeType: "text/rtf", Description: "Rich Text Format data"}
+	case bytes.HasPrefix(header, []byte("%!PS")):
+		return &Info{Format: "PostScript", MimeType: "application/postscript", Description: "PostScript document text"}
+	}
+
+	return nil
+}
+
+func identifyText(header []byte) *Info {
+	mimeType := http.DetectContentType(header)
+	if !isText(header) {
+		return &Info{Format: "data", MimeType: mimeType, Description: "data"}
+	}
+
+	description := textEncoding(header) + " text"
+	switch {
+	case strings.HasPrefix(mimeType, "text/html"):
+		description = "HTML document, " + description
+	case strings.HasPrefix(mimeType, "text/xml"):
+		description = "XML document, " + description
+	}
+
+	return &Info{Format: "text", MimeType: mimeType, Description: description}
+}
+
+func matchMagics(r io.ReaderAt, header []byte, magics []magic) *Info {
+	for _, m := range magics {
+		if !hasMagic(r, header, m) {
+			continue
+		}
+		return &Info{Format: m.format, MimeType: m.mimeType, Description: m.description}
+	}
+
+	return nil
+}
+
+func hasMagic(r io.ReaderAt, header []byte, m magic) bool {
+	if m.offset+len(m.magic) <= len(header) {
+		return string(header[m.offset:m.offset+len(m.magic)]) == m.magic
+	}
+
+	// Magic numbers past the header, e.g. ISO 9660 volume descriptors, are read separately.
+	if len(header) < headerSize {
+		return false
+	}
+	buf := make([]byte, len(m.magic))
+	if _, err := r.ReadAt(buf, int64(m.offset)); err != nil {
+		return false
+	}
+
+	return string(buf) == m.magic
+}
+
+func pdfVersion(header []byte) string {
+	version := header[len("%PDF-"):]
+	for i, b := range version {
+		if (b < '0' || b > '9') && b != '.' {
+			return string(version[:i])
+		}
+	}
+	return string(version)
+}
+
+func isSVG(header []byte) bool {
+	if !isText(header) {
+		return false
+	}
+	text := bytes.TrimSpace(header)
+	return (bytes.HasPrefix(text, []byte("<?xml")) || bytes.HasPrefix(text, []byte("<svg"))) && bytes.Contains(text, []byte("<svg"))
+}
+
+// isText reports whether data holds UTF-8 text without control characters other than whitespace
+// and escape sequences.
+func isText(data []byte) bool {
+	// The header may end in the middle of a multi-byte character.
+	for i := 0; i < utf8.UTFMax && len(data) > 0 && !utf8.Valid(data); i++ {
+		data = data[:len(data)-1]
+	}
+	if !utf8.Valid(data) {
+		return false
+	}
+
+	for _, b := range data {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != '\b' && b != 0x1b {
+			return false
+		}
+	}
+
+	return true
+}
+
+func textEncoding(data []byte) string {
+	for _, b := range data {
+		if b >= utf8.RuneSelf {
+			return "Unicode text, UTF-8"
+		}
+	}
+	return "ASCII"
+}
+
+func abs(i int32) int32 {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
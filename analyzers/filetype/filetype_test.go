@@ -0,0 +1,112 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filetype
+
+import (
+	"bytes"
+	"embed"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+//go:embed testdata
+var testdata embed.FS
+
+func TestIdentify(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want *Info
+	}{
+		{"elf64_x86_64_exec", &Info{Format: "ELF", MimeType: "application/x-executable", Arch: "x86-64", Bits: 64, Description: "ELF 64-bit LSB executable, x86-64"}},
+		{"elf32_arm_so", &Info{Format: "ELF", MimeType: "application/x-sharedlib", Arch: "arm", Bits: 32, Description: "ELF 32-bit LSB shared object, arm"}},
+		{"elf64_s390x_rel", &Info{Format: "ELF", MimeType: "application/x-object", Arch: "s390", Bits: 64, Description: "ELF 64-bit MSB relocatable, s390"}},
+		{"pe32plus_x86_64_console.exe", &Info{Format: "PE32+", MimeType: "application/vnd.microsoft.portable-executable", Arch: "x86-64", Bits: 64, Description: "PE32+ executable (console) x86-64, for MS Windows"}},
+		{"pe32_i386_dotnet.dll", &Info{Format: "PE32", MimeType: "application/vnd.microsoft.portable-executable", Arch: "i386", Bits: 32, Description: "PE32 executable (DLL) (GUI) i386 Mono/.Net assembly, for MS Windows"}},
+		{"msdos.com", &Info{Format: "MS-DOS", MimeType: "application/x-dosexec", Arch: "i8086", Bits: 16, Description: "MS-DOS executable"}},
+		{"macho64_arm64_exec", &Info{Format: "Mach-O", MimeType: "application/x-mach-binary", Arch: "arm64", Bits: 64, Description: "Mach-O 64-bit arm64 executable"}},
+		{"macho32_i386_dylib", &Info{Format: "Mach-O", MimeType: "application/x-mach-binary", Arch: "i386", Bits: 32, Description: "Mach-O 32-bit i386 dynamically linked shared library"}},
+		{"macho_universal", &Info{Format: "Mach-O", MimeType: "application/x-mach-binary", Arch: "x86-64,arm64", Bits: 64, Description: "Mach-O universal binary with 2 architectures: [x86-64, arm64]"}},
+		{"Hello.class", &Info{Format: "Java class", MimeType: "application/x-java-applet", Description: "compiled Java class data"}},
+		{"script.sh", &Info{Format: "script", MimeType: "text/x-shellscript", Description: "POSIX shell script, ASCII text executable"}},
+		{"script.py", &Info{Format: "script", MimeType: "text/x-script.python", Description: "Python script, ASCII text executable"}},
+		{"script.unknown", &Info{Format: "script", MimeType: "text/plain", Description: "a frob script, ASCII text executable"}},
+		{"archive.zip", &Info{Format: "Zip", MimeType: "application/zip", Description: "Zip archive data"}},
+		{"document.docx", &Info{Format: "DOCX", MimeType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document", Description: "Microsoft Word 2007+"}},
+		{"library.jar", &Info{Format: "JAR", MimeType: "application/java-archive", Description: "Java archive data (JAR)"}},
+		{"document.odt", &Info{Format: "OpenDocument", MimeType: "application/vnd.oasis.opendocument.text", Description: "OpenDocument text"}},
+		{"hello.gz", &Info{Format: "gzip", MimeType: "application/gzip", Description: "gzip compressed data"}},
+		{"archive.tar", &Info{Format: "tar", MimeType: "application/x-tar", Description: "POSIX tar archive"}},
+		{"package.deb", &Info{Format: "deb", MimeType: "application/vnd.debian.binary-package", Description: "Debian binary package"}},
+		{"package.rpm", &Info{Format: "RPM", MimeType: "application/x-rpm", Description: "RPM"}},
+		{"document.doc", &Info{Format: "OLE2", MimeType: "application/x-ole-storage", Description: "Composite Document File V2 Document"}},
+		{"image.png", &Info{Format: "PNG", MimeType: "image/png", Description: "PNG image data, 16 x 8"}},
+		{"image.gif", &Info{Format: "GIF", MimeType: "image/gif", Description: "GIF image data, version 89a, 2 x 3"}},
+		{"image.jpg", &Info{Format: "JPEG", MimeType: "image/jpeg", Description: "JPEG image data"}},
+		{"image.svg", &Info{Format: "SVG", MimeType: "image/svg+xml", Description: "SVG Scalable Vector Graphics image"}},
+		{"document.pdf", &Info{Format: "PDF", MimeType: "application/pdf", Description: "PDF document, version 1.7"}},
+		{"text.txt", &Info{Format: "text", MimeType: "text/plain; charset=utf-8", Description: "ASCII text"}},
+		{"utf8.txt", &Info{Format: "text", MimeType: "text/plain; charset=utf-8", Description: "Unicode text, UTF-8 text"}},
+		{"index.html", &Info{Format: "text", MimeType: "text/html; charset=utf-8", Description: "HTML document, ASCII text"}},
+		{"data.bin", &Info{Format: "data", MimeType: "application/octet-stream", Description: "data"}},
+		{"empty", &Info{Format: "empty", MimeType: "inode/x-empty", Description: "empty"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := testdata.ReadFile(filepath.Join("testdata", tc.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Identify(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatalf("unexpected error while running Identify(): %v", err)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Identify() unexpected diff (-want/+got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestIdentifyISO9660(t *testing.T) {
+	data := make([]byte, 0x8800)
+	copy(data[0x8000:], "\x01CD001\x01")
+
+	got, err := Identify(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("unexpected error while running Identify(): %v", err)
+	}
+
+	if got.Format != "ISO 9660" || got.MimeType != "application/x-iso9660-image" {
+		t.Errorf("Identify() = %+v; want ISO 9660 image", got)
+	}
+}
+
+func TestIdentifyFile(t *testing.T) {
+	got, err := IdentifyFile("testdata/elf64_x86_64_exec")
+	if err != nil {
+		t.Fatalf("unexpected error while running IdentifyFile(): %v", err)
+	}
+
+	if got.Format != "ELF" || got.Arch != "x86-64" || got.Bits != 64 {
+		t.Errorf("IdentifyFile() = %+v; want 64-bit x86-64 ELF", got)
+	}
+
+	if _, err := IdentifyFile("testdata/does_not_exist"); err == nil {
+		t.Error("IdentifyFile() expected error for missing file")
+	}
+}
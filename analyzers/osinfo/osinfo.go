@@ -0,0 +1,442 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package osinfo implements detection of the operating system of disk images from the extracted
+// files: os-release and kernel modules on Linux, CurrentVersion registry key on Windows.
+package osinfo
+
+import (
+	"bufio"
+	"debug/elf"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/hashr/common"
+)
+
+const (
+	// maxRootDepth is the depth of directories under the extraction directory that are searched
+	// for file system roots, e.g. export/p1/ for partitions extracted by image_export.
+	maxRootDepth = 2
+	// maxLinks limits the number of symbolic links followed when resolving a path.
+	maxLinks = 40
+	// currentVersionKey holds the version of Windows in the SOFTWARE hive.
+	currentVersionKey = `Microsoft\Windows NT\CurrentVersion`
+)
+
+// Detector identifies the operating system of extracted disk images.
+type Detector struct {
+}
+
+// New returns new operating system detector.
+func New() *Detector {
+	return &Detector{}
+}
+
+// DetectOS returns the operating system found in an extraction directory. File systems are looked
+// up in the directory itself and in its subdirectories, the first one holding Linux or Windows
+// installation is used. Nil is returned if none is found.
+func (d *Detector) DetectOS(extractionDir string) (*common.OSInfo, error) {
+	roots := []string{extractionDir}
+	dirs := []string{extractionDir}
+	for depth := 0; depth < maxRootDepth; depth++ {
+		var subdirs []string
+		for _, dir := range dirs {
+			entries, err := ioutil.ReadDir(dir)
+			if err != nil {
+				if dir == extractionDir {
+					return nil, err
+				}
+				continue
+			}
+			for _, entry := range entries {
+				if entry.IsDir() {
+					subdirs = append(subdirs, filepath.Join(dir, entry.Name()))
+				}
+			}
+		}
+		roots = append(roots, subdirs...)
+		dirs = subdirs
+	}
+
+	for _, root := range roots {
+		if info, err := detectLinux(root); err == nil {
+			return info, nil
+		}
+		if info, err := detectWindows(root); err == nil {
+			return info, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// detectLinux reads os-release of a Linux file system.
+func detectLinux(root string) (*common.OSInfo, error) {
+	var fields map[string]string
+	var err error
+	for _, path := range []string{"etc/os-release", "usr/lib/os-release"} {
+		if fields, err = readOSRelease(root, path); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info := &common.OSInfo{
+		Family:   "linux",
+		ID:       fields["ID"],
+		Name:     fields["PRETTY_NAME"],
+		Version:  fields["VERSION_ID"],
+		Codename: fields["VERSION_CODENAME"],
+		Kernel:   linuxKernel(root),
+	}
+	if info.Codename == "" {
+		info.Codename = fields["UBUNTU_CODENAME"]
+	}
+	if info.ID == "" {
+		// Default value defined by os-release(5).
+		info.ID = "linux"
+	}
+	if info.Name == "" {
+		info.Name = strings.TrimSpace(fields["NAME"] + " " + fields["VERSION"])
+	}
+
+	// Shell and coreutils are present on every Linux installation, busybox on the minimal ones.
+	for _, path := range []string{"bin/sh", "usr/bin/sh", "bin/ls", "bin/busybox"} {
+		if info.Architecture = elfArchitecture(root, path); info.Architecture != "" {
+			break
+		}
+	}
+
+	return info, nil
+}
+
+// readOSRelease parses os-release file, which holds shell-compatible variable assignments.
+func readOSRelease(root, path string) (map[string]string, error) {
+	resolved, err := resolve(root, path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(resolved)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		s := strings.SplitN(line, "=", 2)
+		if len(s) != 2 {
+			continue
+		}
+
+		value := s[1]
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `"'`)
+		}
+		fields[s[0]] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+
+	return fields, nil
+}
+
+// linuxKernel returns the newest kernel version installed, taken from kernel module directories
+// and kernel images in /boot.
+func linuxKernel(root string) string {
+	var versions []string
+	for _, dir := range []string{"lib/modules", "usr/lib/modules"} {
+		resolved, err := resolve(root, dir)
+		if err != nil {
+			continue
+		}
+		entries, err := ioutil.ReadDir(resolved)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				versions = append(versions, entry.Name())
+			}
+		}
+	}
+
+	if resolved, err := resolve(root, "boot"); err == nil {
+		entries, _ := ioutil.ReadDir(resolved)
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), "vmlinuz-") {
+				versions = append(versions, strings.TrimPrefix(entry.Name(), "vmlinuz-"))
+			}
+		}
+	}
+
+	if len(versions) == 0 {
+		return ""
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) < 0
+	})
+
+	return versions[len(versions)-1]
+}
+
+// compareVersions compares version strings, numeric parts are compared as numbers.
+func compareVersions(a, b string) int {
+	for a != "" && b != "" {
+		var partA, partB string
+		partA, a = nextVersionPart(a)
+		partB, b = nextVersionPart(b)
+		if partA == partB {
+			continue
+		}
+
+		numA, errA := strconv.ParseUint(partA, 10, 64)
+		numB, errB := strconv.ParseUint(partB, 10, 64)
+		switch {
+		case errA == nil && errB == nil:
+			if numA < numB {
+				return -1
+			}
+			return 1
+		case partA < partB:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	return len(a) - len(b)
+}
+
+// nextVersionPart splits a version string into a leading run of digits or non-digits and the rest.
+func nextVersionPart(s string) (string, string) {
+	isDigit := func(c byte) bool { return c >= '0' && c <= '9' }
+	i := 1
+	for i < len(s) && isDigit(s[i]) == isDigit(s[0]) {
+		i++
+	}
+
+	return s[:i], s[i:]
+}
+
+// elfArchitecture returns the Debian name of the architecture of an ELF file.
+func elfArchitecture(root, path string) string {
+	resolved, err := resolve(root, path)
+	if err != nil {
+		return ""
+	}
+	f, err := elf.Open(resolved)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	switch f.Machine {
+	case elf.EM_X86_64:
+		return "amd64"
+	case elf.EM_386:
+		return "i386"
+	case elf.EM_AARCH64:
+		return "arm64"
+	case elf.EM_ARM:
+		return "arm"
+	case elf.EM_PPC64:
+		if f.Data == elf.ELFDATA2LSB {
+			return "ppc64el"
+		}
+		return "ppc64"
+	case elf.EM_S390:
+		return "s390x"
+	case elf.EM_RISCV:
+		return "riscv64"
+	}
+
+	return ""
+}
+
+// detectWindows reads the version of Windows from the SOFTWARE registry hive.
+func detectWindows(root string) (*common.OSInfo, error) {
+	hivePath, err := resolve(root, "Windows/System32/config/SOFTWARE")
+	if err != nil {
+		return nil, err
+	}
+	h, err := openHive(hivePath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := h.openKey(currentVersionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for _, name := range []string{"ProductName", "CurrentVersion", "CurrentMajorVersionNumber", "CurrentMinorVersionNumber", "CurrentBuildNumber", "CurrentBuild", "UBR", "DisplayVersion", "ReleaseId"} {
+		values[name], _ = key.value(name)
+	}
+
+	build := values["CurrentBuildNumber"]
+	if build == "" {
+		build = values["CurrentBuild"]
+	}
+	// Windows 10 and newer keep CurrentVersion at 6.3 for compatibility.
+	version := values["CurrentVersion"]
+	if values["CurrentMajorVersionNumber"] != "" {
+		version = values["CurrentMajorVersionNumber"] + "." + values["CurrentMinorVersionNumber"]
+	}
+	if version == "" || build == "" {
+		return nil, errors.New("Windows version not found")
+	}
+
+	info := &common.OSInfo{
+		Family:   "windows",
+		ID:       "windows",
+		Name:     values["ProductName"],
+		Version:  version + "." + build,
+		Codename: values["DisplayVersion"],
+		Kernel:   version + "." + build,
+	}
+	if info.Codename == "" {
+		info.Codename = values["ReleaseId"]
+	}
+	if values["UBR"] != "" {
+		info.Kernel += "." + values["UBR"]
+	}
+	// Windows 11 keeps "Windows 10" in ProductName.
+	if buildNumber, err := strconv.Atoi(build); err == nil && buildNumber >= 22000 && strings.HasPrefix(info.Name, "Windows 10") {
+		info.Name = "Windows 11" + strings.TrimPrefix(info.Name, "Windows 10")
+	}
+
+	for _, path := range []string{"Windows/System32/ntoskrnl.exe", "Windows/System32/kernel32.dll"} {
+		if info.Architecture = peArchitecture(root, path); info.Architecture != "" {
+			break
+		}
+	}
+
+	return info, nil
+}
+
+// peArchitecture returns the Debian name of the architecture of a PE file.
+func peArchitecture(root, path string) string {
+	resolved, err := resolve(root, path)
+	if err != nil {
+		return ""
+	}
+	f, err := pe.Open(resolved)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	switch f.Machine {
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		return "amd64"
+	case pe.IMAGE_FILE_MACHINE_I386:
+		return "i386"
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		return "arm64"
+	case pe.IMAGE_FILE_MACHINE_ARMNT:
+		return "arm"
+	}
+
+	return ""
+}
+
+// resolve returns the path of a file within a file system root. Symbolic links are resolved
+// relative to the root, so absolute links don't point outside of it. Names that don't exist are
+// matched case-insensitively, as file systems of Windows are.
+func resolve(root, path string) (string, error) {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	resolved := root
+	links := 0
+	for len(parts) > 0 {
+		name := parts[0]
+		parts = parts[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			if resolved != root {
+				resolved = filepath.Dir(resolved)
+			}
+			continue
+		}
+
+		next := filepath.Join(resolved, name)
+		fi, err := os.Lstat(next)
+		if os.IsNotExist(err) {
+			if next, err = findFold(resolved, name); err == nil {
+				fi, err = os.Lstat(next)
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxLinks {
+			return "", fmt.Errorf("too many symbolic links in %s", path)
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = root
+		}
+		parts = append(strings.Split(filepath.ToSlash(target), "/"), parts...)
+	}
+
+	return resolved, nil
+}
+
+// findFold returns path of a directory entry matching a name case-insensitively.
+func findFold(dir, name string) (string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if strings.EqualFold(entry.Name(), name) {
+			return filepath.Join(dir, entry.Name()), nil
+		}
+	}
+
+	return "", os.ErrNotExist
+}
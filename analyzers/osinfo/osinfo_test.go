@@ -0,0 +1,182 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osinfo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/hashr/common"
+)
+
+const osRelease = `PRETTY_NAME="Ubuntu 22.04.1 LTS"
+NAME="Ubuntu"
+VERSION_ID="22.04"
+VERSION="22.04.1 LTS (Jammy Jellyfish)"
+VERSION_CODENAME=jammy
+ID=ubuntu
+ID_LIKE=debian
+UBUNTU_CODENAME=jammy
+`
+
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dst, data)
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func symlink(t *testing.T, target, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ubuntuRoot creates a merged-/usr file system of Ubuntu. Symbolic links are created at runtime, as
+// they can't be part of Go modules.
+func ubuntuRoot(t *testing.T, root string) {
+	writeFile(t, filepath.Join(root, "usr/lib/os-release"), []byte(osRelease))
+	// Absolute link, which must be resolved within the file system root.
+	symlink(t, "/usr/lib/os-release", filepath.Join(root, "etc/os-release"))
+	symlink(t, "usr/bin", filepath.Join(root, "bin"))
+	symlink(t, "usr/lib", filepath.Join(root, "lib"))
+	copyFile(t, "testdata/sh_arm64", filepath.Join(root, "usr/bin/dash"))
+	symlink(t, "dash", filepath.Join(root, "usr/bin/sh"))
+	for _, version := range []string{"5.15.0-9-generic", "5.15.0-10-generic", "5.4.0-99-generic"} {
+		if err := os.MkdirAll(filepath.Join(root, "usr/lib/modules", version), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(root, "boot/vmlinuz-5.15.0-9-generic"), nil)
+}
+
+// windowsRoot creates a Windows file system with upper case directory names.
+func windowsRoot(t *testing.T, root string) {
+	copyFile(t, "testdata/SOFTWARE", filepath.Join(root, "WINDOWS/System32/config/SOFTWARE"))
+	copyFile(t, "testdata/ntoskrnl.exe", filepath.Join(root, "WINDOWS/System32/ntoskrnl.exe"))
+}
+
+func TestDetectOS(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		setup func(t *testing.T, dir string)
+		want  *common.OSInfo
+	}{
+		{
+			name: "ubuntu",
+			setup: func(t *testing.T, dir string) {
+				// Boot partition is ignored.
+				writeFile(t, filepath.Join(dir, "export/p1/grub/grub.cfg"), nil)
+				ubuntuRoot(t, filepath.Join(dir, "export/p2"))
+			},
+			want: &common.OSInfo{
+				Family:       "linux",
+				ID:           "ubuntu",
+				Name:         "Ubuntu 22.04.1 LTS",
+				Version:      "22.04",
+				Codename:     "jammy",
+				Kernel:       "5.15.0-10-generic",
+				Architecture: "arm64",
+			},
+		},
+		{
+			name:  "windows",
+			setup: func(t *testing.T, dir string) { windowsRoot(t, filepath.Join(dir, "export/p3")) },
+			want: &common.OSInfo{
+				Family:       "windows",
+				ID:           "windows",
+				Name:         "Windows 11 Pro",
+				Version:      "10.0.22621",
+				Codename:     "22H2",
+				Kernel:       "10.0.22621.2428",
+				Architecture: "amd64",
+			},
+		},
+		{
+			name: "unknown",
+			setup: func(t *testing.T, dir string) {
+				writeFile(t, filepath.Join(dir, "export/p1/README"), []byte("readme\n"))
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tc.setup(t, dir)
+
+			got, err := New().DetectOS(dir)
+			if err != nil {
+				t.Fatalf("unexpected error while running DetectOS(): %v", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("DetectOS() unexpected diff (-want/+got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	root := t.TempDir()
+	ubuntuRoot(t, root)
+	symlink(t, "../../../..", filepath.Join(root, "usr/lib/up"))
+	symlink(t, "loop", filepath.Join(root, "loop"))
+
+	for _, tc := range []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "bin/sh", want: "usr/bin/dash"},
+		{path: "etc/os-release", want: "usr/lib/os-release"},
+		{path: "ETC/OS-RELEASE", want: "usr/lib/os-release"},
+		// Relative links don't go above the root either.
+		{path: "usr/lib/up/etc/os-release", want: "usr/lib/os-release"},
+		{path: "loop", wantErr: true},
+		{path: "etc/missing", wantErr: true},
+	} {
+		got, err := resolve(root, tc.path)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("resolve(%q) expected error, got %s", tc.path, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error while running resolve(%q): %v", tc.path, err)
+			continue
+		}
+		if want := filepath.Join(root, tc.want); got != want {
+			t.Errorf("resolve(%q) = %s, want %s", tc.path, got, want)
+		}
+	}
+}
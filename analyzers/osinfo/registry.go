@@ -0,0 +1,297 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osinfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Windows registry hive (regf) format constants.
+const (
+	// hiveBinsOffset is the file offset of the first hive bin, cell offsets are relative to it.
+	hiveBinsOffset = 4096
+	// keyCompressedName is set on keys with ASCII names, other names are UTF-16.
+	keyCompressedName = 0x20
+	// valueCompressedName is set on values with ASCII names.
+	valueCompressedName = 0x1
+	// dataInline is set in data size of values stored in the data offset field.
+	dataInline = 0x80000000
+	// maxHiveSize limits the size of hives read into memory.
+	maxHiveSize = 512 << 20
+	// maxListDepth limits nesting of index roots (ri).
+	maxListDepth = 4
+
+	regSZ       = 1
+	regExpandSZ = 2
+	regDWORD    = 4
+)
+
+// hive is a read-only Windows registry hive. Transaction logs are not applied.
+type hive struct {
+	data []byte
+	root uint32
+}
+
+// registryKey is a key (nk cell) of a hive.
+type registryKey struct {
+	h    *hive
+	cell []byte
+}
+
+func openHive(hivePath string) (*hive, error) {
+	fi, err := os.Stat(hivePath)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() > maxHiveSize {
+		return nil, fmt.Errorf("hive is too big: %d bytes", fi.Size())
+	}
+
+	data, err := ioutil.ReadFile(hivePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < hiveBinsOffset || !bytes.Equal(data[:4], []byte("regf")) {
+		return nil, fmt.Errorf("not a registry hive")
+	}
+
+	return &hive{data: data, root: binary.LittleEndian.Uint32(data[0x24:])}, nil
+}
+
+// cell returns data of a cell at a given offset, without the size field.
+func (h *hive) cell(offset uint32) ([]byte, error) {
+	start := int64(hiveBinsOffset) + int64(offset)
+	if start+4 > int64(len(h.data)) {
+		return nil, fmt.Errorf("cell offset 0x%x is outside of the hive", offset)
+	}
+
+	// Allocated cells have negative size.
+	size := -int64(int32(binary.LittleEndian.Uint32(h.data[start:])))
+	if size < 4 || start+size > int64(len(h.data)) {
+		return nil, fmt.Errorf("invalid size of cell at 0x%x", offset)
+	}
+
+	return h.data[start+4 : start+size], nil
+}
+
+func (h *hive) key(offset uint32) (*registryKey, error) {
+	cell, err := h.cell(offset)
+	if err != nil {
+		return nil, err
+	}
+	if len(cell) < 76 || !bytes.Equal(cell[:2], []byte("nk")) {
+		return nil, fmt.Errorf("cell at 0x%x is not a key", offset)
+	}
+
+	return &registryKey{h: h, cell: cell}, nil
+}
+
+// openKey returns key at a given path, separated by backslashes, relative to the root key. Names
+// are compared case-insensitively.
+func (h *hive) openKey(path string) (*registryKey, error) {
+	key, err := h.key(h.root)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range strings.Split(path, `\`) {
+		if key, err = key.subkey(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+func (k *registryKey) name() string {
+	length := int(binary.LittleEndian.Uint16(k.cell[72:]))
+	if 76+length > len(k.cell) {
+		return ""
+	}
+	name := k.cell[76 : 76+length]
+	if binary.LittleEndian.Uint16(k.cell[2:])&keyCompressedName != 0 {
+		return latin1(name)
+	}
+
+	return decodeUTF16(name)
+}
+
+// subkey returns a direct subkey with a given name.
+func (k *registryKey) subkey(name string) (*registryKey, error) {
+	if binary.LittleEndian.Uint32(k.cell[20:]) == 0 {
+		return nil, fmt.Errorf("key %s not found", name)
+	}
+
+	offsets, err := k.h.subkeyOffsets(binary.LittleEndian.Uint32(k.cell[28:]), 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, offset := range offsets {
+		subkey, err := k.h.key(offset)
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(subkey.name(), name) {
+			return subkey, nil
+		}
+	}
+
+	return nil, fmt.Errorf("key %s not found", name)
+}
+
+// subkeyOffsets returns offsets of keys in a subkey list. Index leaves (li) hold only the offsets,
+// fast and hash leaves (lf, lh) hold offsets followed by a hint, index roots (ri) hold offsets of
+// other lists.
+func (h *hive) subkeyOffsets(offset uint32, depth int) ([]uint32, error) {
+	cell, err := h.cell(offset)
+	if err != nil {
+		return nil, err
+	}
+	if len(cell) < 4 {
+		return nil, fmt.Errorf("invalid subkey list at 0x%x", offset)
+	}
+
+	count := int(binary.LittleEndian.Uint16(cell[2:]))
+	entrySize := 4
+	switch string(cell[:2]) {
+	case "lf", "lh":
+		entrySize = 8
+	case "li", "ri":
+	default:
+		return nil, fmt.Errorf("unknown subkey list type at 0x%x: %q", offset, cell[:2])
+	}
+	if 4+count*entrySize > len(cell) {
+		return nil, fmt.Errorf("subkey list at 0x%x is truncated", offset)
+	}
+
+	var offsets []uint32
+	for i := 0; i < count; i++ {
+		entry := binary.LittleEndian.Uint32(cell[4+i*entrySize:])
+		if string(cell[:2]) != "ri" {
+			offsets = append(offsets, entry)
+			continue
+		}
+		if depth >= maxListDepth {
+			return nil, fmt.Errorf("subkey lists are nested too deep")
+		}
+		nested, err := h.subkeyOffsets(entry, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		offsets = append(offsets, nested...)
+	}
+
+	return offsets, nil
+}
+
+// value returns the data of a value with a given name. REG_SZ and REG_EXPAND_SZ values are returned
+// as strings, REG_DWORD values as decimal numbers. Second return value is false if the value is
+// not present or has other type.
+func (k *registryKey) value(name string) (string, bool) {
+	count := int(binary.LittleEndian.Uint32(k.cell[36:]))
+	if count == 0 {
+		return "", false
+	}
+	list, err := k.h.cell(binary.LittleEndian.Uint32(k.cell[40:]))
+	if err != nil || count*4 > len(list) {
+		return "", false
+	}
+
+	for i := 0; i < count; i++ {
+		vk, err := k.h.cell(binary.LittleEndian.Uint32(list[i*4:]))
+		if err != nil || len(vk) < 20 || !bytes.Equal(vk[:2], []byte("vk")) {
+			continue
+		}
+
+		nameLength := int(binary.LittleEndian.Uint16(vk[2:]))
+		if 20+nameLength > len(vk) {
+			continue
+		}
+		valueName := vk[20 : 20+nameLength]
+		if binary.LittleEndian.Uint16(vk[16:])&valueCompressedName != 0 {
+			if !strings.EqualFold(latin1(valueName), name) {
+				continue
+			}
+		} else if !strings.EqualFold(decodeUTF16(valueName), name) {
+			continue
+		}
+
+		data, ok := k.h.valueData(vk)
+		if !ok {
+			return "", false
+		}
+		switch binary.LittleEndian.Uint32(vk[12:]) {
+		case regSZ, regExpandSZ:
+			s := decodeUTF16(data)
+			if i := strings.IndexByte(s, 0); i >= 0 {
+				s = s[:i]
+			}
+			return s, true
+		case regDWORD:
+			if len(data) < 4 {
+				return "", false
+			}
+			return strconv.FormatUint(uint64(binary.LittleEndian.Uint32(data)), 10), true
+		}
+		return "", false
+	}
+
+	return "", false
+}
+
+// valueData returns data of a value. Data of up to 4 bytes is stored in the offset field, big data
+// (db) records used for values over 16344 bytes are not supported.
+func (h *hive) valueData(vk []byte) ([]byte, bool) {
+	size := binary.LittleEndian.Uint32(vk[4:])
+	if size&dataInline != 0 {
+		size &^= dataInline
+		if size > 4 {
+			return nil, false
+		}
+		return vk[8 : 8+size], true
+	}
+
+	data, err := h.cell(binary.LittleEndian.Uint32(vk[8:]))
+	if err != nil || int(size) > len(data) {
+		return nil, false
+	}
+
+	return data[:size], true
+}
+
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+
+	return string(runes)
+}
+
+func decodeUTF16(b []byte) string {
+	chars := make([]uint16, len(b)/2)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+
+	return string(utf16.Decode(chars))
+}
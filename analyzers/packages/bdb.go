@@ -0,0 +1,178 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packages
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// Berkeley DB hash database constants, see dbinc/db_page.h.
+const (
+	hashMagic = 0x061561
+
+	pageHeaderSize = 26
+
+	pageTypeHashUnsorted = 2
+	pageTypeOverflow     = 7
+	pageTypeHash         = 13
+
+	itemTypeKeyData = 1
+	itemTypeOffPage = 3
+
+	// maxValueSize limits the size of a single value, RPM headers are well below it.
+	maxValueSize = 64 << 20
+)
+
+// hashDB is a read-only Berkeley DB database using the hash access method.
+type hashDB struct {
+	f         *os.File
+	byteOrder binary.ByteOrder
+	pageSize  uint32
+	lastPage  uint32
+}
+
+// readHashDB returns all values stored in a Berkeley DB hash database. Values are read from hash
+// pages in their physical order, keys are ignored.
+func readHashDB(dbPath string) ([][]byte, error) {
+	f, err := os.Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	db := &hashDB{f: f}
+	meta := make([]byte, 512)
+	if _, err := f.ReadAt(meta, 0); err != nil {
+		return nil, fmt.Errorf("could not read metadata page: %v", err)
+	}
+	// Database uses byte order of the machine that created it.
+	switch {
+	case binary.LittleEndian.Uint32(meta[12:]) == hashMagic:
+		db.byteOrder = binary.LittleEndian
+	case binary.BigEndian.Uint32(meta[12:]) == hashMagic:
+		db.byteOrder = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("not a Berkeley DB hash database")
+	}
+	if meta[24] != 0 {
+		return nil, fmt.Errorf("encrypted databases are not supported")
+	}
+	db.pageSize = db.byteOrder.Uint32(meta[20:])
+	db.lastPage = db.byteOrder.Uint32(meta[32:])
+	if db.pageSize < 512 || db.pageSize > 64*1024 {
+		return nil, fmt.Errorf("invalid page size: %d", db.pageSize)
+	}
+
+	var values [][]byte
+	for pgno := uint32(1); pgno <= db.lastPage; pgno++ {
+		page, err := db.page(pgno)
+		if err != nil {
+			return nil, err
+		}
+		if page[25] != pageTypeHash && page[25] != pageTypeHashUnsorted {
+			continue
+		}
+
+		pageValues, err := db.hashPageValues(page)
+		if err != nil {
+			return nil, fmt.Errorf("could not read page %d: %v", pgno, err)
+		}
+		values = append(values, pageValues...)
+	}
+
+	return values, nil
+}
+
+func (db *hashDB) page(pgno uint32) ([]byte, error) {
+	page := make([]byte, db.pageSize)
+	if _, err := db.f.ReadAt(page, int64(pgno)*int64(db.pageSize)); err != nil {
+		return nil, fmt.Errorf("could not read page %d: %v", pgno, err)
+	}
+
+	return page, nil
+}
+
+// hashPageValues returns values of key/value pairs stored in a hash page. Items are stored from the
+// end of the page, their offsets follow the page header.
+func (db *hashDB) hashPageValues(page []byte) ([][]byte, error) {
+	entries := int(db.byteOrder.Uint16(page[20:]))
+	if pageHeaderSize+2*entries > len(page) {
+		return nil, fmt.Errorf("invalid number of entries: %d", entries)
+	}
+
+	var values [][]byte
+	// Keys and values alternate, values have odd indexes.
+	for i := 1; i < entries; i += 2 {
+		start := int(db.byteOrder.Uint16(page[pageHeaderSize+2*i:]))
+		end := int(db.byteOrder.Uint16(page[pageHeaderSize+2*(i-1):]))
+		if start < pageHeaderSize || end > len(page) || start >= end {
+			return nil, fmt.Errorf("invalid item offset: %d", start)
+		}
+		item := page[start:end]
+
+		switch item[0] {
+		case itemTypeKeyData:
+			values = append(values, item[1:])
+		case itemTypeOffPage:
+			if len(item) < 12 {
+				return nil, fmt.Errorf("truncated off-page item")
+			}
+			value, err := db.overflowValue(db.byteOrder.Uint32(item[4:]), db.byteOrder.Uint32(item[8:]))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+	}
+
+	return values, nil
+}
+
+// overflowValue reads a value stored in a chain of overflow pages.
+func (db *hashDB) overflowValue(pgno, size uint32) ([]byte, error) {
+	if size > maxValueSize {
+		return nil, fmt.Errorf("value is too big: %d bytes", size)
+	}
+
+	value := make([]byte, 0, size)
+	for pgno != 0 && uint32(len(value)) < size {
+		if pgno > db.lastPage {
+			return nil, fmt.Errorf("invalid overflow page: %d", pgno)
+		}
+		page, err := db.page(pgno)
+		if err != nil {
+			return nil, err
+		}
+		if page[25] != pageTypeOverflow {
+			return nil, fmt.Errorf("page %d is not an overflow page", pgno)
+		}
+
+		// Number of bytes stored in an overflow page is kept in the hf_offset field.
+		n := int(db.byteOrder.Uint16(page[22:]))
+		if pageHeaderSize+n > len(page) {
+			return nil, fmt.Errorf("invalid overflow page length: %d", n)
+		}
+		value = append(value, page[pageHeaderSize:pageHeaderSize+n]...)
+		pgno = db.byteOrder.Uint32(page[16:])
+	}
+
+	if uint32(len(value)) != size {
+		return nil, fmt.Errorf("overflow value is truncated: %d of %d bytes", len(value), size)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packages
+
+import (
+	"bufio"
+	"crypto"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/hashr/common"
+
+	"pault.ag/go/debian/control"
+)
+
+// readDpkg reads files owned by installed packages from dpkg status file and the package file
+// lists and MD5 sums stored next to it in the info/ directory.
+func readDpkg(statusPath string) (map[string]*packageFile, error) {
+	f, err := os.Open(statusPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader, err := control.NewParagraphReader(f, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	infoDir := filepath.Join(filepath.Dir(statusPath), "info")
+	files := make(map[string]*packageFile)
+	for {
+		paragraph, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		status := strings.Fields(paragraph.Values["Status"])
+		if len(status) == 0 || status[len(status)-1] == "not-installed" {
+			continue
+		}
+
+		owner := common.PackageOwner{
+			Manager:      dpkg,
+			Package:      paragraph.Values["Package"],
+			Version:      paragraph.Values["Version"],
+			Architecture: paragraph.Values["Architecture"],
+		}
+		add := func(path, digest string, config bool) {
+			file := &packageFile{owner: owner, hash: crypto.MD5, digest: digest}
+			file.owner.Config = config
+			files[path] = file
+		}
+
+		// Info files of Multi-Arch: same packages are qualified with the architecture.
+		names := []string{owner.Package + ":" + owner.Architecture, owner.Package}
+
+		// Files listed without MD5 sum (e.g. symbolic links) are owned by the package as well.
+		for _, line := range readInfoFile(infoDir, names, ".list") {
+			if strings.HasPrefix(line, "/") {
+				add(line, "", false)
+			}
+		}
+
+		// Each line holds MD5 sum and path without the leading slash, separated by two spaces.
+		for _, line := range readInfoFile(infoDir, names, ".md5sums") {
+			s := strings.SplitN(line, "  ", 2)
+			if len(s) == 2 {
+				add("/"+strings.TrimPrefix(s[1], "/"), strings.ToLower(s[0]), false)
+			}
+		}
+
+		// Configuration files are not part of md5sums, their MD5 sums are kept in the status file.
+		// Sums of files that weren't installed yet are set to "newconffile".
+		for _, line := range strings.Split(paragraph.Values["Conffiles"], "\n") {
+			s := strings.Fields(line)
+			if len(s) < 2 {
+				continue
+			}
+			digest := s[1]
+			if len(digest) != 32 {
+				digest = ""
+			}
+			add(s[0], digest, true)
+		}
+	}
+
+	return files, nil
+}
+
+// readInfoFile returns lines of the first existing info file of a package.
+func readInfoFile(infoDir string, names []string, ext string) []string {
+	for _, name := range names {
+		f, err := os.Open(filepath.Join(infoDir, name+ext))
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+
+		var lines []string
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+
+	return nil
+}
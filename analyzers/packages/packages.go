@@ -0,0 +1,226 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package packages implements analyzer mapping files extracted from disk images to packages that
+// own them, according to dpkg and RPM databases found in the same image.
+package packages
+
+import (
+	"context"
+	"crypto"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/google/hashr/common"
+)
+
+// Name contains name of the analyzer.
+const Name = "packages"
+
+// Package managers.
+const (
+	dpkg = "dpkg"
+	rpm  = "rpm"
+)
+
+// databases maps paths of package databases, relative to the root of a file system, to functions
+// reading them. A file system holding both the SQLite and Berkeley DB RPM database is read from the
+// first one found.
+var databases = []struct {
+	manager string
+	path    string
+	read    func(string) (map[string]*packageFile, error)
+}{
+	{dpkg, "var/lib/dpkg/status", readDpkg},
+	{rpm, "usr/lib/sysimage/rpm/rpmdb.sqlite", readRPMSQLite},
+	{rpm, "var/lib/rpm/rpmdb.sqlite", readRPMSQLite},
+	{rpm, "var/lib/rpm/Packages", readRPMBerkeleyDB},
+}
+
+// packageFile holds a file listed in a package database.
+type packageFile struct {
+	owner common.PackageOwner
+	// hash and digest are the algorithm and hex encoded hash of the file, as recorded in the
+	// database. Digest is empty if it's not known.
+	hash   crypto.Hash
+	digest string
+}
+
+// fileSystem holds files owned by packages of a single file system, e.g. partition of a disk
+// image.
+type fileSystem struct {
+	// root is the path of the file system root, with trailing slash.
+	root    string
+	manager string
+	files   map[string]*packageFile
+}
+
+// Analyzer is an instance of packages analyzer.
+type Analyzer struct {
+}
+
+// New returns new packages analyzer instance.
+func New() *Analyzer {
+	return &Analyzer{}
+}
+
+// Name returns analyzer name.
+func (a *Analyzer) Name() string {
+	return Name
+}
+
+// Analyze sets owners of sample paths located in file systems that hold a dpkg or RPM database.
+// Paths not owned by any package are recorded without the package name, hashes of owned files are
+// compared with the ones recorded in the database. Samples from sources without package database
+// are left as they are.
+func (a *Analyzer) Analyze(ctx context.Context, samples []common.Sample) error {
+	fileSystems := readDatabases(samples)
+	if len(fileSystems) == 0 {
+		return nil
+	}
+
+	for i := range samples {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Hashes are calculated once per sample, as all its paths have the same content.
+		hashes := map[crypto.Hash]string{crypto.SHA256: samples[i].Sha256}
+		for _, path := range samples[i].Paths {
+			fs := fileSystemOf(fileSystems, path)
+			if fs == nil {
+				continue
+			}
+
+			owner := &common.PackageOwner{Manager: fs.manager}
+			if file, ok := fs.lookup("/" + strings.TrimPrefix(path, fs.root)); ok {
+				*owner = file.owner
+				if file.digest != "" {
+					digest, err := sampleHash(samples[i], file.hash, hashes)
+					if err != nil {
+						glog.Warningf("Could not hash %s: %v", path, err)
+					} else {
+						owner.Modified = digest != file.digest
+					}
+				}
+			}
+
+			if samples[i].Packages == nil {
+				samples[i].Packages = make(map[string]*common.PackageOwner)
+			}
+			samples[i].Packages[path] = owner
+		}
+	}
+
+	return nil
+}
+
+// readDatabases finds package databases among sample paths and reads them.
+func readDatabases(samples []common.Sample) []*fileSystem {
+	var fileSystems []*fileSystem
+	seen := make(map[string]bool)
+	for _, db := range databases {
+		for _, sample := range samples {
+			for _, path := range sample.Paths {
+				if !strings.HasSuffix(path, "/"+db.path) {
+					continue
+				}
+				root := strings.TrimSuffix(path, db.path)
+				if seen[root+db.manager] {
+					continue
+				}
+
+				files, err := db.read(path)
+				if err != nil {
+					glog.Warningf("Could not read %s database %s: %v", db.manager, path, err)
+					continue
+				}
+				seen[root+db.manager] = true
+				glog.Infof("Found %d files owned by %s packages in %s", len(files), db.manager, path)
+				fileSystems = append(fileSystems, &fileSystem{root: root, manager: db.manager, files: files})
+			}
+		}
+	}
+
+	// Nested file systems (e.g. container layers) take precedence over the ones containing them.
+	sort.SliceStable(fileSystems, func(i, j int) bool {
+		return len(fileSystems[i].root) > len(fileSystems[j].root)
+	})
+
+	return fileSystems
+}
+
+// fileSystemOf returns the file system containing a given path, nil if there's none.
+func fileSystemOf(fileSystems []*fileSystem, path string) *fileSystem {
+	for _, fs := range fileSystems {
+		if strings.HasPrefix(path, fs.root) {
+			return fs
+		}
+	}
+
+	return nil
+}
+
+// lookup returns package file with a given absolute path. Files in /usr/bin, /usr/sbin and
+// /usr/lib* are also looked up without the /usr prefix, as packages built before the /usr merge
+// install them to /bin, /sbin and /lib*.
+func (fs *fileSystem) lookup(path string) (*packageFile, bool) {
+	if file, ok := fs.files[path]; ok {
+		return file, true
+	}
+
+	for _, dir := range []string{"/usr/bin/", "/usr/sbin/", "/usr/lib"} {
+		if strings.HasPrefix(path, dir) {
+			file, ok := fs.files[strings.TrimPrefix(path, "/usr")]
+			return file, ok
+		}
+	}
+
+	return nil, false
+}
+
+// sampleHash returns hex encoded hash of a sample, calculated hashes are cached in hashes.
+func sampleHash(sample common.Sample, hash crypto.Hash, hashes map[crypto.Hash]string) (string, error) {
+	if digest, ok := hashes[hash]; ok {
+		return digest, nil
+	}
+	if !hash.Available() {
+		return "", fmt.Errorf("unsupported hash algorithm: %v", hash)
+	}
+
+	var err error
+	for _, path := range sample.Paths {
+		var f *os.File
+		f, err = os.Open(path)
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+
+		h := hash.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+		hashes[hash] = hex.EncodeToString(h.Sum(nil))
+		return hashes[hash], nil
+	}
+
+	return "", fmt.Errorf("no valid path: %v", err)
+}
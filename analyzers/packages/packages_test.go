@@ -0,0 +1,169 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packages
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/hashr/common"
+)
+
+// copyTree copies files from src to dst and returns samples, one per file.
+func copyTree(t *testing.T, src, dst string) []common.Sample {
+	t.Helper()
+	var samples []common.Sample
+	err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		samples = append(samples, writeSample(t, filepath.Join(dst, relPath), data))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return samples
+}
+
+func writeSample(t *testing.T, path string, data []byte) common.Sample {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return common.Sample{Sha256: fmt.Sprintf("%x", sha256.Sum256(data)), Paths: []string{path}}
+}
+
+func TestAnalyze(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "extracted", "export")
+	var samples []common.Sample
+	for partition, tree := range map[string]string{"p1": "debian", "p2": "centos", "p3": "fedora"} {
+		samples = append(samples, copyTree(t, filepath.Join("testdata", tree), filepath.Join(root, partition))...)
+	}
+	// Info files of Multi-Arch: same packages hold the architecture, which can't be part of file
+	// names in Go modules.
+	samples = append(samples, writeSample(t, filepath.Join(root, "p1/var/lib/dpkg/info/libfoo1:amd64.md5sums"),
+		[]byte("c157a79031e1c40f85931829bc5fc552  usr/lib/x86_64-linux-gnu/libfoo.so.1\n")))
+	// Files outside of file systems with package database are left as they are.
+	samples = append(samples, writeSample(t, filepath.Join(root, "p4/README"), []byte("readme\n")))
+
+	if err := New().Analyze(context.Background(), samples); err != nil {
+		t.Fatalf("unexpected error while running Analyze(): %v", err)
+	}
+
+	got := make(map[string]*common.PackageOwner)
+	for _, sample := range samples {
+		for path, owner := range sample.Packages {
+			got[strings.TrimPrefix(path, root+"/")] = owner
+		}
+	}
+
+	unowned := func(manager string) *common.PackageOwner {
+		return &common.PackageOwner{Manager: manager}
+	}
+	hello := common.PackageOwner{Manager: "dpkg", Package: "hello", Version: "2.10-2", Architecture: "amd64"}
+	simple := common.PackageOwner{Manager: "rpm", Package: "simple", Version: "1.0.1-1", Architecture: "i386"}
+	oneEpoch := &common.PackageOwner{Manager: "rpm", Package: "one-epoch", Version: "1:0.1-1", Architecture: "x86_64"}
+	with := func(owner common.PackageOwner, config, modified bool) *common.PackageOwner {
+		owner.Config = config
+		owner.Modified = modified
+		return &owner
+	}
+
+	want := map[string]*common.PackageOwner{
+		"p1/usr/bin/hello": with(hello, false, false),
+		// Configuration file was changed after installation.
+		"p1/etc/hello.conf": with(hello, true, true),
+		// Package installs the file to /bin, which is a symbolic link to /usr/bin.
+		"p1/usr/bin/legacy":                          with(hello, false, false),
+		"p1/usr/lib/x86_64-linux-gnu/libfoo.so.1":    {Manager: "dpkg", Package: "libfoo1", Version: "1:1.0-1", Architecture: "amd64", Modified: true},
+		"p1/usr/bin/old":                             unowned("dpkg"),
+		"p1/usr/local/bin/extra":                     unowned("dpkg"),
+		"p1/var/lib/dpkg/status":                     unowned("dpkg"),
+		"p1/var/lib/dpkg/info/hello.list":            unowned("dpkg"),
+		"p1/var/lib/dpkg/info/hello.md5sums":         unowned("dpkg"),
+		"p1/var/lib/dpkg/info/oldpkg.list":           unowned("dpkg"),
+		"p1/var/lib/dpkg/info/libfoo1:amd64.md5sums": unowned("dpkg"),
+		// Berkeley DB database.
+		"p2/config":                  with(simple, true, true),
+		"p2/normal":                  with(simple, false, false),
+		"p2/usr/share/one-epoch.txt": oneEpoch,
+		"p2/usr/local/bin/extra":     unowned("rpm"),
+		"p2/var/lib/rpm/Packages":    unowned("rpm"),
+		// SQLite database.
+		"p3/config":                            with(simple, true, false),
+		"p3/normal":                            with(simple, false, false),
+		"p3/usr/share/one-epoch.txt":           oneEpoch,
+		"p3/usr/lib/sysimage/rpm/rpmdb.sqlite": unowned("rpm"),
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Analyze() unexpected diff (-want/+got):\n%s", diff)
+	}
+}
+
+func TestAnalyzeWithoutDatabase(t *testing.T) {
+	samples := []common.Sample{writeSample(t, filepath.Join(t.TempDir(), "extracted", "usr/bin/hello"), []byte("hello\n"))}
+
+	if err := New().Analyze(context.Background(), samples); err != nil {
+		t.Fatalf("unexpected error while running Analyze(): %v", err)
+	}
+	if samples[0].Packages != nil {
+		t.Errorf("Analyze() = %v, want no package owners", samples[0].Packages)
+	}
+}
+
+func TestReadHashDB(t *testing.T) {
+	bdb, err := readRPMBerkeleyDB("testdata/centos/var/lib/rpm/Packages")
+	if err != nil {
+		t.Fatalf("unexpected error while running readRPMBerkeleyDB(): %v", err)
+	}
+	sqlite, err := readRPMSQLite("testdata/fedora/usr/lib/sysimage/rpm/rpmdb.sqlite")
+	if err != nil {
+		t.Fatalf("unexpected error while running readRPMSQLite(): %v", err)
+	}
+
+	// Both databases hold the same packages.
+	if diff := cmp.Diff(sqlite, bdb, cmp.AllowUnexported(packageFile{})); diff != "" {
+		t.Errorf("readRPMBerkeleyDB() unexpected diff (-sqlite/+bdb):\n%s", diff)
+	}
+	if len(bdb) != 3 {
+		t.Errorf("readRPMBerkeleyDB() returned %d files, want 3", len(bdb))
+	}
+
+	if _, err := readHashDB("testdata/fedora/usr/lib/sysimage/rpm/rpmdb.sqlite"); err == nil {
+		t.Error("readHashDB() expected error for a file that is not a Berkeley DB database")
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packages
+
+import (
+	"bytes"
+	"crypto"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/google/hashr/common"
+
+	rpmutils "github.com/sassoftware/go-rpmutils"
+
+	// Register SQLite driver.
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// rpmFileConfig is the RPMFILE_CONFIG bit of file flags.
+	rpmFileConfig = 1 << 0
+	// headerMagic starts RPM header structure, it's not stored in the database.
+	headerMagic = 0x8eade801
+	// leadMagic starts RPM package files.
+	leadMagic = 0xedabeedb
+)
+
+// rpmDigestAlgorithms maps values of RPMTAG_FILEDIGESTALGO to hash algorithms.
+var rpmDigestAlgorithms = map[int]crypto.Hash{
+	1:  crypto.MD5,
+	2:  crypto.SHA1,
+	8:  crypto.SHA256,
+	9:  crypto.SHA384,
+	10: crypto.SHA512,
+	11: crypto.SHA224,
+}
+
+// readRPMSQLite reads files owned by installed packages from RPM database in SQLite format, used
+// since RPM 4.16.
+func readRPMSQLite(dbPath string) (map[string]*packageFile, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, err
+	}
+
+	// Database is opened as immutable, so SQLite doesn't write journal files to the extraction.
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro&immutable=1", dbPath))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT blob FROM Packages`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var headers [][]byte
+	for rows.Next() {
+		var blob []byte
+		if err := rows.Scan(&blob); err != nil {
+			return nil, err
+		}
+		headers = append(headers, blob)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rpmFiles(headers), nil
+}
+
+// readRPMBerkeleyDB reads files owned by installed packages from RPM database in Berkeley DB
+// format, used by older distributions (e.g. CentOS 7 or Amazon Linux 2).
+func readRPMBerkeleyDB(dbPath string) (map[string]*packageFile, error) {
+	values, err := readHashDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpmFiles(values), nil
+}
+
+// rpmFiles returns files of packages with given headers. Headers that can't be parsed are skipped.
+func rpmFiles(headers [][]byte) map[string]*packageFile {
+	files := make(map[string]*packageFile)
+	for _, blob := range headers {
+		// Berkeley DB holds the last used package number under key 0.
+		if len(blob) < 8 {
+			continue
+		}
+
+		hdr, err := parseRPMHeader(blob)
+		if err != nil {
+			glog.Warningf("Could not parse RPM header: %v", err)
+			continue
+		}
+
+		nevra, err := hdr.GetNEVRA()
+		if err != nil {
+			glog.Warningf("Could not get RPM package name: %v", err)
+			continue
+		}
+		version := nevra.Version + "-" + nevra.Release
+		if nevra.Epoch != "" && nevra.Epoch != "0" {
+			version = nevra.Epoch + ":" + version
+		}
+		owner := common.PackageOwner{Manager: rpm, Package: nevra.Name, Version: version, Architecture: nevra.Arch}
+
+		// Packages without digest algorithm use MD5.
+		hash := crypto.MD5
+		if algos, err := hdr.GetInts(rpmutils.FILEDIGESTALGO); err == nil && len(algos) > 0 {
+			var ok bool
+			if hash, ok = rpmDigestAlgorithms[algos[0]]; !ok {
+				glog.Warningf("Unknown file digest algorithm of %s: %d", nevra.Name, algos[0])
+			}
+		}
+
+		pkgFiles, err := hdr.GetFiles()
+		if err != nil {
+			glog.Warningf("Could not get files of %s: %v", nevra.Name, err)
+			continue
+		}
+		for _, fi := range pkgFiles {
+			if fi.Mode()&0170000 == 0040000 {
+				continue
+			}
+
+			file := &packageFile{owner: owner, hash: hash, digest: strings.ToLower(fi.Digest())}
+			file.owner.Config = fi.Flags()&rpmFileConfig != 0
+			if file.hash == 0 {
+				file.digest = ""
+			}
+			files[fi.Name()] = file
+		}
+	}
+
+	return files
+}
+
+// parseRPMHeader parses a header stored in RPM database. The database holds only the main header
+// without its magic, so it's wrapped in a lead and an empty signature header to be read like a
+// package file.
+func parseRPMHeader(blob []byte) (*rpmutils.RpmHeader, error) {
+	var buf bytes.Buffer
+	lead := make([]byte, 96)
+	binary.BigEndian.PutUint32(lead, leadMagic)
+	buf.Write(lead)
+	for _, v := range []uint32{headerMagic, 0, 0, 0, headerMagic, 0} {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	buf.Write(blob)
+
+	return rpmutils.ReadHeader(&buf)
+}
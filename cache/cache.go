@@ -54,6 +54,21 @@ func readJSON(extraction *common.Extraction) ([]common.Sample, error) {
 	return samples, nil
 }
 
+// relativePaths returns sample paths relative to the extraction root.
+func relativePaths(extraction *common.Extraction, paths []string) []string {
+	var relPaths []string
+	for _, path := range paths {
+		relPath, ok := extraction.RelativePath(path)
+		if !ok {
+			glog.Warningf("sample path does not follow expected format: %s", path)
+			continue
+		}
+		relPaths = append(relPaths, relPath)
+	}
+
+	return relPaths
+}
+
 // Save saves the cache to a local file.
 func Save(repoName, cacheDir string, cacheMap *sync.Map) error {
 	// TODO(mlegin): Compress the file before saving it to disk.
@@ -95,32 +110,23 @@ func Save(repoName, cacheDir string, cacheMap *sync.Map) error {
 	return nil
 }
 
-// Load reads cache entries from a file stored locally. If the file is not present, the cache is
-// created in memory.
+// Load reads cache entries from a file stored locally. Missing, unreadable or corrupted cache
+// files are reported as errors.
 func Load(repoName, cacheDir string) (*sync.Map, error) {
 	var cacheMap sync.Map
 	cachePath := filepath.Join(cacheDir, fmt.Sprintf("hashr-cache-%s", repoName))
 	if _, err := os.Stat(cachePath); os.IsNotExist(err) {
-		glog.Infof("Cache for %s repo not found at %s. Creating new cache in memory.", repoName, cachePath)
-		return &cacheMap, nil
+		return nil, fmt.Errorf("cache for %s repo not found at %s", repoName, cachePath)
 	}
 
 	data, err := ioutil.ReadFile(cachePath)
 	if err != nil {
-		// If there is an error while reading the file it might be corrupted.
-		if err := os.Remove(cachePath); err != nil {
-			return nil, fmt.Errorf("error while trying to delete the %s repo cache file: %v", repoName, err)
-		}
-		return &cacheMap, nil
+		return nil, fmt.Errorf("error while reading %s repo cache file: %v", repoName, err)
 	}
 
 	cache := &cpb.Cache{}
 	if err := proto.Unmarshal(data, cache); err != nil {
-		// If there is an error while unmarshalling the file it might be corrupted.
-		if err := os.Remove(cachePath); err != nil {
-			return nil, fmt.Errorf("error while trying to delete the %s repo cache file: %v", repoName, err)
-		}
-		return &cacheMap, nil
+		return nil, fmt.Errorf("error while unmarshalling %s repo cache file: %v", repoName, err)
 	}
 	glog.Infof("Successfully loaded cache for %s repo from %s.", repoName, cachePath)
 
@@ -131,6 +137,18 @@ func Load(repoName, cacheDir string) (*sync.Map, error) {
 	return &cacheMap, nil
 }
 
+// LoadOrNew reads cache entries like Load. If the file is not present or can't be loaded, the
+// cache is created in memory and the file is replaced on the next Save.
+func LoadOrNew(repoName, cacheDir string) *sync.Map {
+	cacheMap, err := Load(repoName, cacheDir)
+	if err != nil {
+		glog.Warningf("%v. Creating new cache in memory.", err)
+		return &sync.Map{}
+	}
+
+	return cacheMap
+}
+
 // Check checks if files present in a given extraction are already in the local cache.
 func Check(extraction *common.Extraction, cache *sync.Map) ([]common.Sample, error) {
 	samples, err := readJSON(extraction)
@@ -143,6 +161,7 @@ func Check(extraction *common.Extraction, cache *sync.Map) ([]common.Sample, err
 		newCacheEntry := &cpb.CacheEntry{
 			SourceId:   extraction.SourceID,
 			SourceHash: extraction.SourceSHA256,
+			Path:       relativePaths(extraction, sample.Paths),
 		}
 		newExport := common.Sample{
 			Sha256: sample.Sha256,
@@ -15,6 +15,8 @@
 package cache
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
 	"sync"
 	"testing"
@@ -293,3 +295,83 @@ func TestLoad(t *testing.T) {
 		t.Errorf("Load(\"gLinux\", %s) unexpected diff (-want/+got):\n%s", testdataPath, diff)
 	}
 }
+
+func TestLoadInvalid(t *testing.T) {
+	cacheDir := t.TempDir()
+	if _, err := Load("gLinux", cacheDir); err == nil {
+		t.Error("Load() of a missing cache file expected error, got nil")
+	}
+
+	cachePath := filepath.Join(cacheDir, "hashr-cache-gLinux")
+	if err := os.WriteFile(cachePath, []byte("corrupted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load("gLinux", cacheDir); err == nil {
+		t.Error("Load() of a corrupted cache file expected error, got nil")
+	}
+	if _, err := os.Stat(cachePath); err != nil {
+		t.Errorf("Load() removed the corrupted cache file: %v", err)
+	}
+
+	var samples int
+	LoadOrNew("gLinux", cacheDir).Range(func(key, value interface{}) bool {
+		samples++
+		return true
+	})
+	if samples != 0 {
+		t.Errorf("LoadOrNew() of a corrupted cache file returned %d samples, want 0", samples)
+	}
+}
+
+func TestCheckPaths(t *testing.T) {
+	extraction := &common.Extraction{
+		SourceID:     "20200227.00.00-desktop",
+		RepoName:     "gLinux",
+		Path:         testdataPath,
+		SourceSHA256: "6e0290d62f6db1779d6318df50209de8c9b93adb29b7dd46e7b563f044103b40",
+	}
+
+	var cacheMap sync.Map
+	if _, err := Check(extraction, &cacheMap); err != nil {
+		t.Fatalf("unexpected error while checking cache: %v", err)
+	}
+
+	value, ok := cacheMap.Load("d5d66fe6a4559c59ad103ab40e01c4fc0df7eb8ba901d50e5ceae3909b2e0d61")
+	if !ok {
+		t.Fatal("Check() did not add a new sample to the cache")
+	}
+
+	wantEntries := []*cpb.CacheEntry{
+		{
+			SourceId:   "20200227.00.00-desktop",
+			SourceHash: "6e0290d62f6db1779d6318df50209de8c9b93adb29b7dd46e7b563f044103b40",
+			Path:       []string{"gLinuxTestRepo/20200227.00.00/export/file.09"},
+		},
+	}
+	if diff := cmp.Diff(wantEntries, value.(*cpb.Entries).GetEntries(), protocmp.Transform()); diff != "" {
+		t.Errorf("Check() unexpected cache entries diff (-want/+got):\n%s", diff)
+	}
+
+	for _, tc := range []struct {
+		path string
+		want []string
+	}{
+		{
+			path: "/tmp/hashr-ubuntu-123/export/tmp/hashr-ubuntu-123/extracted/usr/bin/ls",
+			want: []string{"usr/bin/ls"},
+		},
+		{
+			path: "testdata/etc/passwd",
+			want: []string{"etc/passwd"},
+		},
+		{
+			// Paths outside of the extraction are skipped.
+			path: "other/etc/passwd",
+			want: nil,
+		},
+	} {
+		if got := relativePaths(extraction, []string{tc.path}); !cmp.Equal(tc.want, got) {
+			t.Errorf("relativePaths(%s) = %v; want = %v", tc.path, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/hashr/common"
+	"google.golang.org/protobuf/proto"
+
+	cpb "github.com/google/hashr/cache/proto"
+)
+
+// SourceStats holds number of samples seen in a given source.
+type SourceStats struct {
+	SourceID   string
+	SourceHash string
+	Samples    int
+}
+
+// Stats holds summary statistics of a repository cache.
+type Stats struct {
+	// Samples is the number of unique samples in the cache.
+	Samples int
+	// Entries is the total number of sample <-> source entries in the cache.
+	Entries int
+	// Sources contains per-source sample counts, sorted by number of samples.
+	Sources []*SourceStats
+	// MaxFanOut is the largest number of entries held by a single sample.
+	MaxFanOut int
+	// MaxFanOutSample is the hash of the sample with the largest number of entries.
+	MaxFanOutSample string
+}
+
+// PathMatch holds a single cache entry which path matched a lookup pattern.
+type PathMatch struct {
+	Sha256     string
+	SourceID   string
+	SourceHash string
+	Path       string
+}
+
+// jsonEntry is a JSON representation of a single cache entry.
+type jsonEntry struct {
+	SourceID   string   `json:"source_id"`
+	SourceHash string   `json:"source_hash"`
+	Paths      []string `json:"paths,omitempty"`
+}
+
+// jsonSample is a JSON representation of all cache entries of a given sample.
+type jsonSample struct {
+	Sha256      string       `json:"sha256"`
+	LastUpdated time.Time    `json:"last_updated"`
+	Entries     []*jsonEntry `json:"entries"`
+}
+
+func rangeEntries(cacheMap *sync.Map, fn func(hash string, entries *cpb.Entries) bool) {
+	cacheMap.Range(func(key, value interface{}) bool {
+		hash, ok := key.(string)
+		if !ok {
+			glog.Exitf("Unexpected key type in cache map: %v", key)
+		}
+
+		entries, ok := value.(*cpb.Entries)
+		if !ok {
+			glog.Exitf("Unexpected value type in cache map: %v", key)
+		}
+
+		return fn(hash, entries)
+	})
+}
+
+// Lookup returns cache entries of a given sample.
+func Lookup(cacheMap *sync.Map, hash string) (*cpb.Entries, bool) {
+	value, ok := cacheMap.Load(strings.ToLower(hash))
+	if !ok {
+		return nil, false
+	}
+
+	return value.(*cpb.Entries), true
+}
+
+// LookupPath returns cache entries which path matches a given pattern. The pattern is matched
+// against paths relative to the extraction root using path.Match syntax.
+func LookupPath(cacheMap *sync.Map, pattern string) ([]*PathMatch, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid path pattern %s: %v", pattern, err)
+	}
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var matches []*PathMatch
+	rangeEntries(cacheMap, func(hash string, entries *cpb.Entries) bool {
+		for _, entry := range entries.GetEntries() {
+			for _, p := range entry.GetPath() {
+				if ok, _ := path.Match(pattern, strings.TrimPrefix(p, "/")); ok {
+					matches = append(matches, &PathMatch{Sha256: hash, SourceID: entry.GetSourceId(), SourceHash: entry.GetSourceHash(), Path: p})
+				}
+			}
+		}
+		return true
+	})
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Path != matches[j].Path {
+			return matches[i].Path < matches[j].Path
+		}
+		if matches[i].Sha256 != matches[j].Sha256 {
+			return matches[i].Sha256 < matches[j].Sha256
+		}
+		return matches[i].SourceID < matches[j].SourceID
+	})
+
+	return matches, nil
+}
+
+// ComputeStats returns summary statistics of a given cache.
+func ComputeStats(cacheMap *sync.Map) *Stats {
+	stats := &Stats{}
+	sources := make(map[string]*SourceStats)
+
+	rangeEntries(cacheMap, func(hash string, entries *cpb.Entries) bool {
+		stats.Samples++
+		stats.Entries += len(entries.GetEntries())
+		if len(entries.GetEntries()) > stats.MaxFanOut {
+			stats.MaxFanOut = len(entries.GetEntries())
+			stats.MaxFanOutSample = hash
+		}
+
+		for _, entry := range entries.GetEntries() {
+			key := fmt.Sprintf("%s/%s", entry.GetSourceHash(), entry.GetSourceId())
+			if _, ok := sources[key]; !ok {
+				sources[key] = &SourceStats{SourceID: entry.GetSourceId(), SourceHash: entry.GetSourceHash()}
+			}
+			sources[key].Samples++
+		}
+
+		return true
+	})
+
+	for _, source := range sources {
+		stats.Sources = append(stats.Sources, source)
+	}
+	sort.Slice(stats.Sources, func(i, j int) bool {
+		if stats.Sources[i].Samples != stats.Sources[j].Samples {
+			return stats.Sources[i].Samples > stats.Sources[j].Samples
+		}
+		return stats.Sources[i].SourceID < stats.Sources[j].SourceID
+	})
+
+	return stats
+}
+
+// SourcePaths returns paths, relative to the extraction root, of all samples seen in a given
+// source. Duplicate paths, e.g. from reprocessing the same source, are returned once.
+func SourcePaths(cacheMap *sync.Map, sourceHash string) map[string][]string {
+	paths := make(map[string][]string)
+
+	rangeEntries(cacheMap, func(hash string, entries *cpb.Entries) bool {
+		seen := make(map[string]bool)
+		for _, entry := range entries.GetEntries() {
+			if !strings.EqualFold(entry.GetSourceHash(), sourceHash) {
+				continue
+			}
+			if _, ok := paths[hash]; !ok {
+				paths[hash] = []string{}
+			}
+			for _, p := range entry.GetPath() {
+				if relPath, ok := common.TrimExtractionRoot(p); ok {
+					p = relPath
+				}
+				if !seen[p] {
+					seen[p] = true
+					paths[hash] = append(paths[hash], p)
+				}
+			}
+		}
+		return true
+	})
+
+	return paths
+}
+
+// Merge adds entries from the src cache to the dst cache and returns the number of samples that
+// were not present in dst. Entries already present in dst are skipped.
+func Merge(dst, src *sync.Map) int {
+	var added int
+
+	rangeEntries(src, func(hash string, srcEntries *cpb.Entries) bool {
+		value, ok := dst.Load(hash)
+		if !ok {
+			dst.Store(hash, proto.Clone(srcEntries))
+			added++
+			return true
+		}
+
+		dstEntries := value.(*cpb.Entries)
+		for _, srcEntry := range srcEntries.GetEntries() {
+			var exists bool
+			for _, dstEntry := range dstEntries.GetEntries() {
+				if dstEntry.GetSourceHash() == srcEntry.GetSourceHash() && dstEntry.GetSourceId() == srcEntry.GetSourceId() {
+					exists = true
+					break
+				}
+			}
+			if !exists {
+				dstEntries.Entries = append(dstEntries.Entries, proto.Clone(srcEntry).(*cpb.CacheEntry))
+			}
+		}
+
+		if srcEntries.GetLastUpdated().AsTime().After(dstEntries.GetLastUpdated().AsTime()) {
+			dstEntries.LastUpdated = srcEntries.GetLastUpdated()
+		}
+
+		return true
+	})
+
+	return added
+}
+
+// Prune removes samples that were last updated before a given time and returns the number of
+// removed samples.
+func Prune(cacheMap *sync.Map, before time.Time) int {
+	var pruned int
+
+	rangeEntries(cacheMap, func(hash string, entries *cpb.Entries) bool {
+		if entries.GetLastUpdated().AsTime().Before(before) {
+			cacheMap.Delete(hash)
+			pruned++
+		}
+		return true
+	})
+
+	return pruned
+}
+
+// WriteJSONL writes the content of the cache to w, one JSON object per sample, sorted by sample
+// hash.
+func WriteJSONL(w io.Writer, cacheMap *sync.Map) error {
+	var hashes []string
+	rangeEntries(cacheMap, func(hash string, entries *cpb.Entries) bool {
+		hashes = append(hashes, hash)
+		return true
+	})
+	sort.Strings(hashes)
+
+	encoder := json.NewEncoder(w)
+	for _, hash := range hashes {
+		entries, _ := Lookup(cacheMap, hash)
+		sample := &jsonSample{Sha256: hash, LastUpdated: entries.GetLastUpdated().AsTime().UTC()}
+		for _, entry := range entries.GetEntries() {
+			sample.Entries = append(sample.Entries, &jsonEntry{
+				SourceID:   entry.GetSourceId(),
+				SourceHash: entry.GetSourceHash(),
+				Paths:      entry.GetPath(),
+			})
+		}
+
+		if err := encoder.Encode(sample); err != nil {
+			return fmt.Errorf("error while writing %s cache entries: %v", hash, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,214 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	cpb "github.com/google/hashr/cache/proto"
+	tpb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func testCacheMap() *sync.Map {
+	var cacheMap sync.Map
+	for hash, entries := range cloneProtoMap(wantCacheSamples).(map[string]*cpb.Entries) {
+		cacheMap.Store(hash, entries)
+	}
+	return &cacheMap
+}
+
+func TestLookup(t *testing.T) {
+	cacheMap := testCacheMap()
+
+	gotEntries, ok := Lookup(cacheMap, "E0A98AD618A3CEF7F8754A2711322E398879F47E50CA491C75ECA6BA476E421A")
+	if !ok {
+		t.Fatal("Lookup() returned no entries for a sample present in the cache")
+	}
+
+	if diff := cmp.Diff(wantCacheSamples["e0a98ad618a3cef7f8754a2711322e398879f47e50ca491c75eca6ba476e421a"], gotEntries, protocmp.Transform()); diff != "" {
+		t.Errorf("Lookup() unexpected diff (-want/+got):\n%s", diff)
+	}
+
+	if _, ok := Lookup(cacheMap, "0000000000000000000000000000000000000000000000000000000000000000"); ok {
+		t.Error("Lookup() returned entries for a sample not present in the cache")
+	}
+}
+
+func TestComputeStats(t *testing.T) {
+	gotStats := ComputeStats(testCacheMap())
+
+	wantStats := &Stats{
+		Samples: 10,
+		Entries: 19,
+		Sources: []*SourceStats{
+			{SourceID: "20200108.00.00-ubuntu-desktop", SourceHash: "dcb3a5937e4e609ca8bae24bf79380a06f07d3b0ea08e8cf3b342ae7b1c3f149", Samples: 10},
+			{SourceID: "20200109.00.00-ubuntu-desktop", SourceHash: "5a3b7f7f65cdb332854432f6496b53da27ec6f6c25aa243da2c5822d8991c903", Samples: 9},
+		},
+		MaxFanOut: 2,
+	}
+
+	if diff := cmp.Diff(wantStats, gotStats, cmp.FilterPath(func(p cmp.Path) bool {
+		return p.String() == "MaxFanOutSample"
+	}, cmp.Ignore())); diff != "" {
+		t.Errorf("ComputeStats() unexpected diff (-want/+got):\n%s", diff)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	dst := testCacheMap()
+
+	var src sync.Map
+	src.Store("e0a98ad618a3cef7f8754a2711322e398879f47e50ca491c75eca6ba476e421a", &cpb.Entries{
+		LastUpdated: &tpb.Timestamp{Seconds: 1686286139},
+		Entries: []*cpb.CacheEntry{
+			{SourceId: "20200108.00.00-ubuntu-desktop", SourceHash: "dcb3a5937e4e609ca8bae24bf79380a06f07d3b0ea08e8cf3b342ae7b1c3f149"},
+			{SourceId: "20200110.00.00-ubuntu-desktop", SourceHash: "6e0290d62f6db1779d6318df50209de8c9b93adb29b7dd46e7b563f044103b40"},
+		},
+	})
+	src.Store("99962d9e62c15c73527ca72b4e5e85809d4254326800eb2c65b35339029e02d1", &cpb.Entries{
+		LastUpdated: &tpb.Timestamp{Seconds: 1686286139},
+		Entries: []*cpb.CacheEntry{
+			{SourceId: "20200110.00.00-ubuntu-desktop", SourceHash: "6e0290d62f6db1779d6318df50209de8c9b93adb29b7dd46e7b563f044103b40"},
+		},
+	})
+
+	if got := Merge(dst, &src); got != 1 {
+		t.Errorf("Merge() = %d; want = 1", got)
+	}
+
+	wantEntries := &cpb.Entries{
+		LastUpdated: &tpb.Timestamp{Seconds: 1686286139},
+		Entries: []*cpb.CacheEntry{
+			{
+				SourceId:   "20200108.00.00-ubuntu-desktop",
+				SourceHash: "dcb3a5937e4e609ca8bae24bf79380a06f07d3b0ea08e8cf3b342ae7b1c3f149",
+				Path:       []string{"/tmp/hashr-20200108.00.00-ubuntu-desktop-818523028/export/tmp/hashr-20200108.00.00-ubuntu-desktop-818523028/extracted/file.10"},
+			},
+			{SourceId: "20200110.00.00-ubuntu-desktop", SourceHash: "6e0290d62f6db1779d6318df50209de8c9b93adb29b7dd46e7b563f044103b40"},
+		},
+	}
+
+	gotEntries, _ := Lookup(dst, "e0a98ad618a3cef7f8754a2711322e398879f47e50ca491c75eca6ba476e421a")
+	if diff := cmp.Diff(wantEntries, gotEntries, protocmp.Transform()); diff != "" {
+		t.Errorf("Merge() unexpected diff (-want/+got):\n%s", diff)
+	}
+
+	if _, ok := Lookup(dst, "99962d9e62c15c73527ca72b4e5e85809d4254326800eb2c65b35339029e02d1"); !ok {
+		t.Error("Merge() did not add a new sample to the destination cache")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	cacheMap := testCacheMap()
+	cacheMap.Store("99962d9e62c15c73527ca72b4e5e85809d4254326800eb2c65b35339029e02d1", &cpb.Entries{
+		LastUpdated: tpb.New(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)),
+	})
+
+	if got := Prune(cacheMap, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)); got != 10 {
+		t.Errorf("Prune() = %d; want = 10", got)
+	}
+
+	if got := ComputeStats(cacheMap).Samples; got != 1 {
+		t.Errorf("ComputeStats().Samples after Prune() = %d; want = 1", got)
+	}
+}
+
+func TestWriteJSONL(t *testing.T) {
+	var cacheMap sync.Map
+	cacheMap.Store("b1f8a81821e18bba696a52b5169524076f77bc588c02ab195f969df4e2650dce", &cpb.Entries{
+		LastUpdated: &tpb.Timestamp{Seconds: 1586286139},
+		Entries: []*cpb.CacheEntry{
+			{SourceId: "20200109.00.00-ubuntu-desktop", SourceHash: "5a3b7f7f65cdb332854432f6496b53da27ec6f6c25aa243da2c5822d8991c903", Path: []string{"file.05"}},
+		},
+	})
+	cacheMap.Store("8780622e75a9c1be4b30ae9e15d6d94249926aaa9139b7a563e42ee0eab70eea", &cpb.Entries{
+		LastUpdated: &tpb.Timestamp{Seconds: 1586286139},
+		Entries: []*cpb.CacheEntry{
+			{SourceId: "20200109.00.00-ubuntu-desktop", SourceHash: "5a3b7f7f65cdb332854432f6496b53da27ec6f6c25aa243da2c5822d8991c903"},
+		},
+	})
+
+	var buf bytes.Buffer
+	if err := WriteJSONL(&buf, &cacheMap); err != nil {
+		t.Fatalf("unexpected error while writing JSONL: %v", err)
+	}
+
+	want := `{"sha256":"8780622e75a9c1be4b30ae9e15d6d94249926aaa9139b7a563e42ee0eab70eea","last_updated":"2020-04-07T19:02:19Z","entries":[{"source_id":"20200109.00.00-ubuntu-desktop","source_hash":"5a3b7f7f65cdb332854432f6496b53da27ec6f6c25aa243da2c5822d8991c903"}]}
+{"sha256":"b1f8a81821e18bba696a52b5169524076f77bc588c02ab195f969df4e2650dce","last_updated":"2020-04-07T19:02:19Z","entries":[{"source_id":"20200109.00.00-ubuntu-desktop","source_hash":"5a3b7f7f65cdb332854432f6496b53da27ec6f6c25aa243da2c5822d8991c903","paths":["file.05"]}]}
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("WriteJSONL() unexpected diff (-want/+got):\n%s", diff)
+	}
+}
+
+func TestLookupPath(t *testing.T) {
+	cacheMap := testCacheMap()
+	cacheMap.Store("99962d9e62c15c73527ca72b4e5e85809d4254326800eb2c65b35339029e02d1", &cpb.Entries{
+		Entries: []*cpb.CacheEntry{
+			{SourceId: "20200110.00.00-ubuntu-desktop", SourceHash: "6e0290d62f6db1779d6318df50209de8c9b93adb29b7dd46e7b563f044103b40", Path: []string{"usr/bin/ls", "bin/ls"}},
+		},
+	})
+
+	gotMatches, err := LookupPath(cacheMap, "/usr/bin/*")
+	if err != nil {
+		t.Fatalf("unexpected error while looking up path: %v", err)
+	}
+
+	wantMatches := []*PathMatch{
+		{
+			Sha256:     "99962d9e62c15c73527ca72b4e5e85809d4254326800eb2c65b35339029e02d1",
+			SourceID:   "20200110.00.00-ubuntu-desktop",
+			SourceHash: "6e0290d62f6db1779d6318df50209de8c9b93adb29b7dd46e7b563f044103b40",
+			Path:       "usr/bin/ls",
+		},
+	}
+	if diff := cmp.Diff(wantMatches, gotMatches); diff != "" {
+		t.Errorf("LookupPath() unexpected diff (-want/+got):\n%s", diff)
+	}
+
+	if _, err := LookupPath(cacheMap, "[usr"); err == nil {
+		t.Error("LookupPath() expected error for malformed pattern")
+	}
+}
+
+func TestSourcePaths(t *testing.T) {
+	cacheMap := testCacheMap()
+	cacheMap.Store("99962d9e62c15c73527ca72b4e5e85809d4254326800eb2c65b35339029e02d1", &cpb.Entries{
+		Entries: []*cpb.CacheEntry{
+			{SourceId: "20200110.00.00-ubuntu-desktop", SourceHash: "6e0290d62f6db1779d6318df50209de8c9b93adb29b7dd46e7b563f044103b40", Path: []string{"usr/bin/ls", "bin/ls"}},
+			// Reprocessing the same source adds a duplicate entry.
+			{SourceId: "20200110.00.00-ubuntu-desktop", SourceHash: "6e0290d62f6db1779d6318df50209de8c9b93adb29b7dd46e7b563f044103b40", Path: []string{"usr/bin/ls"}},
+		},
+	})
+
+	gotPaths := SourcePaths(cacheMap, "6E0290D62F6DB1779D6318DF50209DE8C9B93ADB29B7DD46E7B563F044103B40")
+	wantPaths := map[string][]string{
+		"99962d9e62c15c73527ca72b4e5e85809d4254326800eb2c65b35339029e02d1": {"usr/bin/ls", "bin/ls"},
+	}
+	if diff := cmp.Diff(wantPaths, gotPaths); diff != "" {
+		t.Errorf("SourcePaths() unexpected diff (-want/+got):\n%s", diff)
+	}
+
+	gotPaths = SourcePaths(cacheMap, "dcb3a5937e4e609ca8bae24bf79380a06f07d3b0ea08e8cf3b342ae7b1c3f149")
+	if got := gotPaths["e0a98ad618a3cef7f8754a2711322e398879f47e50ca491c75eca6ba476e421a"]; !cmp.Equal(got, []string{"file.10"}) {
+		t.Errorf("SourcePaths() = %v; want = [file.10]", got)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/golang/glog"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	cpb "github.com/google/hashr/cache/proto"
+)
+
+// Relationship holds a single sample <-> source relationship stored by an exporter.
+type Relationship struct {
+	SampleSHA256 string
+	SourceSHA256 string
+	SourceIDs    []string
+	Paths        []string
+}
+
+// Reader represents exporter data sink that can be used to seed and verify the local cache.
+type Reader interface {
+	// Relationships calls fn for every sample <-> source relationship of a given repository.
+	Relationships(ctx context.Context, repoName string, fn func(r *Relationship) error) error
+}
+
+// Mismatch identifies a sample <-> source pair that is present only on one side.
+type Mismatch struct {
+	SampleSHA256 string
+	SourceSHA256 string
+}
+
+// Report holds the result of comparing the local cache with exporter data.
+type Report struct {
+	// MissingFromExporter contains entries present in the cache, but not in the exporter.
+	MissingFromExporter []Mismatch
+	// MissingFromCache contains entries present in the exporter, but not in the cache.
+	MissingFromCache []Mismatch
+}
+
+// Rebuild creates a new cache for a given repository based on the data stored by an exporter.
+func Rebuild(ctx context.Context, reader Reader, repoName string) (*sync.Map, error) {
+	var cacheMap sync.Map
+	var count int
+
+	err := reader.Relationships(ctx, repoName, func(r *Relationship) error {
+		sourceIDs := r.SourceIDs
+		if len(sourceIDs) == 0 {
+			sourceIDs = []string{""}
+		}
+
+		var newEntries []*cpb.CacheEntry
+		for _, sourceID := range sourceIDs {
+			newEntries = append(newEntries, &cpb.CacheEntry{
+				SourceId:   sourceID,
+				SourceHash: r.SourceSHA256,
+				Path:       r.Paths,
+			})
+		}
+
+		if sampleCache, ok := cacheMap.Load(r.SampleSHA256); ok {
+			sampleCache.(*cpb.Entries).Entries = append(sampleCache.(*cpb.Entries).Entries, newEntries...)
+		} else {
+			cacheMap.Store(r.SampleSHA256, &cpb.Entries{
+				LastUpdated: timestamppb.Now(),
+				Entries:     newEntries,
+			})
+		}
+		count++
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error while reading %s repo data from exporter: %v", repoName, err)
+	}
+	glog.Infof("Rebuilt %s repo cache from %d sample <-> source relationships.", repoName, count)
+
+	return &cacheMap, nil
+}
+
+// Verify compares the local cache with the data stored by an exporter and reports entries that
+// are present only on one side.
+func Verify(ctx context.Context, reader Reader, repoName string, cacheMap *sync.Map) (*Report, error) {
+	cached := make(map[Mismatch]bool)
+	rangeEntries(cacheMap, func(hash string, entries *cpb.Entries) bool {
+		for _, entry := range entries.GetEntries() {
+			cached[Mismatch{SampleSHA256: hash, SourceSHA256: entry.GetSourceHash()}] = true
+		}
+
+		return true
+	})
+
+	report := &Report{}
+	exported := make(map[Mismatch]bool)
+	err := reader.Relationships(ctx, repoName, func(r *Relationship) error {
+		key := Mismatch{SampleSHA256: r.SampleSHA256, SourceSHA256: r.SourceSHA256}
+		exported[key] = true
+		if !cached[key] {
+			report.MissingFromCache = append(report.MissingFromCache, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error while reading %s repo data from exporter: %v", repoName, err)
+	}
+
+	for key := range cached {
+		if !exported[key] {
+			report.MissingFromExporter = append(report.MissingFromExporter, key)
+		}
+	}
+
+	sortMismatches(report.MissingFromCache)
+	sortMismatches(report.MissingFromExporter)
+
+	return report, nil
+}
+
+func sortMismatches(m []Mismatch) {
+	sort.Slice(m, func(i, j int) bool {
+		if m[i].SampleSHA256 != m[j].SampleSHA256 {
+			return m[i].SampleSHA256 < m[j].SampleSHA256
+		}
+		return m[i].SourceSHA256 < m[j].SourceSHA256
+	})
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	cpb "github.com/google/hashr/cache/proto"
+)
+
+type fakeReader struct {
+	relationships []*Relationship
+}
+
+func (r *fakeReader) Relationships(ctx context.Context, repoName string, fn func(r *Relationship) error) error {
+	for _, relationship := range r.relationships {
+		if err := fn(relationship); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var testRelationships = []*Relationship{
+	{
+		SampleSHA256: "a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3",
+		SourceSHA256: "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc",
+		SourceIDs:    []string{"ubuntu-1604-lts"},
+		Paths:        []string{"file.01"},
+	},
+	{
+		SampleSHA256: "a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3",
+		SourceSHA256: "9ad2027cae0d7b0f041a6fc1e3124ad4046b2665068c44c74546ad9811e81ec7",
+		SourceIDs:    []string{"ubuntu-1804-lts", "ubuntu-1804-lts-v2"},
+		Paths:        []string{"file.01", "dir/file.01"},
+	},
+	{
+		SampleSHA256: "5c7a0f6e38f86f4db12130e5ca9f734f4def519b9a884ee8ea9fc45f9626c6fb",
+		SourceSHA256: "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc",
+		SourceIDs:    []string{"ubuntu-1604-lts"},
+		Paths:        []string{"file.02"},
+	},
+}
+
+func TestRebuild(t *testing.T) {
+	gotCache, err := Rebuild(context.Background(), &fakeReader{relationships: testRelationships}, "GCP")
+	if err != nil {
+		t.Fatalf("unexpected error while rebuilding cache: %v", err)
+	}
+
+	wantCache := map[string][]*cpb.CacheEntry{
+		"a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3": {
+			{SourceId: "ubuntu-1604-lts", SourceHash: "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc", Path: []string{"file.01"}},
+			{SourceId: "ubuntu-1804-lts", SourceHash: "9ad2027cae0d7b0f041a6fc1e3124ad4046b2665068c44c74546ad9811e81ec7", Path: []string{"file.01", "dir/file.01"}},
+			{SourceId: "ubuntu-1804-lts-v2", SourceHash: "9ad2027cae0d7b0f041a6fc1e3124ad4046b2665068c44c74546ad9811e81ec7", Path: []string{"file.01", "dir/file.01"}},
+		},
+		"5c7a0f6e38f86f4db12130e5ca9f734f4def519b9a884ee8ea9fc45f9626c6fb": {
+			{SourceId: "ubuntu-1604-lts", SourceHash: "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc", Path: []string{"file.02"}},
+		},
+	}
+
+	got := make(map[string][]*cpb.CacheEntry)
+	gotCache.Range(func(key, value interface{}) bool {
+		got[key.(string)] = value.(*cpb.Entries).GetEntries()
+		return true
+	})
+
+	if diff := cmp.Diff(wantCache, got, protocmp.Transform()); diff != "" {
+		t.Errorf("Rebuild() unexpected diff (-want/+got):\n%s", diff)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	var cacheMap sync.Map
+	cacheMap.Store("a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3", &cpb.Entries{
+		Entries: []*cpb.CacheEntry{
+			{SourceId: "ubuntu-1604-lts", SourceHash: "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc"},
+		},
+	})
+	cacheMap.Store("e0a98ad618a3cef7f8754a2711322e398879f47e50ca491c75eca6ba476e421a", &cpb.Entries{
+		Entries: []*cpb.CacheEntry{
+			{SourceId: "ubuntu-2004-lts", SourceHash: "dcb3a5937e4e609ca8bae24bf79380a06f07d3b0ea08e8cf3b342ae7b1c3f149"},
+		},
+	})
+
+	gotReport, err := Verify(context.Background(), &fakeReader{relationships: testRelationships}, "GCP", &cacheMap)
+	if err != nil {
+		t.Fatalf("unexpected error while verifying cache: %v", err)
+	}
+
+	wantReport := &Report{
+		MissingFromExporter: []Mismatch{
+			{SampleSHA256: "e0a98ad618a3cef7f8754a2711322e398879f47e50ca491c75eca6ba476e421a", SourceSHA256: "dcb3a5937e4e609ca8bae24bf79380a06f07d3b0ea08e8cf3b342ae7b1c3f149"},
+		},
+		MissingFromCache: []Mismatch{
+			{SampleSHA256: "5c7a0f6e38f86f4db12130e5ca9f734f4def519b9a884ee8ea9fc45f9626c6fb", SourceSHA256: "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc"},
+			{SampleSHA256: "a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3", SourceSHA256: "9ad2027cae0d7b0f041a6fc1e3124ad4046b2665068c44c74546ad9811e81ec7"},
+		},
+	}
+
+	if diff := cmp.Diff(wantReport, gotReport); diff != "" {
+		t.Errorf("Verify() unexpected diff (-want/+got):\n%s", diff)
+	}
+}
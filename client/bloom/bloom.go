@@ -0,0 +1,224 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bloom implements a serialized Bloom filter over sample SHA256 digests, which allows to
+// check hashes against hashR data offline.
+//
+// The file starts with an 8 byte magic value, followed by a 4 byte little-endian length of a JSON
+// encoded metadata header, the header itself and the filter bits stored as little-endian uint64
+// words. Since SHA256 digests are uniformly distributed, bit positions are derived directly from
+// the digest using double hashing, there is no need to re-hash the input.
+package bloom
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+const (
+	magic = "HASHRBF1"
+	// maxHeaderSize limits the size of the metadata header that will be read from a file.
+	maxHeaderSize = 1024 * 1024 * 10 // 10MB
+)
+
+// Metadata holds information about the content of the filter.
+type Metadata struct {
+	Created           time.Time `json:"created"`
+	FalsePositiveRate float64   `json:"false_positive_rate"`
+	Count             uint64    `json:"count"`
+	Bits              uint64    `json:"bits"`
+	Hashes            uint32    `json:"hashes"`
+	Repos             []string  `json:"repos,omitempty"`
+	Sources           []string  `json:"sources,omitempty"`
+}
+
+// Filter is a Bloom filter over SHA256 digests.
+type Filter struct {
+	Metadata
+	words []uint64
+}
+
+// New returns a filter sized to hold n digests with a given false positive rate.
+func New(n uint64, fpRate float64) (*Filter, error) {
+	if fpRate <= 0 || fpRate >= 1 {
+		return nil, fmt.Errorf("false positive rate needs to be in (0, 1) range, got %v", fpRate)
+	}
+	if n == 0 {
+		n = 1
+	}
+
+	bits := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	bits = (bits + 63) / 64 * 64
+	hashes := uint32(math.Max(1, math.Round(float64(bits)/float64(n)*math.Ln2)))
+
+	return &Filter{
+		Metadata: Metadata{
+			Created:           time.Now().UTC(),
+			FalsePositiveRate: fpRate,
+			Bits:              bits,
+			Hashes:            hashes,
+		},
+		words: make([]uint64, bits/64),
+	}, nil
+}
+
+func parseHex(hash string) ([]byte, error) {
+	digest, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHA256 value %s: %v", hash, err)
+	}
+	if len(digest) != 32 {
+		return nil, fmt.Errorf("invalid SHA256 value %s: unexpected length", hash)
+	}
+
+	return digest, nil
+}
+
+// positions calls fn for every bit position of a given digest.
+func (f *Filter) positions(digest []byte, fn func(pos uint64) bool) {
+	h1 := binary.LittleEndian.Uint64(digest[0:8])
+	h2 := binary.LittleEndian.Uint64(digest[8:16]) | 1
+	for i := uint64(0); i < uint64(f.Hashes); i++ {
+		if !fn((h1 + i*h2) % f.Bits) {
+			return
+		}
+	}
+}
+
+// Add adds a hex encoded SHA256 digest to the filter.
+func (f *Filter) Add(hash string) error {
+	digest, err := parseHex(hash)
+	if err != nil {
+		return err
+	}
+
+	f.positions(digest, func(pos uint64) bool {
+		f.words[pos/64] |= 1 << (pos % 64)
+		return true
+	})
+	f.Count++
+
+	return nil
+}
+
+// Contains checks if a hex encoded SHA256 digest is (probably) present in the filter.
+func (f *Filter) Contains(hash string) (bool, error) {
+	digest, err := parseHex(hash)
+	if err != nil {
+		return false, err
+	}
+
+	found := true
+	f.positions(digest, func(pos uint64) bool {
+		if f.words[pos/64]&(1<<(pos%64)) == 0 {
+			found = false
+		}
+		return found
+	})
+
+	return found, nil
+}
+
+// WriteTo writes the serialized filter to w.
+func (f *Filter) WriteTo(w io.Writer) (int64, error) {
+	header, err := json.Marshal(f.Metadata)
+	if err != nil {
+		return 0, fmt.Errorf("error while marshalling filter metadata: %v", err)
+	}
+
+	headerSize := make([]byte, 4)
+	binary.LittleEndian.PutUint32(headerSize, uint32(len(header)))
+
+	bw := bufio.NewWriter(w)
+	var n int64
+	for _, data := range [][]byte{[]byte(magic), headerSize, header} {
+		written, err := bw.Write(data)
+		n += int64(written)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	buf := make([]byte, 8)
+	for _, word := range f.words {
+		binary.LittleEndian.PutUint64(buf, word)
+		written, err := bw.Write(buf)
+		n += int64(written)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, bw.Flush()
+}
+
+// Read reads a serialized filter from r.
+func Read(r io.Reader) (*Filter, error) {
+	br := bufio.NewReader(r)
+
+	prefix := make([]byte, len(magic)+4)
+	if _, err := io.ReadFull(br, prefix); err != nil {
+		return nil, fmt.Errorf("error while reading filter header: %v", err)
+	}
+	if string(prefix[:len(magic)]) != magic {
+		return nil, errors.New("not a hashR Bloom filter file")
+	}
+
+	headerSize := binary.LittleEndian.Uint32(prefix[len(magic):])
+	if headerSize > maxHeaderSize {
+		return nil, fmt.Errorf("filter header too large: %d bytes", headerSize)
+	}
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("error while reading filter header: %v", err)
+	}
+
+	f := &Filter{}
+	if err := json.Unmarshal(header, &f.Metadata); err != nil {
+		return nil, fmt.Errorf("error while unmarshalling filter metadata: %v", err)
+	}
+	if f.Bits == 0 || f.Bits%64 != 0 || f.Hashes == 0 {
+		return nil, fmt.Errorf("invalid filter parameters: bits=%d, hashes=%d", f.Bits, f.Hashes)
+	}
+
+	f.words = make([]uint64, f.Bits/64)
+	buf := make([]byte, 8)
+	for i := range f.words {
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, fmt.Errorf("error while reading filter bits: %v", err)
+		}
+		f.words[i] = binary.LittleEndian.Uint64(buf)
+	}
+
+	return f, nil
+}
+
+// Open reads a serialized filter from a file.
+func Open(path string) (*Filter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Read(file)
+}
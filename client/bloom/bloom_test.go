@@ -0,0 +1,100 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bloom
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func testHash(i int) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("sample-%d", i))))
+}
+
+func TestFilter(t *testing.T) {
+	const count = 10000
+	const fpRate = 0.01
+
+	filter, err := New(count, fpRate)
+	if err != nil {
+		t.Fatalf("unexpected error while creating filter: %v", err)
+	}
+	filter.Repos = []string{"deb"}
+
+	for i := 0; i < count; i++ {
+		if err := filter.Add(testHash(i)); err != nil {
+			t.Fatalf("unexpected error while adding hash: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := filter.WriteTo(&buf); err != nil {
+		t.Fatalf("unexpected error while writing filter: %v", err)
+	}
+
+	gotFilter, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error while reading filter: %v", err)
+	}
+
+	if diff := cmp.Diff(filter.Metadata, gotFilter.Metadata); diff != "" {
+		t.Errorf("Read() unexpected metadata diff (-want/+got):\n%s", diff)
+	}
+
+	for i := 0; i < count; i++ {
+		found, err := gotFilter.Contains(testHash(i))
+		if err != nil {
+			t.Fatalf("unexpected error while querying filter: %v", err)
+		}
+		if !found {
+			t.Fatalf("Contains(%s) = false; want = true", testHash(i))
+		}
+	}
+
+	var falsePositives int
+	for i := count; i < count*2; i++ {
+		if found, _ := gotFilter.Contains(testHash(i)); found {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / count; rate > fpRate*2 {
+		t.Errorf("false positive rate = %v; want <= %v", rate, fpRate*2)
+	}
+}
+
+func TestInvalidInput(t *testing.T) {
+	filter, err := New(10, 0.01)
+	if err != nil {
+		t.Fatalf("unexpected error while creating filter: %v", err)
+	}
+
+	for _, hash := range []string{"", "abc", "zz2d9e62c15c73527ca72b4e5e85809d4254326800eb2c65b35339029e02d1"} {
+		if err := filter.Add(hash); err == nil {
+			t.Errorf("Add(%q) expected error", hash)
+		}
+	}
+
+	if _, err := New(10, 1.5); err == nil {
+		t.Error("New() expected error for invalid false positive rate")
+	}
+
+	if _, err := Read(bytes.NewReader([]byte("NOTBLOOM0000"))); err == nil {
+		t.Error("Read() expected error for invalid magic value")
+	}
+}
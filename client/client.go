@@ -6,8 +6,12 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"os"
+	"strings"
 
 	"cloud.google.com/go/spanner"
+	"github.com/google/hashr/cache"
+	"github.com/google/hashr/client/bloom"
 	"github.com/google/hashr/client/cloudspanner"
 	"github.com/google/hashr/client/postgres"
 	_ "github.com/lib/pq"
@@ -25,11 +29,18 @@ var (
 	postgresUser     = flag.String("postgres_user", "hashr", "PostgresSQL user.")
 	postgresPassword = flag.String("postgres_password", "hashr", "PostgresSQL password.")
 	postgresDBName   = flag.String("postgres_db", "hashr", "PostgresSQL database.")
+
+	// Bloom filter flags
+	bloomOutput = flag.String("bloom_output", "", "If set, a Bloom filter over sample SHA256 values will be written to this path instead of printing samples.")
+	bloomFPRate = flag.Float64("bloom_fp_rate", 0.0001, "False positive rate of the Bloom filter.")
+	bloomRepos  = flag.String("bloom_repos", "", "Comma separated list of repositories which samples should be included in the Bloom filter, all if empty.")
+	bloomSrcs   = flag.String("bloom_sources", "", "Comma separated list of source IDs or SHA256 values which samples should be included in the Bloom filter, all if empty.")
 )
 
 // Storage represents  storage that is used to store data about processed sources.
 type Storage interface {
 	GetSamples(ctx context.Context) (map[string]map[string]string, error)
+	Relationships(ctx context.Context, repoName string, fn func(r *cache.Relationship) error) error
 }
 
 func main() {
@@ -66,6 +77,14 @@ func main() {
 		glog.Exit("hashStorage flag needs to have one of the two values: postgres, cloudspanner")
 
 	}
+
+	if *bloomOutput != "" {
+		if err := writeBloom(ctx, storage); err != nil {
+			glog.Exitf("Error writing Bloom filter: %v", err)
+		}
+		return
+	}
+
 	samples, err := storage.GetSamples(ctx)
 	if err != nil {
 		glog.Exitf("Error retriving samples: %v", err)
@@ -79,3 +98,78 @@ func main() {
 
 	fmt.Println(string(jsonData))
 }
+
+func splitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// writeBloom builds a Bloom filter over sample SHA256 values matching repo and source filters and
+// writes it to the output file.
+func writeBloom(ctx context.Context, storage Storage) error {
+	repos := splitList(*bloomRepos)
+	sources := make(map[string]bool)
+	for _, source := range splitList(*bloomSrcs) {
+		sources[source] = true
+	}
+
+	match := func(r *cache.Relationship) bool {
+		if len(sources) == 0 || sources[r.SourceSHA256] {
+			return true
+		}
+		for _, sourceID := range r.SourceIDs {
+			if sources[sourceID] {
+				return true
+			}
+		}
+		return false
+	}
+
+	hashes := make(map[string]bool)
+	collect := func(r *cache.Relationship) error {
+		if match(r) {
+			hashes[strings.ToLower(r.SampleSHA256)] = true
+		}
+		return nil
+	}
+
+	if len(repos) == 0 {
+		if err := storage.Relationships(ctx, "", collect); err != nil {
+			return err
+		}
+	}
+	for _, repo := range repos {
+		if err := storage.Relationships(ctx, repo, collect); err != nil {
+			return err
+		}
+	}
+
+	filter, err := bloom.New(uint64(len(hashes)), *bloomFPRate)
+	if err != nil {
+		return err
+	}
+	filter.Repos = repos
+	filter.Sources = splitList(*bloomSrcs)
+	for hash := range hashes {
+		if err := filter.Add(hash); err != nil {
+			glog.Warningf("skipping sample: %v", err)
+		}
+	}
+
+	out, err := os.Create(*bloomOutput)
+	if err != nil {
+		return err
+	}
+	if _, err := filter.WriteTo(out); err != nil {
+		out.Close()
+		return err
+	}
+	glog.Infof("Wrote Bloom filter with %d samples to %s", filter.Count, *bloomOutput)
+
+	return out.Close()
+}
@@ -5,6 +5,7 @@ import (
 	"strconv"
 
 	"cloud.google.com/go/spanner"
+	"github.com/google/hashr/cache"
 
 	"google.golang.org/api/iterator"
 )
@@ -62,3 +63,38 @@ func (s *Storage) GetSamples(ctx context.Context) (map[string]map[string]string,
 	}
 	return samples, nil
 }
+
+// Relationships streams sample <-> source relationships of a given repository from cloud spanner.
+// If repoName is empty, relationships of all repositories are returned.
+func (s *Storage) Relationships(ctx context.Context, repoName string, fn func(r *cache.Relationship) error) error {
+	stmt := spanner.Statement{
+		SQL: `SELECT samples_sources.sample_sha256, samples_sources.source_sha256, sources.source_id, samples_sources.sample_paths
+		FROM samples_sources
+		JOIN sources ON samples_sources.source_sha256 = sources.sha256
+		WHERE @repo_name = '' OR sources.repo_name = @repo_name`,
+		Params: map[string]interface{}{
+			"repo_name": repoName,
+		},
+	}
+
+	iter := s.spannerClient.Single().Query(ctx, stmt)
+	defer iter.Stop()
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		r := &cache.Relationship{}
+		if err := row.Columns(&r.SampleSHA256, &r.SourceSHA256, &r.SourceIDs, &r.Paths); err != nil {
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -6,8 +6,8 @@ import (
 	"database/sql"
 	"fmt"
 
-	// Blank import below is needed for the SQL driver.
-	_ "github.com/lib/pq"
+	"github.com/google/hashr/cache"
+	"github.com/lib/pq"
 )
 
 // Storage allows to interact with PostgreSQL instance.
@@ -63,6 +63,34 @@ func (s *Storage) GetSamples(ctx context.Context) (map[string]map[string]string,
 	return samples, nil
 }
 
+// Relationships streams sample <-> source relationships of a given repository from postgres. If
+// repoName is empty, relationships of all repositories are returned.
+func (s *Storage) Relationships(ctx context.Context, repoName string, fn func(r *cache.Relationship) error) error {
+	sql := `
+	SELECT samples_sources.sample_sha256, samples_sources.source_sha256, sources.sourceID, samples_sources.sample_paths
+	FROM samples_sources
+	JOIN sources ON samples_sources.source_sha256 = sources.sha256
+	WHERE $1 = '' OR sources.repoName = $1;`
+
+	rows, err := s.sqlDB.QueryContext(ctx, sql, repoName)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := &cache.Relationship{}
+		if err := rows.Scan(&r.SampleSHA256, &r.SourceSHA256, pq.Array(&r.SourceIDs), pq.Array(&r.Paths)); err != nil {
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
 func tableExists(db *sql.DB, tableName string) (bool, error) {
 	// Query to check if the table exists in PostgreSQL
 	query := `
@@ -15,11 +15,277 @@
 // Package common provides common data structures used in hashR.
 package common
 
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MetadataFile is the name of the file holding metadata of extracted files. It's stored in the
+// base directory of a source, next to the extracted files.
+const MetadataFile = "metadata.json"
+
 // Sample represent single file extracted from a given source.
 type Sample struct {
 	Sha256 string   `json:"sha256"`
 	Paths  []string `json:"paths"`
 	Upload bool     `json:"Upload"`
+	// Metadata holds file system metadata of sample paths, keyed by path.
+	Metadata map[string]*FileMetadata `json:"metadata,omitempty"`
+	// Executable holds metadata of ELF, PE and Mach-O samples, set by the executable analyzer.
+	Executable *ExecutableMetadata `json:"executable,omitempty"`
+	// Signatures holds Authenticode signatures of PE samples, set by the authenticode analyzer.
+	Signatures []*Signature `json:"signatures,omitempty"`
+	// Packages holds owners of sample paths according to dpkg or RPM database found in the same
+	// source, keyed by path. Set by the packages analyzer.
+	Packages map[string]*PackageOwner `json:"packages,omitempty"`
+}
+
+// FirstValidPath returns the first path of the sample that exists, together with its file info.
+// Samples can have more than one path associated with them, some of which might not be present
+// on disk.
+func (s *Sample) FirstValidPath() (string, os.FileInfo, error) {
+	var err error
+	for _, path := range s.Paths {
+		var fi os.FileInfo
+		if fi, err = os.Stat(path); err == nil {
+			return path, fi, nil
+		}
+	}
+
+	return "", nil, fmt.Errorf("no valid path: %v", err)
+}
+
+// PackageOwner holds the package owning a file, as recorded in the package database of a disk
+// image.
+type PackageOwner struct {
+	// Manager is either "dpkg" or "rpm".
+	Manager string `json:"manager"`
+	// Package, Version and Architecture are empty for files that are not owned by any package, e.g.
+	// files added by the image builder.
+	Package      string `json:"package,omitempty"`
+	Version      string `json:"version,omitempty"`
+	Architecture string `json:"architecture,omitempty"`
+	// Config is true for configuration files, which are expected to be modified.
+	Config bool `json:"config,omitempty"`
+	// Modified is true if the hash of the file does not match the one recorded in the package
+	// database.
+	Modified bool `json:"modified,omitempty"`
+}
+
+// PackageInfo holds metadata of a source that is a single package (e.g. a .deb file), as recorded
+// in the package itself.
+type PackageInfo struct {
+	// Manager is the package manager using the package format, e.g. "dpkg" or "rpm".
+	Manager string `json:"manager"`
+	Package string `json:"package"`
+	// Version is the full version of deb packages. RPM packages keep epoch and release separately.
+	Version      string `json:"version,omitempty"`
+	Epoch        int    `json:"epoch,omitempty"`
+	Release      string `json:"release,omitempty"`
+	Architecture string `json:"architecture,omitempty"`
+	Maintainer   string `json:"maintainer,omitempty"`
+	// Source is the name of the source package the package was built from.
+	Source   string `json:"source,omitempty"`
+	Homepage string `json:"homepage,omitempty"`
+	Vendor   string `json:"vendor,omitempty"`
+	Packager string `json:"packager,omitempty"`
+	// BuildHost and BuildTime are recorded in RPM headers, BuildTime is in seconds since the Unix
+	// epoch.
+	BuildHost string `json:"build_host,omitempty"`
+	BuildTime int64  `json:"build_time,omitempty"`
+	// SignerKeyID is the hex encoded ID of the OpenPGP key that signed the package, empty for
+	// unsigned packages.
+	SignerKeyID string `json:"signer_key_id,omitempty"`
+	// Trusted is true if the signature was verified with a key from the configured keyring.
+	Trusted bool `json:"trusted,omitempty"`
+	// SignatureError describes why the signature or the digests of the package could not be
+	// verified.
+	SignatureError string `json:"signature_error,omitempty"`
+	// VerifiedFiles is the number of extracted files matching the digests recorded in the package.
+	VerifiedFiles int `json:"verified_files"`
+	// MismatchedFiles holds paths of files that don't match the digests recorded in the package or
+	// that are missing from its data.
+	MismatchedFiles []string `json:"mismatched_files,omitempty"`
+}
+
+// ExecutableMetadata holds metadata extracted from headers of ELF, PE and Mach-O executables.
+type ExecutableMetadata struct {
+	// Format is one of "ELF", "PE32", "PE32+" or "Mach-O".
+	Format string `json:"format"`
+	// Archs holds the CPU architecture (machine type), universal Mach-O binaries hold one per
+	// image.
+	Archs []string `json:"archs,omitempty"`
+	Bits  int      `json:"bits,omitempty"`
+
+	// BuildID is the GNU build ID of ELF files, hex encoded.
+	BuildID string `json:"build_id,omitempty"`
+	// Soname and Needed are the DT_SONAME and DT_NEEDED entries of ELF dynamic section.
+	Soname string   `json:"soname,omitempty"`
+	Needed []string `json:"needed,omitempty"`
+	// Stripped is true for ELF files without symbol table.
+	Stripped bool `json:"stripped,omitempty"`
+
+	// CompileTimestamp is the TimeDateStamp of PE file header in seconds since the Unix epoch.
+	// Reproducible builds store a hash of the file in this field.
+	CompileTimestamp int64 `json:"compile_timestamp,omitempty"`
+	// Imphash is the MD5 hash of PE imports, as calculated by pefile.
+	Imphash string `json:"imphash,omitempty"`
+	// VersionInfo holds strings of PE version-info resource, e.g. CompanyName or ProductVersion.
+	VersionInfo map[string]string `json:"version_info,omitempty"`
+
+	// UUIDs holds the LC_UUID of Mach-O files, one per image.
+	UUIDs []string `json:"uuids,omitempty"`
+}
+
+// Signature holds an Authenticode signature of a PE file, either embedded in the file or in a
+// catalog file that lists its hash.
+type Signature struct {
+	// Catalog is the path of the catalog file relative to the extraction root, empty for signatures
+	// embedded in the file.
+	Catalog string `json:"catalog,omitempty"`
+	// DigestAlgorithm and Digest are the algorithm and the hex encoded Authenticode hash of the file,
+	// as signed.
+	DigestAlgorithm string `json:"digest_algorithm"`
+	Digest          string `json:"digest"`
+	// Verified is true if the signed hash matches the file and the signer's signature is valid.
+	// Certificates are not checked against trusted roots or for revocation.
+	Verified bool `json:"verified"`
+	// Error describes why the signature could not be verified.
+	Error string `json:"error,omitempty"`
+	// SigningTime is taken from the timestamp countersignature or, if the signature is not
+	// timestamped, from the signing-time attribute. It's in seconds since the Unix epoch, 0 if not
+	// known.
+	SigningTime int64 `json:"signing_time,omitempty"`
+	// Certificates holds the certificate chain of the signer, starting with the signer certificate.
+	Certificates []*Certificate `json:"certificates,omitempty"`
+}
+
+// Certificate holds the details of an X.509 certificate.
+type Certificate struct {
+	Subject      string `json:"subject"`
+	Issuer       string `json:"issuer"`
+	SerialNumber string `json:"serial_number"`
+	// NotBefore and NotAfter are in seconds since the Unix epoch.
+	NotBefore int64 `json:"not_before"`
+	NotAfter  int64 `json:"not_after"`
+	// Sha256 is the hex encoded SHA256 fingerprint of the certificate.
+	Sha256 string `json:"sha256"`
+}
+
+// OSInfo holds the operating system of a source, detected from the extracted files of disk images.
+type OSInfo struct {
+	// Family is either "linux" or "windows".
+	Family string `json:"family"`
+	// ID is the lower case identifier of the distribution (ID from os-release, e.g. "ubuntu"), or
+	// "windows".
+	ID string `json:"id"`
+	// Name is the human readable name, PRETTY_NAME from os-release or ProductName of Windows.
+	Name string `json:"name,omitempty"`
+	// Version is VERSION_ID from os-release (e.g. "22.04"), or major.minor.build of Windows (e.g.
+	// "10.0.20348").
+	Version string `json:"version,omitempty"`
+	// Codename is VERSION_CODENAME from os-release, or DisplayVersion (ReleaseId on older versions)
+	// of Windows, e.g. "21H2".
+	Codename string `json:"codename,omitempty"`
+	// Kernel is the newest kernel version found on Linux, or the full build number of Windows.
+	Kernel string `json:"kernel,omitempty"`
+	// Architecture uses Debian names, e.g. "amd64" or "arm64".
+	Architecture string `json:"architecture,omitempty"`
+}
+
+// FileMetadata holds file system metadata of a single file, as recorded in the source (e.g.
+// package or archive headers).
+type FileMetadata struct {
+	// Mode is the Unix mode (st_mode), including file type, permission and setuid, setgid and
+	// sticky bits.
+	Mode uint32 `json:"mode"`
+	// UID and GID are set to -1 if they are not known, e.g. RPM packages only hold user and group
+	// names.
+	UID   int    `json:"uid"`
+	GID   int    `json:"gid"`
+	User  string `json:"user,omitempty"`
+	Group string `json:"group,omitempty"`
+	// Mtime is the modification time in seconds since the Unix epoch.
+	Mtime int64 `json:"mtime"`
+	// LinkTarget is the target of a symbolic link, empty for other files.
+	LinkTarget string `json:"link_target,omitempty"`
+}
+
+// Unix file type and mode bits, as used in st_mode.
+const (
+	modeSetUID   = 0o4000
+	modeSetGID   = 0o2000
+	modeSticky   = 0o1000
+	modeTypeDir  = 0o040000
+	modeTypeReg  = 0o100000
+	modeTypeLnk  = 0o120000
+	modeTypeChr  = 0o020000
+	modeTypeBlk  = 0o060000
+	modeTypeFIFO = 0o010000
+	modeTypeSock = 0o140000
+)
+
+// UnixMode converts os.FileMode to Unix mode (st_mode).
+func UnixMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= modeSetUID
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= modeSetGID
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= modeSticky
+	}
+
+	switch {
+	case mode&os.ModeDir != 0:
+		m |= modeTypeDir
+	case mode&os.ModeSymlink != 0:
+		m |= modeTypeLnk
+	case mode&os.ModeCharDevice != 0:
+		m |= modeTypeChr
+	case mode&os.ModeDevice != 0:
+		m |= modeTypeBlk
+	case mode&os.ModeNamedPipe != 0:
+		m |= modeTypeFIFO
+	case mode&os.ModeSocket != 0:
+		m |= modeTypeSock
+	default:
+		m |= modeTypeReg
+	}
+
+	return m
+}
+
+// WriteMetadata saves metadata of extracted files, keyed by their path relative to the extraction
+// root, to a given file.
+func WriteMetadata(path string, metadata map[string]*FileMetadata) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// ReadMetadata reads metadata of extracted files saved by WriteMetadata.
+func ReadMetadata(path string) (map[string]*FileMetadata, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata map[string]*FileMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
 }
 
 // Extraction contains information about image_export.py extraction.
@@ -30,3 +296,30 @@ type Extraction struct {
 	Path         string
 	SourceSHA256 string
 }
+
+// TrimExtractionRoot returns path of an extracted file relative to the extraction root, which is
+// the part of the path after the last "/extracted/" element. The second return value is false if
+// the path does not follow this format.
+func TrimExtractionRoot(path string) (string, bool) {
+	s := strings.Split(path, "/extracted/")
+	if len(s) < 2 {
+		return path, false
+	}
+
+	return s[len(s)-1], true
+}
+
+// RelativePath returns path of an extracted file relative to the extraction root. Paths that are
+// outside of the extraction are rejected, the second return value is false for them.
+func (e *Extraction) RelativePath(path string) (string, bool) {
+	if relPath, ok := TrimExtractionRoot(path); ok {
+		return relPath, true
+	}
+
+	relPath, err := filepath.Rel(e.Path, path)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, "../") {
+		return "", false
+	}
+
+	return relPath, true
+}
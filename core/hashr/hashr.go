@@ -26,6 +26,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -79,6 +80,12 @@ type Processor interface {
 type Storage interface {
 	UpdateJobs(ctx context.Context, qHash string, p *ProcessingSource) error
 	FetchJobs(ctx context.Context) (map[string]string, error)
+	// FetchJob returns a single processing job, or nil if the job is not present.
+	FetchJob(ctx context.Context, qHash string) (*ProcessingSource, error)
+	// UpdateExport stores the status of exporting a given source with a given exporter.
+	UpdateExport(ctx context.Context, qHash string, e *ExportStatus) error
+	// FetchExports returns export statuses keyed by source quick hash and exporter name.
+	FetchExports(ctx context.Context) (map[string]map[string]*ExportStatus, error)
 }
 
 // Exporter represents exporter instance that will be used to export extracted data.
@@ -89,11 +96,49 @@ type Exporter interface {
 	Name() string
 }
 
+// OSExporter is implemented by exporters that store the operating system of sources.
+type OSExporter interface {
+	// ExportOS stores the operating system of a source that was already exported.
+	ExportOS(ctx context.Context, sourceHash string, sourceOS *common.OSInfo) error
+}
+
+// PackageExporter is implemented by exporters that store metadata of sources that are single
+// packages.
+type PackageExporter interface {
+	// ExportPackage stores package metadata of a source that was already exported.
+	ExportPackage(ctx context.Context, sourceHash string, pkg *common.PackageInfo) error
+}
+
+// PackageSource is implemented by sources that are single packages (e.g. .deb files).
+type PackageSource interface {
+	// Package returns metadata recorded in the package, nil if it's not known before the source
+	// is preprocessed.
+	Package() *common.PackageInfo
+}
+
+// OSDetector identifies the operating system of sources.
+type OSDetector interface {
+	// DetectOS returns the operating system of files extracted to a given directory, or nil if it
+	// can't be identified.
+	DetectOS(extractionDir string) (*common.OSInfo, error)
+}
+
+// Analyzer represents analyzer instance that will be used to extract additional data from the
+// content of samples before they are exported.
+type Analyzer interface {
+	// Analyze adds data extracted from samples flagged for upload to them.
+	Analyze(ctx context.Context, samples []common.Sample) error
+	// Name returns analyzer name.
+	Name() string
+}
+
 // HashR holds data related to running instance of HashR.
 type HashR struct {
 	Importers              []Importer
 	Processor              Processor
 	Exporters              []Exporter
+	Analyzers              []Analyzer
+	OSDetector             OSDetector
 	Storage                Storage
 	ProcessingWorkerCount  int
 	ExportWorkerCount      int
@@ -102,11 +147,16 @@ type HashR struct {
 	Export                 bool
 	ExportPath             string
 	SourcesForReprocessing []string
+	// Backfill enables exporting already exported sources with exporters that did not succeed for
+	// them yet (e.g. newly added exporters). Only sources previously saved to ExportPath can be
+	// backfilled, as the content of their samples is read from there.
+	Backfill               bool
 	cacheSaveCounter       int
 	wg                     sync.WaitGroup
 	mu                     sync.Mutex
 	processingSources      map[string]*ProcessingSource
 	processingSourcesMutex sync.RWMutex
+	exports                map[string]map[string]*ExportStatus
 }
 
 // ProcessingSource holds data related to a processing source.
@@ -116,7 +166,7 @@ type ProcessingSource struct {
 	RepoPath              string
 	RemoteSourcePath      string
 	Sha256                string
-	Status                status
+	Status                Status
 	ImportedAt            int64
 	PreprocessingDuration time.Duration
 	ProcessingDuration    time.Duration
@@ -126,8 +176,19 @@ type ProcessingSource struct {
 	Error                 string
 }
 
+// ExportStatus holds data related to exporting a source with a single exporter.
+type ExportStatus struct {
+	Exporter    string
+	Sha256      string
+	Status      Status
+	Error       string
+	ExportedAt  int64
+	Duration    time.Duration
+	SampleCount int
+}
+
 // Status is a type to store the status of a processing job.
-type status string
+type Status string
 
 const (
 	discovered   = "discovered"
@@ -144,20 +205,26 @@ func New(importers []Importer, processor Processor, exporters []Exporter, storag
 	return &HashR{Importers: importers, Processor: processor, Exporters: exporters, Storage: storage}
 }
 
-// newSources returns sources that were not yet processed.
-func (h *HashR) newSources(ctx context.Context, i Importer) ([]Source, error) {
-	var newSources []Source
+// newSources returns sources that were not yet processed and, if backfill is enabled, already
+// exported sources that some of the exporters did not succeed for.
+func (h *HashR) newSources(ctx context.Context, i Importer) ([]Source, []Source, error) {
+	var newSources, backfillSources []Source
 
 	glog.Infof("Discovering %s %s repository.", i.RepoName(), i.RepoPath())
 	sources, err := i.DiscoverRepo()
 	if err != nil {
-		return nil, fmt.Errorf("%s: error discovering repo: %v", i.RepoName(), err)
+		return nil, nil, fmt.Errorf("%s: error discovering repo: %v", i.RepoName(), err)
 	}
 	glog.Infof("Discovered %d sources in %s repository.", len(sources), i.RepoName())
 
 	processedSources, err := h.Storage.FetchJobs(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("could not fetch processed sources from storage: %v", err)
+		return nil, nil, fmt.Errorf("could not fetch processed sources from storage: %v", err)
+	}
+
+	h.exports, err = h.Storage.FetchExports(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not fetch export statuses from storage: %v", err)
 	}
 
 	for _, source := range sources {
@@ -170,13 +237,293 @@ func (h *HashR) newSources(ctx context.Context, i Importer) ([]Source, error) {
 		// Check if the source was already processed or should be reprocessed.
 
 		status, processed := processedSources[qHash]
-		if !processed || contains(h.SourcesForReprocessing, qHash) || strings.EqualFold(status, reprocess) {
+		switch {
+		case !processed || contains(h.SourcesForReprocessing, qHash) || strings.EqualFold(status, reprocess):
 			newSources = append(newSources, source)
+		case h.Export && h.Backfill && strings.EqualFold(status, exported) && len(h.pendingExporters(qHash)) > 0:
+			backfillSources = append(backfillSources, source)
 		}
 	}
 	glog.Infof("Discovered %d new sources in %s (%s) repository.", len(newSources), i.RepoName(), i.RepoPath())
+	if len(backfillSources) > 0 {
+		glog.Infof("Discovered %d sources to backfill in %s (%s) repository.", len(backfillSources), i.RepoName(), i.RepoPath())
+	}
+
+	return newSources, backfillSources, nil
+}
+
+// pendingExporters returns exporters that did not successfully export a given source yet.
+func (h *HashR) pendingExporters(qHash string) []Exporter {
+	var pending []Exporter
+	for _, exporter := range h.Exporters {
+		if e, ok := h.exports[qHash][exporter.Name()]; ok && e.Status == exported {
+			continue
+		}
+		pending = append(pending, exporter)
+	}
+
+	return pending
+}
+
+// export exports samples using a single exporter and stores the export status.
+func (h *HashR) export(ctx context.Context, qHash string, exporter Exporter, source Source, sourceHash string, details sourceDetails, samples []common.Sample) error {
+	glog.Infof("Exporting samples from %s with %s hash using %s exporter", source.ID(), sourceHash, exporter.Name())
+	start := time.Now()
+	err := exporter.Export(ctx, source.RepoName(), source.RepoPath(), source.ID(), sourceHash, source.LocalPath(), source.Description(), samples)
+	if err == nil {
+		err = exportDetails(ctx, exporter, sourceHash, details)
+	}
+
+	exportStatus := &ExportStatus{Exporter: exporter.Name(), Sha256: sourceHash, Status: exported, ExportedAt: time.Now().Unix(), Duration: time.Since(start), SampleCount: len(samples)}
+	if err != nil {
+		err = fmt.Errorf("%s exporter: %v", exporter.Name(), err)
+		exportStatus.Status = failed
+		exportStatus.Error = err.Error()
+	}
+	if err := h.Storage.UpdateExport(ctx, qHash, exportStatus); err != nil {
+		glog.Errorf("could not update storage: %v", err)
+	}
+	glog.Infof("Done exporting samples from %s with %s using %s exporter", source.ID(), sourceHash, exporter.Name())
+
+	return err
+}
+
+// sourceDetails holds data about a source that's exported in addition to its samples, by exporters
+// that support it.
+type sourceDetails struct {
+	os  *common.OSInfo
+	pkg *common.PackageInfo
+}
+
+// exportDetails exports data about a source that was already exported.
+func exportDetails(ctx context.Context, exporter Exporter, sourceHash string, details sourceDetails) error {
+	if osExporter, ok := exporter.(OSExporter); ok && details.os != nil {
+		if err := osExporter.ExportOS(ctx, sourceHash, details.os); err != nil {
+			return fmt.Errorf("could not export operating system: %v", err)
+		}
+	}
+	if packageExporter, ok := exporter.(PackageExporter); ok && details.pkg != nil {
+		if err := packageExporter.ExportPackage(ctx, sourceHash, details.pkg); err != nil {
+			return fmt.Errorf("could not export package metadata: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// backfill exports an already exported source with exporters that did not succeed for it yet.
+// Samples are reconstructed from the local cache and samples saved in ExportPath, the source is
+// not processed again. content holds paths to the content of samples saved with other sources.
+func (h *HashR) backfill(ctx context.Context, source Source, c *sync.Map, content map[string]string) error {
+	qHash, err := source.QuickSHA256Hash()
+	if err != nil {
+		return fmt.Errorf("could not calculate quick sha256 value: %v", err)
+	}
+
+	job, err := h.Storage.FetchJob(ctx, qHash)
+	if err != nil {
+		return fmt.Errorf("could not fetch processing job: %v", err)
+	}
+	if job == nil || job.Sha256 == "" {
+		return fmt.Errorf("SHA256 of the source is unknown")
+	}
+
+	linkDir, err := ioutil.TempDir("", "hashr-backfill-")
+	if err != nil {
+		return fmt.Errorf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(linkDir)
+
+	samples, err := h.backfillSamples(source, job.Sha256, c, content, filepath.Join(linkDir, "extracted"))
+	if err != nil {
+		return err
+	}
+	h.analyze(ctx, source, samples)
+
+	// Operating system and package metadata are only known for sources saved to ExportPath.
+	var details sourceDetails
+	savedDir := filepath.Join(h.ExportPath, fmt.Sprintf("%s___%s___%s", source.RepoName(), source.ID(), job.Sha256))
+	if data, err := ioutil.ReadFile(filepath.Join(savedDir, "source.json")); err == nil {
+		var saved savedSource
+		if err := json.Unmarshal(data, &saved); err != nil {
+			glog.Warningf("could not unmarshal %s: %v", filepath.Join(savedDir, "source.json"), err)
+		}
+		details = sourceDetails{os: saved.OS, pkg: saved.Package}
+	}
+
+	var errs []string
+	for _, exporter := range h.pendingExporters(qHash) {
+		if err := h.export(ctx, qHash, exporter, source, job.Sha256, details, samples); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ";"))
+	}
+
+	return nil
+}
+
+// backfillSamples returns samples of an already processed source. Paths are taken from the local
+// cache, the content of samples is available only if they were saved in ExportPath. Content saved
+// with other sources is linked under linkRoot, so paths of other sources are not recorded for this
+// one. Exporters need the content of every sample, so an error is returned if it can't be found for
+// any of them.
+func (h *HashR) backfillSamples(source Source, sourceHash string, c *sync.Map, content map[string]string, linkRoot string) ([]common.Sample, error) {
+	subDir := fmt.Sprintf("%s___%s___%s", source.RepoName(), source.ID(), sourceHash)
+	savedDir := filepath.Join(h.ExportPath, subDir)
+	// Cached paths are relative to the extraction root, exporters expect them under it.
+	extractionRoot := filepath.Join(savedDir, "extracted")
+
+	savedPaths := make(map[string]string)
+	data, err := ioutil.ReadFile(filepath.Join(savedDir, "samples.json"))
+	if err == nil {
+		var saved []common.Sample
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return nil, fmt.Errorf("could not unmarshal saved samples: %v", err)
+		}
+		for _, sample := range saved {
+			if sample.Upload && len(sample.Paths) > 0 {
+				savedPaths[sample.Sha256] = sample.Paths[0]
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read saved samples: %v", err)
+	}
+
+	cachedPaths := cache.SourcePaths(c, sourceHash)
+	if len(cachedPaths) == 0 && len(savedPaths) == 0 {
+		return nil, fmt.Errorf("no samples of %s found in the cache or in %s", sourceHash, savedDir)
+	}
+
+	for hash := range savedPaths {
+		if _, ok := cachedPaths[hash]; !ok {
+			cachedPaths[hash] = []string{}
+		}
+	}
+
+	var samples []common.Sample
+	var missing int
+	for hash, paths := range cachedPaths {
+		sample := common.Sample{Sha256: hash}
+		for _, path := range paths {
+			sample.Paths = append(sample.Paths, filepath.Join(extractionRoot, path))
+		}
+		if savedPath, ok := savedPaths[hash]; ok {
+			// Content of samples saved with the extraction layout is already at one of the paths.
+			if !containsPath(sample.Paths, savedPath) {
+				sample.Paths = append(sample.Paths, savedPath)
+			}
+			sample.Upload = true
+		}
+		if _, _, err := sample.FirstValidPath(); err != nil {
+			// Content of samples seen before is saved with the source they were first seen in.
+			ok, err := linkContent(&sample, paths, content, linkRoot)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				missing++
+				continue
+			}
+		}
+		samples = append(samples, sample)
+	}
+	if missing > 0 {
+		return nil, fmt.Errorf("content of %d out of %d samples of %s not found in %s", missing, len(cachedPaths), sourceHash, h.ExportPath)
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Sha256 < samples[j].Sha256
+	})
+
+	return samples, nil
+}
+
+// linkContent makes content of a sample saved with another source available under linkRoot, at
+// the given paths relative to the extraction root, and sets sample paths to them. It returns false
+// if the content of the sample can't be found.
+func linkContent(sample *common.Sample, relPaths []string, content map[string]string, linkRoot string) (bool, error) {
+	contentPath, ok := content[sample.Sha256]
+	if !ok || len(relPaths) == 0 {
+		return false, nil
+	}
+
+	sample.Paths = nil
+	for _, path := range relPaths {
+		sample.Paths = append(sample.Paths, filepath.Join(linkRoot, path))
+	}
+	if err := linkFile(contentPath, sample.Paths[0]); err != nil {
+		return false, fmt.Errorf("could not link content of sample %s: %v", sample.Sha256, err)
+	}
+
+	return true, nil
+}
+
+// linkFile makes the content of src available at dst, as a hard link or as a copy if the link
+// can't be created (e.g. src is on a different file system).
+func linkFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(dst, data, 0644)
+}
+
+// savedContent returns paths to the content of samples saved to exportPath by runs with export
+// disabled, keyed by sample SHA256.
+func savedContent(exportPath string) (map[string]string, error) {
+	content := make(map[string]string)
+	entries, err := ioutil.ReadDir(exportPath)
+	if os.IsNotExist(err) {
+		return content, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", exportPath, err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.Contains(entry.Name(), "___") {
+			continue
+		}
+
+		samplesPath := filepath.Join(exportPath, entry.Name(), "samples.json")
+		data, err := ioutil.ReadFile(samplesPath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read saved samples: %v", err)
+		}
+
+		var saved []common.Sample
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return nil, fmt.Errorf("could not unmarshal %s: %v", samplesPath, err)
+		}
+		for _, sample := range saved {
+			if _, ok := content[sample.Sha256]; !ok && sample.Upload && len(sample.Paths) > 0 {
+				content[sample.Sha256] = sample.Paths[0]
+			}
+		}
+	}
 
-	return newSources, nil
+	return content, nil
+}
+
+func containsPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
 }
 
 func contains(slice []string, s string) bool {
@@ -263,22 +610,18 @@ func (h *HashR) Run(ctx context.Context) error {
 	h.cacheSaveCounter = 0
 
 	for _, importer := range h.Importers {
-		newSources, err := h.newSources(ctx, importer)
+		newSources, backfillSources, err := h.newSources(ctx, importer)
 		if err != nil {
 			glog.Errorf("skipping %s repo: %v", importer.RepoName(), err)
 			continue
 		}
 
-		if len(newSources) == 0 {
+		if len(newSources) == 0 && len(backfillSources) == 0 {
 			glog.Infof("No new sources in %s (%s) repo.", importer.RepoName(), importer.RepoPath())
 			continue
 		}
 
-		c, err := cache.Load(importer.RepoName(), h.CacheDir)
-		if err != nil {
-			glog.Errorf("skipping %s repo: %v", importer.RepoName(), err)
-			continue
-		}
+		c := cache.LoadOrNew(importer.RepoName(), h.CacheDir)
 
 		processingJobs := make(chan Source)
 		for w := 1; w <= h.ProcessingWorkerCount; w++ {
@@ -295,6 +638,20 @@ func (h *HashR) Run(ctx context.Context) error {
 
 		h.wg.Wait()
 
+		if len(backfillSources) > 0 {
+			content, err := savedContent(h.ExportPath)
+			if err != nil {
+				glog.Errorf("%s: skipping backfill: %v", importer.RepoName(), err)
+				backfillSources = nil
+			}
+			for _, source := range backfillSources {
+				glog.Infof("Backfilling %s", source.ID())
+				if err := h.backfill(ctx, source, c, content); err != nil {
+					glog.Errorf("%s: could not backfill source %s: %v", source.RepoName(), source.ID(), err)
+				}
+			}
+		}
+
 		err = cache.Save(importer.RepoName(), h.CacheDir, c)
 		if err != nil {
 			glog.Errorf("could not save %s repo cache: %v", importer.RepoName(), err)
@@ -370,6 +727,16 @@ func (h *HashR) processingWorker(ctx context.Context, newSources <-chan Source,
 
 		glog.Infof("Done checking cache for existing samples from %s", source.ID())
 
+		if err := attachMetadata(extraction, samples); err != nil {
+			glog.Warningf("could not attach file metadata to samples from %s: %v", source.ID(), err)
+		}
+
+		h.analyze(ctx, source, samples)
+		details := sourceDetails{os: h.detectOS(source, extraction)}
+		if packageSource, ok := source.(PackageSource); ok {
+			details.pkg = packageSource.Package()
+		}
+
 		h.processingSourcesMutex.RLock()
 		h.processingSources[qHash].Status = cached
 		processingSource = h.processingSources[qHash]
@@ -390,17 +757,14 @@ func (h *HashR) processingWorker(ctx context.Context, newSources <-chan Source,
 			h.cacheSaveCounter = 0
 		}
 
-		// TODO(mlegin): Iterate over all definied exporters.
 		if h.Export {
 			var errs []string
 			start := time.Now()
-			for _, exporter := range h.Exporters {
-				glog.Infof("Exporting samples from %s with %s hash using %s exporter", source.ID(), extraction.SourceSHA256, exporter.Name())
-				err = exporter.Export(ctx, source.RepoName(), source.RepoPath(), extraction.SourceID, extraction.SourceSHA256, source.LocalPath(), source.Description(), samples)
-				if err != nil {
+			// Exporters that already succeeded for this source are skipped when it's reprocessed.
+			for _, exporter := range h.pendingExporters(qHash) {
+				if err := h.export(ctx, qHash, exporter, source, extraction.SourceSHA256, details, samples); err != nil {
 					errs = append(errs, err.Error())
 				}
-				glog.Infof("Done exporting samples from %s with %s using %s exporter", source.ID(), extraction.SourceSHA256, exporter.Name())
 			}
 
 			if len(errs) > 0 {
@@ -422,7 +786,7 @@ func (h *HashR) processingWorker(ctx context.Context, newSources <-chan Source,
 			}
 
 		} else {
-			err = h.saveSamples(source.RepoName(), extraction.SourceID, extraction.SourceSHA256, samples)
+			err = h.saveSamples(qHash, source, extraction, details, samples)
 			if err != nil {
 				h.processingSourcesMutex.RLock()
 				processingSource := h.processingSources[qHash]
@@ -450,10 +814,159 @@ func (h *HashR) processingWorker(ctx context.Context, newSources <-chan Source,
 	}
 }
 
-func (h *HashR) saveSamples(sourceImporter, sourceID, sourceHash string, samples []common.Sample) error {
+// detectOS returns the operating system of an extracted source, nil if it's not known. Detection
+// errors are logged.
+func (h *HashR) detectOS(source Source, extraction *common.Extraction) *common.OSInfo {
+	if h.OSDetector == nil {
+		return nil
+	}
+
+	sourceOS, err := h.OSDetector.DetectOS(extraction.Path)
+	if err != nil {
+		glog.Warningf("could not detect operating system of %s: %v", source.ID(), err)
+		return nil
+	}
+	if sourceOS != nil {
+		glog.Infof("Detected %s %s (%s) in %s", sourceOS.ID, sourceOS.Version, sourceOS.Architecture, source.ID())
+	}
+
+	return sourceOS
+}
+
+// analyze runs analyzers on samples of a given source. Analyzer errors are logged, samples are
+// exported without the data of failed analyzers.
+func (h *HashR) analyze(ctx context.Context, source Source, samples []common.Sample) {
+	for _, analyzer := range h.Analyzers {
+		glog.Infof("Running %s analyzer on samples from %s", analyzer.Name(), source.ID())
+		if err := analyzer.Analyze(ctx, samples); err != nil {
+			glog.Warningf("%s analyzer failed on samples from %s: %v", analyzer.Name(), source.ID(), err)
+		}
+	}
+}
+
+// savedSource holds data about a source saved to ExportPath, which is needed to export it later.
+type savedSource struct {
+	QuickSha256      string `json:"quick_sha256"`
+	ID               string `json:"id"`
+	RepoName         string `json:"repo_name"`
+	RepoPath         string `json:"repo_path"`
+	LocalPath        string `json:"local_path"`
+	RemoteSourcePath string `json:"remote_source_path"`
+	Description      string `json:"description"`
+	Sha256           string `json:"sha256"`
+	ImportedAt       int64  `json:"imported_at"`
+	// OS is the operating system detected from the extracted files, if any.
+	OS *common.OSInfo `json:"os,omitempty"`
+	// Package holds metadata of sources that are single packages.
+	Package *common.PackageInfo `json:"package,omitempty"`
+}
+
+// maxLinkHops is the maximum number of symbolic links followed when resolving a link target.
+const maxLinkHops = 8
+
+// attachMetadata adds file metadata saved during preprocessing or processing to samples. Symbolic
+// links pointing to a sample are added to its paths, so exporters can record them.
+func attachMetadata(extraction *common.Extraction, samples []common.Sample) error {
+	metadata, err := common.ReadMetadata(filepath.Join(extraction.BaseDir, common.MetadataFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Index of samples by their path relative to the extraction root and the part of the path
+	// preceding it, which is used to construct paths of symbolic links.
+	type samplePath struct {
+		index  int
+		prefix string
+	}
+	byPath := make(map[string]samplePath)
+	for i := range samples {
+		for _, path := range samples[i].Paths {
+			relPath, ok := extraction.RelativePath(path)
+			if !ok {
+				continue
+			}
+			relPath = strings.TrimPrefix(filepath.ToSlash(relPath), "/")
+			byPath[relPath] = samplePath{index: i, prefix: strings.TrimSuffix(path, relPath)}
+			if md, ok := metadata[relPath]; ok {
+				if samples[i].Metadata == nil {
+					samples[i].Metadata = make(map[string]*common.FileMetadata)
+				}
+				samples[i].Metadata[path] = md
+			}
+		}
+	}
+
+	var links []string
+	for relPath, md := range metadata {
+		if md.LinkTarget != "" {
+			links = append(links, relPath)
+		}
+	}
+	sort.Strings(links)
+
+	for _, link := range links {
+		target, ok := resolveLink(metadata, link)
+		if !ok {
+			continue
+		}
+		sp, ok := byPath[target]
+		if !ok {
+			continue
+		}
+		sample := &samples[sp.index]
+		linkPath := sp.prefix + link
+		if containsPath(sample.Paths, linkPath) {
+			continue
+		}
+		sample.Paths = append(sample.Paths, linkPath)
+		if sample.Metadata == nil {
+			sample.Metadata = make(map[string]*common.FileMetadata)
+		}
+		sample.Metadata[linkPath] = metadata[link]
+	}
+
+	return nil
+}
+
+// resolveLink returns the path, relative to the extraction root, of the file a symbolic link
+// points to. Links that point outside of the extraction root, to missing files or that form loops
+// are not resolved.
+func resolveLink(metadata map[string]*common.FileMetadata, link string) (string, bool) {
+	current := link
+	for i := 0; i < maxLinkHops; i++ {
+		md, ok := metadata[current]
+		if !ok {
+			return "", false
+		}
+		if md.LinkTarget == "" {
+			return current, true
+		}
+
+		target := md.LinkTarget
+		if !strings.HasPrefix(target, "/") {
+			target = filepath.Join(filepath.Dir(current), target)
+		}
+		target = strings.TrimPrefix(filepath.Clean("/"+target), "/")
+		if target == "" {
+			return "", false
+		}
+		current = target
+	}
+
+	return "", false
+}
+
+// saveSamples saves samples and data about the source to ExportPath, so they can be exported
+// later. Files are saved under the extracted/ folder keeping their path relative to the extraction
+// root, paths of samples that were already in the cache are kept without the content.
+func (h *HashR) saveSamples(qHash string, source Source, extraction *common.Extraction, details sourceDetails, samples []common.Sample) error {
 	var samplesOut []common.Sample
-	subDir := fmt.Sprintf("%s___%s___%s", sourceImporter, sourceID, sourceHash)
+	subDir := fmt.Sprintf("%s___%s___%s", source.RepoName(), extraction.SourceID, extraction.SourceSHA256)
 	destDir := filepath.Join(h.ExportPath, subDir)
+	extractionRoot := filepath.Join(destDir, "extracted")
 	glog.Infof("Saving samples locally to %s", destDir)
 
 	if err := os.MkdirAll(destDir, 0755); err != nil {
@@ -461,20 +974,33 @@ func (h *HashR) saveSamples(sourceImporter, sourceID, sourceHash string, samples
 	}
 
 	for _, sample := range samples {
-		if !sample.Upload {
-			samplesOut = append(samplesOut, common.Sample{Sha256: sample.Sha256, Upload: false})
-		} else {
-			if err := os.Mkdir(filepath.Join(destDir, sample.Sha256), 0755); err != nil {
-				return err
+		sampleOut := common.Sample{Sha256: sample.Sha256, Paths: []string{}, Upload: sample.Upload, Executable: sample.Executable, Signatures: sample.Signatures}
+		for _, path := range sample.Paths {
+			relPath, ok := extraction.RelativePath(path)
+			if !ok {
+				glog.Warningf("sample path does not follow expected format: %s", path)
+				continue
 			}
-
-			var samplePath string
-			// If sample has more than one path associated with it, take the first that is valid.
-			for _, path := range sample.Paths {
-				if _, err := os.Stat(path); err == nil {
-					samplePath = path
-					break
+			savedPath := filepath.Join(extractionRoot, relPath)
+			sampleOut.Paths = append(sampleOut.Paths, savedPath)
+			if md, ok := sample.Metadata[path]; ok {
+				if sampleOut.Metadata == nil {
+					sampleOut.Metadata = make(map[string]*common.FileMetadata)
+				}
+				sampleOut.Metadata[savedPath] = md
+			}
+			if owner, ok := sample.Packages[path]; ok {
+				if sampleOut.Packages == nil {
+					sampleOut.Packages = make(map[string]*common.PackageOwner)
 				}
+				sampleOut.Packages[savedPath] = owner
+			}
+		}
+
+		if sample.Upload {
+			samplePath, _, err := sample.FirstValidPath()
+			if err != nil {
+				return err
 			}
 
 			input, err := ioutil.ReadFile(samplePath)
@@ -482,15 +1008,25 @@ func (h *HashR) saveSamples(sourceImporter, sourceID, sourceHash string, samples
 				return err
 			}
 
+			// Content is saved at the first path, followed by the remaining paths of the sample.
 			destFile := filepath.Join(destDir, sample.Sha256, filepath.Base(samplePath))
+			if len(sampleOut.Paths) > 0 {
+				destFile = sampleOut.Paths[0]
+			} else {
+				sampleOut.Paths = append(sampleOut.Paths, destFile)
+			}
+
+			if err := os.MkdirAll(filepath.Dir(destFile), 0755); err != nil {
+				return err
+			}
 
 			err = ioutil.WriteFile(destFile, input, 0755)
 			if err != nil {
 				return err
 			}
-
-			samplesOut = append(samplesOut, common.Sample{Sha256: sample.Sha256, Paths: []string{destFile}, Upload: true})
 		}
+
+		samplesOut = append(samplesOut, sampleOut)
 	}
 
 	jsonBytes, err := json.Marshal(samplesOut)
@@ -503,5 +1039,26 @@ func (h *HashR) saveSamples(sourceImporter, sourceID, sourceHash string, samples
 		return err
 	}
 
-	return nil
+	h.processingSourcesMutex.RLock()
+	importedAt := h.processingSources[qHash].ImportedAt
+	h.processingSourcesMutex.RUnlock()
+
+	jsonBytes, err = json.Marshal(&savedSource{
+		QuickSha256:      qHash,
+		ID:               source.ID(),
+		RepoName:         source.RepoName(),
+		RepoPath:         source.RepoPath(),
+		LocalPath:        source.LocalPath(),
+		RemoteSourcePath: source.RemotePath(),
+		Description:      source.Description(),
+		Sha256:           extraction.SourceSHA256,
+		ImportedAt:       importedAt,
+		OS:               details.os,
+		Package:          details.pkg,
+	})
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(destDir, "source.json"), jsonBytes, 0755)
 }
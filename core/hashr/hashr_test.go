@@ -18,11 +18,19 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/golang/glog"
+	"github.com/google/go-cmp/cmp"
 
 	"github.com/google/hashr/common"
+	"github.com/google/hashr/exporters/postgres"
+
+	cpb "github.com/google/hashr/cache/proto"
 
 	"cloud.google.com/go/spanner"
 	"google.golang.org/api/option"
@@ -117,6 +125,9 @@ func (s *testSource) LocalPath() string {
 func (s *testSource) Description() string {
 	return ""
 }
+func (s *testSource) Package() *common.PackageInfo {
+	return &common.PackageInfo{Manager: "dpkg", Package: "test-" + s.id, Version: "1.0"}
+}
 
 type testProcessor struct {
 }
@@ -267,3 +278,505 @@ func (s *fakeStorage) UpdateJobs(ctx context.Context, qHash string, p *Processin
 func (s *fakeStorage) FetchJobs(ctx context.Context) (map[string]string, error) {
 	return make(map[string]string), nil
 }
+
+// FetchJob fetches a single processing job from cloud spanner.
+func (s *fakeStorage) FetchJob(ctx context.Context, qHash string) (*ProcessingSource, error) {
+	return nil, nil
+}
+
+// UpdateExport updates export status in cloud spanner.
+func (s *fakeStorage) UpdateExport(ctx context.Context, qHash string, e *ExportStatus) error {
+	return nil
+}
+
+// FetchExports fetches export statuses from cloud spanner.
+func (s *fakeStorage) FetchExports(ctx context.Context) (map[string]map[string]*ExportStatus, error) {
+	return make(map[string]map[string]*ExportStatus), nil
+}
+
+// memStorage keeps processing jobs and export statuses in memory.
+type memStorage struct {
+	mu      sync.Mutex
+	jobs    map[string]ProcessingSource
+	exports map[string]map[string]*ExportStatus
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{jobs: make(map[string]ProcessingSource), exports: make(map[string]map[string]*ExportStatus)}
+}
+
+func (s *memStorage) UpdateJobs(ctx context.Context, qHash string, p *ProcessingSource) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[qHash] = *p
+	return nil
+}
+
+func (s *memStorage) FetchJobs(ctx context.Context) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make(map[string]string)
+	for qHash, p := range s.jobs {
+		jobs[qHash] = string(p.Status)
+	}
+	return jobs, nil
+}
+
+func (s *memStorage) FetchJob(ctx context.Context, qHash string) (*ProcessingSource, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.jobs[qHash]
+	if !ok {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+func (s *memStorage) UpdateExport(ctx context.Context, qHash string, e *ExportStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.exports[qHash]; !ok {
+		s.exports[qHash] = make(map[string]*ExportStatus)
+	}
+	exportStatus := *e
+	s.exports[qHash][e.Exporter] = &exportStatus
+	return nil
+}
+
+func (s *memStorage) FetchExports(ctx context.Context) (map[string]map[string]*ExportStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exports := make(map[string]map[string]*ExportStatus)
+	for qHash, statuses := range s.exports {
+		exports[qHash] = make(map[string]*ExportStatus)
+		for name, e := range statuses {
+			exportStatus := *e
+			exports[qHash][name] = &exportStatus
+		}
+	}
+	return exports, nil
+}
+
+type countingProcessor struct {
+	calls int
+}
+
+func (p *countingProcessor) ImageExport(sourcePath string) (string, error) {
+	p.calls++
+	return "testdata/20200106.00.00-ubuntu-laptop-export", nil
+}
+
+type countingExporter struct {
+	name     string
+	fail     bool
+	calls    int
+	samples  []common.Sample
+	sourceOS *common.OSInfo
+	pkg      *common.PackageInfo
+}
+
+func (e *countingExporter) Export(ctx context.Context, repoName, repoPath, sourceID, sourceHash, sourcePath, sourceDescription string, samples []common.Sample) error {
+	e.calls++
+	e.samples = samples
+	if e.fail {
+		return fmt.Errorf("export failed")
+	}
+	return nil
+}
+
+func (e *countingExporter) ExportOS(ctx context.Context, sourceHash string, sourceOS *common.OSInfo) error {
+	e.sourceOS = sourceOS
+	return nil
+}
+
+func (e *countingExporter) ExportPackage(ctx context.Context, sourceHash string, pkg *common.PackageInfo) error {
+	e.pkg = pkg
+	return nil
+}
+
+func (e *countingExporter) Name() string {
+	return e.name
+}
+
+type testDetector struct {
+}
+
+func (d *testDetector) DetectOS(extractionDir string) (*common.OSInfo, error) {
+	return &common.OSInfo{Family: "linux", ID: "ubuntu", Version: "22.04", Architecture: "arm64"}, nil
+}
+
+type testAnalyzer struct {
+	calls int
+}
+
+func (a *testAnalyzer) Analyze(ctx context.Context, samples []common.Sample) error {
+	a.calls++
+	for i := range samples {
+		if samples[i].Upload {
+			samples[i].Executable = &common.ExecutableMetadata{Format: "test"}
+		}
+	}
+	return nil
+}
+
+func (a *testAnalyzer) Name() string {
+	return "test"
+}
+
+type failingAnalyzer struct {
+}
+
+func (a *failingAnalyzer) Analyze(ctx context.Context, samples []common.Sample) error {
+	return fmt.Errorf("analysis failed")
+}
+
+func (a *failingAnalyzer) Name() string {
+	return "failing"
+}
+
+func TestRunExportStatus(t *testing.T) {
+	ctx := context.Background()
+	qHashes := []string{"7a3e6b16cb75f48fb897eff3ae732f3154f6d203b53f33660f01b4c3b6bc2df9", "a1dd6837f284625bdb1cb68f1dbc85c5dc4d8b05bae24c94ed5f55c477326ea2"}
+
+	storage := newMemStorage()
+	processor := &countingProcessor{}
+	good := &countingExporter{name: "good"}
+	bad := &countingExporter{name: "bad", fail: true}
+
+	h := New([]Importer{&testImporter{}}, processor, []Exporter{good, bad}, storage)
+	h.CacheDir = t.TempDir()
+	h.ExportPath = t.TempDir()
+	h.Export = true
+	h.ProcessingWorkerCount = 1
+	analyzer := &testAnalyzer{}
+	h.Analyzers = []Analyzer{&failingAnalyzer{}, analyzer}
+	h.OSDetector = &testDetector{}
+
+	checkStatuses := func(wantJob string, wantExports map[string]string) {
+		t.Helper()
+		for _, qHash := range qHashes {
+			if got := string(storage.jobs[qHash].Status); got != wantJob {
+				t.Errorf("job %s status = %s; want = %s", qHash, got, wantJob)
+			}
+			gotExports := make(map[string]string)
+			for name, e := range storage.exports[qHash] {
+				gotExports[name] = string(e.Status)
+			}
+			if diff := cmp.Diff(wantExports, gotExports); diff != "" {
+				t.Errorf("source %s unexpected export statuses (-want/+got):\n%s", qHash, diff)
+			}
+		}
+	}
+
+	if err := h.Run(ctx); err != nil {
+		t.Fatalf("unexpected error while running hashR: %v", err)
+	}
+	checkStatuses(failed, map[string]string{"good": exported, "bad": failed})
+	if analyzer.calls != 2 {
+		t.Errorf("analyzer calls = %d; want = 2", analyzer.calls)
+	}
+	if good.sourceOS == nil || good.sourceOS.ID != "ubuntu" {
+		t.Errorf("exported operating system = %v; want ubuntu", good.sourceOS)
+	}
+	if good.pkg == nil || !strings.HasPrefix(good.pkg.Package, "test-") {
+		t.Errorf("exported package = %v; want test source package", good.pkg)
+	}
+	// Operating system and package are exported only with samples.
+	if bad.sourceOS != nil || bad.pkg != nil {
+		t.Errorf("operating system or package exported by a failed exporter: %v, %v", bad.sourceOS, bad.pkg)
+	}
+	for _, sample := range good.samples {
+		if sample.Upload && (sample.Executable == nil || sample.Executable.Format != "test") {
+			t.Errorf("exported sample %s was not analyzed", sample.Sha256)
+		}
+	}
+
+	// Reprocessing runs only the exporter that failed.
+	bad.fail = false
+	h.SourcesForReprocessing = qHashes
+	if err := h.Run(ctx); err != nil {
+		t.Fatalf("unexpected error while running hashR: %v", err)
+	}
+	checkStatuses(exported, map[string]string{"good": exported, "bad": exported})
+	if good.calls != 2 || bad.calls != 4 {
+		t.Errorf("exporter calls: good = %d, bad = %d; want = 2, 4", good.calls, bad.calls)
+	}
+
+	// Backfill fails before exporting if the content of samples was not saved.
+	added := &countingExporter{name: "added"}
+	h.Exporters = append(h.Exporters, added)
+	h.SourcesForReprocessing = nil
+	h.Backfill = true
+	if err := h.Run(ctx); err != nil {
+		t.Fatalf("unexpected error while running hashR: %v", err)
+	}
+	if added.calls != 0 {
+		t.Errorf("added exporter calls = %d; want = 0", added.calls)
+	}
+	checkStatuses(exported, map[string]string{"good": exported, "bad": exported})
+
+	// New exporter is backfilled without processing the sources again, once the extraction is saved.
+	for _, id := range []string{"001", "002"} {
+		extractionRoot := filepath.Join(h.ExportPath, "ubuntu___"+id+"___e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", "extracted")
+		copyTestDir(t, "testdata/20200106.00.00-ubuntu-laptop-export/tmp", filepath.Join(extractionRoot, "tmp"))
+	}
+	if err := h.Run(ctx); err != nil {
+		t.Fatalf("unexpected error while running hashR: %v", err)
+	}
+	checkStatuses(exported, map[string]string{"good": exported, "bad": exported, "added": exported})
+	if processor.calls != 4 {
+		t.Errorf("processor calls = %d; want = 4", processor.calls)
+	}
+	if added.calls != 2 || good.calls != 2 || bad.calls != 4 {
+		t.Errorf("exporter calls: added = %d, good = %d, bad = %d; want = 2, 2, 4", added.calls, good.calls, bad.calls)
+	}
+	if len(added.samples) != 10 {
+		t.Fatalf("backfilled %d samples; want = 10", len(added.samples))
+	}
+	for _, sample := range added.samples {
+		if sample.Upload {
+			t.Errorf("backfilled sample %s marked for upload without saved samples", sample.Sha256)
+		}
+		if len(sample.Paths) != 1 {
+			t.Fatalf("backfilled sample %s has %d paths; want = 1", sample.Sha256, len(sample.Paths))
+		}
+		if relPath, ok := common.TrimExtractionRoot(sample.Paths[0]); !ok || !strings.HasPrefix(relPath, "tmp/hashr/20200106.00.00-ubuntu-laptop/") {
+			t.Errorf("backfilled sample %s has unexpected path %s", sample.Sha256, sample.Paths[0])
+		}
+	}
+
+	// Nothing left to backfill.
+	if err := h.Run(ctx); err != nil {
+		t.Fatalf("unexpected error while running hashR: %v", err)
+	}
+	if added.calls != 2 {
+		t.Errorf("added exporter calls = %d; want = 2", added.calls)
+	}
+}
+
+func TestBackfillSamples(t *testing.T) {
+	sourceHash := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	source := &testSource{id: "001", localPath: "/tmp/001", quickSha256hash: "7a3e6b16cb75f48fb897eff3ae732f3154f6d203b53f33660f01b4c3b6bc2df9", repoPath: "/tmp/"}
+
+	h := &HashR{ExportPath: t.TempDir()}
+	savedDir := filepath.Join(h.ExportPath, "ubuntu___001___"+sourceHash)
+	savedPath := filepath.Join(savedDir, "aaaa", "ls")
+	writeTestFile(t, savedPath)
+	saved := `[{"sha256":"aaaa","paths":["` + savedPath + `"],"Upload":true},{"sha256":"bbbb","Upload":false}]`
+	if err := os.WriteFile(filepath.Join(savedDir, "samples.json"), []byte(saved), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Content of bbbb is saved with a source it was seen in before.
+	otherPath := filepath.Join(h.ExportPath, "ubuntu___000___other", "extracted", "bin", "cat")
+	writeTestFile(t, otherPath)
+	otherSaved := `[{"sha256":"bbbb","paths":["` + otherPath + `"],"Upload":true}]`
+	if err := os.WriteFile(filepath.Join(h.ExportPath, "ubuntu___000___other", "samples.json"), []byte(otherSaved), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := savedContent(h.ExportPath)
+	if err != nil {
+		t.Fatalf("unexpected error while running savedContent(): %v", err)
+	}
+	if diff := cmp.Diff(map[string]string{"aaaa": savedPath, "bbbb": otherPath}, content); diff != "" {
+		t.Errorf("savedContent() unexpected diff (-want/+got):\n%s", diff)
+	}
+
+	var c sync.Map
+	c.Store("aaaa", &cpb.Entries{Entries: []*cpb.CacheEntry{{SourceId: "001", SourceHash: sourceHash, Path: []string{"bin/ls", "usr/bin/ls"}}}})
+	c.Store("bbbb", &cpb.Entries{Entries: []*cpb.CacheEntry{{SourceId: "001", SourceHash: sourceHash, Path: []string{"bin/cat"}}}})
+	c.Store("cccc", &cpb.Entries{Entries: []*cpb.CacheEntry{{SourceId: "002", SourceHash: "other", Path: []string{"bin/sh"}}}})
+
+	linkRoot := filepath.Join(t.TempDir(), "extracted")
+	got, err := h.backfillSamples(source, sourceHash, &c, content, linkRoot)
+	if err != nil {
+		t.Fatalf("unexpected error while running backfillSamples(): %v", err)
+	}
+
+	extractionRoot := filepath.Join(savedDir, "extracted")
+	want := []common.Sample{
+		{Sha256: "aaaa", Paths: []string{filepath.Join(extractionRoot, "bin/ls"), filepath.Join(extractionRoot, "usr/bin/ls"), savedPath}, Upload: true},
+		{Sha256: "bbbb", Paths: []string{filepath.Join(linkRoot, "bin/cat")}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("backfillSamples() unexpected diff (-want/+got):\n%s", diff)
+	}
+	if data, err := os.ReadFile(filepath.Join(linkRoot, "bin/cat")); err != nil || string(data) != "cat" {
+		t.Errorf("backfillSamples() did not link content of bbbb: %q, %v", data, err)
+	}
+
+	if _, err := h.backfillSamples(source, sourceHash, &c, map[string]string{}, linkRoot); err == nil {
+		t.Error("backfillSamples() expected error when content of a sample is missing")
+	}
+
+	if _, err := h.backfillSamples(source, "missing", &sync.Map{}, content, linkRoot); err == nil {
+		t.Error("backfillSamples() expected error when no samples are available")
+	}
+}
+
+func writeTestFile(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(filepath.Base(path)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func copyTestDir(t *testing.T, src, dst string) {
+	t.Helper()
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(dst, filepath.Dir(relPath)), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, relPath), data, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackfillPostgres(t *testing.T) {
+	ctx := context.Background()
+	sourceHash := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	source := &testSource{id: "002", localPath: "/tmp/002", quickSha256hash: "a1dd6837f284625bdb1cb68f1dbc85c5dc4d8b05bae24c94ed5f55c477326ea2", repoPath: "/tmp/"}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("could not open a stub database connection: %v", err)
+	}
+	defer db.Close()
+
+	for _, table := range []string{"samples", "payloads", "sources"} {
+		mock.ExpectQuery(`SELECT EXISTS`).WithArgs(table).WillReturnRows(mock.NewRows([]string{"t"}).AddRow("t"))
+	}
+	mock.ExpectExec(`ALTER TABLE sources`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`ALTER TABLE sources`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE INDEX IF NOT EXISTS sources_os`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT EXISTS`).WithArgs("samples_sources").WillReturnRows(mock.NewRows([]string{"t"}).AddRow("t"))
+	mock.ExpectExec(`ALTER TABLE samples_sources`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`ALTER TABLE samples_sources`).WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, table := range []string{"executables", "signatures"} {
+		mock.ExpectQuery(`SELECT EXISTS`).WithArgs(table).WillReturnRows(mock.NewRows([]string{"t"}).AddRow("t"))
+	}
+
+	exporter, err := postgres.NewExporter(db, false, nil)
+	if err != nil {
+		t.Fatalf("could not create Postgres exporter: %v", err)
+	}
+
+	h := New(nil, nil, []Exporter{exporter}, newMemStorage())
+	h.ExportPath = t.TempDir()
+	if err := h.Storage.UpdateJobs(ctx, source.quickSha256hash, &ProcessingSource{ID: source.id, Sha256: sourceHash, Status: exported}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Source 001 was saved with the content of the samples, source 002 only references them.
+	otherRoot := filepath.Join(h.ExportPath, "ubuntu___001___"+sourceHash, "extracted")
+	var saved []string
+	var c sync.Map
+	for _, name := range []string{"file.01", "file.02"} {
+		path := filepath.Join(otherRoot, "bin", name)
+		writeTestFile(t, path)
+		saved = append(saved, fmt.Sprintf(`{"sha256":"%s","paths":["%s"],"Upload":true}`, name, path))
+		c.Store(name, &cpb.Entries{Entries: []*cpb.CacheEntry{{SourceId: "002", SourceHash: sourceHash, Path: []string{"usr/bin/" + name}}}})
+	}
+	if err := os.WriteFile(filepath.Join(otherRoot, "..", "samples.json"), []byte("["+strings.Join(saved, ",")+"]"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO sources`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`SELECT sha256 FROM samples`).WillReturnRows(mock.NewRows([]string{"sha256"}))
+	for i := 0; i < 5; i++ {
+		mock.ExpectExec(`CREATE TEMP TABLE`).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectPrepare(`COPY "samples_staging"`)
+	mock.ExpectExec(`COPY "samples_staging"`).WithArgs("file.01", "text/plain; charset=utf-8", sqlmock.AnyArg(), 7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`COPY "samples_staging"`).WithArgs("file.02", "text/plain; charset=utf-8", sqlmock.AnyArg(), 7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`COPY "samples_staging"`).WithArgs().WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectPrepare(`COPY "samples_sources_staging"`)
+	mock.ExpectExec(`COPY "samples_sources_staging"`).WithArgs("file.01", `{"usr/bin/file.01"}`, nil, nil).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`COPY "samples_sources_staging"`).WithArgs("file.02", `{"usr/bin/file.02"}`, nil, nil).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`COPY "samples_sources_staging"`).WithArgs().WillReturnResult(sqlmock.NewResult(0, 2))
+	for i := 0; i < 5; i++ {
+		mock.ExpectExec(`INSERT INTO`).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectCommit()
+
+	content, err := savedContent(h.ExportPath)
+	if err != nil {
+		t.Fatalf("unexpected error while running savedContent(): %v", err)
+	}
+	if err := h.backfill(ctx, source, &c, content); err != nil {
+		t.Fatalf("unexpected error while running backfill(): %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("backfill() did not run expected queries: %v", err)
+	}
+	if e := h.Storage.(*memStorage).exports[source.quickSha256hash][postgres.Name]; e == nil || e.Status != exported {
+		t.Errorf("backfill() export status = %+v; want exported", e)
+	}
+}
+
+func TestAttachMetadata(t *testing.T) {
+	baseDir := t.TempDir()
+	extraction := &common.Extraction{BaseDir: baseDir, Path: filepath.Join(baseDir, "export")}
+	lsPath := filepath.Join(extraction.Path, "extracted", "usr", "bin", "ls")
+	catPath := filepath.Join(extraction.Path, "extracted", "usr", "bin", "cat")
+
+	metadata := map[string]*common.FileMetadata{
+		"usr/bin/ls":    {Mode: 0100755, UID: 0, GID: 0, Mtime: 1600000000},
+		"usr/bin/cat":   {Mode: 0100755, UID: -1, GID: -1, User: "root", Group: "root"},
+		"usr/bin/dir":   {Mode: 0120777, LinkTarget: "ls"},
+		"bin":           {Mode: 0120777, LinkTarget: "/usr/bin"},
+		"bin/list":      {Mode: 0120777, LinkTarget: "/usr/bin/dir"},
+		"usr/bin/loop":  {Mode: 0120777, LinkTarget: "loop"},
+		"usr/bin/outer": {Mode: 0120777, LinkTarget: "../../../etc/passwd"},
+	}
+	if err := common.WriteMetadata(filepath.Join(baseDir, common.MetadataFile), metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	samples := []common.Sample{
+		{Sha256: "aaaa", Paths: []string{lsPath}},
+		{Sha256: "bbbb", Paths: []string{catPath}},
+	}
+	if err := attachMetadata(extraction, samples); err != nil {
+		t.Fatalf("unexpected error while running attachMetadata(): %v", err)
+	}
+
+	wantPaths := []string{lsPath, filepath.Join(extraction.Path, "extracted", "bin", "list"), filepath.Join(extraction.Path, "extracted", "usr", "bin", "dir")}
+	if diff := cmp.Diff(wantPaths, samples[0].Paths); diff != "" {
+		t.Errorf("attachMetadata() unexpected paths (-want +got):\n%s", diff)
+	}
+	if md := samples[0].Metadata[lsPath]; md == nil || md.Mtime != 1600000000 {
+		t.Errorf("attachMetadata() unexpected metadata of %s: %+v", lsPath, md)
+	}
+	if md := samples[0].Metadata[wantPaths[2]]; md == nil || md.LinkTarget != "ls" {
+		t.Errorf("attachMetadata() unexpected metadata of %s: %+v", wantPaths[2], md)
+	}
+	if md := samples[1].Metadata[catPath]; len(samples[1].Paths) != 1 || md == nil || md.User != "root" {
+		t.Errorf("attachMetadata() unexpected sample: %+v", samples[1])
+	}
+
+	// Sources without saved metadata are left untouched.
+	extraction.BaseDir = t.TempDir()
+	samples = []common.Sample{{Sha256: "aaaa", Paths: []string{lsPath}}}
+	if err := attachMetadata(extraction, samples); err != nil || samples[0].Metadata != nil {
+		t.Errorf("attachMetadata() = %v, metadata = %v; want no error and no metadata", err, samples[0].Metadata)
+	}
+}
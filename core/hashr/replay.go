@@ -0,0 +1,292 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/hashr/common"
+)
+
+// replaySource is a source that was saved to disk by a previous run with export disabled.
+type replaySource struct {
+	savedSource
+}
+
+func (s *replaySource) ID() string {
+	return s.savedSource.ID
+}
+
+func (s *replaySource) RepoName() string {
+	return s.savedSource.RepoName
+}
+
+func (s *replaySource) RepoPath() string {
+	return s.savedSource.RepoPath
+}
+
+func (s *replaySource) LocalPath() string {
+	return s.savedSource.LocalPath
+}
+
+func (s *replaySource) RemotePath() string {
+	return s.savedSource.RemoteSourcePath
+}
+
+func (s *replaySource) Preprocess() (string, error) {
+	return "", errors.New("saved sources can't be preprocessed")
+}
+
+func (s *replaySource) QuickSHA256Hash() (string, error) {
+	return s.savedSource.QuickSha256, nil
+}
+
+func (s *replaySource) Description() string {
+	return s.savedSource.Description
+}
+
+// readSavedSource reads source data saved by saveSamples. Directories saved by older versions of
+// hashR don't hold source.json, in which case source data is taken from the directory name
+// (<importer>___<id>___<hash>) and the source hash is used in place of the quick hash.
+func readSavedSource(dir string) (*replaySource, error) {
+	parts := strings.Split(filepath.Base(dir), "___")
+	if len(parts) < 3 {
+		return nil, fmt.Errorf("%s does not follow <importer>___<id>___<hash> format", dir)
+	}
+
+	source := &replaySource{savedSource{
+		RepoName:    parts[0],
+		ID:          strings.Join(parts[1:len(parts)-1], "___"),
+		Sha256:      parts[len(parts)-1],
+		QuickSha256: parts[len(parts)-1],
+	}}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "source.json"))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &source.savedSource); err != nil {
+			return nil, fmt.Errorf("error unmarshalling source.json file: %v", err)
+		}
+	case os.IsNotExist(err):
+		glog.Warningf("%s does not contain source.json, using source hash as quick hash", dir)
+	default:
+		return nil, fmt.Errorf("error while reading source.json file: %v", err)
+	}
+
+	return source, nil
+}
+
+// loadSavedSource reads source data and samples saved by saveSamples.
+func loadSavedSource(dir string) (*replaySource, []common.Sample, error) {
+	source, err := readSavedSource(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "samples.json"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error while reading samples.json file: %v", err)
+	}
+
+	var samples []common.Sample
+	if err := json.Unmarshal(data, &samples); err != nil {
+		return nil, nil, fmt.Errorf("error unmarshalling samples.json file: %v", err)
+	}
+
+	return source, samples, nil
+}
+
+// sortByImportTime sorts saved source directories in the order in which the sources were
+// imported. Content of a sample is only saved with the first source it was seen in, so that source
+// needs to be exported before the others. Directories without source.json are replayed first and
+// ties are broken by directory name.
+func sortByImportTime(dirs []string) {
+	importedAt := make(map[string]int64)
+	for _, dir := range dirs {
+		// Directories that can't be read are reported when they are replayed.
+		if source, err := readSavedSource(dir); err == nil {
+			importedAt[dir] = source.ImportedAt
+		}
+	}
+
+	sort.Slice(dirs, func(i, j int) bool {
+		if importedAt[dirs[i]] != importedAt[dirs[j]] {
+			return importedAt[dirs[i]] < importedAt[dirs[j]]
+		}
+		return dirs[i] < dirs[j]
+	})
+}
+
+// Replay exports sources saved to path by runs with export disabled, using the configured
+// exporters, in the order in which the sources were imported. Job and export statuses are updated
+// in the same way as when the sources are processed, sources already exported by all exporters are
+// skipped.
+func (h *HashR) Replay(ctx context.Context, path string) error {
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %v", path, err)
+	}
+
+	var dirs []string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.Contains(entry.Name(), "___") {
+			dirs = append(dirs, filepath.Join(path, entry.Name()))
+		}
+	}
+	sortByImportTime(dirs)
+
+	jobs, err := h.Storage.FetchJobs(ctx)
+	if err != nil {
+		return fmt.Errorf("could not fetch processed sources from storage: %v", err)
+	}
+
+	h.exports, err = h.Storage.FetchExports(ctx)
+	if err != nil {
+		return fmt.Errorf("could not fetch export statuses from storage: %v", err)
+	}
+
+	content, err := savedContent(path)
+	if err != nil {
+		return err
+	}
+
+	var failedCount int
+	for _, dir := range dirs {
+		if err := h.replay(ctx, dir, jobs, content); err != nil {
+			glog.Errorf("could not replay %s: %v", dir, err)
+			failedCount++
+		}
+	}
+
+	if failedCount > 0 {
+		return fmt.Errorf("could not replay %d out of %d sources", failedCount, len(dirs))
+	}
+
+	return nil
+}
+
+// replay exports a single saved source. content holds paths to the content of samples saved with
+// other sources.
+func (h *HashR) replay(ctx context.Context, dir string, jobs map[string]string, content map[string]string) error {
+	source, samples, err := loadSavedSource(dir)
+	if err != nil {
+		return err
+	}
+
+	qHash := source.QuickSha256
+	pending := h.pendingExporters(qHash)
+	if strings.EqualFold(jobs[qHash], exported) && len(pending) == 0 {
+		glog.Infof("Skipping %s, already exported", source.ID())
+		return nil
+	}
+
+	linkDir, err := ioutil.TempDir("", "hashr-replay-")
+	if err != nil {
+		return fmt.Errorf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(linkDir)
+
+	missing, err := replaySamples(samples, content, filepath.Join(linkDir, "extracted"))
+	if err != nil {
+		return err
+	}
+	if missing > 0 {
+		return fmt.Errorf("content of %d out of %d samples of %s not found in %s", missing, len(samples), source.Sha256, filepath.Dir(dir))
+	}
+
+	processingSource := &ProcessingSource{
+		ID:               source.ID(),
+		Repo:             source.RepoName(),
+		RepoPath:         source.RepoPath(),
+		RemoteSourcePath: source.RemotePath(),
+		Sha256:           source.Sha256,
+		ImportedAt:       source.ImportedAt,
+		SampleCount:      len(samples),
+		Status:           cached,
+	}
+	if processingSource.ImportedAt == 0 {
+		processingSource.ImportedAt = time.Now().Unix()
+	}
+	for _, sample := range samples {
+		if sample.Upload {
+			processingSource.ExportCount++
+		}
+	}
+	if err := h.Storage.UpdateJobs(ctx, qHash, processingSource); err != nil {
+		glog.Errorf("could not update storage: %v", err)
+	}
+
+	glog.Infof("Replaying %d samples from %s", len(samples), source.ID())
+	var errs []string
+	start := time.Now()
+	for _, exporter := range pending {
+		if err := h.export(ctx, qHash, exporter, source, source.Sha256, sourceDetails{os: source.OS, pkg: source.Package}, samples); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	processingSource.ExportDuration = time.Since(start)
+
+	var exportErr error
+	processingSource.Status = exported
+	if len(errs) > 0 {
+		exportErr = errors.New(strings.Join(errs, ";"))
+		processingSource.Status = failed
+		processingSource.Error = exportErr.Error()
+	}
+	if err := h.Storage.UpdateJobs(ctx, qHash, processingSource); err != nil {
+		glog.Errorf("could not update storage: %v", err)
+	}
+
+	return exportErr
+}
+
+// replaySamples makes content of saved samples available to exporters. Content is only saved for
+// samples that were new when the source was processed, content of the others is linked under
+// linkRoot from the source it was saved with. It returns the number of samples whose content can't
+// be found.
+func replaySamples(samples []common.Sample, content map[string]string, linkRoot string) (int, error) {
+	var missing int
+	for i := range samples {
+		if _, _, err := samples[i].FirstValidPath(); err == nil {
+			continue
+		}
+
+		var relPaths []string
+		for _, path := range samples[i].Paths {
+			if relPath, ok := common.TrimExtractionRoot(path); ok {
+				relPaths = append(relPaths, relPath)
+			}
+		}
+		ok, err := linkContent(&samples[i], relPaths, content, linkRoot)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			missing++
+		}
+	}
+
+	return missing, nil
+}
@@ -0,0 +1,261 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hashr
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/google/hashr/common"
+	"github.com/google/hashr/exporters/file"
+)
+
+func TestReplay(t *testing.T) {
+	ctx := context.Background()
+	emptySha256 := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	qHashes := map[string]string{
+		"001": "7a3e6b16cb75f48fb897eff3ae732f3154f6d203b53f33660f01b4c3b6bc2df9",
+		"002": "a1dd6837f284625bdb1cb68f1dbc85c5dc4d8b05bae24c94ed5f55c477326ea2",
+	}
+	exportPath := t.TempDir()
+
+	// Process sources without exporting them, e.g. on an air-gapped machine.
+	offline := New([]Importer{&testImporter{}}, &countingProcessor{}, nil, newMemStorage())
+	offline.CacheDir = t.TempDir()
+	offline.ExportPath = exportPath
+	offline.ProcessingWorkerCount = 1
+	if err := offline.Run(ctx); err != nil {
+		t.Fatalf("unexpected error while running hashR: %v", err)
+	}
+
+	// Directory saved by an older version of hashR, without source.json. Its only sample was saved
+	// with the first source.
+	_, saved, err := loadSavedSource(filepath.Join(exportPath, "ubuntu___001___"+emptySha256))
+	if err != nil {
+		t.Fatalf("unexpected error while loading saved source: %v", err)
+	}
+	legacyDir := filepath.Join(exportPath, "ubuntu___003___"+emptySha256)
+	if err := os.MkdirAll(legacyDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	legacySamples := fmt.Sprintf(`[{"sha256":%q,"paths":[%q],"Upload":false}]`, saved[0].Sha256, filepath.Join(legacyDir, "extracted", "etc", "legacy"))
+	if err := os.WriteFile(filepath.Join(legacyDir, "samples.json"), []byte(legacySamples), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	storage := newMemStorage()
+	exporter := &countingExporter{name: "replay"}
+	h := New(nil, nil, []Exporter{exporter}, storage)
+	if err := h.Replay(ctx, exportPath); err != nil {
+		t.Fatalf("unexpected error while running Replay(): %v", err)
+	}
+
+	if exporter.calls != 3 {
+		t.Errorf("Replay() exported %d sources; want = 3", exporter.calls)
+	}
+	// Package metadata is saved with the source.
+	if exporter.pkg == nil || exporter.pkg.Manager != "dpkg" {
+		t.Errorf("Replay() exported package = %v; want saved package", exporter.pkg)
+	}
+
+	for id, qHash := range qHashes {
+		job, ok := storage.jobs[qHash]
+		if !ok {
+			t.Fatalf("Replay() did not record job for source %s", id)
+		}
+		if job.Status != exported || job.ID != id || job.Sha256 != emptySha256 || job.SampleCount != 10 {
+			t.Errorf("Replay() unexpected job for source %s: %+v", id, job)
+		}
+		if e := storage.exports[qHash]["replay"]; e == nil || e.Status != exported {
+			t.Errorf("Replay() did not record export status for source %s", id)
+		}
+	}
+	if job := storage.jobs[emptySha256]; job.Status != exported || job.ID != "003" {
+		t.Errorf("Replay() unexpected job for legacy source: %+v", job)
+	}
+
+	// Samples of the first source were new, the second source only references them.
+	source, samples, err := loadSavedSource(filepath.Join(exportPath, "ubuntu___001___"+emptySha256))
+	if err != nil {
+		t.Fatalf("unexpected error while loading saved source: %v", err)
+	}
+	if source.QuickSha256 != qHashes["001"] || source.LocalPath() != "/tmp/001" {
+		t.Errorf("loadSavedSource() unexpected source: %+v", source.savedSource)
+	}
+	for _, sample := range samples {
+		if !sample.Upload || len(sample.Paths) != 1 {
+			t.Fatalf("sample %s: upload = %v, paths = %v; want upload with a single path", sample.Sha256, sample.Upload, sample.Paths)
+		}
+		checkSavedPath(t, sample)
+		if _, err := os.Stat(sample.Paths[0]); err != nil {
+			t.Errorf("content of sample %s was not saved: %v", sample.Sha256, err)
+		}
+	}
+
+	_, samples, err = loadSavedSource(filepath.Join(exportPath, "ubuntu___002___"+emptySha256))
+	if err != nil {
+		t.Fatalf("unexpected error while loading saved source: %v", err)
+	}
+	for _, sample := range samples {
+		if sample.Upload || len(sample.Paths) != 1 {
+			t.Fatalf("sample %s: upload = %v, paths = %v; want a single path without upload", sample.Sha256, sample.Upload, sample.Paths)
+		}
+		checkSavedPath(t, sample)
+	}
+
+	// Sources that were already exported are skipped.
+	if err := h.Replay(ctx, exportPath); err != nil {
+		t.Fatalf("unexpected error while running Replay(): %v", err)
+	}
+	if exporter.calls != 3 {
+		t.Errorf("Replay() exported already exported sources, calls = %d; want = 3", exporter.calls)
+	}
+}
+
+func TestReplayContent(t *testing.T) {
+	ctx := context.Background()
+	emptySha256 := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	exportPath := t.TempDir()
+
+	offline := New([]Importer{&testImporter{}}, &countingProcessor{}, nil, newMemStorage())
+	offline.CacheDir = t.TempDir()
+	offline.ExportPath = exportPath
+	offline.ProcessingWorkerCount = 1
+	if err := offline.Run(ctx); err != nil {
+		t.Fatalf("unexpected error while running hashR: %v", err)
+	}
+
+	outputDir := t.TempDir()
+	fileExporter, err := file.NewExporter(outputDir, file.FormatJSONL, false, 0, nil)
+	if err != nil {
+		t.Fatalf("could not create file exporter: %v", err)
+	}
+	h := New(nil, nil, []Exporter{fileExporter}, newMemStorage())
+	if err := h.Replay(ctx, exportPath); err != nil {
+		t.Fatalf("unexpected error while running Replay(): %v", err)
+	}
+	if err := fileExporter.Close(); err != nil {
+		t.Fatalf("unexpected error while closing file exporter: %v", err)
+	}
+
+	// Content of samples is only saved with the first source, the second source is exported using
+	// the same content.
+	_, saved, err := loadSavedSource(filepath.Join(exportPath, "ubuntu___001___"+emptySha256))
+	if err != nil {
+		t.Fatalf("unexpected error while loading saved source: %v", err)
+	}
+	var want []file.Record
+	for _, sample := range saved {
+		data, err := os.ReadFile(sample.Paths[0])
+		if err != nil {
+			t.Fatalf("content of sample %s was not saved: %v", sample.Sha256, err)
+		}
+		relPath, _ := common.TrimExtractionRoot(sample.Paths[0])
+		for _, id := range []string{"001", "002"} {
+			want = append(want, file.Record{
+				Sha256:     sample.Sha256,
+				Sha1:       fmt.Sprintf("%x", sha1.Sum(data)),
+				Md5:        fmt.Sprintf("%x", md5.Sum(data)),
+				Size:       int64(len(data)),
+				Path:       relPath,
+				SourceID:   id,
+				SourceHash: emptySha256,
+				RepoName:   "ubuntu",
+			})
+		}
+	}
+
+	outputs, err := filepath.Glob(filepath.Join(outputDir, "*.jsonl"))
+	if err != nil || len(outputs) != 1 {
+		t.Fatalf("Replay() wrote output files %v (%v); want a single file", outputs, err)
+	}
+	f, err := os.Open(outputs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []file.Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record file.Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("could not unmarshal record: %v", err)
+		}
+		got = append(got, record)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	ignore := cmpopts.IgnoreFields(file.Record{}, "MimeType", "SourcePath", "SourceDescription", "RepoPath")
+	sortRecords := cmpopts.SortSlices(func(a, b file.Record) bool {
+		return a.SourceID+a.Path < b.SourceID+b.Path
+	})
+	if diff := cmp.Diff(want, got, ignore, sortRecords); diff != "" {
+		t.Errorf("Replay() unexpected records (-want/+got):\n%s", diff)
+	}
+}
+
+func checkSavedPath(t *testing.T, sample common.Sample) {
+	t.Helper()
+	relPath, ok := common.TrimExtractionRoot(sample.Paths[0])
+	if !ok || !strings.HasPrefix(relPath, "tmp/hashr/20200106.00.00-ubuntu-laptop/") {
+		t.Errorf("sample %s has unexpected path %s", sample.Sha256, sample.Paths[0])
+	}
+}
+
+func TestSortByImportTime(t *testing.T) {
+	exportPath := t.TempDir()
+	var dirs []string
+	for name, importedAt := range map[string]string{
+		"ubuntu___a___aaaa": `{"imported_at":300}`,
+		"ubuntu___b___bbbb": `{"imported_at":100}`,
+		"ubuntu___c___cccc": `{"imported_at":200}`,
+		"ubuntu___d___dddd": "",
+	} {
+		dir := filepath.Join(exportPath, name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if importedAt != "" {
+			if err := os.WriteFile(filepath.Join(dir, "source.json"), []byte(importedAt), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dirs = append(dirs, dir)
+	}
+
+	sortByImportTime(dirs)
+
+	var got []string
+	for _, dir := range dirs {
+		got = append(got, filepath.Base(dir))
+	}
+	want := []string{"ubuntu___d___dddd", "ubuntu___b___bbbb", "ubuntu___c___cccc", "ubuntu___a___aaaa"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("sortByImportTime() unexpected diff (-want/+got):\n%s", diff)
+	}
+}
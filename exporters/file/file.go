@@ -0,0 +1,315 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package file provides functions required to export data to rotating JSONL or CSV files.
+package file
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/google/hashr/analyzers/filetype"
+	"github.com/google/hashr/common"
+	"github.com/google/hashr/exporters/payloads"
+)
+
+const (
+	// Name contains name of the exporter.
+	Name = "file"
+	// FormatJSONL writes one JSON object per line.
+	FormatJSONL = "jsonl"
+	// FormatCSV writes comma separated values with a header row.
+	FormatCSV = "csv"
+)
+
+// csvHeader holds column names of CSV output, order matches Record.csv.
+var csvHeader = []string{"sha256", "sha1", "md5", "size", "mimetype", "path", "source_id", "source_hash", "source_path", "source_description", "repo_name", "repo_path"}
+
+// Record represents a single (sample, source, path) relationship.
+type Record struct {
+	Sha256            string `json:"sha256"`
+	Sha1              string `json:"sha1"`
+	Md5               string `json:"md5"`
+	Size              int64  `json:"size"`
+	MimeType          string `json:"mimetype"`
+	Path              string `json:"path"`
+	SourceID          string `json:"source_id"`
+	SourceHash        string `json:"source_hash"`
+	SourcePath        string `json:"source_path"`
+	SourceDescription string `json:"source_description"`
+	RepoName          string `json:"repo_name"`
+	RepoPath          string `json:"repo_path"`
+}
+
+func (r *Record) csv() []string {
+	return []string{r.Sha256, r.Sha1, r.Md5, strconv.FormatInt(r.Size, 10), r.MimeType, r.Path, r.SourceID, r.SourceHash, r.SourcePath, r.SourceDescription, r.RepoName, r.RepoPath}
+}
+
+// Exporter is an instance of file Exporter.
+type Exporter struct {
+	outputDir    string
+	format       string
+	compress     bool
+	maxRecords   int
+	payloadStore payloads.PayloadStore
+
+	mu      sync.Mutex
+	file    *os.File
+	gz      *gzip.Writer
+	jsonEnc *json.Encoder
+	csvW    *csv.Writer
+	records int
+	seq     int
+}
+
+// Name returns exporter name.
+func (e *Exporter) Name() string {
+	return Name
+}
+
+// NewExporter creates new file exporter. Records are written to outputDir and a new file is started
+// once maxRecords records were written to the current one (0 disables rotation). If payloadStore is
+// set, the content of new samples is uploaded to it.
+func NewExporter(outputDir, format string, compress bool, maxRecords int, payloadStore payloads.PayloadStore) (*Exporter, error) {
+	if format != FormatJSONL && format != FormatCSV {
+		return nil, fmt.Errorf("unsupported output format %s, needs to be one of: %s, %s", format, FormatJSONL, FormatCSV)
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create output directory %s: %v", outputDir, err)
+	}
+
+	return &Exporter{
+		outputDir:    outputDir,
+		format:       format,
+		compress:     compress,
+		maxRecords:   maxRecords,
+		payloadStore: payloadStore,
+	}, nil
+}
+
+// Export writes one record per (sample, source, path) to the current output file. Errors of
+// individual samples are aggregated and no records of the source are written if any sample
+// couldn't be read or its payload couldn't be stored.
+func (e *Exporter) Export(ctx context.Context, sourceRepoName, sourceRepoPath, sourceID, sourceHash, sourcePath, sourceDescription string, samples []common.Sample) error {
+	var records []*Record
+	var errs []string
+	for _, sample := range samples {
+		record, samplePath, err := sampleRecord(sample)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("could not read sample %s: %v", sample.Sha256, err))
+			continue
+		}
+		record.SourceID = sourceID
+		record.SourceHash = sourceHash
+		record.SourcePath = sourcePath
+		record.SourceDescription = sourceDescription
+		record.RepoName = sourceRepoName
+		record.RepoPath = sourceRepoPath
+
+		if e.payloadStore != nil && sample.Upload {
+			if _, _, err := payloads.Upload(ctx, e.payloadStore, sample.Sha256, samplePath); err != nil {
+				errs = append(errs, fmt.Sprintf("could not store payload of %s: %v", sample.Sha256, err))
+				continue
+			}
+		}
+
+		for _, path := range sample.Paths {
+			relPath, ok := common.TrimExtractionRoot(path)
+			if !ok {
+				glog.Warningf("sample path does not follow expected format: %s", path)
+			}
+			pathRecord := *record
+			pathRecord.Path = relPath
+			records = append(records, &pathRecord)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d errors while exporting samples: %s", len(errs), strings.Join(errs, "; "))
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, record := range records {
+		if err := e.write(record); err != nil {
+			return fmt.Errorf("could not write record of %s: %v", record.Sha256, err)
+		}
+	}
+
+	return e.flush()
+}
+
+// Close flushes and closes the current output file.
+func (e *Exporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.closeFile()
+}
+
+// sampleRecord returns record with sample metadata and the path that was used to read the sample.
+func sampleRecord(sample common.Sample) (*Record, string, error) {
+	samplePath, fi, err := sample.FirstValidPath()
+	if err != nil {
+		return nil, "", err
+	}
+
+	file, err := os.Open(samplePath)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	// File type is identified the same way as in other exporters.
+	info, err := filetype.Identify(file, fi.Size())
+	if err != nil {
+		return nil, "", fmt.Errorf("could not identify file type: %v", err)
+	}
+
+	md5Hash := md5.New()
+	sha1Hash := sha1.New()
+	size, err := io.Copy(io.MultiWriter(md5Hash, sha1Hash), file)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &Record{
+		Sha256:   sample.Sha256,
+		Sha1:     fmt.Sprintf("%x", sha1Hash.Sum(nil)),
+		Md5:      fmt.Sprintf("%x", md5Hash.Sum(nil)),
+		Size:     size,
+		MimeType: info.MimeType,
+	}, file.Name(), nil
+}
+
+func (e *Exporter) write(record *Record) error {
+	if e.file != nil && e.maxRecords > 0 && e.records >= e.maxRecords {
+		if err := e.closeFile(); err != nil {
+			return err
+		}
+	}
+
+	if e.file == nil {
+		if err := e.openFile(); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	switch e.format {
+	case FormatJSONL:
+		err = e.jsonEnc.Encode(record)
+	case FormatCSV:
+		err = e.csvW.Write(record.csv())
+	}
+	if err != nil {
+		return err
+	}
+	e.records++
+
+	return nil
+}
+
+func (e *Exporter) openFile() error {
+	e.seq++
+	filename := fmt.Sprintf("hashr-%s-%06d.%s", time.Now().UTC().Format("20060102T150405Z"), e.seq, e.format)
+	if e.compress {
+		filename += ".gz"
+	}
+
+	file, err := os.Create(filepath.Join(e.outputDir, filename))
+	if err != nil {
+		return fmt.Errorf("could not create output file: %v", err)
+	}
+	e.file = file
+	e.records = 0
+
+	var w io.Writer = file
+	if e.compress {
+		e.gz = gzip.NewWriter(file)
+		w = e.gz
+	}
+
+	switch e.format {
+	case FormatJSONL:
+		e.jsonEnc = json.NewEncoder(w)
+	case FormatCSV:
+		e.csvW = csv.NewWriter(w)
+		if err := e.csvW.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+
+	glog.Infof("Writing %s records to %s", Name, file.Name())
+
+	return nil
+}
+
+// flush makes sure all records written so far are persisted in the current output file.
+func (e *Exporter) flush() error {
+	if e.file == nil {
+		return nil
+	}
+
+	if e.csvW != nil {
+		e.csvW.Flush()
+		if err := e.csvW.Error(); err != nil {
+			return err
+		}
+	}
+
+	if e.gz != nil {
+		if err := e.gz.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *Exporter) closeFile() error {
+	if e.file == nil {
+		return nil
+	}
+
+	if err := e.flush(); err != nil {
+		return err
+	}
+
+	if e.gz != nil {
+		if err := e.gz.Close(); err != nil {
+			return err
+		}
+	}
+
+	err := e.file.Close()
+	e.file, e.gz, e.jsonEnc, e.csvW = nil, nil, nil, nil
+
+	return err
+}
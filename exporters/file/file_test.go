@@ -0,0 +1,218 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/hashr/common"
+	"github.com/google/hashr/exporters/payloads"
+)
+
+const (
+	helloSha256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	sourceHash  = "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc"
+)
+
+func testSamples(t *testing.T) []common.Sample {
+	extractionDir := filepath.Join(t.TempDir(), "extracted")
+	if err := os.MkdirAll(filepath.Join(extractionDir, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range []string{"bin/hello", "hello.txt"} {
+		if err := os.WriteFile(filepath.Join(extractionDir, filename), []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return []common.Sample{
+		{
+			Sha256: helloSha256,
+			Paths:  []string{filepath.Join(extractionDir, "bin/hello"), filepath.Join(extractionDir, "hello.txt")},
+			Upload: true,
+		},
+	}
+}
+
+func wantRecords() []*Record {
+	var records []*Record
+	for _, path := range []string{"bin/hello", "hello.txt"} {
+		records = append(records, &Record{
+			Sha256:            helloSha256,
+			Sha1:              "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d",
+			Md5:               "5d41402abc4b2a76b9719d911017c592",
+			Size:              5,
+			MimeType:          "text/plain; charset=utf-8",
+			Path:              path,
+			SourceID:          "ubuntu-1604-lts",
+			SourceHash:        sourceHash,
+			SourceDescription: "Official Ubuntu GCP image.",
+			RepoName:          "GCP",
+			RepoPath:          "ubuntu",
+		})
+	}
+	return records
+}
+
+func outputFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "hashr-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestExportJSONL(t *testing.T) {
+	outputDir := t.TempDir()
+	payloadStore, err := payloads.NewLocalStore(filepath.Join(outputDir, "payloads"))
+	if err != nil {
+		t.Fatalf("could not create payload store: %v", err)
+	}
+
+	exporter, err := NewExporter(outputDir, FormatJSONL, true, 1, payloadStore)
+	if err != nil {
+		t.Fatalf("could not create file exporter: %v", err)
+	}
+
+	if err := exporter.Export(context.Background(), "GCP", "ubuntu", "ubuntu-1604-lts", sourceHash, "", "Official Ubuntu GCP image.", testSamples(t)); err != nil {
+		t.Fatalf("unexpected error while running Export(): %v", err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatalf("unexpected error while running Close(): %v", err)
+	}
+
+	files := outputFiles(t, outputDir)
+	if len(files) != 2 {
+		t.Fatalf("Export() created %d files; want = 2 (one record per file)", len(files))
+	}
+
+	var gotRecords []*Record
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("could not open %s as gzip: %v", file, err)
+		}
+
+		scanner := bufio.NewScanner(gz)
+		for scanner.Scan() {
+			var record Record
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				t.Fatalf("could not unmarshal record: %v", err)
+			}
+			gotRecords = append(gotRecords, &record)
+		}
+	}
+
+	if diff := cmp.Diff(wantRecords(), gotRecords); diff != "" {
+		t.Errorf("Export() unexpected diff (-want/+got):\n%s", diff)
+	}
+
+	payload, err := os.ReadFile(filepath.Join(outputDir, "payloads", payloads.ObjectName(helloSha256)))
+	if err != nil {
+		t.Fatalf("could not read stored payload: %v", err)
+	}
+	if string(payload) != "hello" {
+		t.Errorf("stored payload = %q; want = %q", payload, "hello")
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	outputDir := t.TempDir()
+	exporter, err := NewExporter(outputDir, FormatCSV, false, 0, nil)
+	if err != nil {
+		t.Fatalf("could not create file exporter: %v", err)
+	}
+
+	if err := exporter.Export(context.Background(), "GCP", "ubuntu", "ubuntu-1604-lts", sourceHash, "", "Official Ubuntu GCP image.", testSamples(t)); err != nil {
+		t.Fatalf("unexpected error while running Export(): %v", err)
+	}
+
+	// Records need to be readable before the exporter is closed.
+	files := outputFiles(t, outputDir)
+	if len(files) != 1 {
+		t.Fatalf("Export() created %d files; want = 1", len(files))
+	}
+
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gotRows, err := csv.NewReader(f).ReadAll()
+	if err != nil && err != io.EOF {
+		t.Fatalf("could not read CSV output: %v", err)
+	}
+
+	wantRows := [][]string{csvHeader}
+	for _, record := range wantRecords() {
+		wantRows = append(wantRows, record.csv())
+	}
+
+	if diff := cmp.Diff(wantRows, gotRows); diff != "" {
+		t.Errorf("Export() unexpected diff (-want/+got):\n%s", diff)
+	}
+
+	if err := exporter.Close(); err != nil {
+		t.Fatalf("unexpected error while running Close(): %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(outputDir, "payloads")); !os.IsNotExist(err) {
+		t.Error("Export() stored payloads even though payload store was not set")
+	}
+}
+
+func TestNewExporterInvalidFormat(t *testing.T) {
+	if _, err := NewExporter(t.TempDir(), "xml", false, 0, nil); err == nil {
+		t.Error("NewExporter() expected error for unsupported format")
+	}
+}
+
+func TestExportUnreadableSample(t *testing.T) {
+	outputDir := t.TempDir()
+	exporter, err := NewExporter(outputDir, FormatJSONL, false, 0, nil)
+	if err != nil {
+		t.Fatalf("could not create file exporter: %v", err)
+	}
+	defer exporter.Close()
+
+	samples := append(testSamples(t), common.Sample{Sha256: sourceHash, Paths: []string{filepath.Join(outputDir, "missing")}})
+	if err := exporter.Export(context.Background(), "GCP", "ubuntu", "ubuntu-1604-lts", sourceHash, "", "Official Ubuntu GCP image.", samples); err == nil {
+		t.Error("Export() expected error for a sample that can't be read")
+	}
+
+	// Records of the source are not written, so it's exported again in full once it's retried.
+	if files := outputFiles(t, outputDir); len(files) != 0 {
+		t.Errorf("Export() created %d files; want = 0", len(files))
+	}
+}
@@ -1,41 +1,50 @@
 package gcp
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
-	"net/http"
-	"os"
-	"os/exec"
 	"strings"
 	"sync"
 
 	"cloud.google.com/go/spanner"
 	"github.com/golang/glog"
+	"github.com/google/hashr/analyzers/filetype"
 	"github.com/google/hashr/common"
-	"google.golang.org/api/iterator"
-	"google.golang.org/api/storage/v1"
+	"github.com/google/hashr/exporters/payloads"
 	"google.golang.org/grpc/codes"
 )
 
 const (
 	// Name contains name of the exporter.
 	Name = "GCP"
+	// maxMutationCells is the maximum number of mutated cells (columns * rows) sent in a single
+	// commit. Spanner allows up to 80000 mutations per commit, secondary indexes and foreign keys
+	// count towards that limit, so we stay well below it.
+	maxMutationCells = 20000
+	// maxKeysPerQuery is the maximum number of keys used in a single read.
+	maxKeysPerQuery = 5000
 )
 
 // Exporter is an instance of GCP Exporter.
 type Exporter struct {
 	spannerClient  *spanner.Client
-	storageClient  *storage.Service
-	GCSBucket      string
+	payloadStore   payloads.PayloadStore
 	uploadPayloads bool
 	workerCount    int
-	wg             sync.WaitGroup
 }
 
-// NewExporter creates new GCP exporter.
-func NewExporter(spannerClient *spanner.Client, storageClient *storage.Service, GCSBucket string, uploadPayloads bool, workerCount int) (*Exporter, error) {
-	return &Exporter{spannerClient: spannerClient, storageClient: storageClient, GCSBucket: GCSBucket, uploadPayloads: uploadPayloads, workerCount: workerCount}, nil
+// NewExporter creates new GCP exporter. Payloads are uploaded to payloadStore, which is usually
+// backed by a GCS bucket.
+func NewExporter(spannerClient *spanner.Client, payloadStore payloads.PayloadStore, uploadPayloads bool, workerCount int) (*Exporter, error) {
+	if uploadPayloads && payloadStore == nil {
+		return nil, fmt.Errorf("payload store needs to be set in order to upload payloads")
+	}
+	if workerCount < 1 {
+		workerCount = 1
+	}
+
+	return &Exporter{spannerClient: spannerClient, payloadStore: payloadStore, uploadPayloads: uploadPayloads, workerCount: workerCount}, nil
 }
 
 // Name returns exporter name.
@@ -43,124 +52,243 @@ func (e *Exporter) Name() string {
 	return Name
 }
 
-// Export exports extracted data to GCP (Spanner + GCS).
+// exportErrors aggregates errors of individual samples.
+type exportErrors struct {
+	mu   sync.Mutex
+	errs []string
+}
+
+func (e *exportErrors) add(format string, a ...interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.errs = append(e.errs, fmt.Sprintf(format, a...))
+}
+
+func (e *exportErrors) err() error {
+	if len(e.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d errors while exporting samples: %s", len(e.errs), strings.Join(e.errs, "; "))
+}
+
+// Export exports extracted data to GCP (Spanner + GCS). Samples are written using batched
+// mutations, source <-> sample relationships are merged in read-write transactions. Errors of
+// individual samples are aggregated and returned once all samples were processed.
 func (e *Exporter) Export(ctx context.Context, sourceRepoName, sourceRepoPath, sourceID, sourceHash, sourcePath, sourceDescription string, samples []common.Sample) error {
 	if err := e.insertSource(ctx, sourceHash, sourceID, sourcePath, sourceRepoName, sourceRepoPath, sourceDescription); err != nil {
 		return fmt.Errorf("could not upload source data: %v", err)
 	}
 
-	jobs := make(chan common.Sample, len(samples))
-	for w := 1; w <= e.workerCount; w++ {
-		e.wg.Add(1)
-		go e.worker(ctx, sourceHash, jobs)
+	existing, err := e.existingSamples(ctx, samples)
+	if err != nil {
+		return fmt.Errorf("could not check which samples were already uploaded: %v", err)
+	}
+
+	errs := &exportErrors{}
+	mutations, executableMutations, signatureMutations := e.sampleMutations(ctx, samples, existing, errs)
+
+	// Sample and payload mutations have at most 4 columns.
+	if err := e.applyBatched(ctx, mutations, 4); err != nil {
+		return fmt.Errorf("could not insert sample data: %v", err)
 	}
 
-	go func() {
-		for _, sample := range samples {
-			jobs <- sample
+	// Executables reference samples, so they are inserted once all samples are committed.
+	if err := e.applyBatched(ctx, executableMutations, len(executableColumns)); err != nil {
+		return fmt.Errorf("could not insert executable metadata: %v", err)
+	}
+
+	if err := e.applyBatched(ctx, signatureMutations, len(signatureColumns)); err != nil {
+		return fmt.Errorf("could not insert signatures: %v", err)
+	}
+
+	var exported []common.Sample
+	for _, sample := range samples {
+		if existing[sample.Sha256] {
+			exported = append(exported, sample)
 		}
-		close(jobs)
-	}()
-	e.wg.Wait()
+	}
 
-	return nil
+	if err := e.insertRelationships(ctx, exported, sourceHash); err != nil {
+		return fmt.Errorf("could not insert source <-> sample relationships: %v", err)
+	}
+
+	return errs.err()
 }
 
-func (e *Exporter) worker(ctx context.Context, sourceHash string, samples <-chan common.Sample) {
-	defer e.wg.Done()
-	for sample := range samples {
-		if err := e.insertSample(ctx, sample); err != nil {
-			glog.Errorf("skipping %s, could not insert sample data: %v", sample.Sha256, err)
-			continue
+// existingSamples returns samples which are already present in the samples table.
+func (e *Exporter) existingSamples(ctx context.Context, samples []common.Sample) (map[string]bool, error) {
+	existing := make(map[string]bool)
+
+	for start := 0; start < len(samples); start += maxKeysPerQuery {
+		end := start + maxKeysPerQuery
+		if end > len(samples) {
+			end = len(samples)
 		}
 
-		if err := e.insertRelationship(ctx, sample, sourceHash); err != nil {
-			glog.Errorf("skipping %s, could not insert source <-> sample relationship: %v", sample.Sha256, err)
-			continue
+		var hashes []string
+		for _, sample := range samples[start:end] {
+			hashes = append(hashes, sample.Sha256)
+		}
+
+		stmt := spanner.Statement{
+			SQL:    `SELECT sha256 FROM samples WHERE sha256 IN UNNEST(@hashes)`,
+			Params: map[string]interface{}{"hashes": hashes},
+		}
+
+		err := e.spannerClient.Single().Query(ctx, stmt).Do(func(row *spanner.Row) error {
+			var sha256 string
+			if err := row.Columns(&sha256); err != nil {
+				return err
+			}
+			existing[sha256] = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
 	}
+
+	return existing, nil
 }
 
-func (e *Exporter) insertRelationship(ctx context.Context, sample common.Sample, sourceSha256 string) error {
-	var paths, existingPaths []string
+// sampleMutations returns mutations inserting metadata (and payload locations) of samples that are
+// not present in the samples table yet, followed by mutations inserting metadata and Authenticode
+// signatures of executable samples. Samples are processed by a pool of workers. Successfully
+// processed samples are added to existing.
+func (e *Exporter) sampleMutations(ctx context.Context, samples []common.Sample, existing map[string]bool, errs *exportErrors) ([]*spanner.Mutation, []*spanner.Mutation, []*spanner.Mutation) {
+	var mu sync.Mutex
+	var mutations, executableMutations, signatureMutations []*spanner.Mutation
+	var wg sync.WaitGroup
 
-	for _, path := range sample.Paths {
-		s := strings.Split(path, "/extracted/")
-		if len(s) < 2 {
-			glog.Warningf("sample path does not follow expected format: %s", path)
+	jobs := make(chan common.Sample, len(samples))
+	seen := make(map[string]bool)
+	for _, sample := range samples {
+		// Sample can be present more than once.
+		if existing[sample.Sha256] || seen[sample.Sha256] {
 			continue
 		}
-		paths = append(paths, strings.TrimPrefix(strings.TrimPrefix(s[len(s)-1], "mnt"), "export"))
+		seen[sample.Sha256] = true
+		jobs <- sample
 	}
+	close(jobs)
 
-	sql := spanner.Statement{
-		SQL: `SELECT sample_paths FROM samples_sources WHERE sample_sha256 = @sha256`,
-		Params: map[string]interface{}{
-			"sha256": sample.Sha256,
-		},
+	for w := 1; w <= e.workerCount; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sample := range jobs {
+				sampleMutations, err := e.sampleMutation(ctx, sample)
+				if err != nil {
+					errs.add("%s: %v", sample.Sha256, err)
+					continue
+				}
+
+				mu.Lock()
+				mutations = append(mutations, sampleMutations...)
+				if sample.Executable != nil {
+					executableMutations = append(executableMutations, executableMutation(sample.Sha256, sample.Executable))
+				}
+				for i, sig := range sample.Signatures {
+					signatureMutations = append(signatureMutations, signatureMutation(sample.Sha256, i, sig))
+				}
+				existing[sample.Sha256] = true
+				mu.Unlock()
+			}
+		}()
 	}
+	wg.Wait()
 
-	iter := e.spannerClient.Single().Query(ctx, sql)
-	defer iter.Stop()
-	row, err := iter.Next()
-	if err != iterator.Done {
-		if err := row.Columns(&existingPaths); err != nil {
-			return err
-		}
-	}
-	if err != iterator.Done && err != nil {
-		return err
-	}
+	return mutations, executableMutations, signatureMutations
+}
 
-	_, err = e.spannerClient.Apply(ctx, []*spanner.Mutation{
-		spanner.InsertOrUpdate("samples_sources",
-			[]string{
-				"sample_sha256",
-				"source_sha256",
-				"sample_paths"},
-			[]interface{}{
-				sample.Sha256,
-				sourceSha256,
-				append(existingPaths, paths...),
-			})})
-	if err != nil {
-		return fmt.Errorf("failed to insert data %v", err)
+// executableColumns are the columns of the executables table.
+var executableColumns = []string{
+	"sha256",
+	"format",
+	"architectures",
+	"bits",
+	"build_id",
+	"soname",
+	"needed",
+	"stripped",
+	"compile_timestamp",
+	"imphash",
+	"version_info",
+	"uuids",
+}
+
+// executableMutation returns mutation inserting metadata of an executable sample.
+func executableMutation(sha256 string, md *common.ExecutableMetadata) *spanner.Mutation {
+	var versionInfo spanner.NullJSON
+	if len(md.VersionInfo) > 0 {
+		versionInfo = spanner.NullJSON{Value: md.VersionInfo, Valid: true}
 	}
 
-	return nil
+	return spanner.InsertOrUpdate("executables", executableColumns,
+		[]interface{}{
+			sha256,
+			md.Format,
+			md.Archs,
+			int64(md.Bits),
+			md.BuildID,
+			md.Soname,
+			md.Needed,
+			md.Stripped,
+			md.CompileTimestamp,
+			md.Imphash,
+			versionInfo,
+			md.UUIDs,
+		})
 }
 
-func (e *Exporter) insertSample(ctx context.Context, sample common.Sample) error {
-	var samplePath string
-	var fi os.FileInfo
-	var err error
-	// If sample has more than one path associated with it, take the first that is valid.
-	for _, path := range sample.Paths {
-		if fi, err = os.Stat(path); err == nil {
-			samplePath = path
-			break
-		}
-	}
+// signatureColumns are the columns of the signatures table.
+var signatureColumns = []string{
+	"sha256",
+	"signature_index",
+	"catalog",
+	"digest_algorithm",
+	"digest",
+	"verified",
+	"error",
+	"signing_time",
+	"signer",
+	"certificates",
+}
 
-	file, err := os.Open(samplePath)
-	if err != nil {
-		return fmt.Errorf("could not open %v", samplePath)
+// signatureMutation returns mutation inserting an Authenticode signature of a sample. Signer is the
+// subject of the first certificate of the chain.
+func signatureMutation(sha256 string, index int, sig *common.Signature) *spanner.Mutation {
+	var signer string
+	var certificates spanner.NullJSON
+	if len(sig.Certificates) > 0 {
+		signer = sig.Certificates[0].Subject
+		certificates = spanner.NullJSON{Value: sig.Certificates, Valid: true}
 	}
-	defer file.Close()
 
-	mimeType, err := getFileContentType(file)
-	if err != nil {
-		glog.Warningf("Could not get file content type: %v", err)
-	}
+	return spanner.InsertOrUpdate("signatures", signatureColumns,
+		[]interface{}{
+			sha256,
+			int64(index),
+			sig.Catalog,
+			sig.DigestAlgorithm,
+			sig.Digest,
+			sig.Verified,
+			sig.Error,
+			sig.SigningTime,
+			signer,
+			certificates,
+		})
+}
 
-	fileOutput, err := fileCmdOutput(samplePath)
+func (e *Exporter) sampleMutation(ctx context.Context, sample common.Sample) ([]*spanner.Mutation, error) {
+	samplePath, fi, err := sample.FirstValidPath()
 	if err != nil {
-		glog.Warningf("Could not get file cmd output: %v", err)
+		return nil, err
 	}
 
-	fileOutput = strings.TrimPrefix(fileOutput, fmt.Sprintf("%s%s", samplePath, ":"))
-	_, err = e.spannerClient.Apply(ctx, []*spanner.Mutation{
-		spanner.Insert("samples",
+	mimeType, fileOutput := fileMetadata(samplePath)
+	mutations := []*spanner.Mutation{
+		spanner.InsertOrUpdate("samples",
 			[]string{
 				"sha256",
 				"mimetype",
@@ -171,123 +299,297 @@ func (e *Exporter) insertSample(ctx context.Context, sample common.Sample) error
 				mimeType,
 				fileOutput,
 				fi.Size(),
-			})})
-	if spanner.ErrCode(err) != codes.AlreadyExists && err != nil {
-		return fmt.Errorf("failed to insert data %v", err)
-	}
+			})}
 
 	if e.uploadPayloads && sample.Upload {
-		fmt.Println(samplePath)
-		file, err := os.Open(samplePath)
+		uri, _, err := payloads.Upload(ctx, e.payloadStore, sample.Sha256, samplePath)
 		if err != nil {
-			return fmt.Errorf("error while opening file: %v", err)
+			return nil, err
 		}
 
-		fi, err := file.Stat()
-		if err != nil {
-			return fmt.Errorf("error while opening file: %v", err)
+		mutations = append(mutations, spanner.InsertOrUpdate("payloads",
+			[]string{
+				"sha256",
+				"gcs_path"},
+			[]interface{}{
+				sample.Sha256,
+				uri,
+			}))
+	}
+
+	return mutations, nil
+}
+
+// fileMetadata returns mimetype and description of a given file. They're left empty if the file
+// type can't be identified.
+func fileMetadata(samplePath string) (string, string) {
+	info, err := filetype.IdentifyFile(samplePath)
+	if err != nil {
+		glog.Warningf("Could not identify file type of %s: %v", samplePath, err)
+		return "", ""
+	}
+
+	return info.MimeType, info.Description
+}
+
+// applyBatched applies mutations with at most a given number of columns in batches that stay
+// within Spanner commit limits.
+func (e *Exporter) applyBatched(ctx context.Context, mutations []*spanner.Mutation, columns int) error {
+	batchSize := maxMutationCells / columns
+
+	for start := 0; start < len(mutations); start += batchSize {
+		end := start + batchSize
+		if end > len(mutations) {
+			end = len(mutations)
 		}
 
-		fmt.Println(fi.Size())
+		if _, err := e.spannerClient.Apply(ctx, mutations[start:end]); err != nil {
+			return err
+		}
+	}
 
-		name := fmt.Sprintf("%s/%s", strings.ToUpper(sample.Sha256[0:2]), strings.ToUpper(sample.Sha256))
-		object := &storage.Object{
-			Name: name,
+	return nil
+}
+
+// insertRelationships merges sample paths with the paths already stored for the same source <->
+// sample relationship. Read and write are done in a single read-write transaction, so concurrent
+// exports of the same source don't overwrite each other's paths.
+func (e *Exporter) insertRelationships(ctx context.Context, samples []common.Sample, sourceSha256 string) error {
+	// Each relationship mutation has 5 columns.
+	const batchSize = maxMutationCells / 5
+
+	paths := make(map[string][]string)
+	metadata := make(map[string]map[string]*common.FileMetadata)
+	packages := make(map[string]map[string]*common.PackageOwner)
+	var hashes []string
+	for _, sample := range samples {
+		if _, ok := paths[sample.Sha256]; !ok {
+			hashes = append(hashes, sample.Sha256)
 		}
 
-		_, err = e.storageClient.Objects.Insert(e.GCSBucket, object).Media(file).Do()
-		if err != nil {
-			return fmt.Errorf("error uploading data to GCS: %v", err)
+		for _, path := range sample.Paths {
+			relPath, ok := common.TrimExtractionRoot(path)
+			if !ok {
+				glog.Warningf("sample path does not follow expected format: %s", path)
+				continue
+			}
+			relPath = strings.TrimPrefix(strings.TrimPrefix(relPath, "mnt"), "export")
+			paths[sample.Sha256] = append(paths[sample.Sha256], relPath)
+			if md, ok := sample.Metadata[path]; ok {
+				if metadata[sample.Sha256] == nil {
+					metadata[sample.Sha256] = make(map[string]*common.FileMetadata)
+				}
+				metadata[sample.Sha256][relPath] = md
+			}
+			if owner, ok := sample.Packages[path]; ok {
+				if packages[sample.Sha256] == nil {
+					packages[sample.Sha256] = make(map[string]*common.PackageOwner)
+				}
+				packages[sample.Sha256][relPath] = owner
+			}
 		}
+	}
 
-		_, err = e.spannerClient.Apply(ctx, []*spanner.Mutation{
-			spanner.Insert("payloads",
-				[]string{
-					"sha256",
-					"gcs_path"},
-				[]interface{}{
-					sample.Sha256,
-					fmt.Sprintf("gs://%s/%s", e.GCSBucket, name),
-				})})
-		if spanner.ErrCode(err) != codes.AlreadyExists && err != nil {
-			return fmt.Errorf("failed to insert data %v", err)
+	for start := 0; start < len(hashes); start += batchSize {
+		end := start + batchSize
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+		batch := hashes[start:end]
+
+		_, err := e.spannerClient.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+			var keys []spanner.KeySet
+			for _, sha256 := range batch {
+				keys = append(keys, spanner.Key{sha256, sourceSha256})
+			}
+
+			existingPaths := make(map[string][]string)
+			existingMetadata := make(map[string]spanner.NullJSON)
+			existingPackages := make(map[string]spanner.NullJSON)
+			err := txn.Read(ctx, "samples_sources", spanner.KeySets(keys...), []string{"sample_sha256", "sample_paths", "sample_metadata", "sample_packages"}).Do(func(row *spanner.Row) error {
+				var sha256 string
+				var samplePaths []string
+				var sampleMetadata, samplePackages spanner.NullJSON
+				if err := row.Columns(&sha256, &samplePaths, &sampleMetadata, &samplePackages); err != nil {
+					return err
+				}
+				existingPaths[sha256] = samplePaths
+				existingMetadata[sha256] = sampleMetadata
+				existingPackages[sha256] = samplePackages
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			var mutations []*spanner.Mutation
+			for _, sha256 := range batch {
+				sampleMetadata, err := mergeMetadata(existingMetadata[sha256], metadata[sha256])
+				if err != nil {
+					return fmt.Errorf("could not merge metadata of %s: %v", sha256, err)
+				}
+				samplePackages, err := mergePackages(existingPackages[sha256], packages[sha256])
+				if err != nil {
+					return fmt.Errorf("could not merge package owners of %s: %v", sha256, err)
+				}
+				mutations = append(mutations, spanner.InsertOrUpdate("samples_sources",
+					[]string{
+						"sample_sha256",
+						"source_sha256",
+						"sample_paths",
+						"sample_metadata",
+						"sample_packages"},
+					[]interface{}{
+						sha256,
+						sourceSha256,
+						mergePaths(existingPaths[sha256], paths[sha256]),
+						sampleMetadata,
+						samplePackages,
+					}))
+			}
+
+			return txn.BufferWrite(mutations)
+		})
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func (e *Exporter) insertSource(ctx context.Context, sourceHash, sourceID, sourcePath, sourceRepoName, sourceRepoPath, sourceDescription string) error {
-	var sourceIDs []string
+// mergePaths returns paths from both slices without duplicates, preserving order.
+func mergePaths(existing, paths []string) []string {
+	seen := make(map[string]bool)
+	merged := []string{}
+	for _, path := range append(append([]string{}, existing...), paths...) {
+		if !seen[path] {
+			seen[path] = true
+			merged = append(merged, path)
+		}
+	}
 
-	sql := spanner.Statement{
-		SQL: `SELECT source_id FROM sources WHERE sha256 = @sha256`,
-		Params: map[string]interface{}{
-			"sha256": sourceHash,
-		},
+	return merged
+}
+
+// mergeMetadata adds file metadata, keyed by path, to metadata already stored for the same source
+// <-> sample relationship. Metadata of paths present in both is replaced.
+func mergeMetadata(existing spanner.NullJSON, metadata map[string]*common.FileMetadata) (spanner.NullJSON, error) {
+	merged := make(map[string]*common.FileMetadata)
+	if existing.Valid {
+		if err := json.Unmarshal([]byte(existing.String()), &merged); err != nil {
+			return spanner.NullJSON{}, err
+		}
+	}
+	for path, md := range metadata {
+		merged[path] = md
+	}
+
+	if len(merged) == 0 {
+		return spanner.NullJSON{}, nil
 	}
 
-	iter := e.spannerClient.Single().Query(ctx, sql)
-	defer iter.Stop()
-	row, err := iter.Next()
-	if err != iterator.Done {
-		if err := row.Columns(&sourceIDs); err != nil {
+	return spanner.NullJSON{Value: merged, Valid: true}, nil
+}
+
+func (e *Exporter) insertSource(ctx context.Context, sourceHash, sourceID, sourcePath, sourceRepoName, sourceRepoPath, sourceDescription string) error {
+	_, err := e.spannerClient.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		var sourceIDs []string
+
+		row, err := txn.ReadRow(ctx, "sources", spanner.Key{sourceHash}, []string{"source_id"})
+		if err != nil && spanner.ErrCode(err) != codes.NotFound {
 			return err
 		}
+		if err == nil {
+			if err := row.Columns(&sourceIDs); err != nil {
+				return err
+			}
+		}
+
+		return txn.BufferWrite([]*spanner.Mutation{
+			spanner.InsertOrUpdate("sources",
+				[]string{
+					"sha256",
+					"source_id",
+					"source_path",
+					"source_description",
+					"repo_name",
+					"repo_path"},
+				[]interface{}{
+					sourceHash,
+					mergePaths(sourceIDs, []string{sourceID}),
+					sourcePath,
+					sourceDescription,
+					sourceRepoName,
+					sourceRepoPath,
+				})})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to insert data %v", err)
 	}
-	if err != iterator.Done && err != nil {
-		return err
-	}
 
-	_, err = e.spannerClient.Apply(ctx, []*spanner.Mutation{
-		spanner.InsertOrUpdate("sources",
+	return nil
+}
+
+// ExportOS stores the operating system of an exported source.
+func (e *Exporter) ExportOS(ctx context.Context, sourceHash string, sourceOS *common.OSInfo) error {
+	_, err := e.spannerClient.Apply(ctx, []*spanner.Mutation{
+		spanner.Update("sources",
 			[]string{
 				"sha256",
-				"source_id",
-				"source_path",
-				"source_description",
-				"repo_name",
-				"repo_path"},
+				"os_family",
+				"os_id",
+				"os_name",
+				"os_version",
+				"os_codename",
+				"kernel_version",
+				"architecture"},
 			[]interface{}{
 				sourceHash,
-				append(sourceIDs, sourceID),
-				sourcePath,
-				sourceDescription,
-				sourceRepoName,
-				sourceRepoPath,
+				sourceOS.Family,
+				sourceOS.ID,
+				sourceOS.Name,
+				sourceOS.Version,
+				sourceOS.Codename,
+				sourceOS.Kernel,
+				sourceOS.Architecture,
 			})})
 	if err != nil {
-		return fmt.Errorf("failed to insert data %v", err)
+		return fmt.Errorf("failed to update source %v", err)
 	}
 
 	return nil
 }
 
-func getFileContentType(out *os.File) (string, error) {
-
-	// Only the first 512 bytes are used to check the content type.
-	buffer := make([]byte, 512)
-
-	_, err := out.Read(buffer)
+// ExportPackage stores package metadata of an exported source.
+func (e *Exporter) ExportPackage(ctx context.Context, sourceHash string, pkg *common.PackageInfo) error {
+	_, err := e.spannerClient.Apply(ctx, []*spanner.Mutation{
+		spanner.Update("sources",
+			[]string{"sha256", "package_metadata"},
+			[]interface{}{sourceHash, spanner.NullJSON{Value: pkg, Valid: true}})})
 	if err != nil {
-		return "", err
+		return fmt.Errorf("failed to update source %v", err)
 	}
 
-	contentType := http.DetectContentType(buffer)
-
-	return contentType, nil
+	return nil
 }
 
-func fileCmdOutput(filepath string) (string, error) {
-	cmd := exec.Command("/usr/bin/file", filepath)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+// mergePackages adds package owners, keyed by path, to owners already stored for the same source
+// <-> sample relationship. Owners of paths present in both are replaced.
+func mergePackages(existing spanner.NullJSON, packages map[string]*common.PackageOwner) (spanner.NullJSON, error) {
+	merged := make(map[string]*common.PackageOwner)
+	if existing.Valid {
+		if err := json.Unmarshal([]byte(existing.String()), &merged); err != nil {
+			return spanner.NullJSON{}, err
+		}
+	}
+	for path, owner := range packages {
+		merged[path] = owner
+	}
 
-	err := cmd.Run()
-	if err != nil {
-		return "", fmt.Errorf("error while executing %s: %v\nStdout: %v\nStderr: %v", "/usr/bin/file", err, stdout.String(), stderr.String())
+	if len(merged) == 0 {
+		return spanner.NullJSON{}, nil
 	}
 
-	return strings.TrimSuffix(stdout.String(), "\n"), nil
+	return spanner.NullJSON{Value: merged, Valid: true}, nil
 }
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/golang/glog"
+	"github.com/google/go-cmp/cmp"
 	"github.com/google/hashr/common"
 	"google.golang.org/api/option"
 	"google.golang.org/grpc"
@@ -42,15 +43,54 @@ const (
         source_description STRING(MAX),
         repo_name STRING(MAX),
         repo_path STRING(MAX),
+        os_family STRING(MAX),
+        os_id STRING(MAX),
+        os_name STRING(MAX),
+        os_version STRING(MAX),
+        os_codename STRING(MAX),
+        kernel_version STRING(MAX),
+        architecture STRING(MAX),
+        package_metadata JSON,
 	) PRIMARY KEY(sha256)`
 
 	samplesSourcesTable = `CREATE TABLE samples_sources (
 		sample_sha256 STRING(100),
 		source_sha256 STRING(100),
 		sample_paths ARRAY<STRING(MAX)>,
+		sample_metadata JSON,
+		sample_packages JSON,
 		CONSTRAINT FK_Sample FOREIGN KEY (sample_sha256) REFERENCES samples (sha256),
 		CONSTRAINT FK_Source FOREIGN KEY (source_sha256) REFERENCES sources (sha256),
 	)  PRIMARY KEY (sample_sha256, source_sha256)`
+
+	executablesTable = `CREATE TABLE executables (
+		sha256 STRING(100),
+		format STRING(MAX),
+		architectures ARRAY<STRING(MAX)>,
+		bits INT64,
+		build_id STRING(MAX),
+		soname STRING(MAX),
+		needed ARRAY<STRING(MAX)>,
+		stripped BOOL,
+		compile_timestamp INT64,
+		imphash STRING(MAX),
+		version_info JSON,
+		uuids ARRAY<STRING(MAX)>,
+		CONSTRAINT FK_Executable FOREIGN KEY (sha256) REFERENCES samples (sha256),
+	) PRIMARY KEY(sha256)`
+	signaturesTable = `CREATE TABLE signatures (
+		sha256 STRING(100),
+		signature_index INT64,
+		catalog STRING(MAX),
+		digest_algorithm STRING(MAX),
+		digest STRING(MAX),
+		verified BOOL,
+		error STRING(MAX),
+		signing_time INT64,
+		signer STRING(MAX),
+		certificates JSON,
+		CONSTRAINT FK_Signature FOREIGN KEY (sha256) REFERENCES samples (sha256),
+	) PRIMARY KEY(sha256, signature_index)`
 )
 
 func TestExport(t *testing.T) {
@@ -96,7 +136,7 @@ func TestExport(t *testing.T) {
 	op2, err := databaseAdmin.CreateDatabase(ctx, &dbadminpb.CreateDatabaseRequest{
 		Parent:          "projects/hashr/instances/hashr",
 		CreateStatement: "CREATE DATABASE hashr",
-		ExtraStatements: []string{samplesTable, sourcesTable, payloadsTable, samplesSourcesTable},
+		ExtraStatements: []string{samplesTable, sourcesTable, payloadsTable, samplesSourcesTable, executablesTable, signaturesTable},
 	})
 	if err != nil {
 		glog.Fatalf("error creating test DB %v: %v", dbURI, err)
@@ -110,7 +150,7 @@ func TestExport(t *testing.T) {
 		glog.Fatalf("error creating Spanner client %v: %v", dbURI, err)
 	}
 
-	exporter, err := NewExporter(spannerClient, nil, "gcs-bucket", false, 10)
+	exporter, err := NewExporter(spannerClient, nil, false, 10)
 	if err != nil {
 		glog.Fatalf("error creating Cloud Spanner exporter: %v", err)
 	}
@@ -137,4 +177,144 @@ func TestExport(t *testing.T) {
 		t.Fatalf("unexpected error while running Export() = %v", err)
 	}
 
+	// Exporting the same source under a different ID must not duplicate samples or relationships.
+	if err := exporter.Export(ctx, "GCP", "ubuntu", "ubuntu-1604-lts-v2", "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc", "", "Official Ubuntu GCP image.", append(samples, samples[0])); err != nil {
+		t.Fatalf("unexpected error while running Export() = %v", err)
+	}
+
+	row, err := spannerClient.Single().ReadRow(ctx, "sources", spanner.Key{"07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc"}, []string{"source_id"})
+	if err != nil {
+		t.Fatalf("could not read source: %v", err)
+	}
+	var sourceIDs []string
+	if err := row.Columns(&sourceIDs); err != nil {
+		t.Fatalf("could not read source: %v", err)
+	}
+	if diff := cmp.Diff([]string{"ubuntu-1604-lts", "ubuntu-1604-lts-v2"}, sourceIDs); diff != "" {
+		t.Errorf("Export() unexpected source IDs (-want/+got):\n%s", diff)
+	}
+
+	sourceOS := &common.OSInfo{Family: "linux", ID: "ubuntu", Name: "Ubuntu 16.04.7 LTS", Version: "16.04", Codename: "xenial", Kernel: "4.15.0-1098-gcp", Architecture: "amd64"}
+	if err := exporter.ExportOS(ctx, "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc", sourceOS); err != nil {
+		t.Fatalf("unexpected error while running ExportOS() = %v", err)
+	}
+	row, err = spannerClient.Single().ReadRow(ctx, "sources", spanner.Key{"07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc"}, []string{"os_id", "os_version", "architecture"})
+	if err != nil {
+		t.Fatalf("could not read source: %v", err)
+	}
+	var osID, osVersion, architecture string
+	if err := row.Columns(&osID, &osVersion, &architecture); err != nil {
+		t.Fatalf("could not read source: %v", err)
+	}
+	if osID != "ubuntu" || osVersion != "16.04" || architecture != "amd64" {
+		t.Errorf("ExportOS() stored %s %s %s; want ubuntu 16.04 amd64", osID, osVersion, architecture)
+	}
+
+	pkg := &common.PackageInfo{Manager: "dpkg", Package: "hello", Version: "2.10-2", Architecture: "amd64", VerifiedFiles: 4}
+	if err := exporter.ExportPackage(ctx, "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc", pkg); err != nil {
+		t.Fatalf("unexpected error while running ExportPackage() = %v", err)
+	}
+	row, err = spannerClient.Single().ReadRow(ctx, "sources", spanner.Key{"07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc"}, []string{"package_metadata"})
+	if err != nil {
+		t.Fatalf("could not read source: %v", err)
+	}
+	var pkgJSON spanner.NullJSON
+	if err := row.Columns(&pkgJSON); err != nil {
+		t.Fatalf("could not read source: %v", err)
+	}
+	if want := `{"architecture":"amd64","manager":"dpkg","package":"hello","verified_files":4,"version":"2.10-2"}`; pkgJSON.String() != want {
+		t.Errorf("ExportPackage() stored %s; want = %s", pkgJSON.String(), want)
+	}
+
+	for table, want := range map[string]int64{"samples": 3, "samples_sources": 3} {
+		var got int64
+		err := spannerClient.Single().Query(ctx, spanner.Statement{SQL: "SELECT COUNT(*) FROM " + table}).Do(func(row *spanner.Row) error {
+			return row.Columns(&got)
+		})
+		if err != nil {
+			t.Fatalf("could not count rows in %s: %v", table, err)
+		}
+		if got != want {
+			t.Errorf("Export() created %d rows in %s; want = %d", got, table, want)
+		}
+	}
+}
+
+func TestMergePaths(t *testing.T) {
+	got := mergePaths([]string{"bin/ls", "usr/bin/ls"}, []string{"usr/bin/ls", "sbin/ls", "sbin/ls"})
+	want := []string{"bin/ls", "usr/bin/ls", "sbin/ls"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mergePaths() unexpected diff (-want/+got):\n%s", diff)
+	}
+}
+
+func TestMergeMetadata(t *testing.T) {
+	existing := spanner.NullJSON{Value: map[string]interface{}{
+		"bin/ls":     map[string]interface{}{"mode": 33261, "uid": 0, "gid": 0, "mtime": 1500000000},
+		"usr/bin/ls": map[string]interface{}{"mode": 33261, "uid": 0, "gid": 0, "mtime": 1500000000},
+	}, Valid: true}
+	metadata := map[string]*common.FileMetadata{
+		"usr/bin/ls": {Mode: 0100755, UID: -1, GID: -1, User: "root", Group: "root", Mtime: 1600000000},
+	}
+
+	got, err := mergeMetadata(existing, metadata)
+	if err != nil {
+		t.Fatalf("unexpected error while running mergeMetadata(): %v", err)
+	}
+	want := map[string]*common.FileMetadata{
+		"bin/ls":     {Mode: 0100755, UID: 0, GID: 0, Mtime: 1500000000},
+		"usr/bin/ls": {Mode: 0100755, UID: -1, GID: -1, User: "root", Group: "root", Mtime: 1600000000},
+	}
+	if diff := cmp.Diff(want, got.Value); diff != "" {
+		t.Errorf("mergeMetadata() unexpected diff (-want/+got):\n%s", diff)
+	}
+
+	got, err = mergeMetadata(spanner.NullJSON{}, nil)
+	if err != nil || got.Valid {
+		t.Errorf("mergeMetadata() = %v, %v; want NULL", got, err)
+	}
+}
+
+func TestMergePackages(t *testing.T) {
+	existing := spanner.NullJSON{Value: map[string]interface{}{
+		"usr/bin/ls":        map[string]interface{}{"manager": "dpkg", "package": "coreutils", "version": "8.30-3", "architecture": "amd64"},
+		"usr/local/bin/foo": map[string]interface{}{"manager": "dpkg"},
+	}, Valid: true}
+	packages := map[string]*common.PackageOwner{
+		"usr/bin/ls": {Manager: "dpkg", Package: "coreutils", Version: "8.30-3", Architecture: "amd64", Modified: true},
+	}
+
+	got, err := mergePackages(existing, packages)
+	if err != nil {
+		t.Fatalf("unexpected error while running mergePackages(): %v", err)
+	}
+	want := map[string]*common.PackageOwner{
+		"usr/bin/ls":        {Manager: "dpkg", Package: "coreutils", Version: "8.30-3", Architecture: "amd64", Modified: true},
+		"usr/local/bin/foo": {Manager: "dpkg"},
+	}
+	if diff := cmp.Diff(want, got.Value); diff != "" {
+		t.Errorf("mergePackages() unexpected diff (-want/+got):\n%s", diff)
+	}
+
+	got, err = mergePackages(spanner.NullJSON{}, nil)
+	if err != nil || got.Valid {
+		t.Errorf("mergePackages() = %v, %v; want NULL", got, err)
+	}
+}
+
+func TestFileMetadata(t *testing.T) {
+	for _, tc := range []struct {
+		path           string
+		wantMimeType   string
+		wantFileOutput string
+	}{
+		{path: "testdata/extraction/file.01", wantMimeType: "application/octet-stream", wantFileOutput: "data"},
+		// File type of a directory can't be identified, metadata is left empty.
+		{path: "testdata/extraction", wantMimeType: "", wantFileOutput: ""},
+	} {
+		mimeType, fileOutput := fileMetadata(tc.path)
+		if mimeType != tc.wantMimeType || fileOutput != tc.wantFileOutput {
+			t.Errorf("fileMetadata(%s) = %q, %q; want %q, %q", tc.path, mimeType, fileOutput, tc.wantMimeType, tc.wantFileOutput)
+		}
+	}
 }
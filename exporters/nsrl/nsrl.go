@@ -0,0 +1,257 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nsrl provides functions required to export data to a NIST NSRL RDSv3 compatible SQLite
+// database.
+package nsrl
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/hashr/common"
+
+	// Blank import below is needed for the SQL driver.
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// Name contains name of the exporter.
+	Name = "nsrl"
+	// rdsVersion is the version of the RDS schema produced by this exporter.
+	rdsVersion = "3"
+)
+
+// schema holds RDSv3 tables and views, see https://www.nist.gov/itl/ssd/software-quality-group/national-software-reference-library-nsrl/nsrl-download/current-rds.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS VERSION (
+		version VARCHAR UNIQUE NOT NULL,
+		build_set VARCHAR NOT NULL,
+		build_date TIMESTAMP NOT NULL,
+		release_date TIMESTAMP NOT NULL,
+		description VARCHAR NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS MFG (
+		manufacturer_id INTEGER NOT NULL,
+		name VARCHAR NOT NULL,
+		PRIMARY KEY (manufacturer_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS OS (
+		operating_system_id INTEGER NOT NULL,
+		name VARCHAR NOT NULL,
+		version VARCHAR NOT NULL,
+		manufacturer_id INTEGER NOT NULL,
+		PRIMARY KEY (operating_system_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS PKG (
+		package_id INTEGER NOT NULL,
+		name VARCHAR NOT NULL,
+		version VARCHAR NOT NULL,
+		operating_system_id INTEGER NOT NULL,
+		manufacturer_id INTEGER NOT NULL,
+		language VARCHAR NOT NULL,
+		application_type VARCHAR NOT NULL,
+		PRIMARY KEY (package_id, name, version, operating_system_id, manufacturer_id, language, application_type)
+	) WITHOUT ROWID`,
+	`CREATE TABLE IF NOT EXISTS FILE (
+		sha256 VARCHAR NOT NULL,
+		sha1 VARCHAR NOT NULL,
+		md5 VARCHAR NOT NULL,
+		crc32 VARCHAR NOT NULL,
+		file_name VARCHAR NOT NULL,
+		file_size INTEGER NOT NULL,
+		package_id INTEGER NOT NULL,
+		PRIMARY KEY (sha256, sha1, md5, crc32, file_name, file_size, package_id)
+	) WITHOUT ROWID`,
+	`CREATE VIEW IF NOT EXISTS DISTINCT_HASH AS
+		SELECT DISTINCT sha256, sha1, md5, crc32 FROM FILE`,
+}
+
+// Exporter is an instance of NSRL RDSv3 Exporter.
+type Exporter struct {
+	sqlDB *sql.DB
+}
+
+// Name returns exporter name.
+func (e *Exporter) Name() string {
+	return Name
+}
+
+// NewExporter creates new NSRL RDSv3 exporter and all the necessary tables, if they don't exist.
+func NewExporter(sqlDB *sql.DB) (*Exporter, error) {
+	for _, stmt := range schema {
+		if _, err := sqlDB.Exec(stmt); err != nil {
+			return nil, fmt.Errorf("error while creating RDS schema: %v", err)
+		}
+	}
+
+	now := time.Now().UTC()
+	_, err := sqlDB.Exec(`
+	INSERT INTO VERSION (version, build_set, build_date, release_date, description)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (version) DO UPDATE SET build_date = excluded.build_date`,
+		rdsVersion, "hashR", now, now, "RDSv3 compatible export generated by hashR")
+	if err != nil {
+		return nil, fmt.Errorf("error while inserting RDS version: %v", err)
+	}
+
+	return &Exporter{sqlDB: sqlDB}, nil
+}
+
+// id returns stable, positive integer ID derived from given values.
+func id(values ...string) int64 {
+	digest := sha256.Sum256([]byte(strings.Join(values, "\x00")))
+	return int64(binary.BigEndian.Uint64(digest[:8]) >> 1)
+}
+
+// fileHashes holds digests of a single file, as required by RDS.
+type fileHashes struct {
+	md5   string
+	sha1  string
+	crc32 string
+	size  int64
+}
+
+func hashFile(path string) (*fileHashes, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	md5Hash := md5.New()
+	sha1Hash := sha1.New()
+	crc32Hash := crc32.NewIEEE()
+	size, err := io.Copy(io.MultiWriter(md5Hash, sha1Hash, crc32Hash), file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileHashes{
+		md5:   fmt.Sprintf("%X", md5Hash.Sum(nil)),
+		sha1:  fmt.Sprintf("%X", sha1Hash.Sum(nil)),
+		crc32: fmt.Sprintf("%08X", crc32Hash.Sum32()),
+		size:  size,
+	}, nil
+}
+
+// Export exports extracted data to RDSv3 SQLite database. Repositories are stored as
+// manufacturers, sources as operating systems named after the source description and as packages,
+// and samples as files. Package version is left empty until it's set by ExportPackage.
+func (e *Exporter) Export(ctx context.Context, sourceRepoName, sourceRepoPath, sourceID, sourceHash, sourcePath, sourceDescription string, samples []common.Sample) error {
+	tx, err := e.sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	repoID := id(sourceRepoName)
+	osID := id(sourceRepoName, sourceHash)
+	packageID := id(sourceHash)
+
+	osName := sourceDescription
+	if osName == "" {
+		osName = sourceID
+	}
+
+	if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO MFG (manufacturer_id, name) VALUES ($1, $2)`, repoID, sourceRepoName); err != nil {
+		return fmt.Errorf("could not insert manufacturer: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO OS (operating_system_id, name, version, manufacturer_id) VALUES ($1, $2, $3, $4)`, osID, osName, "", repoID); err != nil {
+		return fmt.Errorf("could not insert operating system: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+	INSERT OR IGNORE INTO PKG (package_id, name, version, operating_system_id, manufacturer_id, language, application_type)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`, packageID, sourceID, "", osID, repoID, "", ""); err != nil {
+		return fmt.Errorf("could not insert package: %v", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+	INSERT OR IGNORE INTO FILE (sha256, sha1, md5, crc32, file_name, file_size, package_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`)
+	if err != nil {
+		return fmt.Errorf("could not prepare SQL statement: %v", err)
+	}
+	defer stmt.Close()
+
+	for _, sample := range samples {
+		samplePath, _, err := sample.FirstValidPath()
+		if err != nil {
+			return fmt.Errorf("could not read sample %s: %v", sample.Sha256, err)
+		}
+		hashes, err := hashFile(samplePath)
+		if err != nil {
+			return fmt.Errorf("could not read sample %s: %v", sample.Sha256, err)
+		}
+
+		fileNames := make(map[string]bool)
+		for _, path := range sample.Paths {
+			fileNames[filepath.Base(path)] = true
+		}
+
+		for fileName := range fileNames {
+			_, err := stmt.ExecContext(ctx, strings.ToUpper(sample.Sha256), hashes.sha1, hashes.md5, hashes.crc32, fileName, hashes.size, packageID)
+			if err != nil {
+				return fmt.Errorf("could not insert file %s: %v", sample.Sha256, err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ExportPackage sets the version of a package exported from a given source.
+func (e *Exporter) ExportPackage(ctx context.Context, sourceHash string, pkg *common.PackageInfo) error {
+	result, err := e.sqlDB.ExecContext(ctx, `UPDATE PKG SET version = $2 WHERE package_id = $1`, id(sourceHash), packageVersion(pkg))
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return fmt.Errorf("package of source %s was not exported", sourceHash)
+	}
+
+	return nil
+}
+
+// packageVersion returns the full version of a package, including epoch and release of RPM
+// packages.
+func packageVersion(pkg *common.PackageInfo) string {
+	version := pkg.Version
+	if pkg.Release != "" {
+		version = fmt.Sprintf("%s-%s", version, pkg.Release)
+	}
+	if pkg.Epoch > 0 {
+		version = fmt.Sprintf("%d:%s", pkg.Epoch, version)
+	}
+
+	return version
+}
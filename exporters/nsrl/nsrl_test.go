@@ -0,0 +1,179 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nsrl
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/hashr/common"
+)
+
+type fileRow struct {
+	SHA256, SHA1, MD5, CRC32, FileName string
+	FileSize                           int64
+	PackageID                          int64
+}
+
+func TestExport(t *testing.T) {
+	tempDir := t.TempDir()
+
+	db, err := sql.Open("sqlite", filepath.Join(tempDir, "rds.db"))
+	if err != nil {
+		t.Fatalf("could not open SQLite database: %v", err)
+	}
+	defer db.Close()
+
+	exporter, err := NewExporter(db)
+	if err != nil {
+		t.Fatalf("could not create NSRL exporter: %v", err)
+	}
+
+	extractionDir := filepath.Join(tempDir, "extracted")
+	if err := os.MkdirAll(filepath.Join(extractionDir, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range []string{"bin/hello", "hello.txt"} {
+		if err := os.WriteFile(filepath.Join(extractionDir, filename), []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	samples := []common.Sample{
+		{
+			Sha256: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+			Paths:  []string{filepath.Join(extractionDir, "bin/hello"), filepath.Join(extractionDir, "hello.txt")},
+			Upload: true,
+		},
+	}
+
+	const (
+		sourceHash = "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc"
+		repoName   = "GCP"
+	)
+
+	// Exporting the same source twice should not create duplicate rows.
+	for i := 0; i < 2; i++ {
+		if err := exporter.Export(context.Background(), repoName, "ubuntu", "ubuntu-1604-lts", sourceHash, "", "Official Ubuntu GCP image.", samples); err != nil {
+			t.Fatalf("unexpected error while running Export(): %v", err)
+		}
+	}
+
+	// Package metadata can be exported by a different exporter instance, e.g. in a later run.
+	exporter, err = NewExporter(db)
+	if err != nil {
+		t.Fatalf("could not create NSRL exporter: %v", err)
+	}
+	if err := exporter.ExportPackage(context.Background(), sourceHash, &common.PackageInfo{Manager: "rpm", Package: "hello", Version: "2.10", Epoch: 1, Release: "8.el9"}); err != nil {
+		t.Fatalf("unexpected error while running ExportPackage(): %v", err)
+	}
+	if err := exporter.ExportPackage(context.Background(), "missing", &common.PackageInfo{Version: "1.0"}); err == nil {
+		t.Error("ExportPackage() of a source that was not exported expected error, got nil")
+	}
+
+	rows, err := db.Query(`SELECT sha256, sha1, md5, crc32, file_name, file_size, package_id FROM FILE ORDER BY file_name`)
+	if err != nil {
+		t.Fatalf("could not query FILE table: %v", err)
+	}
+	defer rows.Close()
+
+	var gotFiles []fileRow
+	for rows.Next() {
+		var row fileRow
+		if err := rows.Scan(&row.SHA256, &row.SHA1, &row.MD5, &row.CRC32, &row.FileName, &row.FileSize, &row.PackageID); err != nil {
+			t.Fatalf("could not scan FILE row: %v", err)
+		}
+		gotFiles = append(gotFiles, row)
+	}
+
+	packageID := id(sourceHash)
+	wantFiles := []fileRow{
+		{
+			SHA256:    "2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824",
+			SHA1:      "AAF4C61DDCC5E8A2DABEDE0F3B482CD9AEA9434D",
+			MD5:       "5D41402ABC4B2A76B9719D911017C592",
+			CRC32:     "3610A686",
+			FileName:  "hello",
+			FileSize:  5,
+			PackageID: packageID,
+		},
+		{
+			SHA256:    "2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824",
+			SHA1:      "AAF4C61DDCC5E8A2DABEDE0F3B482CD9AEA9434D",
+			MD5:       "5D41402ABC4B2A76B9719D911017C592",
+			CRC32:     "3610A686",
+			FileName:  "hello.txt",
+			FileSize:  5,
+			PackageID: packageID,
+		},
+	}
+
+	if diff := cmp.Diff(wantFiles, gotFiles); diff != "" {
+		t.Errorf("Export() unexpected FILE diff (-want/+got):\n%s", diff)
+	}
+
+	var pkgName, pkgVersion, appType string
+	var osID, mfgID int64
+	if err := db.QueryRow(`SELECT name, version, operating_system_id, manufacturer_id, application_type FROM PKG WHERE package_id = $1`, packageID).Scan(&pkgName, &pkgVersion, &osID, &mfgID, &appType); err != nil {
+		t.Fatalf("could not query PKG table: %v", err)
+	}
+	if pkgName != "ubuntu-1604-lts" || pkgVersion != "1:2.10-8.el9" || appType != "" {
+		t.Errorf("Export() unexpected PKG row: name=%s, version=%s, application_type=%s", pkgName, pkgVersion, appType)
+	}
+
+	var osName, mfgName string
+	if err := db.QueryRow(`SELECT OS.name, MFG.name FROM OS JOIN MFG ON OS.manufacturer_id = MFG.manufacturer_id WHERE OS.operating_system_id = $1`, osID).Scan(&osName, &mfgName); err != nil {
+		t.Fatalf("could not query OS table: %v", err)
+	}
+	if osName != "Official Ubuntu GCP image." || mfgName != repoName {
+		t.Errorf("Export() unexpected OS/MFG names: %s, %s; want = Official Ubuntu GCP image., %s", osName, mfgName, repoName)
+	}
+}
+
+func TestExportUnreadableSample(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "rds.db"))
+	if err != nil {
+		t.Fatalf("could not open SQLite database: %v", err)
+	}
+	defer db.Close()
+
+	exporter, err := NewExporter(db)
+	if err != nil {
+		t.Fatalf("could not create NSRL exporter: %v", err)
+	}
+
+	samples := []common.Sample{
+		{
+			Sha256: "0000000000000000000000000000000000000000000000000000000000000000",
+			Paths:  []string{filepath.Join(t.TempDir(), "missing")},
+		},
+	}
+	if err := exporter.Export(context.Background(), "GCP", "ubuntu", "ubuntu-1604-lts", "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc", "", "", samples); err == nil {
+		t.Fatal("Export() of an unreadable sample expected error, got nil")
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM PKG`).Scan(&count); err != nil {
+		t.Fatalf("could not query PKG table: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Export() of an unreadable sample left %d PKG rows, want 0", count)
+	}
+}
@@ -0,0 +1,386 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package opensearch provides functions required to export data to OpenSearch or Elasticsearch
+// using the bulk API.
+package opensearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/google/hashr/analyzers/filetype"
+	"github.com/google/hashr/common"
+)
+
+const (
+	// Name contains name of the exporter.
+	Name = "opensearch"
+	// DefaultIndex is the default index name pattern.
+	DefaultIndex = "hashr-{repo}"
+	// maxRetries is the maximum number of times a bulk request is retried after 429 response.
+	maxRetries = 5
+)
+
+// upsertScript adds the source to the document, or replaces it if the document already holds an
+// entry for the same source hash, so re-exports update existing documents instead of duplicating
+// provenance information.
+const upsertScript = `if (ctx._source.sources == null) { ctx._source.sources = []; }
+boolean found = false;
+for (s in ctx._source.sources) {
+  if (s.source_hash == params.source.source_hash) { s.putAll(params.source); found = true; }
+}
+if (!found) { ctx._source.sources.add(params.source); }
+if (params.size != null) { ctx._source.size = params.size; ctx._source.mimetype = params.mimetype; }`
+
+// indexMapping defines sources as a nested field, so source and path can be queried together.
+const indexMapping = `{
+  "mappings": {
+    "properties": {
+      "sha256": {"type": "keyword"},
+      "mimetype": {"type": "keyword"},
+      "size": {"type": "long"},
+      "sources": {
+        "type": "nested",
+        "properties": {
+          "repo_name": {"type": "keyword"},
+          "repo_path": {"type": "keyword"},
+          "source_id": {"type": "keyword"},
+          "source_hash": {"type": "keyword"},
+          "source_path": {"type": "keyword"},
+          "source_description": {"type": "text"},
+          "paths": {"type": "keyword"}
+        }
+      }
+    }
+  }
+}`
+
+// Source holds provenance of a sample within a single source.
+type Source struct {
+	RepoName          string   `json:"repo_name"`
+	RepoPath          string   `json:"repo_path"`
+	SourceID          string   `json:"source_id"`
+	SourceHash        string   `json:"source_hash"`
+	SourcePath        string   `json:"source_path"`
+	SourceDescription string   `json:"source_description"`
+	Paths             []string `json:"paths"`
+}
+
+// Document is a single sample document.
+type Document struct {
+	Sha256   string    `json:"sha256"`
+	MimeType string    `json:"mimetype,omitempty"`
+	Size     *int64    `json:"size,omitempty"`
+	Sources  []*Source `json:"sources"`
+}
+
+type bulkAction struct {
+	Update struct {
+		Index           string `json:"_index"`
+		ID              string `json:"_id"`
+		RetryOnConflict int    `json:"retry_on_conflict"`
+	} `json:"update"`
+}
+
+type bulkScript struct {
+	Source string                 `json:"source"`
+	Lang   string                 `json:"lang"`
+	Params map[string]interface{} `json:"params"`
+}
+
+type bulkUpdate struct {
+	Script bulkScript `json:"script"`
+	Upsert *Document  `json:"upsert"`
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string          `json:"_id"`
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// Exporter is an instance of OpenSearch Exporter.
+type Exporter struct {
+	client    *http.Client
+	url       string
+	index     string
+	username  string
+	password  string
+	batchSize int
+	// retryDelay is the initial delay between retries, it's doubled after every retry.
+	retryDelay time.Duration
+
+	indices sync.Map
+}
+
+// Name returns exporter name.
+func (e *Exporter) Name() string {
+	return Name
+}
+
+// NewExporter creates new OpenSearch exporter. Index is the name of the index that documents will
+// be written to, {repo} is replaced with the lowercase repository name. Username and password are
+// optional.
+func NewExporter(client *http.Client, url, index, username, password string, batchSize int) (*Exporter, error) {
+	if url == "" {
+		return nil, fmt.Errorf("OpenSearch URL needs to be set")
+	}
+	if index == "" {
+		index = DefaultIndex
+	}
+	if batchSize <= 0 {
+		return nil, fmt.Errorf("batch size needs to be greater than 0, got %d", batchSize)
+	}
+
+	return &Exporter{
+		client:     client,
+		url:        strings.TrimSuffix(url, "/"),
+		index:      index,
+		username:   username,
+		password:   password,
+		batchSize:  batchSize,
+		retryDelay: time.Second,
+	}, nil
+}
+
+// Export indexes one document per sample, with sample SHA256 as the document ID.
+func (e *Exporter) Export(ctx context.Context, sourceRepoName, sourceRepoPath, sourceID, sourceHash, sourcePath, sourceDescription string, samples []common.Sample) error {
+	index := e.indexName(sourceRepoName)
+	if err := e.ensureIndex(ctx, index); err != nil {
+		return fmt.Errorf("could not create index %s: %v", index, err)
+	}
+
+	var failed int
+	for start := 0; start < len(samples); start += e.batchSize {
+		end := start + e.batchSize
+		if end > len(samples) {
+			end = len(samples)
+		}
+
+		var lines [][]byte
+		for _, sample := range samples[start:end] {
+			doc := sampleDocument(sample, &Source{
+				RepoName:          sourceRepoName,
+				RepoPath:          sourceRepoPath,
+				SourceID:          sourceID,
+				SourceHash:        sourceHash,
+				SourcePath:        sourcePath,
+				SourceDescription: sourceDescription,
+			})
+
+			line, err := bulkLine(index, doc)
+			if err != nil {
+				glog.Errorf("skipping %s, could not marshal document: %v", sample.Sha256, err)
+				failed++
+				continue
+			}
+			lines = append(lines, line)
+		}
+
+		n, err := e.bulk(ctx, lines)
+		if err != nil {
+			return err
+		}
+		failed += n
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("could not index %d out of %d samples", failed, len(samples))
+	}
+
+	return nil
+}
+
+func (e *Exporter) indexName(repoName string) string {
+	return strings.ReplaceAll(e.index, "{repo}", strings.ToLower(repoName))
+}
+
+func sampleDocument(sample common.Sample, source *Source) *Document {
+	doc := &Document{Sha256: sample.Sha256, Sources: []*Source{source}}
+
+	for _, path := range sample.Paths {
+		relPath, ok := common.TrimExtractionRoot(path)
+		if !ok {
+			glog.Warningf("sample path does not follow expected format: %s", path)
+			continue
+		}
+		source.Paths = append(source.Paths, relPath)
+	}
+
+	if samplePath, fi, err := sample.FirstValidPath(); err == nil {
+		size := fi.Size()
+		doc.Size = &size
+		if info, err := filetype.IdentifyFile(samplePath); err == nil {
+			doc.MimeType = info.MimeType
+		} else {
+			glog.Warningf("Could not identify file type of %s: %v", samplePath, err)
+		}
+	}
+
+	return doc
+}
+
+// bulkLine returns action and update lines of a single document.
+func bulkLine(index string, doc *Document) ([]byte, error) {
+	var action bulkAction
+	action.Update.Index = index
+	action.Update.ID = doc.Sha256
+	action.Update.RetryOnConflict = 3
+
+	params := map[string]interface{}{"source": doc.Sources[0]}
+	if doc.Size != nil {
+		params["size"] = *doc.Size
+		params["mimetype"] = doc.MimeType
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	if err := encoder.Encode(action); err != nil {
+		return nil, err
+	}
+	if err := encoder.Encode(bulkUpdate{Script: bulkScript{Source: upsertScript, Lang: "painless", Params: params}, Upsert: doc}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// bulk sends documents to the bulk API and returns the number of documents that could not be
+// indexed. Requests rejected with 429 status code, as well as single documents rejected with 429,
+// are retried with exponential backoff.
+func (e *Exporter) bulk(ctx context.Context, lines [][]byte) (int, error) {
+	delay := e.retryDelay
+	for attempt := 0; len(lines) > 0; attempt++ {
+		resp, status, err := e.do(ctx, http.MethodPost, "/_bulk", bytes.Join(lines, nil))
+		if err != nil {
+			return 0, err
+		}
+
+		var retry [][]byte
+		var failed int
+		switch {
+		case status == http.StatusTooManyRequests:
+			retry = lines
+		case status >= 300:
+			return 0, fmt.Errorf("bulk request failed with status %d: %s", status, resp)
+		default:
+			var br bulkResponse
+			if err := json.Unmarshal(resp, &br); err != nil {
+				return 0, fmt.Errorf("could not unmarshal bulk response: %v", err)
+			}
+			if len(br.Items) != len(lines) {
+				return 0, fmt.Errorf("bulk response holds %d items, expected %d", len(br.Items), len(lines))
+			}
+
+			for i, item := range br.Items {
+				result := item["update"]
+				switch {
+				case result.Status == http.StatusTooManyRequests:
+					retry = append(retry, lines[i])
+				case result.Status >= 300:
+					glog.Errorf("could not index %s: status %d: %s", result.ID, result.Status, result.Error)
+					failed++
+				}
+			}
+		}
+
+		if len(retry) == 0 {
+			return failed, nil
+		}
+		if attempt >= maxRetries {
+			return failed + len(retry), nil
+		}
+
+		glog.Warningf("OpenSearch rejected %d documents with status 429, retrying in %v", len(retry), delay)
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+		lines = retry
+	}
+
+	return 0, nil
+}
+
+// ensureIndex creates the index with nested source mapping, unless it already exists.
+func (e *Exporter) ensureIndex(ctx context.Context, index string) error {
+	if _, ok := e.indices.Load(index); ok {
+		return nil
+	}
+
+	_, status, err := e.do(ctx, http.MethodHead, "/"+index, nil)
+	if err != nil {
+		return err
+	}
+
+	if status == http.StatusNotFound {
+		resp, status, err := e.do(ctx, http.MethodPut, "/"+index, []byte(indexMapping))
+		if err != nil {
+			return err
+		}
+		// Index might have been created by a different worker in the meantime.
+		if status >= 300 && !strings.Contains(string(resp), "resource_already_exists_exception") {
+			return fmt.Errorf("status %d: %s", status, resp)
+		}
+	} else if status >= 300 {
+		return fmt.Errorf("status %d while checking if index exists", status)
+	}
+
+	e.indices.Store(index, true)
+
+	return nil
+}
+
+func (e *Exporter) do(ctx context.Context, method, path string, body []byte) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, e.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	contentType := "application/json"
+	if strings.HasSuffix(path, "/_bulk") {
+		contentType = "application/x-ndjson"
+	}
+	req.Header.Set("Content-Type", contentType)
+	if e.username != "" {
+		req.SetBasicAuth(e.username, e.password)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s %s request failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not read %s %s response: %v", method, path, err)
+	}
+
+	return data, resp.StatusCode, nil
+}
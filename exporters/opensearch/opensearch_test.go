@@ -0,0 +1,235 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package opensearch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/google/hashr/common"
+)
+
+const sourceHash = "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc"
+
+// fakeBulkServer is a minimal stand-in for the index and bulk APIs. It rejects the first bulk
+// request with 429, and the first document of the second bulk request with 429.
+type fakeBulkServer struct {
+	mu           sync.Mutex
+	indices      map[string]bool
+	bulkRequests int
+	// docs holds upsert documents by index and document ID.
+	docs map[string]map[string]*bulkUpdate
+}
+
+func (f *fakeBulkServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if user, pass, _ := r.BasicAuth(); user != "hashr" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodHead:
+		if !f.indices[r.URL.Path[1:]] {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == http.MethodPut:
+		f.indices[r.URL.Path[1:]] = true
+		fmt.Fprint(w, `{"acknowledged":true}`)
+	case r.Method == http.MethodPost && r.URL.Path == "/_bulk":
+		f.bulkRequests++
+		if f.bulkRequests == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		var br bulkResponse
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+		for i := 0; scanner.Scan(); i++ {
+			var action bulkAction
+			if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if !scanner.Scan() {
+				http.Error(w, "missing update line", http.StatusBadRequest)
+				return
+			}
+			var update bulkUpdate
+			if err := json.Unmarshal(scanner.Bytes(), &update); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			item := map[string]struct {
+				ID     string          `json:"_id"`
+				Status int             `json:"status"`
+				Error  json.RawMessage `json:"error"`
+			}{}
+			result := item["update"]
+			result.ID = action.Update.ID
+			if f.bulkRequests == 2 && i == 0 {
+				result.Status = http.StatusTooManyRequests
+				br.Errors = true
+			} else {
+				result.Status = http.StatusOK
+				if f.docs[action.Update.Index] == nil {
+					f.docs[action.Update.Index] = make(map[string]*bulkUpdate)
+				}
+				f.docs[action.Update.Index][action.Update.ID] = &update
+			}
+			item["update"] = result
+			br.Items = append(br.Items, item)
+		}
+		json.NewEncoder(w).Encode(br)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func testSamples(t *testing.T) []common.Sample {
+	extractionDir := filepath.Join(t.TempDir(), "extracted")
+	if err := os.MkdirAll(extractionDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var samples []common.Sample
+	for i := 1; i <= 3; i++ {
+		path := filepath.Join(extractionDir, fmt.Sprintf("file.%02d", i))
+		if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, common.Sample{Sha256: fmt.Sprintf("%064d", i), Paths: []string{path}, Upload: true})
+	}
+
+	return samples
+}
+
+func TestExport(t *testing.T) {
+	fake := &fakeBulkServer{indices: make(map[string]bool), docs: make(map[string]map[string]*bulkUpdate)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	exporter, err := NewExporter(server.Client(), server.URL, "hashr-{repo}-samples", "hashr", "secret", 2)
+	if err != nil {
+		t.Fatalf("could not create OpenSearch exporter: %v", err)
+	}
+	exporter.retryDelay = time.Millisecond
+
+	if err := exporter.Export(context.Background(), "GCP", "ubuntu", "ubuntu-1604-lts", sourceHash, "", "Official Ubuntu GCP image.", testSamples(t)); err != nil {
+		t.Fatalf("unexpected error while running Export(): %v", err)
+	}
+
+	if !fake.indices["hashr-gcp-samples"] {
+		t.Errorf("Export() did not create index hashr-gcp-samples, got: %v", fake.indices)
+	}
+
+	// 1st request rejected with 429, 2nd with one document rejected, 3rd retrying that document,
+	// 4th with the last document.
+	if fake.bulkRequests != 4 {
+		t.Errorf("Export() sent %d bulk requests; want = 4", fake.bulkRequests)
+	}
+
+	var gotIDs []string
+	for id := range fake.docs["hashr-gcp-samples"] {
+		gotIDs = append(gotIDs, id)
+	}
+	sort.Strings(gotIDs)
+
+	wantIDs := []string{fmt.Sprintf("%064d", 1), fmt.Sprintf("%064d", 2), fmt.Sprintf("%064d", 3)}
+	if diff := cmp.Diff(wantIDs, gotIDs); diff != "" {
+		t.Errorf("Export() unexpected document IDs (-want/+got):\n%s", diff)
+	}
+
+	size := int64(5)
+	wantDoc := &Document{
+		Sha256:   fmt.Sprintf("%064d", 1),
+		MimeType: "text/plain; charset=utf-8",
+		Size:     &size,
+		Sources: []*Source{
+			{
+				RepoName:          "GCP",
+				RepoPath:          "ubuntu",
+				SourceID:          "ubuntu-1604-lts",
+				SourceHash:        sourceHash,
+				SourceDescription: "Official Ubuntu GCP image.",
+				Paths:             []string{"file.01"},
+			},
+		},
+	}
+	if diff := cmp.Diff(wantDoc, fake.docs["hashr-gcp-samples"][fmt.Sprintf("%064d", 1)].Upsert); diff != "" {
+		t.Errorf("Export() unexpected upsert document (-want/+got):\n%s", diff)
+	}
+}
+
+func TestExportRetriesExhausted(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			requests++
+			io.Copy(io.Discard, r.Body)
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	exporter, err := NewExporter(server.Client(), server.URL, "", "", "", 10)
+	if err != nil {
+		t.Fatalf("could not create OpenSearch exporter: %v", err)
+	}
+	exporter.retryDelay = time.Millisecond
+
+	if err := exporter.Export(context.Background(), "GCP", "ubuntu", "ubuntu-1604-lts", sourceHash, "", "", testSamples(t)); err == nil {
+		t.Error("Export() expected error when all retries were rejected")
+	}
+
+	if requests != maxRetries+1 {
+		t.Errorf("Export() sent %d bulk requests; want = %d", requests, maxRetries+1)
+	}
+}
+
+func TestBulkLine(t *testing.T) {
+	line, err := bulkLine("hashr-gcp", &Document{Sha256: "abc", Sources: []*Source{{SourceHash: sourceHash}}})
+	if err != nil {
+		t.Fatalf("unexpected error while running bulkLine(): %v", err)
+	}
+
+	lines := bytes.Split(bytes.TrimSuffix(line, []byte("\n")), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("bulkLine() returned %d lines; want = 2", len(lines))
+	}
+
+	want := `{"update":{"_index":"hashr-gcp","_id":"abc","retry_on_conflict":3}}`
+	if diff := cmp.Diff(want, string(lines[0])); diff != "" {
+		t.Errorf("bulkLine() unexpected action (-want/+got):\n%s", diff)
+	}
+}
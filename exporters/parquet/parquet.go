@@ -0,0 +1,490 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package parquet provides functions required to export data to partitioned Parquet datasets.
+//
+// Each table (sources, samples, samples_sources) is written to its own dataset, partitioned by
+// repository and export date:
+//
+//	<output_dir>/<table>/repo=<repo_name>/date=<YYYY-MM-DD>/part-<run_id>-<seq>.parquet
+//
+// Files are written under a temporary name and renamed once complete. Files written for a source
+// are then appended, one line each, to <output_dir>/_manifest.jsonl before the source is reported
+// as exported, so files that are not listed in the manifest (e.g. left behind by an interrupted
+// run) should be ignored by readers.
+package parquet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/google/hashr/analyzers/filetype"
+	"github.com/google/hashr/common"
+)
+
+const (
+	// Name contains name of the exporter.
+	Name = "parquet"
+	// ManifestFile is the name of the manifest file, relative to the output directory.
+	ManifestFile = "_manifest.jsonl"
+	// Table names, same as the ones used by Postgres exporter.
+	SourcesTable        = "sources"
+	SamplesTable        = "samples"
+	SamplesSourcesTable = "samples_sources"
+	// readBatchSize is the number of rows read at once from existing samples files.
+	readBatchSize = 10000
+)
+
+// Source holds a single row of the sources table.
+type Source struct {
+	Sha256            string   `parquet:"name=sha256, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SourceID          []string `parquet:"name=sourceID, type=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+	SourcePath        string   `parquet:"name=sourcePath, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SourceDescription string   `parquet:"name=sourceDescription, type=BYTE_ARRAY, convertedtype=UTF8"`
+	RepoName          string   `parquet:"name=repoName, type=BYTE_ARRAY, convertedtype=UTF8"`
+	RepoPath          string   `parquet:"name=repoPath, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// Sample holds a single row of the samples table.
+type Sample struct {
+	Sha256     string `parquet:"name=sha256, type=BYTE_ARRAY, convertedtype=UTF8"`
+	MimeType   string `parquet:"name=mimetype, type=BYTE_ARRAY, convertedtype=UTF8"`
+	FileOutput string `parquet:"name=file_output, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Size       int64  `parquet:"name=size, type=INT64"`
+}
+
+// SampleSource holds a single row of the samples_sources table.
+type SampleSource struct {
+	SampleSha256 string   `parquet:"name=sample_sha256, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SourceSha256 string   `parquet:"name=source_sha256, type=BYTE_ARRAY, convertedtype=UTF8"`
+	SamplePaths  []string `parquet:"name=sample_paths, type=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+}
+
+// ManifestEntry describes a single complete Parquet file.
+type ManifestEntry struct {
+	Table   string    `json:"table"`
+	Repo    string    `json:"repo"`
+	Date    string    `json:"date"`
+	Path    string    `json:"path"`
+	Rows    int64     `json:"rows"`
+	Bytes   int64     `json:"bytes"`
+	Created time.Time `json:"created"`
+}
+
+// partition is a single Parquet file that is currently being written.
+type partition struct {
+	entry   *ManifestEntry
+	tmpPath string
+	file    *os.File
+	pw      *writer.ParquetWriter
+}
+
+// batch holds files written for a single source. Files are added to the manifest only once all
+// rows of the source are written.
+type batch struct {
+	partitions map[string]*partition
+	entries    []*ManifestEntry
+}
+
+// Exporter is an instance of Parquet Exporter.
+type Exporter struct {
+	outputDir   string
+	maxFileSize int64
+	runID       string
+
+	mu  sync.Mutex
+	seq int
+	// exported holds hashes of samples present in files listed in the manifest.
+	exported map[string]bool
+}
+
+// Name returns exporter name.
+func (e *Exporter) Name() string {
+	return Name
+}
+
+// NewExporter creates new Parquet exporter writing to outputDir. Files are rolled over once they
+// reach approximately maxFileSize bytes. Hashes of samples exported by previous runs are read from
+// files listed in the manifest, so each sample is written only once.
+func NewExporter(outputDir string, maxFileSize int64) (*Exporter, error) {
+	if maxFileSize <= 0 {
+		return nil, fmt.Errorf("max file size needs to be greater than 0, got %d", maxFileSize)
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create output directory %s: %v", outputDir, err)
+	}
+
+	exported, err := exportedSamples(outputDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read exported samples: %v", err)
+	}
+
+	return &Exporter{
+		outputDir:   outputDir,
+		maxFileSize: maxFileSize,
+		runID:       fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405Z"), os.Getpid()),
+		exported:    exported,
+	}, nil
+}
+
+// Export writes source, samples and relationships to the partitions of a given repository. All
+// files are completed and added to the manifest before Export returns.
+func (e *Exporter) Export(ctx context.Context, sourceRepoName, sourceRepoPath, sourceID, sourceHash, sourcePath, sourceDescription string, samples []common.Sample) error {
+	var sampleRows []*Sample
+	var relationshipRows []interface{}
+	seen := make(map[string]bool)
+	for _, sample := range samples {
+		if !seen[sample.Sha256] && !e.isExported(sample.Sha256) {
+			row, err := sampleRow(sample)
+			if err != nil {
+				return fmt.Errorf("could not read sample %s: %v", sample.Sha256, err)
+			}
+			sampleRows = append(sampleRows, row)
+		}
+		seen[sample.Sha256] = true
+
+		var paths []string
+		for _, path := range sample.Paths {
+			relPath, ok := common.TrimExtractionRoot(path)
+			if !ok {
+				glog.Warningf("sample path does not follow expected format: %s", path)
+				continue
+			}
+			paths = append(paths, relPath)
+		}
+		relationshipRows = append(relationshipRows, &SampleSource{SampleSha256: sample.Sha256, SourceSha256: sourceHash, SamplePaths: paths})
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Samples might have been exported with a different source in the meantime.
+	var newSampleRows []interface{}
+	for _, row := range sampleRows {
+		if !e.exported[row.Sha256] {
+			newSampleRows = append(newSampleRows, row)
+		}
+	}
+
+	date := time.Now().UTC().Format("2006-01-02")
+	sourceRow := &Source{
+		Sha256:            sourceHash,
+		SourceID:          []string{sourceID},
+		SourcePath:        sourcePath,
+		SourceDescription: sourceDescription,
+		RepoName:          sourceRepoName,
+		RepoPath:          sourceRepoPath,
+	}
+
+	b := &batch{partitions: make(map[string]*partition)}
+	for _, t := range []struct {
+		table string
+		obj   interface{}
+		rows  []interface{}
+	}{
+		{SourcesTable, new(Source), []interface{}{sourceRow}},
+		{SamplesTable, new(Sample), newSampleRows},
+		{SamplesSourcesTable, new(SampleSource), relationshipRows},
+	} {
+		for _, row := range t.rows {
+			if err := e.write(b, t.table, sourceRepoName, date, t.obj, row); err != nil {
+				e.abort(b)
+				return fmt.Errorf("could not write %s row: %v", t.table, err)
+			}
+		}
+	}
+
+	if err := e.commit(b); err != nil {
+		return err
+	}
+
+	for _, row := range newSampleRows {
+		e.exported[row.(*Sample).Sha256] = true
+	}
+
+	return nil
+}
+
+func (e *Exporter) isExported(hash string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.exported[hash]
+}
+
+func (e *Exporter) write(b *batch, table, repo, date string, obj, row interface{}) error {
+	key := strings.Join([]string{table, repo, date}, "/")
+
+	p, ok := b.partitions[key]
+	if !ok {
+		var err error
+		if p, err = e.open(table, repo, date, obj); err != nil {
+			return err
+		}
+		b.partitions[key] = p
+	}
+
+	if err := p.pw.Write(row); err != nil {
+		return err
+	}
+	p.entry.Rows++
+
+	// Offset holds the number of bytes already written to the file, Size and ObjsSize hold the
+	// estimated size of buffered data.
+	if p.pw.Offset+p.pw.Size+p.pw.ObjsSize >= e.maxFileSize {
+		delete(b.partitions, key)
+		if err := e.finish(p); err != nil {
+			return err
+		}
+		b.entries = append(b.entries, p.entry)
+	}
+
+	return nil
+}
+
+func (e *Exporter) open(table, repo, date string, obj interface{}) (*partition, error) {
+	e.seq++
+	relDir := filepath.Join(table, "repo="+url.PathEscape(repo), "date="+date)
+	relPath := filepath.Join(relDir, fmt.Sprintf("part-%s-%05d.parquet", e.runID, e.seq))
+
+	if err := os.MkdirAll(filepath.Join(e.outputDir, relDir), 0755); err != nil {
+		return nil, err
+	}
+
+	tmpPath := filepath.Join(e.outputDir, relPath+".tmp")
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+
+	pw, err := writer.NewParquetWriterFromWriter(file, obj, 1)
+	if err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	// Keep row groups well below the file size limit, so the limit can be honored.
+	if rowGroupSize := e.maxFileSize / 4; rowGroupSize < pw.RowGroupSize {
+		pw.RowGroupSize = rowGroupSize
+	}
+
+	return &partition{
+		entry:   &ManifestEntry{Table: table, Repo: repo, Date: date, Path: relPath},
+		tmpPath: tmpPath,
+		file:    file,
+		pw:      pw,
+	}, nil
+}
+
+// finish completes the file of a given partition and renames it to its final name.
+func (e *Exporter) finish(p *partition) error {
+	if err := p.pw.WriteStop(); err != nil {
+		p.file.Close()
+		os.Remove(p.tmpPath)
+		return fmt.Errorf("could not complete %s: %v", p.tmpPath, err)
+	}
+
+	if err := p.file.Close(); err != nil {
+		os.Remove(p.tmpPath)
+		return fmt.Errorf("could not close %s: %v", p.tmpPath, err)
+	}
+
+	fi, err := os.Stat(p.tmpPath)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(p.tmpPath, filepath.Join(e.outputDir, p.entry.Path)); err != nil {
+		os.Remove(p.tmpPath)
+		return err
+	}
+
+	p.entry.Bytes = fi.Size()
+	p.entry.Created = time.Now().UTC()
+
+	return nil
+}
+
+// commit completes all open files of a batch and adds the files to the manifest.
+func (e *Exporter) commit(b *batch) error {
+	var keys []string
+	for key := range b.partitions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		p := b.partitions[key]
+		delete(b.partitions, key)
+		if err := e.finish(p); err != nil {
+			e.abort(b)
+			return fmt.Errorf("could not complete parquet files: %v", err)
+		}
+		b.entries = append(b.entries, p.entry)
+	}
+
+	if err := appendManifest(e.outputDir, b.entries); err != nil {
+		e.abort(b)
+		return err
+	}
+
+	return nil
+}
+
+// abort removes all files of a batch that were not added to the manifest.
+func (e *Exporter) abort(b *batch) {
+	for _, p := range b.partitions {
+		p.file.Close()
+		os.Remove(p.tmpPath)
+	}
+	for _, entry := range b.entries {
+		os.Remove(filepath.Join(e.outputDir, entry.Path))
+	}
+}
+
+// appendManifest appends entries to the manifest. Entries are written with a single write call
+// to a file opened in append mode, so concurrent and subsequent runs never overwrite each other's
+// entries.
+func appendManifest(outputDir string, entries []*ManifestEntry) error {
+	var lines []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	file, err := os.OpenFile(filepath.Join(outputDir, ManifestFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open manifest: %v", err)
+	}
+
+	if _, err := file.Write(lines); err != nil {
+		file.Close()
+		return fmt.Errorf("could not write manifest entry: %v", err)
+	}
+
+	return file.Close()
+}
+
+// ReadManifest returns all entries from the manifest in outputDir.
+func ReadManifest(outputDir string) ([]*ManifestEntry, error) {
+	data, err := os.ReadFile(filepath.Join(outputDir, ManifestFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []*ManifestEntry
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var entry ManifestEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("could not unmarshal manifest entry: %v", err)
+		}
+		entries = append(entries, &entry)
+	}
+
+	return entries, nil
+}
+
+// exportedSamples returns hashes of samples in files listed in the manifest in outputDir.
+func exportedSamples(outputDir string) (map[string]bool, error) {
+	entries, err := ReadManifest(outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	exported := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Table != SamplesTable {
+			continue
+		}
+		if err := readSampleHashes(filepath.Join(outputDir, entry.Path), exported); err != nil {
+			return nil, fmt.Errorf("could not read %s: %v", entry.Path, err)
+		}
+	}
+
+	return exported, nil
+}
+
+func readSampleHashes(path string, hashes map[string]bool) error {
+	file, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	pr, err := reader.NewParquetReader(file, new(Sample), 1)
+	if err != nil {
+		return err
+	}
+	defer pr.ReadStop()
+
+	for remaining := pr.GetNumRows(); remaining > 0; {
+		n := int64(readBatchSize)
+		if remaining < n {
+			n = remaining
+		}
+		rows := make([]Sample, n)
+		if err := pr.Read(&rows); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			hashes[row.Sha256] = true
+		}
+		remaining -= n
+	}
+
+	return nil
+}
+
+func sampleRow(sample common.Sample) (*Sample, error) {
+	samplePath, fi, err := sample.FirstValidPath()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := filetype.IdentifyFile(samplePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not identify file type of %s: %v", samplePath, err)
+	}
+
+	return &Sample{
+		Sha256:     sample.Sha256,
+		MimeType:   info.MimeType,
+		FileOutput: info.Description,
+		Size:       fi.Size(),
+	}, nil
+}
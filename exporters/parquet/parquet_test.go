@@ -0,0 +1,213 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquet
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+
+	"github.com/google/hashr/common"
+)
+
+const sourceHash = "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc"
+
+func testSamples(t *testing.T, count int) []common.Sample {
+	extractionDir := filepath.Join(t.TempDir(), "extracted")
+	if err := os.MkdirAll(extractionDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var samples []common.Sample
+	for i := 0; i < count; i++ {
+		path := filepath.Join(extractionDir, fmt.Sprintf("file.%02d", i))
+		if err := os.WriteFile(path, []byte(fmt.Sprintf("sample %d", i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, common.Sample{Sha256: fmt.Sprintf("%064d", i), Paths: []string{path}, Upload: i%2 == 0})
+	}
+
+	return samples
+}
+
+func readRows(t *testing.T, path string, obj interface{}, rows interface{}) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pr, err := reader.NewParquetReader(buffer.NewBufferFileFromBytes(data), obj, 1)
+	if err != nil {
+		t.Fatalf("could not open %s: %v", path, err)
+	}
+	defer pr.ReadStop()
+
+	if err := pr.Read(rows); err != nil {
+		t.Fatalf("could not read %s: %v", path, err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	outputDir := t.TempDir()
+
+	// Run the exporter twice to make sure manifest entries from previous runs are preserved.
+	for run := 0; run < 2; run++ {
+		exporter, err := NewExporter(outputDir, 1024*1024)
+		if err != nil {
+			t.Fatalf("could not create Parquet exporter: %v", err)
+		}
+		exporter.runID = fmt.Sprintf("run%d", run)
+
+		if err := exporter.Export(context.Background(), "GCP", "ubuntu", "ubuntu-1604-lts", sourceHash, "", "Official Ubuntu GCP image.", testSamples(t, 4)); err != nil {
+			t.Fatalf("unexpected error while running Export(): %v", err)
+		}
+	}
+
+	entries, err := ReadManifest(outputDir)
+	if err != nil {
+		t.Fatalf("unexpected error while reading manifest: %v", err)
+	}
+
+	// Samples were already exported by the first run, so the second run writes no samples file.
+	if len(entries) != 5 {
+		t.Fatalf("ReadManifest() returned %d entries; want = 5", len(entries))
+	}
+
+	rowCounts := make(map[string]int64)
+	for _, entry := range entries {
+		if entry.Repo != "GCP" {
+			t.Errorf("unexpected repo in manifest entry: %s", entry.Repo)
+		}
+		if !strings.HasPrefix(entry.Path, filepath.Join(entry.Table, "repo=GCP", "date="+entry.Date)) {
+			t.Errorf("unexpected path in manifest entry: %s", entry.Path)
+		}
+		if fi, err := os.Stat(filepath.Join(outputDir, entry.Path)); err != nil || fi.Size() != entry.Bytes {
+			t.Errorf("manifest entry %s does not match file on disk: %v", entry.Path, err)
+		}
+		rowCounts[entry.Table] += entry.Rows
+	}
+
+	wantRowCounts := map[string]int64{SourcesTable: 2, SamplesTable: 4, SamplesSourcesTable: 8}
+	if diff := cmp.Diff(wantRowCounts, rowCounts); diff != "" {
+		t.Errorf("unexpected row counts (-want/+got):\n%s", diff)
+	}
+
+	for _, entry := range entries {
+		switch entry.Table {
+		case SourcesTable:
+			gotSources := make([]Source, entry.Rows)
+			readRows(t, filepath.Join(outputDir, entry.Path), new(Source), &gotSources)
+			wantSources := []Source{{Sha256: sourceHash, SourceID: []string{"ubuntu-1604-lts"}, SourceDescription: "Official Ubuntu GCP image.", RepoName: "GCP", RepoPath: "ubuntu"}}
+			if diff := cmp.Diff(wantSources, gotSources); diff != "" {
+				t.Errorf("unexpected sources rows (-want/+got):\n%s", diff)
+			}
+		case SamplesSourcesTable:
+			gotRelationships := make([]SampleSource, entry.Rows)
+			readRows(t, filepath.Join(outputDir, entry.Path), new(SampleSource), &gotRelationships)
+			if diff := cmp.Diff(SampleSource{SampleSha256: fmt.Sprintf("%064d", 1), SourceSha256: sourceHash, SamplePaths: []string{"file.01"}}, gotRelationships[1]); diff != "" {
+				t.Errorf("unexpected samples_sources row (-want/+got):\n%s", diff)
+			}
+		case SamplesTable:
+			gotSamples := make([]Sample, entry.Rows)
+			readRows(t, filepath.Join(outputDir, entry.Path), new(Sample), &gotSamples)
+			if gotSamples[1].Sha256 != fmt.Sprintf("%064d", 1) || gotSamples[1].Size != 8 || gotSamples[1].MimeType != "text/plain; charset=utf-8" {
+				t.Errorf("unexpected samples row: %+v", gotSamples[1])
+			}
+		}
+	}
+}
+
+func TestExportRollover(t *testing.T) {
+	outputDir := t.TempDir()
+
+	exporter, err := NewExporter(outputDir, 1)
+	if err != nil {
+		t.Fatalf("could not create Parquet exporter: %v", err)
+	}
+
+	if err := exporter.Export(context.Background(), "GCP", "ubuntu", "ubuntu-1604-lts", sourceHash, "", "", testSamples(t, 3)); err != nil {
+		t.Fatalf("unexpected error while running Export(): %v", err)
+	}
+
+	entries, err := ReadManifest(outputDir)
+	if err != nil {
+		t.Fatalf("unexpected error while reading manifest: %v", err)
+	}
+
+	// With a 1 byte limit every row ends up in its own file: 1 source, 3 samples, 3 relationships.
+	if len(entries) != 7 {
+		t.Errorf("ReadManifest() returned %d entries; want = 7", len(entries))
+	}
+
+	tmpFiles, err := filepath.Glob(filepath.Join(outputDir, "*", "*", "*", "*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tmpFiles) != 0 {
+		t.Errorf("Export() left temporary files behind: %v", tmpFiles)
+	}
+}
+
+func TestExportSharedSamples(t *testing.T) {
+	outputDir := t.TempDir()
+
+	exporter, err := NewExporter(outputDir, 1024*1024)
+	if err != nil {
+		t.Fatalf("could not create Parquet exporter: %v", err)
+	}
+
+	// Samples are exported once, regardless of the Upload flag set from the local cache.
+	samples := testSamples(t, 2)
+	for _, hash := range []string{sourceHash, strings.Repeat("f", 64)} {
+		if err := exporter.Export(context.Background(), "GCP", "ubuntu", "ubuntu-1604-lts", hash, "", "", samples); err != nil {
+			t.Fatalf("unexpected error while running Export(): %v", err)
+		}
+	}
+
+	unreadable := []common.Sample{{Sha256: strings.Repeat("e", 64), Paths: []string{filepath.Join(t.TempDir(), "missing")}}}
+	if err := exporter.Export(context.Background(), "GCP", "ubuntu", "ubuntu-1804-lts", strings.Repeat("d", 64), "", "", unreadable); err == nil {
+		t.Error("Export() of an unreadable sample expected error, got nil")
+	}
+
+	entries, err := ReadManifest(outputDir)
+	if err != nil {
+		t.Fatalf("unexpected error while reading manifest: %v", err)
+	}
+
+	rowCounts := make(map[string]int64)
+	for _, entry := range entries {
+		rowCounts[entry.Table] += entry.Rows
+	}
+	wantRowCounts := map[string]int64{SourcesTable: 2, SamplesTable: 2, SamplesSourcesTable: 4}
+	if diff := cmp.Diff(wantRowCounts, rowCounts); diff != "" {
+		t.Errorf("unexpected row counts (-want/+got):\n%s", diff)
+	}
+
+	// Samples from the manifest are not exported again by a new exporter.
+	exporter, err = NewExporter(outputDir, 1024*1024)
+	if err != nil {
+		t.Fatalf("could not create Parquet exporter: %v", err)
+	}
+	if len(exporter.exported) != 2 {
+		t.Errorf("NewExporter() read %d exported samples; want = 2", len(exporter.exported))
+	}
+}
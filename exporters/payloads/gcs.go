@@ -0,0 +1,76 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package payloads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/storage/v1"
+)
+
+// GCSStore stores payloads in a GCS bucket.
+type GCSStore struct {
+	storageClient *storage.Service
+	bucket        string
+}
+
+// NewGCSStore creates new GCS payload store.
+func NewGCSStore(storageClient *storage.Service, bucket string) (*GCSStore, error) {
+	if bucket == "" {
+		return nil, errors.New("GCS bucket name needs to be set")
+	}
+
+	return &GCSStore{storageClient: storageClient, bucket: bucket}, nil
+}
+
+// Name returns the name of the store backend.
+func (s *GCSStore) Name() string {
+	return "gcs"
+}
+
+// Exists checks if the payload of a given sample is already stored.
+func (s *GCSStore) Exists(ctx context.Context, sha256 string) (bool, error) {
+	_, err := s.storageClient.Objects.Get(s.bucket, ObjectName(sha256)).Context(ctx).Do()
+	if err == nil {
+		return true, nil
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// Put stores the payload of a given sample.
+func (s *GCSStore) Put(ctx context.Context, sha256 string, r io.ReadSeeker, size int64) error {
+	object := &storage.Object{Name: ObjectName(sha256)}
+	if _, err := s.storageClient.Objects.Insert(s.bucket, object).Media(r).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("error uploading data to GCS: %v", err)
+	}
+
+	return nil
+}
+
+// URI returns the location of the payload of a given sample.
+func (s *GCSStore) URI(sha256 string) string {
+	return fmt.Sprintf("gs://%s/%s", s.bucket, ObjectName(sha256))
+}
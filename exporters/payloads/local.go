@@ -0,0 +1,89 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package payloads
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// LocalStore stores payloads in a local directory.
+type LocalStore struct {
+	dir string
+}
+
+// NewLocalStore creates new local payload store in a given directory.
+func NewLocalStore(dir string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create payload directory %s: %v", dir, err)
+	}
+
+	return &LocalStore{dir: dir}, nil
+}
+
+// Name returns the name of the store backend.
+func (s *LocalStore) Name() string {
+	return "local"
+}
+
+func (s *LocalStore) path(sha256 string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(ObjectName(sha256)))
+}
+
+// Exists checks if the payload of a given sample is already stored.
+func (s *LocalStore) Exists(ctx context.Context, sha256 string) (bool, error) {
+	_, err := os.Stat(s.path(sha256))
+	switch {
+	case err == nil:
+		return true, nil
+	case os.IsNotExist(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// Put stores the payload of a given sample. Data is written to a temporary file first, so
+// partially written payloads are never visible.
+func (s *LocalStore) Put(ctx context.Context, sha256 string, r io.ReadSeeker, size int64) error {
+	destPath := s.path(sha256)
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(destPath), filepath.Base(destPath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+
+	return os.Rename(out.Name(), destPath)
+}
+
+// URI returns the location of the payload of a given sample.
+func (s *LocalStore) URI(sha256 string) string {
+	return "file://" + filepath.ToSlash(s.path(sha256))
+}
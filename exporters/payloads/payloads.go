@@ -0,0 +1,77 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package payloads provides content addressed storage for the content of exported samples, which
+// can be used by any exporter.
+package payloads
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// PayloadStore represents a storage of sample payloads, addressed by sample SHA256.
+type PayloadStore interface {
+	// Name returns the name of the store backend.
+	Name() string
+	// Exists checks if the payload of a given sample is already stored.
+	Exists(ctx context.Context, sha256 string) (bool, error)
+	// Put stores the payload of a given sample.
+	Put(ctx context.Context, sha256 string, r io.ReadSeeker, size int64) error
+	// URI returns the location of the payload of a given sample.
+	URI(sha256 string) string
+}
+
+// ObjectName returns the name of the object holding the payload of a given sample, which is the
+// uppercase SHA256 prefixed with its first two characters, e.g. AB/ABCDEF...
+func ObjectName(sha256 string) string {
+	sha256 = strings.ToUpper(sha256)
+	return fmt.Sprintf("%s/%s", sha256[0:2], sha256)
+}
+
+// Upload stores the file at a given path in the store, unless the payload of a given sample is
+// already present. It returns the URI of the payload and whether the file was uploaded.
+func Upload(ctx context.Context, store PayloadStore, sha256, path string) (string, bool, error) {
+	if len(sha256) < 2 {
+		return "", false, fmt.Errorf("invalid sha256 value: %s", sha256)
+	}
+
+	exists, err := store.Exists(ctx, sha256)
+	if err != nil {
+		return "", false, fmt.Errorf("could not check if %s payload exists in %s store: %v", sha256, store.Name(), err)
+	}
+	if exists {
+		return store.URI(sha256), false, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false, fmt.Errorf("error while opening file: %v", err)
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return "", false, fmt.Errorf("error while opening file: %v", err)
+	}
+
+	if err := store.Put(ctx, sha256, file, fi.Size()); err != nil {
+		return "", false, fmt.Errorf("error uploading %s payload to %s store: %v", sha256, store.Name(), err)
+	}
+
+	return store.URI(sha256), true, nil
+}
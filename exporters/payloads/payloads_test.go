@@ -0,0 +1,214 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package payloads
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+const helloSha256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+// fakeObjectServer is a minimal stand-in for GCS JSON and S3 object APIs, it keeps objects in
+// memory keyed by their name.
+type fakeObjectServer struct {
+	mu      sync.Mutex
+	objects map[string]string
+	puts    int
+}
+
+func newFakeObjectServer() *fakeObjectServer {
+	return &fakeObjectServer{objects: make(map[string]string)}
+}
+
+func (f *fakeObjectServer) put(name, data string) {
+	f.objects[name] = data
+	f.puts++
+}
+
+// ServeGCS handles objects.get and multipart objects.insert calls.
+func (f *fakeObjectServer) ServeGCS(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/storage/v1/b/bucket/o/"):
+		name := strings.TrimPrefix(r.URL.Path, "/storage/v1/b/bucket/o/")
+		if _, ok := f.objects[name]; !ok {
+			http.Error(w, `{"error":{"code":404,"message":"Not Found"}}`, http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"name":"` + name + `"}`))
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/bucket/o":
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		var name, data string
+		for i := 0; ; i++ {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			content, _ := io.ReadAll(part)
+			if i == 0 {
+				name = strings.Split(strings.Split(string(content), `"name":"`)[1], `"`)[0]
+			} else {
+				data = string(content)
+			}
+		}
+		f.put(name, data)
+		w.Write([]byte(`{"name":"` + name + `"}`))
+	default:
+		http.Error(w, "unexpected request "+r.URL.String(), http.StatusBadRequest)
+	}
+}
+
+// ServeS3 handles HeadObject and PutObject calls with path style addressing.
+func (f *fakeObjectServer) ServeS3(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	switch r.Method {
+	case http.MethodHead:
+		if _, ok := f.objects[name]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.put(name, string(data))
+	default:
+		http.Error(w, "unexpected request "+r.URL.String(), http.StatusBadRequest)
+	}
+}
+
+func testFile(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "hello")
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testUpload uploads the same file twice and checks that the second upload was skipped.
+func testUpload(t *testing.T, store PayloadStore, wantURI string) {
+	path := testFile(t)
+
+	for i, wantUploaded := range []bool{true, false} {
+		uri, uploaded, err := Upload(context.Background(), store, helloSha256, path)
+		if err != nil {
+			t.Fatalf("unexpected error while running Upload(): %v", err)
+		}
+		if uploaded != wantUploaded {
+			t.Errorf("Upload() #%d uploaded = %v; want = %v", i, uploaded, wantUploaded)
+		}
+		if uri != wantURI {
+			t.Errorf("Upload() #%d URI = %s; want = %s", i, uri, wantURI)
+		}
+	}
+}
+
+func TestObjectName(t *testing.T) {
+	want := "2C/2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824"
+	if got := ObjectName(helloSha256); got != want {
+		t.Errorf("ObjectName() = %s; want = %s", got, want)
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalStore(dir)
+	if err != nil {
+		t.Fatalf("could not create local store: %v", err)
+	}
+
+	testUpload(t, store, "file://"+filepath.ToSlash(filepath.Join(dir, ObjectName(helloSha256))))
+
+	data, err := os.ReadFile(filepath.Join(dir, ObjectName(helloSha256)))
+	if err != nil {
+		t.Fatalf("could not read stored payload: %v", err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("stored payload = %q; want = %q", data, "hello")
+	}
+}
+
+func TestGCSStore(t *testing.T) {
+	fake := newFakeObjectServer()
+	server := httptest.NewServer(http.HandlerFunc(fake.ServeGCS))
+	defer server.Close()
+
+	storageClient, err := storage.NewService(context.Background(), option.WithEndpoint(server.URL+"/storage/v1/"), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatalf("could not create storage client: %v", err)
+	}
+
+	store, err := NewGCSStore(storageClient, "bucket")
+	if err != nil {
+		t.Fatalf("could not create GCS store: %v", err)
+	}
+
+	testUpload(t, store, "gs://bucket/"+ObjectName(helloSha256))
+
+	if fake.puts != 1 || fake.objects[ObjectName(helloSha256)] != "hello" {
+		t.Errorf("unexpected objects in fake GCS after %d uploads: %v", fake.puts, fake.objects)
+	}
+}
+
+func TestS3Store(t *testing.T) {
+	fake := newFakeObjectServer()
+	server := httptest.NewServer(http.HandlerFunc(fake.ServeS3))
+	defer server.Close()
+
+	s3Client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		HTTPClient:   server.Client(),
+		Credentials:  aws.AnonymousCredentials{},
+	})
+
+	store, err := NewS3Store(s3Client, "bucket")
+	if err != nil {
+		t.Fatalf("could not create S3 store: %v", err)
+	}
+
+	testUpload(t, store, "s3://bucket/"+ObjectName(helloSha256))
+
+	if fake.puts != 1 || fake.objects[ObjectName(helloSha256)] != "hello" {
+		t.Errorf("unexpected objects in fake S3 after %d uploads: %v", fake.puts, fake.objects)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package payloads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3Store stores payloads in an S3 compatible bucket (AWS S3, MinIO, LocalStack etc.).
+type S3Store struct {
+	s3Client *s3.Client
+	bucket   string
+}
+
+// NewS3Store creates new S3 payload store. To use an S3 compatible service other than AWS, the
+// client needs to be created with a custom base endpoint and, usually, path style addressing.
+func NewS3Store(s3Client *s3.Client, bucket string) (*S3Store, error) {
+	if bucket == "" {
+		return nil, errors.New("S3 bucket name needs to be set")
+	}
+
+	return &S3Store{s3Client: s3Client, bucket: bucket}, nil
+}
+
+// Name returns the name of the store backend.
+func (s *S3Store) Name() string {
+	return "s3"
+}
+
+// Exists checks if the payload of a given sample is already stored.
+func (s *S3Store) Exists(ctx context.Context, sha256 string) (bool, error) {
+	_, err := s.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(ObjectName(sha256)),
+	})
+	if err == nil {
+		return true, nil
+	}
+
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// Put stores the payload of a given sample.
+func (s *S3Store) Put(ctx context.Context, sha256 string, r io.ReadSeeker, size int64) error {
+	_, err := s.s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(ObjectName(sha256)),
+		Body:          r,
+		ContentLength: aws.Int64(size),
+	})
+	if err != nil {
+		return fmt.Errorf("error uploading data to S3: %v", err)
+	}
+
+	return nil
+}
+
+// URI returns the location of the payload of a given sample.
+func (s *S3Store) URI(sha256 string) string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket, ObjectName(sha256))
+}
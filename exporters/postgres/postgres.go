@@ -16,18 +16,17 @@
 package postgres
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
-	"net/http"
 	"os"
-	"os/exec"
-	"strings"
 
 	"github.com/golang/glog"
 
+	"github.com/google/hashr/analyzers/filetype"
 	"github.com/google/hashr/common"
+	"github.com/google/hashr/exporters/payloads"
 
 	"github.com/lib/pq"
 )
@@ -41,6 +40,7 @@ const (
 type Exporter struct {
 	sqlDB          *sql.DB
 	uploadPayloads bool
+	payloadStore   payloads.PayloadStore
 }
 
 // Name returns exporter name.
@@ -49,7 +49,8 @@ func (e *Exporter) Name() string {
 }
 
 // NewExporter creates new Postregre exporter and all the necessary tables, if they don't exist.
-func NewExporter(sqlDB *sql.DB, uploadPayloads bool) (*Exporter, error) {
+// If payloadStore is set, payloads are uploaded to it instead of the payloads table.
+func NewExporter(sqlDB *sql.DB, uploadPayloads bool, payloadStore payloads.PayloadStore) (*Exporter, error) {
 	// Check if the "samples" table exists.
 	exists, err := tableExists(sqlDB, "samples")
 	if err != nil {
@@ -99,12 +100,42 @@ func NewExporter(sqlDB *sql.DB, uploadPayloads bool) (*Exporter, error) {
 			sourcePath  text,
 			sourceDescription text,
 			repoName text,
-			repoPath text
+			repoPath text,
+			os_family text,
+			os_id text,
+			os_name text,
+			os_version text,
+			os_codename text,
+			kernel_version text,
+			architecture text,
+			package_metadata jsonb
 		  )`
 		_, err = sqlDB.Exec(sql)
 		if err != nil {
 			return nil, fmt.Errorf("error while creating sources table: %v", err)
 		}
+	} else {
+		// Tables created by older versions of hashR don't hold the operating system and package
+		// metadata.
+		_, err = sqlDB.Exec(`ALTER TABLE sources
+			ADD COLUMN IF NOT EXISTS os_family text,
+			ADD COLUMN IF NOT EXISTS os_id text,
+			ADD COLUMN IF NOT EXISTS os_name text,
+			ADD COLUMN IF NOT EXISTS os_version text,
+			ADD COLUMN IF NOT EXISTS os_codename text,
+			ADD COLUMN IF NOT EXISTS kernel_version text,
+			ADD COLUMN IF NOT EXISTS architecture text`)
+		if err != nil {
+			return nil, fmt.Errorf("error while adding operating system columns to sources table: %v", err)
+		}
+		_, err = sqlDB.Exec(`ALTER TABLE sources ADD COLUMN IF NOT EXISTS package_metadata jsonb`)
+		if err != nil {
+			return nil, fmt.Errorf("error while adding package_metadata column to sources table: %v", err)
+		}
+	}
+	_, err = sqlDB.Exec(`CREATE INDEX IF NOT EXISTS sources_os ON sources (os_id, os_version, architecture)`)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating sources_os index: %v", err)
 	}
 
 	// Check if the "samples_sources" table exists.
@@ -118,246 +149,443 @@ func NewExporter(sqlDB *sql.DB, uploadPayloads bool) (*Exporter, error) {
 			sample_sha256 VARCHAR(100) REFERENCES samples(sha256) NOT NULL,
 			source_sha256 VARCHAR(100) REFERENCES sources(sha256) NOT NULL,
 			sample_paths text[],
+			sample_metadata jsonb,
+			sample_packages jsonb,
 			PRIMARY KEY (sample_sha256, source_sha256)
 		  )`
 		_, err = sqlDB.Exec(sql)
 		if err != nil {
 			return nil, fmt.Errorf("error while creating samples_sources table: %v", err)
 		}
+	} else {
+		// Tables created by older versions of hashR don't hold file metadata and package owners.
+		_, err = sqlDB.Exec(`ALTER TABLE samples_sources ADD COLUMN IF NOT EXISTS sample_metadata jsonb`)
+		if err != nil {
+			return nil, fmt.Errorf("error while adding sample_metadata column to samples_sources table: %v", err)
+		}
+		_, err = sqlDB.Exec(`ALTER TABLE samples_sources ADD COLUMN IF NOT EXISTS sample_packages jsonb`)
+		if err != nil {
+			return nil, fmt.Errorf("error while adding sample_packages column to samples_sources table: %v", err)
+		}
 	}
 
-	return &Exporter{sqlDB: sqlDB, uploadPayloads: uploadPayloads}, nil
-}
-
-// Export exports extracted data to PostgreSQL instance.
-func (e *Exporter) Export(ctx context.Context, sourceRepoName, sourceRepoPath, sourceID, sourceHash, sourcePath, sourceDescription string, samples []common.Sample) error {
-	if err := e.insertSource(sourceHash, sourceID, sourcePath, sourceRepoName, sourceRepoPath, sourceDescription); err != nil {
-		return fmt.Errorf("could not upload source data: %v", err)
+	// Check if the "executables" table exists.
+	exists, err = tableExists(sqlDB, "executables")
+	if err != nil {
+		return nil, fmt.Errorf("error while checking if executables table exists: %v", err)
 	}
 
-	for _, sample := range samples {
-		exists, err := e.sampleExists(sample.Sha256)
-		if err != nil {
-			glog.Errorf("skipping %s, could not check if sample was already uploaded: %v", sample.Sha256, err)
-			continue
-		}
-
-		if !exists {
-			if err := e.insertSample(sample, e.uploadPayloads); err != nil {
-				glog.Errorf("skipping %s, could not insert sample data: %v", sample.Sha256, err)
-				continue
+	if !exists {
+		for _, sql := range []string{
+			`CREATE TABLE executables (
+			sha256 VARCHAR(100) PRIMARY KEY REFERENCES samples(sha256),
+			format text,
+			architectures text[],
+			bits INT,
+			build_id text,
+			soname text,
+			needed text[],
+			stripped boolean,
+			compile_timestamp BIGINT,
+			imphash text,
+			version_info jsonb,
+			uuids text[]
+		  )`,
+			`CREATE INDEX executables_build_id ON executables (build_id)`,
+			`CREATE INDEX executables_imphash ON executables (imphash)`,
+		} {
+			if _, err = sqlDB.Exec(sql); err != nil {
+				return nil, fmt.Errorf("error while creating executables table: %v", err)
 			}
 		}
+	}
 
-		if err := e.insertRelationship(sample, sourceHash); err != nil {
-			glog.Errorf("skipping %s, could not insert source <-> sample relationship: %v", sample.Sha256, err)
-			continue
+	// Check if the "signatures" table exists.
+	exists, err = tableExists(sqlDB, "signatures")
+	if err != nil {
+		return nil, fmt.Errorf("error while checking if signatures table exists: %v", err)
+	}
+
+	if !exists {
+		for _, sql := range []string{
+			`CREATE TABLE signatures (
+			sha256 VARCHAR(100) REFERENCES samples(sha256) NOT NULL,
+			signature_index INT NOT NULL,
+			catalog text,
+			digest_algorithm text,
+			digest text,
+			verified boolean,
+			error text,
+			signing_time BIGINT,
+			signer text,
+			certificates jsonb,
+			PRIMARY KEY (sha256, signature_index)
+		  )`,
+			`CREATE INDEX signatures_signer ON signatures (signer)`,
+		} {
+			if _, err = sqlDB.Exec(sql); err != nil {
+				return nil, fmt.Errorf("error while creating signatures table: %v", err)
+			}
 		}
 	}
 
-	return nil
+	return &Exporter{sqlDB: sqlDB, uploadPayloads: uploadPayloads, payloadStore: payloadStore}, nil
 }
 
-func (e *Exporter) sampleExists(sha256 string) (bool, error) {
-	sqlStatement := `SELECT sha256 FROM samples WHERE sha256=$1;`
-	var quickSha256 string
-	row := e.sqlDB.QueryRow(sqlStatement, sha256)
-	switch err := row.Scan(&quickSha256); err {
-	case sql.ErrNoRows:
-		return false, nil
-	case nil:
-		return true, nil
-	default:
-		return false, err
+// Export exports extracted data to PostgreSQL instance. Samples and relationships are staged with
+// COPY into temporary tables and merged into the target tables in a single transaction, so either
+// all data of a given source is exported or none of it.
+func (e *Exporter) Export(ctx context.Context, sourceRepoName, sourceRepoPath, sourceID, sourceHash, sourcePath, sourceDescription string, samples []common.Sample) error {
+	tx, err := e.sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %v", err)
 	}
-}
+	defer tx.Rollback()
 
-func (e *Exporter) relationshipExists(sourceSha256, sampleSha256 string) (bool, error) {
-	sqlStatement := `
-	SELECT sample_sha256,source_sha256 
-	FROM samples_sources 
-	WHERE sample_sha256=$1 AND source_sha256=$2;`
-	row := e.sqlDB.QueryRow(sqlStatement, sampleSha256, sourceSha256)
-	switch err := row.Scan(&sampleSha256, &sourceSha256); err {
-	case sql.ErrNoRows:
-		return false, nil
-	case nil:
-		return true, nil
-	default:
-		return false, err
+	if err := insertSource(ctx, tx, sourceHash, sourceID, sourcePath, sourceRepoName, sourceRepoPath, sourceDescription); err != nil {
+		return fmt.Errorf("could not upload source data: %v", err)
 	}
-}
 
-func (e *Exporter) sourceExists(sha256 string) (bool, error) {
-	sqlStatement := `
-	SELECT sha256 
-	FROM sources 
-	WHERE sha256=$1;`
-	var quickSha256 string
-	row := e.sqlDB.QueryRow(sqlStatement, sha256)
-	switch err := row.Scan(&quickSha256); err {
-	case sql.ErrNoRows:
-		return false, nil
-	case nil:
-		return true, nil
-	default:
-		return false, err
+	existing, err := existingSamples(ctx, tx, samples)
+	if err != nil {
+		return fmt.Errorf("could not check which samples were already uploaded: %v", err)
 	}
-}
 
-func (e *Exporter) insertSample(sample common.Sample, uploadPayload bool) error {
-	sqlSamples := `
-	INSERT INTO samples (sha256, size, mimetype, file_output)
-	VALUES ($1, $2, $3, $4)`
-
-	var samplePath string
-	var fi os.FileInfo
-	var err error
-	// If sample has more than one path associated with it, take the first that is valid.
-	for _, path := range sample.Paths {
-		if fi, err = os.Stat(path); err == nil {
-			samplePath = path
-			break
+	for _, stmt := range []string{
+		`CREATE TEMP TABLE samples_staging (LIKE samples) ON COMMIT DROP`,
+		`CREATE TEMP TABLE payloads_staging (LIKE payloads) ON COMMIT DROP`,
+		`CREATE TEMP TABLE executables_staging (LIKE executables) ON COMMIT DROP`,
+		`CREATE TEMP TABLE signatures_staging (LIKE signatures) ON COMMIT DROP`,
+		`CREATE TEMP TABLE samples_sources_staging (sample_sha256 VARCHAR(100), sample_paths text[], sample_metadata jsonb, sample_packages jsonb) ON COMMIT DROP`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("could not create staging table: %v", err)
 		}
 	}
 
-	file, err := os.Open(samplePath)
-	if err != nil {
-		return fmt.Errorf("could not open %v", samplePath)
+	if err := e.stageSamples(ctx, tx, samples, existing); err != nil {
+		return err
 	}
-	defer file.Close()
 
-	mimeType, err := getFileContentType(file)
-	if err != nil {
-		glog.Warningf("Could not get file content type: %v", err)
+	if err := stageRelationships(ctx, tx, samples); err != nil {
+		return err
 	}
 
-	fileOutput, err := fileCmdOutput(samplePath)
-	if err != nil {
-		glog.Warningf("Could not get file cmd output: %v", err)
+	for _, stmt := range []string{
+		`INSERT INTO samples (sha256, mimetype, file_output, size)
+		SELECT DISTINCT ON (sha256) sha256, mimetype, file_output, size FROM samples_staging
+		ON CONFLICT (sha256) DO NOTHING`,
+		`INSERT INTO payloads (sha256, payload)
+		SELECT DISTINCT ON (sha256) sha256, payload FROM payloads_staging
+		ON CONFLICT (sha256) DO NOTHING`,
+		`INSERT INTO executables SELECT DISTINCT ON (sha256) * FROM executables_staging
+		ON CONFLICT (sha256) DO NOTHING`,
+		`INSERT INTO signatures SELECT DISTINCT ON (sha256, signature_index) * FROM signatures_staging
+		ON CONFLICT (sha256, signature_index) DO NOTHING`,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("could not merge staged samples: %v", err)
+		}
 	}
 
-	fileOutput = strings.TrimPrefix(fileOutput, fmt.Sprintf("%s%s", samplePath, ":"))
-
-	_, err = e.sqlDB.Exec(sqlSamples, sample.Sha256, int(fi.Size()), mimeType, fileOutput)
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO samples_sources (sample_sha256, source_sha256, sample_paths, sample_metadata, sample_packages)
+	SELECT sample_sha256, $1, sample_paths, sample_metadata, sample_packages FROM samples_sources_staging
+	ON CONFLICT (sample_sha256, source_sha256) DO UPDATE SET sample_paths = ARRAY(
+		SELECT DISTINCT unnest(samples_sources.sample_paths || excluded.sample_paths)),
+		sample_metadata = CASE WHEN excluded.sample_metadata IS NULL THEN samples_sources.sample_metadata
+		ELSE COALESCE(samples_sources.sample_metadata, '{}'::jsonb) || excluded.sample_metadata END,
+		sample_packages = CASE WHEN excluded.sample_packages IS NULL THEN samples_sources.sample_packages
+		ELSE COALESCE(samples_sources.sample_packages, '{}'::jsonb) || excluded.sample_packages END`, sourceHash)
 	if err != nil {
-		return fmt.Errorf("could not execute SQL: %v", err)
+		return fmt.Errorf("could not merge staged relationships: %v", err)
 	}
 
-	if uploadPayload {
-		data, err := os.ReadFile(samplePath)
-		if err != nil {
-			return fmt.Errorf("error while opening file: %v", err)
-		}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %v", err)
+	}
+
+	return nil
+}
 
-		sqlPayloads := `
-		INSERT INTO payloads (sha256, payload)
-		VALUES ($1, $2)`
+// existingSamples returns samples which are already present in the samples table.
+func existingSamples(ctx context.Context, tx *sql.Tx, samples []common.Sample) (map[string]bool, error) {
+	var hashes []string
+	for _, sample := range samples {
+		hashes = append(hashes, sample.Sha256)
+	}
 
-		_, err = e.sqlDB.Exec(sqlPayloads, sample.Sha256, data)
-		if err != nil {
-			return fmt.Errorf("could not execute SQL: %v", err)
+	rows, err := tx.QueryContext(ctx, `SELECT sha256 FROM samples WHERE sha256 = ANY($1)`, pq.Array(hashes))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var sha256 string
+		if err := rows.Scan(&sha256); err != nil {
+			return nil, err
 		}
+		existing[sha256] = true
 	}
 
-	return nil
+	return existing, rows.Err()
 }
 
-func (e *Exporter) insertSource(sourceHash, sourceID, sourcePath, sourceRepoName, sourceRepoPath, sourceDescription string) error {
-	exists, err := e.sourceExists(sourceHash)
+// copyIn streams rows produced by fn to a given table using COPY. Only a single COPY can be in
+// progress on a connection, so the statement is flushed and closed before returning.
+func copyIn(ctx context.Context, tx *sql.Tx, table string, columns []string, fn func(copyRow func(args ...interface{}) error) error) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(table, columns...))
 	if err != nil {
-		return err
+		return fmt.Errorf("could not prepare COPY statement: %v", err)
 	}
+	defer stmt.Close()
 
-	var sql string
-	if !exists {
-		sql = `
-		INSERT INTO sources (sha256, sourceID, sourcePath, repoName, repoPath, sourceDescription)
-		VALUES ($1, $2, $3, $4, $5, $6)`
+	if err := fn(func(args ...interface{}) error {
+		_, err := stmt.ExecContext(ctx, args...)
+		return err
+	}); err != nil {
+		return err
+	}
 
-		_, err = e.sqlDB.Exec(sql, sourceHash, pq.Array([]string{sourceID}), sourcePath, sourceRepoName, sourceRepoPath, sourceDescription)
-		if err != nil {
-			return err
-		}
-	} else {
-		sql = `UPDATE sources set sourceID = array_append(sourceID, $1) WHERE sha256 = $2`
-		_, err = e.sqlDB.Exec(sql, sourceID, sourceHash)
-		if err != nil {
-			return err
-		}
+	// Empty Exec flushes the data buffered by COPY.
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return fmt.Errorf("could not copy data to %s: %v", table, err)
 	}
 
-	return nil
+	return stmt.Close()
 }
 
-func (e *Exporter) insertRelationship(sample common.Sample, sourceSha256 string) error {
-	exists, err := e.relationshipExists(sourceSha256, sample.Sha256)
+// stageSamples copies metadata (and optionally payloads) of samples that are not yet present in the
+// samples table to the staging tables.
+func (e *Exporter) stageSamples(ctx context.Context, tx *sql.Tx, samples []common.Sample, existing map[string]bool) error {
+	payloadPaths := make(map[string]string)
+	executables := make(map[string]*common.ExecutableMetadata)
+	signatures := make(map[string][]*common.Signature)
+
+	err := copyIn(ctx, tx, "samples_staging", []string{"sha256", "mimetype", "file_output", "size"}, func(copyRow func(args ...interface{}) error) error {
+		for _, sample := range samples {
+			if existing[sample.Sha256] {
+				continue
+			}
+			// Mark sample as seen, it can be present more than once.
+			existing[sample.Sha256] = true
+
+			samplePath, fi, err := sample.FirstValidPath()
+			if err != nil {
+				return fmt.Errorf("could not stat sample %s: %v", sample.Sha256, err)
+			}
+
+			mimeType, fileOutput := fileMetadata(samplePath)
+			if err := copyRow(sample.Sha256, mimeType, fileOutput, int(fi.Size())); err != nil {
+				return fmt.Errorf("could not stage sample %s: %v", sample.Sha256, err)
+			}
+
+			if sample.Executable != nil {
+				executables[sample.Sha256] = sample.Executable
+			}
+			if len(sample.Signatures) > 0 {
+				signatures[sample.Sha256] = sample.Signatures
+			}
+
+			if !e.uploadPayloads {
+				continue
+			}
+
+			if e.payloadStore != nil {
+				if _, _, err := payloads.Upload(ctx, e.payloadStore, sample.Sha256, samplePath); err != nil {
+					return err
+				}
+				continue
+			}
+
+			payloadPaths[sample.Sha256] = samplePath
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
 
-	var paths []string
+	if err := stageExecutables(ctx, tx, executables); err != nil {
+		return err
+	}
 
-	for _, path := range sample.Paths {
-		s := strings.Split(path, "/extracted/")
-		if len(s) < 2 {
-			glog.Warningf("sample path does not follow expected format: %s", path)
-			continue
-		}
-		paths = append(paths, s[len(s)-1])
+	if err := stageSignatures(ctx, tx, signatures); err != nil {
+		return err
 	}
 
-	if !exists {
-		sql := `
-		INSERT INTO samples_sources (sample_sha256, source_sha256, sample_paths)
-		VALUES ($1, $2, $3)`
+	if len(payloadPaths) == 0 {
+		return nil
+	}
 
-		_, err = e.sqlDB.Exec(sql, sample.Sha256, sourceSha256, pq.Array(paths))
-		if err != nil {
-			return err
+	return copyIn(ctx, tx, "payloads_staging", []string{"sha256", "payload"}, func(copyRow func(args ...interface{}) error) error {
+		for sha256, samplePath := range payloadPaths {
+			data, err := os.ReadFile(samplePath)
+			if err != nil {
+				return fmt.Errorf("error while opening file: %v", err)
+			}
+			if err := copyRow(sha256, data); err != nil {
+				return fmt.Errorf("could not stage payload %s: %v", sha256, err)
+			}
 		}
-	} else {
-		sql := `
-		UPDATE samples_sources set sample_paths =  array_cat(sample_paths, $1) 
-		WHERE sample_sha256 = $2 AND source_sha256 = $3
-		`
-		_, err = e.sqlDB.Exec(sql, pq.Array(paths), sample.Sha256, sourceSha256)
-		if err != nil {
-			return err
+		return nil
+	})
+}
+
+// stageExecutables copies metadata of executable samples to the staging table.
+func stageExecutables(ctx context.Context, tx *sql.Tx, executables map[string]*common.ExecutableMetadata) error {
+	if len(executables) == 0 {
+		return nil
+	}
+
+	columns := []string{"sha256", "format", "architectures", "bits", "build_id", "soname", "needed", "stripped", "compile_timestamp", "imphash", "version_info", "uuids"}
+	return copyIn(ctx, tx, "executables_staging", columns, func(copyRow func(args ...interface{}) error) error {
+		for sha256, md := range executables {
+			var versionInfo interface{}
+			if len(md.VersionInfo) > 0 {
+				data, err := json.Marshal(md.VersionInfo)
+				if err != nil {
+					return fmt.Errorf("could not marshal version info of %s: %v", sha256, err)
+				}
+				versionInfo = string(data)
+			}
+
+			if err := copyRow(sha256, md.Format, pq.Array(md.Archs), md.Bits, md.BuildID, md.Soname, pq.Array(md.Needed), md.Stripped, md.CompileTimestamp, md.Imphash, versionInfo, pq.Array(md.UUIDs)); err != nil {
+				return fmt.Errorf("could not stage executable %s: %v", sha256, err)
+			}
 		}
-		// UPDATE users SET topics = array_cat(topics, '{cats,mice}');
+		return nil
+	})
+}
+
+// stageSignatures copies Authenticode signatures of samples to the staging table. Signer is the
+// subject of the first certificate of the chain.
+func stageSignatures(ctx context.Context, tx *sql.Tx, signatures map[string][]*common.Signature) error {
+	if len(signatures) == 0 {
+		return nil
 	}
 
-	return nil
+	columns := []string{"sha256", "signature_index", "catalog", "digest_algorithm", "digest", "verified", "error", "signing_time", "signer", "certificates"}
+	return copyIn(ctx, tx, "signatures_staging", columns, func(copyRow func(args ...interface{}) error) error {
+		for sha256, sampleSignatures := range signatures {
+			for i, sig := range sampleSignatures {
+				var signer string
+				if len(sig.Certificates) > 0 {
+					signer = sig.Certificates[0].Subject
+				}
+
+				var certificates interface{}
+				if len(sig.Certificates) > 0 {
+					data, err := json.Marshal(sig.Certificates)
+					if err != nil {
+						return fmt.Errorf("could not marshal certificates of %s: %v", sha256, err)
+					}
+					certificates = string(data)
+				}
+
+				if err := copyRow(sha256, i, sig.Catalog, sig.DigestAlgorithm, sig.Digest, sig.Verified, sig.Error, sig.SigningTime, signer, certificates); err != nil {
+					return fmt.Errorf("could not stage signature %s: %v", sha256, err)
+				}
+			}
+		}
+		return nil
+	})
 }
 
-func getFileContentType(out *os.File) (string, error) {
+// stageRelationships copies paths of all samples, with file metadata and package owners keyed by
+// path, to the staging table.
+func stageRelationships(ctx context.Context, tx *sql.Tx, samples []common.Sample) error {
+	return copyIn(ctx, tx, "samples_sources_staging", []string{"sample_sha256", "sample_paths", "sample_metadata", "sample_packages"}, func(copyRow func(args ...interface{}) error) error {
+		for _, sample := range samples {
+			var paths []string
+			metadata := make(map[string]*common.FileMetadata)
+			packages := make(map[string]*common.PackageOwner)
+			for _, path := range sample.Paths {
+				relPath, ok := common.TrimExtractionRoot(path)
+				if !ok {
+					glog.Warningf("sample path does not follow expected format: %s", path)
+					continue
+				}
+				paths = append(paths, relPath)
+				if md, ok := sample.Metadata[path]; ok {
+					metadata[relPath] = md
+				}
+				if owner, ok := sample.Packages[path]; ok {
+					packages[relPath] = owner
+				}
+			}
+
+			var sampleMetadata interface{}
+			if len(metadata) > 0 {
+				data, err := json.Marshal(metadata)
+				if err != nil {
+					return fmt.Errorf("could not marshal metadata of %s: %v", sample.Sha256, err)
+				}
+				sampleMetadata = string(data)
+			}
 
-	// Only the first 512 bytes are used to check the content type.
-	buffer := make([]byte, 512)
+			var samplePackages interface{}
+			if len(packages) > 0 {
+				data, err := json.Marshal(packages)
+				if err != nil {
+					return fmt.Errorf("could not marshal package owners of %s: %v", sample.Sha256, err)
+				}
+				samplePackages = string(data)
+			}
 
-	_, err := out.Read(buffer)
+			if err := copyRow(sample.Sha256, pq.Array(paths), sampleMetadata, samplePackages); err != nil {
+				return fmt.Errorf("could not stage relationship %s: %v", sample.Sha256, err)
+			}
+		}
+		return nil
+	})
+}
+
+// fileMetadata returns mimetype and description of a given file. They're left empty if the file
+// type can't be identified.
+func fileMetadata(samplePath string) (string, string) {
+	info, err := filetype.IdentifyFile(samplePath)
 	if err != nil {
-		return "", err
+		glog.Warningf("Could not identify file type of %s: %v", samplePath, err)
+		return "", ""
 	}
 
-	contentType := http.DetectContentType(buffer)
+	return info.MimeType, info.Description
+}
+
+func insertSource(ctx context.Context, tx *sql.Tx, sourceHash, sourceID, sourcePath, sourceRepoName, sourceRepoPath, sourceDescription string) error {
+	_, err := tx.ExecContext(ctx, `
+	INSERT INTO sources (sha256, sourceID, sourcePath, repoName, repoPath, sourceDescription)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (sha256) DO UPDATE SET sourceID = CASE
+		WHEN $7 = ANY(sources.sourceID) THEN sources.sourceID
+		ELSE array_append(sources.sourceID, $7) END`,
+		sourceHash, pq.Array([]string{sourceID}), sourcePath, sourceRepoName, sourceRepoPath, sourceDescription, sourceID)
 
-	return contentType, nil
+	return err
 }
 
-func fileCmdOutput(filepath string) (string, error) {
-	cmd := exec.Command("/usr/bin/file", filepath)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+// ExportOS stores the operating system of an exported source.
+func (e *Exporter) ExportOS(ctx context.Context, sourceHash string, sourceOS *common.OSInfo) error {
+	_, err := e.sqlDB.ExecContext(ctx, `
+	UPDATE sources SET os_family = $2, os_id = $3, os_name = $4, os_version = $5, os_codename = $6, kernel_version = $7, architecture = $8
+	WHERE sha256 = $1`,
+		sourceHash, sourceOS.Family, sourceOS.ID, sourceOS.Name, sourceOS.Version, sourceOS.Codename, sourceOS.Kernel, sourceOS.Architecture)
 
-	err := cmd.Run()
+	return err
+}
+
+// ExportPackage stores package metadata of an exported source.
+func (e *Exporter) ExportPackage(ctx context.Context, sourceHash string, pkg *common.PackageInfo) error {
+	data, err := json.Marshal(pkg)
 	if err != nil {
-		return "", fmt.Errorf("error while executing %s: %v\nStdout: %v\nStderr: %v", "/usr/bin/file", err, stdout.String(), stderr.String())
+		return err
 	}
+	_, err = e.sqlDB.ExecContext(ctx, `UPDATE sources SET package_metadata = $2 WHERE sha256 = $1`, sourceHash, string(data))
 
-	return strings.TrimSuffix(stdout.String(), "\n"), nil
+	return err
 }
 
 func tableExists(db *sql.DB, tableName string) (bool, error) {
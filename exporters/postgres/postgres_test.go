@@ -26,6 +26,25 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 )
 
+// expectExistingTables sets up expectations of NewExporter for a database created by an older version
+// of hashR.
+func expectExistingTables(mock sqlmock.Sqlmock) {
+	tableExists := func(table string) {
+		mock.ExpectQuery(`SELECT EXISTS ( SELECT 1 FROM information_schema.tables WHERE table_name=$1 );`).WithArgs(table).WillReturnRows(mock.NewRows([]string{"t"}).AddRow("t"))
+	}
+	tableExists("samples")
+	tableExists("payloads")
+	tableExists("sources")
+	mock.ExpectExec(`ALTER TABLE sources ADD COLUMN IF NOT EXISTS os_family text, ADD COLUMN IF NOT EXISTS os_id text, ADD COLUMN IF NOT EXISTS os_name text, ADD COLUMN IF NOT EXISTS os_version text, ADD COLUMN IF NOT EXISTS os_codename text, ADD COLUMN IF NOT EXISTS kernel_version text, ADD COLUMN IF NOT EXISTS architecture text`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`ALTER TABLE sources ADD COLUMN IF NOT EXISTS package_metadata jsonb`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE INDEX IF NOT EXISTS sources_os ON sources (os_id, os_version, architecture)`).WillReturnResult(sqlmock.NewResult(0, 0))
+	tableExists("samples_sources")
+	mock.ExpectExec(`ALTER TABLE samples_sources ADD COLUMN IF NOT EXISTS sample_metadata jsonb`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`ALTER TABLE samples_sources ADD COLUMN IF NOT EXISTS sample_packages jsonb`).WillReturnResult(sqlmock.NewResult(0, 0))
+	tableExists("executables")
+	tableExists("signatures")
+}
+
 func TestExport(t *testing.T) {
 	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
 	if err != nil {
@@ -33,33 +52,50 @@ func TestExport(t *testing.T) {
 	}
 	defer db.Close()
 
-	mock.ExpectQuery(`SELECT EXISTS ( SELECT 1 FROM information_schema.tables WHERE table_name=$1 );`).WithArgs("samples").WillReturnRows(mock.NewRows([]string{"t"}).AddRow("t"))
-	mock.ExpectQuery(`SELECT EXISTS ( SELECT 1 FROM information_schema.tables WHERE table_name=$1 );`).WithArgs("payloads").WillReturnRows(mock.NewRows([]string{"t"}).AddRow("t"))
-	mock.ExpectQuery(`SELECT EXISTS ( SELECT 1 FROM information_schema.tables WHERE table_name=$1 );`).WithArgs("sources").WillReturnRows(mock.NewRows([]string{"t"}).AddRow("t"))
-	mock.ExpectQuery(`SELECT EXISTS ( SELECT 1 FROM information_schema.tables WHERE table_name=$1 );`).WithArgs("samples_sources").WillReturnRows(mock.NewRows([]string{"t"}).AddRow("t"))
+	expectExistingTables(mock)
 
-	postgresExporter, err := NewExporter(db, false)
+	postgresExporter, err := NewExporter(db, false, nil)
 	if err != nil {
 		t.Fatalf("could not create Postgres exporter: %v", err)
 	}
 
-	mock.ExpectQuery(`SELECT sha256 FROM sources WHERE sha256=$1;`).WithArgs("07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc").WillReturnRows(mock.NewRows([]string{"sha256"}))
-	mock.ExpectExec(`INSERT INTO sources (sha256, sourceID, sourcePath, repoName, repoPath, sourceDescription) VALUES ($1, $2, $3, $4, $5, $6)`).WithArgs("07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc", `{"ubuntu-1604-lts"}`, "", "GCP", "ubuntu", "Official Ubuntu GCP image.").WillReturnResult(sqlmock.NewResult(1, 1))
+	const sourceHash = "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc"
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO sources (sha256, sourceID, sourcePath, repoName, repoPath, sourceDescription) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (sha256) DO UPDATE SET sourceID = CASE WHEN $7 = ANY(sources.sourceID) THEN sources.sourceID ELSE array_append(sources.sourceID, $7) END`).WithArgs(sourceHash, `{"ubuntu-1604-lts"}`, "", "GCP", "ubuntu", "Official Ubuntu GCP image.", "ubuntu-1604-lts").WillReturnResult(sqlmock.NewResult(1, 1))
+	// file.03 was already exported from a different source.
+	mock.ExpectQuery(`SELECT sha256 FROM samples WHERE sha256 = ANY($1)`).WithArgs(`{"a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3","5c7a0f6e38f86f4db12130e5ca9f734f4def519b9a884ee8ea9fc45f9626c6fb","9ad2027cae0d7b0f041a6fc1e3124ad4046b2665068c44c74546ad9811e81ec7"}`).WillReturnRows(mock.NewRows([]string{"sha256"}).AddRow("9ad2027cae0d7b0f041a6fc1e3124ad4046b2665068c44c74546ad9811e81ec7"))
+	mock.ExpectExec(`CREATE TEMP TABLE samples_staging (LIKE samples) ON COMMIT DROP`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TEMP TABLE payloads_staging (LIKE payloads) ON COMMIT DROP`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TEMP TABLE executables_staging (LIKE executables) ON COMMIT DROP`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TEMP TABLE signatures_staging (LIKE signatures) ON COMMIT DROP`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TEMP TABLE samples_sources_staging (sample_sha256 VARCHAR(100), sample_paths text[], sample_metadata jsonb, sample_packages jsonb) ON COMMIT DROP`).WillReturnResult(sqlmock.NewResult(0, 0))
 
-	mock.ExpectQuery(`SELECT sha256 FROM samples WHERE sha256=$1;`).WithArgs("a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3").WillReturnRows(mock.NewRows([]string{"sha256"}))
-	mock.ExpectExec(`INSERT INTO samples (sha256, size, mimetype, file_output) VALUES ($1, $2, $3, $4)`).WithArgs("a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3", 8192, "application/octet-stream", " data").WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectQuery("SELECT sample_sha256,source_sha256 FROM samples_sources WHERE sample_sha256=$1 AND source_sha256=$2;").WithArgs("a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3", "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc").WillReturnRows(mock.NewRows([]string{"a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3", "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc"}))
-	mock.ExpectExec(`INSERT INTO samples_sources (sample_sha256, source_sha256, sample_paths) VALUES ($1, $2, $3)`).WithArgs("a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3", "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc", `{"file.01"}`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectPrepare(`COPY "samples_staging" ("sha256", "mimetype", "file_output", "size") FROM STDIN`)
+	mock.ExpectExec(`COPY "samples_staging" ("sha256", "mimetype", "file_output", "size") FROM STDIN`).WithArgs("a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3", "application/octet-stream", "data", 8192).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`COPY "samples_staging" ("sha256", "mimetype", "file_output", "size") FROM STDIN`).WithArgs("5c7a0f6e38f86f4db12130e5ca9f734f4def519b9a884ee8ea9fc45f9626c6fb", "application/octet-stream", "data", 7168).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`COPY "samples_staging" ("sha256", "mimetype", "file_output", "size") FROM STDIN`).WithArgs().WillReturnResult(sqlmock.NewResult(0, 2))
 
-	mock.ExpectQuery(`SELECT sha256 FROM samples WHERE sha256=$1;`).WithArgs("5c7a0f6e38f86f4db12130e5ca9f734f4def519b9a884ee8ea9fc45f9626c6fb").WillReturnRows(mock.NewRows([]string{"sha256"}))
-	mock.ExpectExec(`INSERT INTO samples (sha256, size, mimetype, file_output) VALUES ($1, $2, $3, $4)`).WithArgs("5c7a0f6e38f86f4db12130e5ca9f734f4def519b9a884ee8ea9fc45f9626c6fb", 7168, "application/octet-stream", " data").WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectQuery("SELECT sample_sha256,source_sha256 FROM samples_sources WHERE sample_sha256=$1 AND source_sha256=$2;").WithArgs("5c7a0f6e38f86f4db12130e5ca9f734f4def519b9a884ee8ea9fc45f9626c6fb", "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc").WillReturnRows(mock.NewRows([]string{"5c7a0f6e38f86f4db12130e5ca9f734f4def519b9a884ee8ea9fc45f9626c6fb", "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc"}))
-	mock.ExpectExec(`INSERT INTO samples_sources (sample_sha256, source_sha256, sample_paths) VALUES ($1, $2, $3)`).WithArgs("5c7a0f6e38f86f4db12130e5ca9f734f4def519b9a884ee8ea9fc45f9626c6fb", "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc", `{"file.02"}`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectPrepare(`COPY "executables_staging" ("sha256", "format", "architectures", "bits", "build_id", "soname", "needed", "stripped", "compile_timestamp", "imphash", "version_info", "uuids") FROM STDIN`)
+	mock.ExpectExec(`COPY "executables_staging" ("sha256", "format", "architectures", "bits", "build_id", "soname", "needed", "stripped", "compile_timestamp", "imphash", "version_info", "uuids") FROM STDIN`).WithArgs("5c7a0f6e38f86f4db12130e5ca9f734f4def519b9a884ee8ea9fc45f9626c6fb", "PE32+", `{"x86-64"}`, 64, "", "", nil, false, 1600000000, "f27781d485fb284685652745f785f57f", `{"ProductName":"Hello"}`, nil).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`COPY "executables_staging" ("sha256", "format", "architectures", "bits", "build_id", "soname", "needed", "stripped", "compile_timestamp", "imphash", "version_info", "uuids") FROM STDIN`).WithArgs().WillReturnResult(sqlmock.NewResult(0, 1))
 
-	mock.ExpectQuery(`SELECT sha256 FROM samples WHERE sha256=$1;`).WithArgs("9ad2027cae0d7b0f041a6fc1e3124ad4046b2665068c44c74546ad9811e81ec7").WillReturnRows(mock.NewRows([]string{"sha256"}))
-	mock.ExpectExec(`INSERT INTO samples (sha256, size, mimetype, file_output) VALUES ($1, $2, $3, $4)`).WithArgs("9ad2027cae0d7b0f041a6fc1e3124ad4046b2665068c44c74546ad9811e81ec7", 5120, "application/octet-stream", " data").WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectQuery("SELECT sample_sha256,source_sha256 FROM samples_sources WHERE sample_sha256=$1 AND source_sha256=$2;").WithArgs("9ad2027cae0d7b0f041a6fc1e3124ad4046b2665068c44c74546ad9811e81ec7", "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc").WillReturnRows(mock.NewRows([]string{"9ad2027cae0d7b0f041a6fc1e3124ad4046b2665068c44c74546ad9811e81ec7", "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc"}))
-	mock.ExpectExec(`INSERT INTO samples_sources (sample_sha256, source_sha256, sample_paths) VALUES ($1, $2, $3)`).WithArgs("9ad2027cae0d7b0f041a6fc1e3124ad4046b2665068c44c74546ad9811e81ec7", "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc", `{"file.03"}`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectPrepare(`COPY "signatures_staging" ("sha256", "signature_index", "catalog", "digest_algorithm", "digest", "verified", "error", "signing_time", "signer", "certificates") FROM STDIN`)
+	mock.ExpectExec(`COPY "signatures_staging" ("sha256", "signature_index", "catalog", "digest_algorithm", "digest", "verified", "error", "signing_time", "signer", "certificates") FROM STDIN`).WithArgs("5c7a0f6e38f86f4db12130e5ca9f734f4def519b9a884ee8ea9fc45f9626c6fb", 0, "Windows/System32/CatRoot/hello.cat", "sha256", "46861b100c90416a5ebfd1f2b9f57bb3a84dff3cdb9585c008fade31eacf9e00", true, "", 1641092645, "CN=Example Corp.", `[{"subject":"CN=Example Corp.","issuer":"CN=Example Root CA","serial_number":"1234","not_before":1577836800,"not_after":2208988800,"sha256":"aaaa"}]`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`COPY "signatures_staging" ("sha256", "signature_index", "catalog", "digest_algorithm", "digest", "verified", "error", "signing_time", "signer", "certificates") FROM STDIN`).WithArgs().WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectPrepare(`COPY "samples_sources_staging" ("sample_sha256", "sample_paths", "sample_metadata", "sample_packages") FROM STDIN`)
+	mock.ExpectExec(`COPY "samples_sources_staging" ("sample_sha256", "sample_paths", "sample_metadata", "sample_packages") FROM STDIN`).WithArgs("a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3", `{"file.01"}`, nil, `{"file.01":{"manager":"dpkg","package":"hello","version":"2.10-2","architecture":"amd64","modified":true}}`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`COPY "samples_sources_staging" ("sample_sha256", "sample_paths", "sample_metadata", "sample_packages") FROM STDIN`).WithArgs("5c7a0f6e38f86f4db12130e5ca9f734f4def519b9a884ee8ea9fc45f9626c6fb", `{"file.02"}`, nil, nil).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`COPY "samples_sources_staging" ("sample_sha256", "sample_paths", "sample_metadata", "sample_packages") FROM STDIN`).WithArgs("9ad2027cae0d7b0f041a6fc1e3124ad4046b2665068c44c74546ad9811e81ec7", `{"file.03"}`, `{"file.03":{"mode":33188,"uid":0,"gid":0,"user":"root","group":"root","mtime":1600000000}}`, nil).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`COPY "samples_sources_staging" ("sample_sha256", "sample_paths", "sample_metadata", "sample_packages") FROM STDIN`).WithArgs().WillReturnResult(sqlmock.NewResult(0, 3))
+
+	mock.ExpectExec(`INSERT INTO samples (sha256, mimetype, file_output, size) SELECT DISTINCT ON (sha256) sha256, mimetype, file_output, size FROM samples_staging ON CONFLICT (sha256) DO NOTHING`).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO payloads (sha256, payload) SELECT DISTINCT ON (sha256) sha256, payload FROM payloads_staging ON CONFLICT (sha256) DO NOTHING`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO executables SELECT DISTINCT ON (sha256) * FROM executables_staging ON CONFLICT (sha256) DO NOTHING`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO signatures SELECT DISTINCT ON (sha256, signature_index) * FROM signatures_staging ON CONFLICT (sha256, signature_index) DO NOTHING`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO samples_sources (sample_sha256, source_sha256, sample_paths, sample_metadata, sample_packages) SELECT sample_sha256, $1, sample_paths, sample_metadata, sample_packages FROM samples_sources_staging ON CONFLICT (sample_sha256, source_sha256) DO UPDATE SET sample_paths = ARRAY( SELECT DISTINCT unnest(samples_sources.sample_paths || excluded.sample_paths)), sample_metadata = CASE WHEN excluded.sample_metadata IS NULL THEN samples_sources.sample_metadata ELSE COALESCE(samples_sources.sample_metadata, '{}'::jsonb) || excluded.sample_metadata END, sample_packages = CASE WHEN excluded.sample_packages IS NULL THEN samples_sources.sample_packages ELSE COALESCE(samples_sources.sample_packages, '{}'::jsonb) || excluded.sample_packages END`).WithArgs(sourceHash).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
 
 	tempDir := "/tmp/extracted/"
 	if err := os.MkdirAll(tempDir, 0777); err != nil {
@@ -88,20 +124,158 @@ func TestExport(t *testing.T) {
 			Sha256: "a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3",
 			Paths:  []string{filepath.Join(tempDir, "file.01")},
 			Upload: true,
+			Packages: map[string]*common.PackageOwner{
+				filepath.Join(tempDir, "file.01"): {Manager: "dpkg", Package: "hello", Version: "2.10-2", Architecture: "amd64", Modified: true},
+			},
 		},
 		{
 			Sha256: "5c7a0f6e38f86f4db12130e5ca9f734f4def519b9a884ee8ea9fc45f9626c6fb",
 			Paths:  []string{filepath.Join(tempDir, "file.02")},
 			Upload: true,
+			Executable: &common.ExecutableMetadata{
+				Format:           "PE32+",
+				Archs:            []string{"x86-64"},
+				Bits:             64,
+				CompileTimestamp: 1600000000,
+				Imphash:          "f27781d485fb284685652745f785f57f",
+				VersionInfo:      map[string]string{"ProductName": "Hello"},
+			},
+			Signatures: []*common.Signature{
+				{
+					Catalog:         "Windows/System32/CatRoot/hello.cat",
+					DigestAlgorithm: "sha256",
+					Digest:          "46861b100c90416a5ebfd1f2b9f57bb3a84dff3cdb9585c008fade31eacf9e00",
+					Verified:        true,
+					SigningTime:     1641092645,
+					Certificates: []*common.Certificate{
+						{Subject: "CN=Example Corp.", Issuer: "CN=Example Root CA", SerialNumber: "1234", NotBefore: 1577836800, NotAfter: 2208988800, Sha256: "aaaa"},
+					},
+				},
+			},
 		},
 		{
 			Sha256: "9ad2027cae0d7b0f041a6fc1e3124ad4046b2665068c44c74546ad9811e81ec7",
 			Paths:  []string{filepath.Join(tempDir, "file.03")},
 			Upload: true,
+			Metadata: map[string]*common.FileMetadata{
+				filepath.Join(tempDir, "file.03"): {Mode: 0100644, UID: 0, GID: 0, User: "root", Group: "root", Mtime: 1600000000},
+			},
 		},
 	}
 
-	if err := postgresExporter.Export(context.Background(), "GCP", "ubuntu", "ubuntu-1604-lts", "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc", "", "Official Ubuntu GCP image.", samples); err != nil {
+	if err := postgresExporter.Export(context.Background(), "GCP", "ubuntu", "ubuntu-1604-lts", sourceHash, "", "Official Ubuntu GCP image.", samples); err != nil {
 		t.Fatalf("unexpected error while running Export() = %v", err)
 	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestExportRollback(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("could not open a stub database connection: %v", err)
+	}
+	defer db.Close()
+
+	expectExistingTables(mock)
+
+	postgresExporter, err := NewExporter(db, false, nil)
+	if err != nil {
+		t.Fatalf("could not create Postgres exporter: %v", err)
+	}
+
+	const sourceHash = "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc"
+	const sampleHash = "a665a45920422f9d417e4867efdc4fb8a04a1f3fff1fa07e998e86f7f7a27ae3"
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO sources (sha256, sourceID, sourcePath, repoName, repoPath, sourceDescription) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (sha256) DO UPDATE SET sourceID = CASE WHEN $7 = ANY(sources.sourceID) THEN sources.sourceID ELSE array_append(sources.sourceID, $7) END`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`SELECT sha256 FROM samples WHERE sha256 = ANY($1)`).WillReturnRows(mock.NewRows([]string{"sha256"}))
+	mock.ExpectExec(`CREATE TEMP TABLE samples_staging (LIKE samples) ON COMMIT DROP`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TEMP TABLE payloads_staging (LIKE payloads) ON COMMIT DROP`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TEMP TABLE executables_staging (LIKE executables) ON COMMIT DROP`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TEMP TABLE signatures_staging (LIKE signatures) ON COMMIT DROP`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TEMP TABLE samples_sources_staging (sample_sha256 VARCHAR(100), sample_paths text[], sample_metadata jsonb, sample_packages jsonb) ON COMMIT DROP`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectPrepare(`COPY "samples_staging" ("sha256", "mimetype", "file_output", "size") FROM STDIN`).WillBeClosed()
+	mock.ExpectRollback()
+
+	samples := []common.Sample{{Sha256: sampleHash, Paths: []string{filepath.Join(t.TempDir(), "extracted", "missing")}, Upload: true}}
+
+	if err := postgresExporter.Export(context.Background(), "GCP", "ubuntu", "ubuntu-1604-lts", sourceHash, "", "", samples); err == nil {
+		t.Error("Export() expected error for a sample that can't be read")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestExportOS(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("could not open a stub database connection: %v", err)
+	}
+	defer db.Close()
+
+	expectExistingTables(mock)
+	postgresExporter, err := NewExporter(db, false, nil)
+	if err != nil {
+		t.Fatalf("could not create Postgres exporter: %v", err)
+	}
+
+	const sourceHash = "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc"
+	mock.ExpectExec(`UPDATE sources SET os_family = $2, os_id = $3, os_name = $4, os_version = $5, os_codename = $6, kernel_version = $7, architecture = $8 WHERE sha256 = $1`).WithArgs(sourceHash, "linux", "ubuntu", "Ubuntu 22.04.1 LTS", "22.04", "jammy", "5.15.0-1019-gcp", "arm64").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	sourceOS := &common.OSInfo{Family: "linux", ID: "ubuntu", Name: "Ubuntu 22.04.1 LTS", Version: "22.04", Codename: "jammy", Kernel: "5.15.0-1019-gcp", Architecture: "arm64"}
+	if err := postgresExporter.ExportOS(context.Background(), sourceHash, sourceOS); err != nil {
+		t.Fatalf("unexpected error while running ExportOS() = %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestExportPackage(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("could not open a stub database connection: %v", err)
+	}
+	defer db.Close()
+
+	expectExistingTables(mock)
+	postgresExporter, err := NewExporter(db, false, nil)
+	if err != nil {
+		t.Fatalf("could not create Postgres exporter: %v", err)
+	}
+
+	const sourceHash = "07123e1f482356c415f684407a3b8723e10b2cbbc0b8fcd6282c49d37c9c1abc"
+	mock.ExpectExec(`UPDATE sources SET package_metadata = $2 WHERE sha256 = $1`).WithArgs(sourceHash, `{"manager":"dpkg","package":"hello","version":"2.10-2","architecture":"amd64","maintainer":"Santiago Vila \u003csanvila@debian.org\u003e","homepage":"http://www.gnu.org/software/hello/","verified_files":4,"mismatched_files":["usr/bin/hello"]}`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	pkg := &common.PackageInfo{Manager: "dpkg", Package: "hello", Version: "2.10-2", Architecture: "amd64", Maintainer: "Santiago Vila <sanvila@debian.org>", Homepage: "http://www.gnu.org/software/hello/", VerifiedFiles: 4, MismatchedFiles: []string{"usr/bin/hello"}}
+	if err := postgresExporter.ExportPackage(context.Background(), sourceHash, pkg); err != nil {
+		t.Fatalf("unexpected error while running ExportPackage() = %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestFileMetadata(t *testing.T) {
+	for _, tc := range []struct {
+		path           string
+		wantMimeType   string
+		wantFileOutput string
+	}{
+		{path: "testdata/extraction/file.01", wantMimeType: "application/octet-stream", wantFileOutput: "data"},
+		// File type of a directory can't be identified, metadata is left empty.
+		{path: "testdata/extraction", wantMimeType: "", wantFileOutput: ""},
+	} {
+		mimeType, fileOutput := fileMetadata(tc.path)
+		if mimeType != tc.wantMimeType || fileOutput != tc.wantFileOutput {
+			t.Errorf("fileMetadata(%s) = %q, %q; want %q, %q", tc.path, mimeType, fileOutput, tc.wantMimeType, tc.wantFileOutput)
+		}
+	}
 }
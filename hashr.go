@@ -17,52 +17,83 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/spanner"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/golang/glog"
+	"github.com/google/hashr/analyzers/authenticode"
+	"github.com/google/hashr/analyzers/executable"
+	"github.com/google/hashr/analyzers/osinfo"
+	"github.com/google/hashr/analyzers/packages"
+	"github.com/google/hashr/cache"
 	"github.com/google/hashr/core/hashr"
+	fileExporter "github.com/google/hashr/exporters/file"
 	gcpExporter "github.com/google/hashr/exporters/gcp"
+	nsrlExporter "github.com/google/hashr/exporters/nsrl"
+	opensearchExporter "github.com/google/hashr/exporters/opensearch"
+	parquetExporter "github.com/google/hashr/exporters/parquet"
+	"github.com/google/hashr/exporters/payloads"
 	postgresExporter "github.com/google/hashr/exporters/postgres"
+	"github.com/google/hashr/importers/apk"
+	"github.com/google/hashr/importers/apt"
+	importerCommon "github.com/google/hashr/importers/common"
 	"github.com/google/hashr/importers/deb"
 	"github.com/google/hashr/importers/gcp"
 	"github.com/google/hashr/importers/gcr"
 	"github.com/google/hashr/importers/iso9660"
+	"github.com/google/hashr/importers/pacman"
 	"github.com/google/hashr/importers/rpm"
 	"github.com/google/hashr/importers/targz"
 	"github.com/google/hashr/importers/windows"
 	"github.com/google/hashr/importers/wsus"
+	"github.com/google/hashr/importers/yum"
 	"github.com/google/hashr/importers/zip"
 	"github.com/google/hashr/processors/local"
 	"github.com/google/hashr/storage/cloudspanner"
 	"github.com/google/hashr/storage/postgres"
+	"golang.org/x/crypto/openpgp"
 	"golang.org/x/oauth2/google"
 
 	"google.golang.org/api/cloudbuild/v1"
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/storage/v1"
 
+	cloudspannerClient "github.com/google/hashr/client/cloudspanner"
+	postgresClient "github.com/google/hashr/client/postgres"
 	awsImporter "github.com/google/hashr/importers/aws"
 )
 
 var (
 	processingWorkerCount  = flag.Int("processing_worker_count", 2, "Number of processing workers.")
-	importersToRun         = flag.String("importers", strings.Join([]string{}, ","), fmt.Sprintf("Importers to be run: %s,%s,%s,%s,%s,%s,%s,%s,%s,%s", gcp.RepoName, awsImporter.RepoName, targz.RepoName, windows.RepoName, wsus.RepoName, deb.RepoName, rpm.RepoName, zip.RepoName, gcr.RepoName, iso9660.RepoName))
-	exportersToRun         = flag.String("exporters", strings.Join([]string{}, ","), fmt.Sprintf("Exporters to be run: %s,%s", gcpExporter.Name, postgresExporter.Name))
+	importersToRun         = flag.String("importers", strings.Join([]string{}, ","), fmt.Sprintf("Importers to be run: %s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s", gcp.RepoName, awsImporter.RepoName, targz.RepoName, windows.RepoName, wsus.RepoName, deb.RepoName, apt.RepoName, rpm.RepoName, yum.RepoName, apk.RepoName, pacman.RepoName, zip.RepoName, gcr.RepoName, iso9660.RepoName))
+	exportersToRun         = flag.String("exporters", strings.Join([]string{}, ","), fmt.Sprintf("Exporters to be run: %s,%s,%s,%s,%s,%s", gcpExporter.Name, postgresExporter.Name, nsrlExporter.Name, fileExporter.Name, parquetExporter.Name, opensearchExporter.Name))
+	analyzersToRun         = flag.String("analyzers", "", fmt.Sprintf("Optional comma separated list of analyzers run on samples before they're exported: %s,%s,%s", executable.Name, authenticode.Name, packages.Name))
+	detectOS               = flag.Bool("detect_os", false, "If true, the operating system of disk images is detected from the extracted files and stored with the source.")
 	jobStorage             = flag.String("storage", "", "Storage that should be used for storing data about processing jobs, can have one of the two values: postgres, cloudspanner")
 	cacheDir               = flag.String("cache_dir", "/tmp/", "Path to cache dir used to store local cache.")
 	export                 = flag.Bool("export", true, "Whether to export samples, otherwise, they'll be saved to disk")
 	exportPath             = flag.String("export_path", "/tmp/hashr-uploads", "If export is set to false, this is the folder where samples will be saved.")
 	reprocess              = flag.String("reprocess", "", "Sha256 of sources that should be reprocessed")
+	backfill               = flag.Bool("backfill", false, "If true, sources previously saved to export_path that were already exported are exported with exporters that did not succeed for them yet. Samples are taken from the local cache and their content from export_path, other sources can't be backfilled.")
 	spannerDBPath          = flag.String("spanner_db_path", "", "Path to spanner DB.")
 	uploadPayloads         = flag.Bool("upload_payloads", false, "If true the content of the files will be uploaded using defined exporters.")
 	gcpExporterWorkerCount = flag.Int("gcp_exporter_worker_count", 100, "Number of workers/goroutines that will be used to upload data to Cloud Spanner.")
 	gcpExporterGCSbucket   = flag.String("gcp_exporter_gcs_bucket", "", "Name of the GCS bucket which will be used by GCP exporter to store exported samples.")
+	payloadStoreType       = flag.String("payload_store", "", "Storage used by all exporters to store payloads when upload_payloads is set: local, gcs, s3. If empty, each exporter uses its default storage.")
+	payloadStorePath       = flag.String("payload_store_path", "/tmp/hashr-payloads", "Path to directory used by local payload store.")
+	payloadStoreBucket     = flag.String("payload_store_bucket", "", "Name of the GCS or S3 bucket used by payload store.")
+	payloadStoreS3Endpoint = flag.String("payload_store_s3_endpoint", "", "Custom endpoint of S3 compatible service (e.g. MinIO), uses path style addressing.")
 
 	// Postgres DB flags
 	postgresHost     = flag.String("postgres_host", "localhost", "PostgreSQL instance address.")
@@ -70,6 +101,22 @@ var (
 	postgresUser     = flag.String("postgres_user", "hashr", "PostgresSQL user.")
 	postgresPassword = flag.String("postgres_password", "hashr", "PostgresSQL password.")
 	postgresDBName   = flag.String("postgres_db", "hashr", "PostgresSQL database.")
+	// File exporter flags
+	fileExporterPath       = flag.String("file_exporter_path", "/tmp/hashr-export", "Path to directory where the file exporter will write its output.")
+	fileExporterFormat     = flag.String("file_exporter_format", fileExporter.FormatJSONL, "Output format of the file exporter: jsonl, csv")
+	fileExporterGzip       = flag.Bool("file_exporter_gzip", false, "If true the file exporter will gzip its output files.")
+	fileExporterMaxRecords = flag.Int("file_exporter_max_records", 1000000, "Number of records after which the file exporter starts a new output file, 0 disables rotation.")
+	// Parquet exporter flags
+	parquetExporterPath        = flag.String("parquet_exporter_path", "/tmp/hashr-parquet", "Path to directory where the Parquet exporter will write partitioned datasets.")
+	parquetExporterMaxFileSize = flag.Int64("parquet_exporter_max_file_size", 256*1024*1024, "Approximate size in bytes after which the Parquet exporter starts a new file.")
+	// OpenSearch exporter flags
+	opensearchURL       = flag.String("opensearch_url", "http://localhost:9200", "OpenSearch (or Elasticsearch) instance address.")
+	opensearchIndex     = flag.String("opensearch_index", opensearchExporter.DefaultIndex, "Name of the OpenSearch index, {repo} is replaced with the repository name.")
+	opensearchUser      = flag.String("opensearch_user", "", "OpenSearch user.")
+	opensearchPassword  = flag.String("opensearch_password", "", "OpenSearch password.")
+	opensearchBatchSize = flag.Int("opensearch_batch_size", 500, "Number of documents sent in a single OpenSearch bulk request.")
+	// NSRL exporter flags
+	nsrlDBPath = flag.String("nsrl_db_path", "/tmp/hashr-nsrl.db", "Path to NSRL RDSv3 SQLite database that will be created or updated by the NSRL exporter.")
 	// WSUS importer flags
 	wsusGCSbucket = flag.String("wsus_repo_gcs_bucket", "", "Name of the GCS bucket containing WSUS packages")
 	// GCP importer flags
@@ -82,8 +129,21 @@ var (
 	tarGzRepoPath = flag.String("targz_repo_path", "", "Path to TarGz repository.")
 	// deb importer flags
 	debRepoPath = flag.String("deb_repo_path", "", "Path to Deb repository.")
+	// apt importer flags
+	aptRepoPath = flag.String("apt_repo_path", "", "Path or HTTP(S) URL of APT repository, the directory holding dists/ and pool/.")
+	aptSuites   = flag.String("apt_suites", "", "Comma-separated list of APT suites (directories in dists/) to import. Required for HTTP repositories, all suites of local mirrors are imported by default.")
+	aptKeyring  = flag.String("apt_keyring", "", "Optional path to OpenPGP keyring (armored or binary) used to verify APT Release files.")
 	// rpm importer flags
 	rpmRepoPath = flag.String("rpm_repo_path", "", "Path to RPM repository.")
+	rpmKeyring  = flag.String("rpm_keyring", "", "Optional path to OpenPGP keyring (armored or binary) used to verify signatures of RPM packages.")
+	// yum importer flags
+	yumRepoPath = flag.String("yum_repo_path", "", "Path or HTTP(S) URL of YUM/DNF repository, the directory holding repodata/.")
+	yumArchs    = flag.String("yum_archs", "", "Optional comma-separated list of architectures of packages to import from YUM repository, e.g. x86_64,noarch.")
+	yumNames    = flag.String("yum_names", "", "Optional comma-separated list of glob patterns of names of packages to import from YUM repository, e.g. kernel*.")
+	// apk importer flags
+	apkRepoPath = flag.String("apk_repo_path", "", "Path or HTTP(S) URL of Alpine repository holding APKINDEX.tar.gz. Local directories without it are searched for .apk files.")
+	// pacman importer flags
+	pacmanRepoPath = flag.String("pacman_repo_path", "", "Path to pacman repository.")
 	// zip importer flags
 	zipRepoPath       = flag.String("zip_repo_path", "", "Path to Zip repository.")
 	zipFileExtensions = flag.String("zip_file_exts", "zip", "Comma-separated list of files to treat as Zip files")
@@ -102,6 +162,22 @@ var (
 func main() {
 	ctx := context.Background()
 	flag.Parse()
+
+	// Replay command needs exporters and job storage, it's run once they are initialized.
+	if flag.NArg() > 0 && flag.Arg(0) != "replay" {
+		var err error
+		switch flag.Arg(0) {
+		case "cache":
+			err = runCacheCommand(ctx, flag.Args()[1:])
+		default:
+			err = fmt.Errorf("unknown command: %s", flag.Arg(0))
+		}
+		if err != nil {
+			glog.Exit(err)
+		}
+		return
+	}
+
 	var importers []hashr.Importer
 
 	if !(*jobStorage == "postgres" || *jobStorage == "cloudspanner") {
@@ -155,8 +231,30 @@ func main() {
 			importers = append(importers, iso9660.NewRepo(*isoRepoPath))
 		case deb.RepoName:
 			importers = append(importers, deb.NewRepo(*debRepoPath))
+		case apt.RepoName:
+			var keyring openpgp.EntityList
+			if *aptKeyring != "" {
+				var err error
+				if keyring, err = importerCommon.ReadKeyring(*aptKeyring); err != nil {
+					glog.Exit(err)
+				}
+			}
+			importers = append(importers, apt.NewRepo(*aptRepoPath, *aptSuites, keyring))
 		case rpm.RepoName:
-			importers = append(importers, rpm.NewRepo(*rpmRepoPath))
+			var keyring openpgp.EntityList
+			if *rpmKeyring != "" {
+				var err error
+				if keyring, err = importerCommon.ReadKeyring(*rpmKeyring); err != nil {
+					glog.Exit(err)
+				}
+			}
+			importers = append(importers, rpm.NewRepo(*rpmRepoPath, keyring))
+		case yum.RepoName:
+			importers = append(importers, yum.NewRepo(*yumRepoPath, *yumArchs, *yumNames))
+		case apk.RepoName:
+			importers = append(importers, apk.NewRepo(*apkRepoPath))
+		case pacman.RepoName:
+			importers = append(importers, pacman.NewRepo(*pacmanRepoPath))
 		case zip.RepoName:
 			importers = append(importers, zip.NewRepo(*zipRepoPath, *zipFileExtensions))
 		case gcr.RepoName:
@@ -191,6 +289,15 @@ func main() {
 		}
 	}
 
+	var payloadStore payloads.PayloadStore
+	if *uploadPayloads && *payloadStoreType != "" {
+		var err error
+		payloadStore, err = newPayloadStore(ctx, *payloadStoreType)
+		if err != nil {
+			glog.Exitf("Error initializing payload store: %v", err)
+		}
+	}
+
 	var exporters []hashr.Exporter
 	// Initialize exporters.
 	for _, exporterName := range strings.Split(*exportersToRun, ",") {
@@ -205,7 +312,7 @@ func main() {
 			}
 			defer db.Close()
 
-			postgresExporter, err := postgresExporter.NewExporter(db, *uploadPayloads)
+			postgresExporter, err := postgresExporter.NewExporter(db, *uploadPayloads, payloadStore)
 			if err != nil {
 				glog.Exitf("Error initializing Postgres exporter: %v", err)
 			}
@@ -216,16 +323,66 @@ func main() {
 				glog.Exitf("Error initializing Spanner client: %v", err)
 			}
 
-			storageClient, err := storage.NewService(ctx)
-			if err != nil {
-				glog.Exitf("Could not initialize GCP Storage client: %v", err)
+			gcpPayloadStore := payloadStore
+			if *uploadPayloads && gcpPayloadStore == nil {
+				storageClient, err := storage.NewService(ctx)
+				if err != nil {
+					glog.Exitf("Could not initialize GCP Storage client: %v", err)
+				}
+
+				gcpPayloadStore, err = payloads.NewGCSStore(storageClient, *gcpExporterGCSbucket)
+				if err != nil {
+					glog.Exitf("Error initializing GCS payload store: %v", err)
+				}
 			}
 
-			gceExporter, err := gcpExporter.NewExporter(spannerClient, storageClient, *gcpExporterGCSbucket, *uploadPayloads, *gcpExporterWorkerCount)
+			gceExporter, err := gcpExporter.NewExporter(spannerClient, gcpPayloadStore, *uploadPayloads, *gcpExporterWorkerCount)
 			if err != nil {
 				glog.Exitf("Error initializing Postgres exporter: %v", err)
 			}
 			exporters = append(exporters, gceExporter)
+		case nsrlExporter.Name:
+			db, err := sql.Open("sqlite", *nsrlDBPath)
+			if err != nil {
+				glog.Exitf("Error initializing SQLite client: %v", err)
+			}
+			defer db.Close()
+			// SQLite allows only a single writer, processing workers need to take turns.
+			db.SetMaxOpenConns(1)
+
+			nsrlExporter, err := nsrlExporter.NewExporter(db)
+			if err != nil {
+				glog.Exitf("Error initializing NSRL exporter: %v", err)
+			}
+			exporters = append(exporters, nsrlExporter)
+		case fileExporter.Name:
+			filePayloadStore := payloadStore
+			if *uploadPayloads && filePayloadStore == nil {
+				var err error
+				filePayloadStore, err = payloads.NewLocalStore(filepath.Join(*fileExporterPath, "payloads"))
+				if err != nil {
+					glog.Exitf("Error initializing local payload store: %v", err)
+				}
+			}
+
+			fileExporter, err := fileExporter.NewExporter(*fileExporterPath, *fileExporterFormat, *fileExporterGzip, *fileExporterMaxRecords, filePayloadStore)
+			if err != nil {
+				glog.Exitf("Error initializing file exporter: %v", err)
+			}
+			defer fileExporter.Close()
+			exporters = append(exporters, fileExporter)
+		case parquetExporter.Name:
+			parquetExporter, err := parquetExporter.NewExporter(*parquetExporterPath, *parquetExporterMaxFileSize)
+			if err != nil {
+				glog.Exitf("Error initializing Parquet exporter: %v", err)
+			}
+			exporters = append(exporters, parquetExporter)
+		case opensearchExporter.Name:
+			opensearchExporter, err := opensearchExporter.NewExporter(&http.Client{Timeout: 5 * time.Minute}, *opensearchURL, *opensearchIndex, *opensearchUser, *opensearchPassword, *opensearchBatchSize)
+			if err != nil {
+				glog.Exitf("Error initializing OpenSearch exporter: %v", err)
+			}
+			exporters = append(exporters, opensearchExporter)
 		}
 	}
 
@@ -233,6 +390,19 @@ func main() {
 		glog.Exit("You need to specify at least one exporter.")
 	}
 
+	var analyzers []hashr.Analyzer
+	// Initialize analyzers.
+	for _, analyzerName := range strings.Split(*analyzersToRun, ",") {
+		switch analyzerName {
+		case executable.Name:
+			analyzers = append(analyzers, executable.New())
+		case authenticode.Name:
+			analyzers = append(analyzers, authenticode.New())
+		case packages.Name:
+			analyzers = append(analyzers, packages.New())
+		}
+	}
+
 	// Initialize job storage.
 	var s hashr.Storage
 	switch *jobStorage {
@@ -266,13 +436,256 @@ func main() {
 
 	hdb := hashr.New(importers, local.New(), exporters, s)
 
+	hdb.Analyzers = analyzers
+	if *detectOS {
+		hdb.OSDetector = osinfo.New()
+	}
 	hdb.ProcessingWorkerCount = *processingWorkerCount
 	hdb.CacheDir = *cacheDir
 	hdb.Export = *export
 	hdb.ExportPath = *exportPath
 	hdb.SourcesForReprocessing = strings.Split(*reprocess, ",")
+	hdb.Backfill = *backfill
+
+	if flag.Arg(0) == "replay" {
+		if err := runReplayCommand(ctx, hdb, flag.Args()[1:]); err != nil {
+			glog.Exit(err)
+		}
+		return
+	}
 
 	if err := hdb.Run(ctx); err != nil {
 		glog.Exit(err)
 	}
 }
+
+// runReplayCommand exports samples saved by a run with -export=false using the configured
+// exporters:
+//
+//	hashr [flags] replay -from=<export_path>
+func runReplayCommand(ctx context.Context, hdb *hashr.HashR, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	from := fs.String("from", *exportPath, "Path to the folder holding samples saved with -export=false.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if len(hdb.Exporters) == 0 {
+		return errors.New("replay requires at least one exporter")
+	}
+
+	return hdb.Replay(ctx, *from)
+}
+
+// runCacheCommand executes one of the cache maintenance commands:
+//
+//	hashr [flags] cache rebuild|verify -repo=<repo> -from=postgres|spanner
+//	hashr [flags] cache query -repo=<repo> -hash=<sha256>|-path=<pattern>
+//	hashr [flags] cache stats -repo=<repo>
+//	hashr [flags] cache merge -repo=<repo> -from_repo=<repo> -from_dir=<cache_dir>
+//	hashr [flags] cache prune -repo=<repo> -before=<YYYY-MM-DD>
+//	hashr [flags] cache export -repo=<repo> -output=<path>
+func runCacheCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("cache command requires one of the following subcommands: rebuild, verify, query, stats, merge, prune, export")
+	}
+
+	fs := flag.NewFlagSet(fmt.Sprintf("cache %s", args[0]), flag.ExitOnError)
+	repoName := fs.String("repo", "", "Name of the repository (importer) which cache should be used.")
+	var from, hash, samplePath, fromRepo, fromDir, before, output *string
+	switch args[0] {
+	case "rebuild", "verify":
+		from = fs.String("from", "", "Exporter database that holds the samples: postgres, spanner")
+	case "query":
+		hash = fs.String("hash", "", "SHA256 of the sample to look up.")
+		samplePath = fs.String("path", "", "Path (relative to the extraction root, path.Match syntax) of the samples to look up.")
+	case "merge":
+		fromRepo = fs.String("from_repo", "", "Name of the repository which cache should be merged, defaults to -repo.")
+		fromDir = fs.String("from_dir", *cacheDir, "Path to the cache dir holding the cache that should be merged.")
+	case "prune":
+		before = fs.String("before", "", "Samples last updated before this date (YYYY-MM-DD) will be removed.")
+	case "export":
+		output = fs.String("output", "", "Path to the output JSONL file, defaults to stdout.")
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *repoName == "" {
+		return errors.New("repo flag needs to be set")
+	}
+
+	switch args[0] {
+	case "rebuild":
+		reader, err := newCacheReader(ctx, *from)
+		if err != nil {
+			return err
+		}
+		c, err := cache.Rebuild(ctx, reader, *repoName)
+		if err != nil {
+			return err
+		}
+		return cache.Save(*repoName, *cacheDir, c)
+	case "verify":
+		reader, err := newCacheReader(ctx, *from)
+		if err != nil {
+			return err
+		}
+		c, err := cache.Load(*repoName, *cacheDir)
+		if err != nil {
+			return err
+		}
+		report, err := cache.Verify(ctx, reader, *repoName, c)
+		if err != nil {
+			return err
+		}
+		for _, m := range report.MissingFromExporter {
+			fmt.Printf("missing from %s: sample %s, source %s\n", *from, m.SampleSHA256, m.SourceSHA256)
+		}
+		for _, m := range report.MissingFromCache {
+			fmt.Printf("missing from cache: sample %s, source %s\n", m.SampleSHA256, m.SourceSHA256)
+		}
+		fmt.Printf("%d entries missing from %s, %d entries missing from cache\n", len(report.MissingFromExporter), *from, len(report.MissingFromCache))
+	case "query":
+		if (*hash == "") == (*samplePath == "") {
+			return errors.New("exactly one of hash or path flags needs to be set")
+		}
+		c, err := cache.Load(*repoName, *cacheDir)
+		if err != nil {
+			return err
+		}
+		if *samplePath != "" {
+			matches, err := cache.LookupPath(c, *samplePath)
+			if err != nil {
+				return err
+			}
+			for _, m := range matches {
+				fmt.Printf("%s %s source_id: %s, source_hash: %s\n", m.Sha256, m.Path, m.SourceID, m.SourceHash)
+			}
+			return nil
+		}
+		entries, ok := cache.Lookup(c, *hash)
+		if !ok {
+			return fmt.Errorf("%s not found in %s repo cache", *hash, *repoName)
+		}
+		fmt.Printf("%s (last updated: %s)\n", *hash, entries.GetLastUpdated().AsTime().UTC().Format(time.RFC3339))
+		for _, entry := range entries.GetEntries() {
+			fmt.Printf("  source_id: %s, source_hash: %s\n", entry.GetSourceId(), entry.GetSourceHash())
+			for _, p := range entry.GetPath() {
+				fmt.Printf("    %s\n", p)
+			}
+		}
+	case "stats":
+		c, err := cache.Load(*repoName, *cacheDir)
+		if err != nil {
+			return err
+		}
+		stats := cache.ComputeStats(c)
+		fmt.Printf("samples: %d\nentries: %d\nsources: %d\n", stats.Samples, stats.Entries, len(stats.Sources))
+		fmt.Printf("largest fan-out: %d (%s)\n", stats.MaxFanOut, stats.MaxFanOutSample)
+		for _, source := range stats.Sources {
+			fmt.Printf("  %s (%s): %d\n", source.SourceID, source.SourceHash, source.Samples)
+		}
+	case "merge":
+		if *fromRepo == "" {
+			*fromRepo = *repoName
+		}
+		if *fromRepo == *repoName && *fromDir == *cacheDir {
+			return errors.New("cannot merge a cache with itself, set from_repo or from_dir")
+		}
+		dst, err := cache.Load(*repoName, *cacheDir)
+		if err != nil {
+			return err
+		}
+		src, err := cache.Load(*fromRepo, *fromDir)
+		if err != nil {
+			return err
+		}
+		added := cache.Merge(dst, src)
+		glog.Infof("Merged %s repo cache from %s, %d new samples added.", *fromRepo, *fromDir, added)
+		return cache.Save(*repoName, *cacheDir, dst)
+	case "prune":
+		date, err := time.Parse("2006-01-02", *before)
+		if err != nil {
+			return fmt.Errorf("before flag needs to be a date in YYYY-MM-DD format: %v", err)
+		}
+		c, err := cache.Load(*repoName, *cacheDir)
+		if err != nil {
+			return err
+		}
+		pruned := cache.Prune(c, date)
+		glog.Infof("Pruned %d samples last updated before %s from %s repo cache.", pruned, *before, *repoName)
+		return cache.Save(*repoName, *cacheDir, c)
+	case "export":
+		c, err := cache.Load(*repoName, *cacheDir)
+		if err != nil {
+			return err
+		}
+		out := os.Stdout
+		if *output != "" {
+			out, err = os.Create(*output)
+			if err != nil {
+				return fmt.Errorf("error while creating %s: %v", *output, err)
+			}
+			defer out.Close()
+		}
+		return cache.WriteJSONL(out, c)
+	default:
+		return fmt.Errorf("unknown cache subcommand: %s", args[0])
+	}
+
+	return nil
+}
+
+// newCacheReader returns exporter database reader used to seed and verify the local cache.
+func newCacheReader(ctx context.Context, from string) (cache.Reader, error) {
+	switch from {
+	case "postgres":
+		psqlInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+			*postgresHost, *postgresPort, *postgresUser, *postgresPassword, *postgresDBName)
+
+		db, err := sql.Open("postgres", psqlInfo)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing Postgres client: %v", err)
+		}
+
+		return postgresClient.NewStorage(db)
+	case "spanner":
+		spannerClient, err := spanner.NewClient(ctx, *spannerDBPath)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing Spanner client: %v", err)
+		}
+
+		return cloudspannerClient.NewStorage(ctx, spannerClient)
+	default:
+		return nil, errors.New("from flag needs to have one of the two values: postgres, spanner")
+	}
+}
+
+// newPayloadStore returns payload store shared by all exporters.
+func newPayloadStore(ctx context.Context, storeType string) (payloads.PayloadStore, error) {
+	switch storeType {
+	case "local":
+		return payloads.NewLocalStore(*payloadStorePath)
+	case "gcs":
+		storageClient, err := storage.NewService(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not initialize GCP Storage client: %v", err)
+		}
+		return payloads.NewGCSStore(storageClient, *payloadStoreBucket)
+	case "s3":
+		awsConfig, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not load AWS config: %v", err)
+		}
+		s3Client := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+			if *payloadStoreS3Endpoint != "" {
+				o.BaseEndpoint = aws.String(*payloadStoreS3Endpoint)
+				o.UsePathStyle = true
+			}
+		})
+		return payloads.NewS3Store(s3Client, *payloadStoreBucket)
+	default:
+		return nil, errors.New("payload_store flag needs to have one of the three values: local, gcs, s3")
+	}
+}
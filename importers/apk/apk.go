@@ -0,0 +1,539 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package apk implements Alpine .apk package importer, which imports packages found in a local
+// directory or listed in APKINDEX.tar.gz of a local mirror or of a repository served over HTTP.
+package apk
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+
+	hashrcommon "github.com/google/hashr/common"
+	"github.com/google/hashr/core/hashr"
+	"github.com/google/hashr/importers/common"
+)
+
+const (
+	// RepoName contains the repository name.
+	RepoName  = "apk"
+	chunkSize = 1024 * 1024 * 10 // 10MB
+	// packageManager is the manager recorded in package metadata.
+	packageManager = "apk"
+	// indexName is the name of the repository index.
+	indexName = "APKINDEX.tar.gz"
+	// checksumRecord is the PAX record holding hex encoded SHA1 of a file in the data archive.
+	checksumRecord = "APK-TOOLS.checksum.SHA1"
+	// checksumPrefix marks base64 encoded SHA1 in APKINDEX checksums.
+	checksumPrefix = "Q1"
+)
+
+// Archive holds data related to a .apk package.
+type Archive struct {
+	filename        string
+	remotePath      string
+	localPath       string
+	quickSha256hash string
+	// checksum is the checksum of the control segment recorded in APKINDEX, empty for packages
+	// that were found by traversing the repository.
+	checksum    string
+	repoPath    string
+	description string
+	client      *http.Client
+	// pkg holds .PKGINFO metadata, it's set during preprocessing.
+	pkg *hashrcommon.PackageInfo
+}
+
+// Preprocess copies a .apk package to the local file system and extracts its contents. Packages
+// listed in APKINDEX are checked against the checksum of their control segment.
+func (a *Archive) Preprocess() (string, error) {
+	tempDir, err := common.LocalTempDir(a.ID())
+	if err != nil {
+		return "", fmt.Errorf("error while creating temp directory: %v", err)
+	}
+	a.localPath = filepath.Join(tempDir, a.filename)
+
+	glog.Infof("Copying %s to %s", a.remotePath, a.localPath)
+	if err := common.Download(a.client, a.remotePath, a.localPath); err != nil {
+		return "", fmt.Errorf("error while copying %s to local file system: %v", a.remotePath, err)
+	}
+
+	extractionDir := filepath.Join(tempDir, "extracted")
+	pkg, description, checksum, err := extract(a.localPath, extractionDir)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(a.checksum, checksumPrefix) && checksum != a.checksum {
+		return "", fmt.Errorf("checksum of %s is %s, APKINDEX lists %s", a.remotePath, checksum, a.checksum)
+	}
+	a.pkg, a.description = pkg, description
+
+	return extractionDir, nil
+}
+
+// ID returns non-unique apk Archive ID.
+func (a *Archive) ID() string {
+	return a.filename
+}
+
+// RepoName returns repository name.
+func (a *Archive) RepoName() string {
+	return RepoName
+}
+
+// RepoPath returns repository path.
+func (a *Archive) RepoPath() string {
+	return a.repoPath
+}
+
+// LocalPath returns local path to an apk Archive .apk file.
+func (a *Archive) LocalPath() string {
+	return a.localPath
+}
+
+// RemotePath returns path or URL of an apk Archive .apk file in the repository.
+func (a *Archive) RemotePath() string {
+	return a.remotePath
+}
+
+// Description provides additional description for a .apk file: name, version, architecture and
+// origin of the package followed by its description. Packages found by traversing the repository
+// don't have it before they're preprocessed.
+func (a *Archive) Description() string {
+	return a.description
+}
+
+// Package returns metadata from the .PKGINFO file of a .apk file, nil before it's preprocessed.
+func (a *Archive) Package() *hashrcommon.PackageInfo {
+	return a.pkg
+}
+
+// QuickSHA256Hash returns SHA256 of the control segment checksum recorded in APKINDEX, so the file
+// doesn't need to be read. For packages found by traversing the repository it calculates sha256
+// hash of .apk file.
+func (a *Archive) QuickSHA256Hash() (string, error) {
+	// Check if the quick hash was already calculated.
+	if a.quickSha256hash != "" {
+		return a.quickSha256hash, nil
+	}
+
+	if a.checksum != "" {
+		a.quickSha256hash = fmt.Sprintf("%x", sha256.Sum256([]byte(a.checksum)))
+		return a.quickSha256hash, nil
+	}
+
+	f, err := os.Open(a.remotePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	// Check if the file is smaller than 20MB, if so hash the whole file.
+	if fileInfo.Size() < int64(chunkSize*2) {
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+		a.quickSha256hash = fmt.Sprintf("%x", h.Sum(nil))
+		return a.quickSha256hash, nil
+	}
+
+	header := make([]byte, chunkSize)
+	_, err = f.Read(header)
+	if err != nil {
+		return "", err
+	}
+
+	footer := make([]byte, chunkSize)
+	_, err = f.ReadAt(footer, fileInfo.Size()-int64(chunkSize))
+	if err != nil {
+		return "", err
+	}
+
+	a.quickSha256hash = fmt.Sprintf("%x", sha256.Sum256(append(header, footer...)))
+	return a.quickSha256hash, nil
+}
+
+// segmentReader passes through a .apk file and hashes the bytes of the current gzip segment. It
+// implements io.ByteReader, so gzip doesn't read past the end of a segment.
+type segmentReader struct {
+	r *bufio.Reader
+	h hash.Hash
+}
+
+func (s *segmentReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.h.Write(p[:n])
+	return n, err
+}
+
+func (s *segmentReader) ReadByte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err == nil {
+		s.h.Write([]byte{b})
+	}
+	return b, err
+}
+
+// extract extracts data of a .apk file and returns its .PKGINFO metadata, description and the
+// checksum of the control segment in the format used by APKINDEX. A .apk file is a concatenation
+// of gzip compressed tar segments: optional signature, control holding .PKGINFO and data.
+// Extracted files are checked against SHA1 digests recorded in PAX headers of the data segment.
+func extract(apkPath, outputFolder string) (*hashrcommon.PackageInfo, string, string, error) {
+	if _, err := os.Stat(outputFolder); os.IsNotExist(err) {
+		if err2 := os.MkdirAll(outputFolder, 0755); err2 != nil {
+			return nil, "", "", fmt.Errorf("error while creating target directory: %v", err2)
+		}
+	}
+
+	fd, err := os.Open(apkPath)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to open apk file: %v", err)
+	}
+	defer fd.Close()
+
+	sr := &segmentReader{r: bufio.NewReader(fd), h: sha1.New()}
+	zr, err := gzip.NewReader(sr)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to parse apk file: %v", err)
+	}
+	defer zr.Close()
+
+	var pkg *hashrcommon.PackageInfo
+	var description, checksum string
+	metadata := make(map[string]*hashrcommon.FileMetadata)
+	digests := make(map[string]string)
+	for {
+		zr.Multistream(false)
+		control, err := extractSegment(tar.NewReader(zr), outputFolder, metadata, digests)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("error while unpacking apk package: %v", err)
+		}
+		// Read the rest of the segment, e.g. padding of the tar archive, to reach its end.
+		if _, err := io.Copy(ioutil.Discard, zr); err != nil {
+			return nil, "", "", fmt.Errorf("error while unpacking apk package: %v", err)
+		}
+		if control != nil {
+			pkg, description = packageInfo(control)
+			checksum = checksumPrefix + base64.StdEncoding.EncodeToString(sr.h.Sum(nil))
+		}
+
+		sr.h = sha1.New()
+		if err := zr.Reset(sr); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, "", "", fmt.Errorf("error while unpacking apk package: %v", err)
+		}
+	}
+	if pkg == nil {
+		return nil, "", "", errors.New("apk file doesn't contain .PKGINFO")
+	}
+
+	if err := hashrcommon.WriteMetadata(common.MetadataPath(outputFolder), metadata); err != nil {
+		return nil, "", "", fmt.Errorf("error while saving file metadata: %v", err)
+	}
+
+	pkg.VerifiedFiles, pkg.MismatchedFiles, err = common.VerifyDigests(outputFolder, crypto.SHA1, digests)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("error while verifying SHA1 checksums: %v", err)
+	}
+	if len(pkg.MismatchedFiles) > 0 {
+		glog.Warningf("%d files extracted from %s don't match their checksums: %s", len(pkg.MismatchedFiles), apkPath, strings.Join(pkg.MismatchedFiles, ", "))
+	}
+
+	return pkg, description, checksum, nil
+}
+
+// extractSegment extracts files of a data segment and records their metadata and checksums. For
+// the control segment it returns fields of .PKGINFO and extracts nothing, signature segments are
+// skipped.
+func extractSegment(tarReader *tar.Reader, outputFolder string, metadata map[string]*hashrcommon.FileMetadata, digests map[string]string) (map[string]string, error) {
+	for first := true; ; first = false {
+		header, err := tarReader.Next()
+		// Signature and control segments don't have the end-of-archive marker.
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := common.TarEntryPath(header.Name)
+		if first && strings.HasPrefix(name, ".SIGN.") {
+			return nil, nil
+		}
+		if first && name == ".PKGINFO" {
+			return readPkgInfo(tarReader)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeSymlink:
+			metadata[name] = common.TarHeaderMetadata(header)
+
+		case tar.TypeDir:
+			continue
+
+		case tar.TypeLink:
+			linkPath := filepath.Join(outputFolder, common.TarEntryPath(header.Linkname))
+			unpackPath := filepath.Join(outputFolder, name)
+			if err := os.MkdirAll(filepath.Dir(unpackPath), 0755); err != nil {
+				return nil, fmt.Errorf("error while creating target directory: %v", err)
+			}
+			if err := os.Link(linkPath, unpackPath); err != nil {
+				return nil, fmt.Errorf("error while creating hard link: %v", err)
+			}
+			metadata[name] = common.TarHeaderMetadata(header)
+			if checksum, ok := header.PAXRecords[checksumRecord]; ok {
+				digests[name] = checksum
+			}
+
+		case tar.TypeReg, tar.TypeRegA:
+			unpackPath := filepath.Join(outputFolder, name)
+			if err := os.MkdirAll(filepath.Dir(unpackPath), 0755); err != nil {
+				return nil, fmt.Errorf("error while creating target directory: %v", err)
+			}
+
+			unpackFileHandle, err := os.Create(unpackPath)
+			if err != nil {
+				return nil, fmt.Errorf("error while creating destination file: %v", err)
+			}
+			_, err = io.Copy(unpackFileHandle, tarReader)
+			unpackFileHandle.Close()
+			if err != nil {
+				return nil, fmt.Errorf("error while writing to destination file: %v", err)
+			}
+			metadata[name] = common.TarHeaderMetadata(header)
+			if checksum, ok := header.PAXRecords[checksumRecord]; ok {
+				digests[name] = checksum
+			}
+
+		default:
+			glog.Warningf("Unknown tar entry type: %c in file %s", header.Typeflag, name)
+		}
+	}
+}
+
+// readPkgInfo returns fields of .PKGINFO, lines holding a key and a value separated by " = ".
+// Fields that can be repeated, e.g. depend, keep the last value.
+func readPkgInfo(r io.Reader) (map[string]string, error) {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		fields[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return fields, scanner.Err()
+}
+
+// packageInfo returns package metadata and description from fields of .PKGINFO.
+func packageInfo(fields map[string]string) (*hashrcommon.PackageInfo, string) {
+	pkg := &hashrcommon.PackageInfo{
+		Manager:      packageManager,
+		Package:      fields["pkgname"],
+		Version:      fields["pkgver"],
+		Architecture: fields["arch"],
+		Maintainer:   fields["maintainer"],
+		Source:       fields["origin"],
+		Homepage:     fields["url"],
+		Packager:     fields["packager"],
+	}
+	if buildTime, err := strconv.ParseInt(fields["builddate"], 10, 64); err == nil {
+		pkg.BuildTime = buildTime
+	}
+
+	return pkg, describe(pkg.Package, pkg.Version, pkg.Architecture, pkg.Source, fields["pkgdesc"])
+}
+
+// describe returns description of a package, e.g. "busybox-1.36.1-r15.x86_64 (origin busybox):
+// Size optimized toolbox of many common UNIX utilities".
+func describe(name, version, arch, origin, description string) string {
+	return fmt.Sprintf("%s-%s.%s (origin %s): %s", name, version, arch, origin, strings.TrimSpace(description))
+}
+
+// NewRepo returns new instance of apk repository. Location is either a local path or HTTP(S) URL
+// of the directory holding APKINDEX.tar.gz. Local directories without APKINDEX.tar.gz are
+// traversed for .apk files.
+func NewRepo(location string) *Repo {
+	return &Repo{location: location, client: http.DefaultClient}
+}
+
+// Repo holds data related to an apk repository.
+type Repo struct {
+	location string
+	files    []string
+	client   *http.Client
+	Archives []*Archive
+}
+
+// RepoName returns repository name.
+func (r *Repo) RepoName() string {
+	return RepoName
+}
+
+// RepoPath returns repository path.
+func (r *Repo) RepoPath() string {
+	return r.location
+}
+
+// DiscoverRepo reads packages listed in APKINDEX.tar.gz or, if the repository doesn't have it,
+// traverses the repository and looks for .apk files.
+func (r *Repo) DiscoverRepo() ([]hashr.Source, error) {
+	r.Archives = nil
+	err := r.readIndex()
+	if os.IsNotExist(err) && !common.IsURL(r.location) {
+		glog.Infof("%s not found in %s, looking for .apk files", indexName, r.location)
+		r.files = nil
+		if err := filepath.Walk(r.location, walk(&r.files)); err != nil {
+			return nil, err
+		}
+		for _, file := range r.files {
+			r.Archives = append(r.Archives, &Archive{filename: filepath.Base(file), remotePath: file, repoPath: r.location, client: r.client})
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error while reading %s: %v", indexName, err)
+	}
+
+	var sources []hashr.Source
+	for _, archive := range r.Archives {
+		sources = append(sources, archive)
+	}
+
+	return sources, nil
+}
+
+// readIndex adds packages listed in APKINDEX.tar.gz to the repository. The index is a signature
+// segment followed by a tar archive holding APKINDEX, blocks of single letter fields separated by
+// empty lines.
+func (r *Repo) readIndex() error {
+	rc, err := common.Open(r.client, r.join(indexName))
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	zr, err := gzip.NewReader(rc)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	tarReader := tar.NewReader(zr)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return errors.New("index doesn't contain APKINDEX")
+		}
+		if err != nil {
+			return err
+		}
+		if common.TarEntryPath(header.Name) != "APKINDEX" {
+			continue
+		}
+
+		fields := make(map[string]string)
+		scanner := bufio.NewScanner(tarReader)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" {
+				r.addPackage(fields)
+				fields = make(map[string]string)
+				continue
+			}
+			if len(line) > 2 && line[1] == ':' {
+				fields[line[:1]] = line[2:]
+			}
+		}
+		r.addPackage(fields)
+
+		return scanner.Err()
+	}
+}
+
+// addPackage adds a package described by an APKINDEX block to the repository.
+func (r *Repo) addPackage(fields map[string]string) {
+	if len(fields) == 0 {
+		return
+	}
+	if fields["P"] == "" || fields["V"] == "" || fields["C"] == "" {
+		glog.Warningf("Skipping APKINDEX entry without name, version or checksum: %v", fields)
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s.apk", fields["P"], fields["V"])
+	r.Archives = append(r.Archives, &Archive{
+		filename:    filename,
+		remotePath:  r.join(filename),
+		checksum:    fields["C"],
+		repoPath:    r.location,
+		description: describe(fields["P"], fields["V"], fields["A"], fields["o"], fields["T"]),
+		client:      r.client,
+	})
+}
+
+// join returns path or URL of a file relative to the repository root.
+func (r *Repo) join(name string) string {
+	if common.IsURL(r.location) {
+		return strings.TrimSuffix(r.location, "/") + "/" + name
+	}
+
+	return filepath.Join(r.location, filepath.FromSlash(name))
+}
+
+func walk(files *[]string) filepath.WalkFunc {
+	return func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			glog.Errorf("Could not open %s: %v", path, err)
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if strings.HasSuffix(info.Name(), ".apk") {
+			*files = append(*files, path)
+		}
+
+		return nil
+	}
+}
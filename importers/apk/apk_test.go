@@ -0,0 +1,292 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	hashrcommon "github.com/google/hashr/common"
+	"github.com/google/hashr/core/hashr"
+)
+
+var testPackages = []string{"busybox", "musl", "zlib"}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// segment returns a gzip compressed tar segment. Only the data segment is terminated with the
+// end-of-archive marker.
+func segment(t *testing.T, headers []*tar.Header, contents []string, terminate bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for i, header := range headers {
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(contents[i])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if terminate {
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	} else if err := tw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func reg(name, content string, checksum string) *tar.Header {
+	header := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0755, Size: int64(len(content)), Uname: "root", Gname: "root"}
+	if checksum != "" {
+		header.PAXRecords = map[string]string{checksumRecord: checksum}
+	}
+	return header
+}
+
+// testAPK returns a signed .apk package and the checksum of its control segment. The checksum of
+// usr/bin/tampered doesn't match its content.
+func testAPK(t *testing.T, name string) ([]byte, string) {
+	t.Helper()
+	sha1hex := func(s string) string { return fmt.Sprintf("%x", sha1.Sum([]byte(s))) }
+
+	signature := segment(t, []*tar.Header{reg(".SIGN.RSA.hashr.rsa.pub", "signature", "")}, []string{"signature"}, false)
+	pkginfo := fmt.Sprintf("# Generated by abuild\npkgname = %s\npkgver = 1.0-r0\npkgdesc = %s test package\nurl = https://example.com/%s\nbuilddate = 1700000000\npackager = Buildozer <alpine-devel@lists.alpinelinux.org>\narch = x86_64\norigin = %s-src\nmaintainer = hashr <hashr@example.com>\ndepend = so:libc.musl-x86_64.so.1\n", name, name, name, name)
+	control := segment(t, []*tar.Header{reg(".PKGINFO", pkginfo, ""), reg(".post-install", "#!/bin/sh", "")}, []string{pkginfo, "#!/bin/sh"}, false)
+	data := segment(t, []*tar.Header{
+		{Typeflag: tar.TypeDir, Name: "usr/bin/", Mode: 0755},
+		reg("usr/bin/"+name, name, sha1hex(name)),
+		reg("usr/bin/tampered", "tampered", sha1hex("original")),
+		reg("usr/share/unchecked", "unchecked", ""),
+		{Typeflag: tar.TypeLink, Name: "usr/bin/hardlink", Linkname: "usr/bin/" + name, PAXRecords: map[string]string{checksumRecord: sha1hex(name)}},
+		{Typeflag: tar.TypeSymlink, Name: "bin/sh", Linkname: "/usr/bin/" + name, Mode: 0777},
+	}, []string{"", name, "tampered", "unchecked", "", ""}, true)
+
+	sum := sha1.Sum(control)
+	return append(append(signature, control...), data...), checksumPrefix + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// testRepo creates a repository with .apk packages. If index is true, APKINDEX.tar.gz listing the
+// packages is added as well.
+func testRepo(t *testing.T, index bool) (string, map[string]string) {
+	t.Helper()
+	root := t.TempDir()
+
+	checksums := make(map[string]string)
+	var apkindex bytes.Buffer
+	for _, name := range testPackages {
+		data, checksum := testAPK(t, name)
+		writeFile(t, filepath.Join(root, name+"-1.0-r0.apk"), data)
+		checksums[name] = checksum
+		fmt.Fprintf(&apkindex, "C:%s\nP:%s\nV:1.0-r0\nA:x86_64\nS:%d\nT:%s test package\no:%s-src\n\n", checksum, name, len(data), name, name)
+	}
+
+	if index {
+		signature := segment(t, []*tar.Header{reg(".SIGN.RSA.hashr.rsa.pub", "signature", "")}, []string{"signature"}, false)
+		contents := []string{"v3.19.0", apkindex.String()}
+		indexData := segment(t, []*tar.Header{reg("DESCRIPTION", contents[0], ""), reg("APKINDEX", contents[1], "")}, contents, true)
+		writeFile(t, filepath.Join(root, indexName), append(signature, indexData...))
+	}
+
+	return root, checksums
+}
+
+func toArchives(t *testing.T, sources []hashr.Source) []*Archive {
+	t.Helper()
+	var archives []*Archive
+	for _, source := range sources {
+		archive, ok := source.(*Archive)
+		if !ok {
+			t.Fatalf("unexpected source type %T", source)
+		}
+		archives = append(archives, archive)
+	}
+
+	return archives
+}
+
+func TestDiscover(t *testing.T) {
+	indexRepo, checksums := testRepo(t, true)
+	walkRepo, _ := testRepo(t, false)
+	server := httptest.NewServer(http.FileServer(http.Dir(indexRepo)))
+	defer server.Close()
+	walkServer := httptest.NewServer(http.FileServer(http.Dir(walkRepo)))
+	defer walkServer.Close()
+
+	for _, tc := range []struct {
+		name     string
+		location string
+		index    bool
+		wantErr  bool
+	}{
+		{
+			name:     "local index",
+			location: indexRepo,
+			index:    true,
+		},
+		{
+			name:     "http index",
+			location: server.URL + "/",
+			index:    true,
+		},
+		{
+			name:     "local directory",
+			location: walkRepo,
+		},
+		{
+			name:     "http without index",
+			location: walkServer.URL,
+			wantErr:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewRepo(tc.location)
+			sources, err := r.DiscoverRepo()
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("DiscoverRepo() expected error, got %d sources", len(sources))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error while running DiscoverRepo(): %v", err)
+			}
+
+			var want []*Archive
+			for _, name := range testPackages {
+				archive := &Archive{
+					filename:   name + "-1.0-r0.apk",
+					remotePath: r.join(name + "-1.0-r0.apk"),
+					repoPath:   tc.location,
+				}
+				if tc.index {
+					archive.checksum = checksums[name]
+					archive.description = fmt.Sprintf("%s-1.0-r0.x86_64 (origin %s-src): %s test package", name, name, name)
+				}
+				want = append(want, archive)
+			}
+			if diff := cmp.Diff(want, toArchives(t, sources), cmp.AllowUnexported(Archive{}), cmpopts.IgnoreFields(Archive{}, "client")); diff != "" {
+				t.Errorf("DiscoverRepo() unexpected diff (-want/+got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPreprocess(t *testing.T) {
+	root, _ := testRepo(t, true)
+	server := httptest.NewServer(http.FileServer(http.Dir(root)))
+	defer server.Close()
+
+	sources, err := NewRepo(server.URL).DiscoverRepo()
+	if err != nil {
+		t.Fatalf("unexpected error while running DiscoverRepo(): %v", err)
+	}
+	archives := toArchives(t, sources)
+
+	extractionDir, err := archives[0].Preprocess()
+	if err != nil {
+		t.Fatalf("unexpected Preprocess() error: %v", err)
+	}
+	for _, name := range []string{"usr/bin/busybox", "usr/bin/hardlink", "usr/share/unchecked"} {
+		if _, err := os.Stat(filepath.Join(extractionDir, name)); err != nil {
+			t.Errorf("%s was not extracted: %v", name, err)
+		}
+	}
+	for _, name := range []string{".PKGINFO", ".post-install", ".SIGN.RSA.hashr.rsa.pub"} {
+		if _, err := os.Stat(filepath.Join(extractionDir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was extracted", name)
+		}
+	}
+
+	wantPkg := &hashrcommon.PackageInfo{
+		Manager:         "apk",
+		Package:         "busybox",
+		Version:         "1.0-r0",
+		Architecture:    "x86_64",
+		Maintainer:      "hashr <hashr@example.com>",
+		Source:          "busybox-src",
+		Homepage:        "https://example.com/busybox",
+		Packager:        "Buildozer <alpine-devel@lists.alpinelinux.org>",
+		BuildTime:       1700000000,
+		VerifiedFiles:   2,
+		MismatchedFiles: []string{"usr/bin/tampered"},
+	}
+	if diff := cmp.Diff(wantPkg, archives[0].Package()); diff != "" {
+		t.Errorf("Package() unexpected diff (-want/+got):\n%s", diff)
+	}
+	if want := "busybox-1.0-r0.x86_64 (origin busybox-src): busybox test package"; archives[0].Description() != want {
+		t.Errorf("Description() = %q, want %q", archives[0].Description(), want)
+	}
+
+	metadata, err := hashrcommon.ReadMetadata(filepath.Join(filepath.Dir(extractionDir), hashrcommon.MetadataFile))
+	if err != nil {
+		t.Fatalf("unexpected error while reading metadata: %v", err)
+	}
+	if got := metadata["bin/sh"]; got == nil || got.LinkTarget != "/usr/bin/busybox" {
+		t.Errorf("metadata of bin/sh = %+v, want link to /usr/bin/busybox", got)
+	}
+
+	// Package doesn't match the checksum from APKINDEX.
+	archives[1].checksum = archives[0].checksum
+	if _, err := archives[1].Preprocess(); err == nil {
+		t.Error("Preprocess() of a package not matching its checksum expected error, got nil")
+	}
+}
+
+func TestPreprocessWithoutIndex(t *testing.T) {
+	root, _ := testRepo(t, false)
+	sources, err := NewRepo(root).DiscoverRepo()
+	if err != nil {
+		t.Fatalf("unexpected error while running DiscoverRepo(): %v", err)
+	}
+	archives := toArchives(t, sources)
+
+	if _, err := archives[2].Preprocess(); err != nil {
+		t.Fatalf("unexpected Preprocess() error: %v", err)
+	}
+	if got := archives[2].Package(); got == nil || got.Package != "zlib" || got.VerifiedFiles != 2 {
+		t.Errorf("Package() = %+v, want zlib package with 2 verified files", got)
+	}
+	if want := "zlib-1.0-r0.x86_64 (origin zlib-src): zlib test package"; archives[2].Description() != want {
+		t.Errorf("Description() = %q, want %q", archives[2].Description(), want)
+	}
+}
@@ -0,0 +1,440 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package apt implements APT repository importer, which imports .deb packages listed in Packages
+// indices of a local mirror or of a repository served over HTTP.
+package apt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"golang.org/x/crypto/openpgp"
+
+	hashrcommon "github.com/google/hashr/common"
+	"github.com/google/hashr/core/hashr"
+	"github.com/google/hashr/importers/common"
+	"github.com/google/hashr/importers/deb"
+
+	"pault.ag/go/debian/control"
+	debian "pault.ag/go/debian/deb"
+)
+
+const (
+	// RepoName contains the repository name.
+	RepoName = "apt"
+	// maxIndexSize limits the size of Release files and Packages indices read into memory.
+	maxIndexSize = 512 << 20
+)
+
+// indexNames holds names of Packages indices in order of preference.
+var indexNames = []string{"Packages.xz", "Packages.gz", "Packages"}
+
+// Archive holds data related to a .deb package listed in a Packages index.
+type Archive struct {
+	filename   string
+	remotePath string
+	localPath  string
+	// sha256 is the digest of the package recorded in the Packages index.
+	sha256      string
+	repoPath    string
+	description string
+	client      *http.Client
+	// pkg holds control metadata, it's set during preprocessing.
+	pkg *hashrcommon.PackageInfo
+}
+
+// Preprocess downloads a .deb package, checks it against the digest from the Packages index and
+// extracts its contents.
+func (a *Archive) Preprocess() (string, error) {
+	tempDir, err := common.LocalTempDir(a.ID())
+	if err != nil {
+		return "", fmt.Errorf("error while creating temp directory: %v", err)
+	}
+	a.localPath = filepath.Join(tempDir, a.filename)
+
+	glog.Infof("Copying %s to %s", a.remotePath, a.localPath)
+	if err := common.Download(a.client, a.remotePath, a.localPath); err != nil {
+		return "", fmt.Errorf("error while copying %s to local file system: %v", a.remotePath, err)
+	}
+
+	sum, err := common.FileDigest(a.localPath, crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	if sum != a.sha256 {
+		return "", fmt.Errorf("SHA256 of %s is %s, Packages index lists %s", a.remotePath, sum, a.sha256)
+	}
+
+	extractionDir := filepath.Join(tempDir, "extracted")
+	a.pkg, _, err = deb.Extract(a.localPath, extractionDir)
+	if err != nil {
+		return "", err
+	}
+
+	return extractionDir, nil
+}
+
+// ID returns non-unique deb Archive ID.
+func (a *Archive) ID() string {
+	return a.filename
+}
+
+// RepoName returns repository name.
+func (a *Archive) RepoName() string {
+	return RepoName
+}
+
+// RepoPath returns repository path.
+func (a *Archive) RepoPath() string {
+	return a.repoPath
+}
+
+// LocalPath returns local path to a deb Archive .deb file.
+func (a *Archive) LocalPath() string {
+	return a.localPath
+}
+
+// RemotePath returns path or URL of a deb Archive .deb file in the repository.
+func (a *Archive) RemotePath() string {
+	return a.remotePath
+}
+
+// Description provides additional description for a .deb file: name, version and architecture of
+// the package followed by its synopsis, as listed in the Packages index.
+func (a *Archive) Description() string {
+	return a.description
+}
+
+// Package returns metadata from the control file of a .deb file, nil before it's preprocessed.
+func (a *Archive) Package() *hashrcommon.PackageInfo {
+	return a.pkg
+}
+
+// QuickSHA256Hash returns the SHA256 of a .deb file recorded in the Packages index, so the file
+// doesn't need to be read.
+func (a *Archive) QuickSHA256Hash() (string, error) {
+	return a.sha256, nil
+}
+
+// NewRepo returns new instance of APT repository. Location is either a local path or HTTP(S) URL
+// of the directory holding dists/ and pool/. Suites is a comma-separated list of directories in
+// dists/ to import, all of them are imported from local mirrors if it's empty. Release files are
+// verified with keys from the keyring, if it's not nil.
+func NewRepo(location, suites string, keyring openpgp.EntityList) *Repo {
+	r := &Repo{location: location, keyring: keyring, client: http.DefaultClient}
+	for _, suite := range strings.Split(suites, ",") {
+		if suite = strings.TrimSpace(suite); suite != "" {
+			r.suites = append(r.suites, suite)
+		}
+	}
+
+	return r
+}
+
+// Repo holds data related to an APT repository.
+type Repo struct {
+	location string
+	suites   []string
+	keyring  openpgp.EntityList
+	client   *http.Client
+	Archives []*Archive
+}
+
+// RepoName returns repository name.
+func (r *Repo) RepoName() string {
+	return RepoName
+}
+
+// RepoPath returns repository path.
+func (r *Repo) RepoPath() string {
+	return r.location
+}
+
+// DiscoverRepo reads Release files of the suites and returns packages listed in their Packages
+// indices. Packages listed in multiple indices (e.g. Architecture: all) are returned once.
+func (r *Repo) DiscoverRepo() ([]hashr.Source, error) {
+	suites := r.suites
+	if len(suites) == 0 {
+		if common.IsURL(r.location) {
+			return nil, errors.New("suites need to be set for repositories served over HTTP")
+		}
+		entries, err := ioutil.ReadDir(filepath.Join(r.location, "dists"))
+		if err != nil {
+			return nil, fmt.Errorf("error while listing suites: %v", err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				suites = append(suites, entry.Name())
+			}
+		}
+	}
+
+	r.Archives = nil
+	seen := make(map[string]bool)
+	for _, suite := range suites {
+		indices, err := r.readRelease(suite)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading Release file of %s: %v", suite, err)
+		}
+
+		for _, alternatives := range indices {
+			archives, err := r.readIndex(alternatives)
+			if err != nil {
+				return nil, err
+			}
+			for _, archive := range archives {
+				if seen[archive.remotePath] {
+					continue
+				}
+				seen[archive.remotePath] = true
+				r.Archives = append(r.Archives, archive)
+			}
+		}
+	}
+
+	var sources []hashr.Source
+	for _, archive := range r.Archives {
+		sources = append(sources, archive)
+	}
+
+	return sources, nil
+}
+
+// indexFile is a Packages index listed in a Release file.
+type indexFile struct {
+	// path is relative to the repository root.
+	path   string
+	sha256 string
+}
+
+// readRelease reads InRelease or, if it's missing, Release file of a suite and returns Packages
+// indices it lists. Indices are grouped by component and architecture, compressed variants of the
+// same index are ordered by preference.
+func (r *Repo) readRelease(suite string) ([][]indexFile, error) {
+	dir := path.Join("dists", suite)
+	paragraph, err := r.readInRelease(dir)
+	if os.IsNotExist(err) {
+		paragraph, err = r.readDetachedRelease(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Components of Debian security suites are prefixed, e.g. updates/main.
+	components := make(map[string]bool)
+	for _, component := range strings.Fields(paragraph.Values["Components"]) {
+		components[component] = true
+	}
+
+	digests := make(map[string]string)
+	for _, line := range strings.Split(paragraph.Values["SHA256"], "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		digests[fields[2]] = fields[0]
+	}
+
+	var dirs []string
+	for name := range digests {
+		indexDir, file := path.Split(name)
+		indexDir = strings.TrimSuffix(indexDir, "/")
+		i := strings.LastIndex(indexDir, "/binary-")
+		if !strings.HasPrefix(file, "Packages") || i < 0 {
+			continue
+		}
+		// Skips e.g. debian-installer indices, which list udebs.
+		if len(components) > 0 && !components[indexDir[:i]] {
+			continue
+		}
+		dirs = append(dirs, indexDir)
+	}
+	sort.Strings(dirs)
+
+	var indices [][]indexFile
+	for i, indexDir := range dirs {
+		if i > 0 && dirs[i-1] == indexDir {
+			continue
+		}
+		var alternatives []indexFile
+		for _, name := range indexNames {
+			if digest, ok := digests[indexDir+"/"+name]; ok {
+				alternatives = append(alternatives, indexFile{path: path.Join(dir, indexDir, name), sha256: digest})
+			}
+		}
+		indices = append(indices, alternatives)
+	}
+
+	return indices, nil
+}
+
+// readInRelease reads clearsigned InRelease file. The signature is verified if the keyring is set.
+func (r *Repo) readInRelease(dir string) (*control.Paragraph, error) {
+	data, err := r.readFile(path.Join(dir, "InRelease"))
+	if err != nil {
+		return nil, err
+	}
+
+	var keyring *openpgp.EntityList
+	if r.keyring != nil {
+		keyring = &r.keyring
+	}
+	reader, err := control.NewParagraphReader(bytes.NewReader(data), keyring)
+	if err != nil {
+		return nil, fmt.Errorf("error while verifying InRelease: %v", err)
+	}
+	if r.keyring != nil && reader.Signer() == nil {
+		return nil, errors.New("InRelease is not signed")
+	}
+
+	return reader.Next()
+}
+
+// readDetachedRelease reads Release file and verifies it with Release.gpg signature, if the keyring
+// is set.
+func (r *Repo) readDetachedRelease(dir string) (*control.Paragraph, error) {
+	data, err := r.readFile(path.Join(dir, "Release"))
+	if err != nil {
+		return nil, err
+	}
+
+	if r.keyring != nil {
+		signature, err := r.readFile(path.Join(dir, "Release.gpg"))
+		if err != nil {
+			return nil, fmt.Errorf("error while reading Release.gpg: %v", err)
+		}
+		if _, err := openpgp.CheckArmoredDetachedSignature(r.keyring, bytes.NewReader(data), bytes.NewReader(signature)); err != nil {
+			return nil, fmt.Errorf("error while verifying Release: %v", err)
+		}
+	}
+
+	reader, err := control.NewParagraphReader(bytes.NewReader(data), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return reader.Next()
+}
+
+// readIndex reads the first of the compressed variants of a Packages index that's present in the
+// repository. Mirrors don't need to hold all variants listed in the Release file.
+func (r *Repo) readIndex(alternatives []indexFile) ([]*Archive, error) {
+	for _, index := range alternatives {
+		archives, err := r.readPackages(index)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error while reading %s: %v", index.path, err)
+		}
+		return archives, nil
+	}
+
+	return nil, fmt.Errorf("none of %s is present in the repository", path.Dir(alternatives[0].path)+"/Packages*")
+}
+
+// readPackages reads a Packages index, checks it against the digest from the Release file and
+// returns packages it lists.
+func (r *Repo) readPackages(index indexFile) ([]*Archive, error) {
+	data, err := r.readFile(index.path)
+	if err != nil {
+		return nil, err
+	}
+	if sum := fmt.Sprintf("%x", sha256.Sum256(data)); sum != index.sha256 {
+		return nil, fmt.Errorf("SHA256 is %s, Release file lists %s", sum, index.sha256)
+	}
+
+	decompressed, err := debian.DecompressorFor(path.Ext(index.path))(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer decompressed.Close()
+
+	reader, err := control.NewParagraphReader(bufio.NewReader(io.LimitReader(decompressed, maxIndexSize)), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var archives []*Archive
+	for {
+		paragraph, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		fields := paragraph.Values
+		filename := common.TarEntryPath(fields["Filename"])
+		if fields["Filename"] == "" || fields["SHA256"] == "" {
+			glog.Warningf("Skipping %s from %s: Filename or SHA256 is missing", fields["Package"], index.path)
+			continue
+		}
+		synopsis := strings.SplitN(fields["Description"], "\n", 2)[0]
+
+		archives = append(archives, &Archive{
+			filename:    path.Base(filename),
+			remotePath:  r.join(filename),
+			sha256:      strings.ToLower(fields["SHA256"]),
+			repoPath:    r.location,
+			description: fmt.Sprintf("%s_%s_%s: %s", fields["Package"], fields["Version"], fields["Architecture"], strings.TrimSpace(synopsis)),
+			client:      r.client,
+		})
+	}
+
+	return archives, nil
+}
+
+// readFile returns contents of a file relative to the repository root.
+func (r *Repo) readFile(name string) ([]byte, error) {
+	rc, err := common.Open(r.client, r.join(name))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(rc, maxIndexSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxIndexSize {
+		return nil, fmt.Errorf("%s is bigger than %d bytes", name, maxIndexSize)
+	}
+
+	return data, nil
+}
+
+// join returns path or URL of a file relative to the repository root.
+func (r *Repo) join(name string) string {
+	if common.IsURL(r.location) {
+		return strings.TrimSuffix(r.location, "/") + "/" + name
+	}
+
+	return filepath.Join(r.location, filepath.FromSlash(name))
+}
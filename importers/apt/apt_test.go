@@ -0,0 +1,277 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+
+	"github.com/google/hashr/core/hashr"
+)
+
+var testPackages = []string{"ubuntu-desktop", "ubuntu-laptop", "ubuntu-server"}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func gzipData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// testMirror creates an APT mirror with the stable suite signed by a given key. Packages are
+// listed in arm64 and all indices, only gzip compressed variant of the indices is present.
+func testMirror(t *testing.T, key *openpgp.Entity) (string, map[string]string) {
+	t.Helper()
+	root := t.TempDir()
+
+	digests := make(map[string]string)
+	var packages bytes.Buffer
+	for _, name := range testPackages {
+		data, err := os.ReadFile(filepath.Join("../deb/testdata/20200106.00.00", name+".deb"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		filename := fmt.Sprintf("pool/main/u/%s/%s_1.0_arm64.deb", name, name)
+		writeFile(t, filepath.Join(root, filename), data)
+		digests[name] = fmt.Sprintf("%x", sha256.Sum256(data))
+		fmt.Fprintf(&packages, "Package: %s\nVersion: 1.0\nArchitecture: arm64\nFilename: %s\nSize: %d\nSHA256: %s\nDescription: %s test package\n Long description.\n\n", name, filename, len(data), digests[name], name)
+	}
+
+	gzipped := gzipData(t, packages.Bytes())
+	release := "Origin: hashr\nSuite: stable\nComponents: main\nArchitectures: arm64\nSHA256:\n"
+	for _, index := range []string{"main/binary-arm64", "main/binary-all"} {
+		writeFile(t, filepath.Join(root, "dists/stable", index, "Packages.gz"), gzipped)
+		release += fmt.Sprintf(" %x %d %s/Packages.gz\n", sha256.Sum256(gzipped), len(gzipped), index)
+		// Listed, but not present in the mirror.
+		release += fmt.Sprintf(" %x %d %s/Packages.xz\n", sha256.Sum256(nil), 0, index)
+	}
+	// Indices of other components are not read.
+	release += fmt.Sprintf(" %x %d main/debian-installer/binary-arm64/Packages.gz\n", sha256.Sum256(nil), 0)
+	writeFile(t, filepath.Join(root, "dists/stable/Release"), []byte(release))
+
+	var detached bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&detached, key, strings.NewReader(release), nil); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(root, "dists/stable/Release.gpg"), detached.Bytes())
+
+	var clearsigned bytes.Buffer
+	w, err := clearsign.Encode(&clearsigned, key.PrivateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(release)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(root, "dists/stable/InRelease"), clearsigned.Bytes())
+
+	return root, digests
+}
+
+func wantArchives(location string, digests map[string]string, join func(string) string) []*Archive {
+	var archives []*Archive
+	for _, name := range testPackages {
+		archives = append(archives, &Archive{
+			filename:    name + "_1.0_arm64.deb",
+			remotePath:  join(fmt.Sprintf("pool/main/u/%s/%s_1.0_arm64.deb", name, name)),
+			sha256:      digests[name],
+			repoPath:    location,
+			description: fmt.Sprintf("%s_1.0_arm64: %s test package", name, name),
+		})
+	}
+
+	return archives
+}
+
+func toArchives(t *testing.T, sources []hashr.Source) []*Archive {
+	t.Helper()
+	var archives []*Archive
+	for _, source := range sources {
+		archive, ok := source.(*Archive)
+		if !ok {
+			t.Fatalf("unexpected source type %T", source)
+		}
+		archives = append(archives, archive)
+	}
+
+	return archives
+}
+
+func TestDiscover(t *testing.T) {
+	key, err := openpgp.NewEntity("hashr", "", "hashr@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := openpgp.NewEntity("other", "", "other@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror, digests := testMirror(t, key)
+	server := httptest.NewServer(http.FileServer(http.Dir(mirror)))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name     string
+		location string
+		suites   string
+		keyring  openpgp.EntityList
+		setup    func(t *testing.T)
+		wantErr  bool
+	}{
+		{
+			name:     "local mirror",
+			location: mirror,
+			keyring:  openpgp.EntityList{key},
+		},
+		{
+			name:     "http",
+			location: server.URL,
+			suites:   "stable",
+			keyring:  openpgp.EntityList{key},
+		},
+		{
+			name:     "without keyring",
+			location: server.URL + "/",
+			suites:   "stable",
+		},
+		{
+			name:     "untrusted InRelease",
+			location: mirror,
+			keyring:  openpgp.EntityList{otherKey},
+			wantErr:  true,
+		},
+		{
+			name:     "http without suites",
+			location: server.URL,
+			wantErr:  true,
+		},
+		{
+			name:     "missing suite",
+			location: mirror,
+			suites:   "stable,testing",
+			wantErr:  true,
+		},
+		{
+			name:     "detached signature",
+			location: server.URL,
+			suites:   "stable",
+			keyring:  openpgp.EntityList{key},
+			setup: func(t *testing.T) {
+				if err := os.Remove(filepath.Join(mirror, "dists/stable/InRelease")); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			// InRelease was removed by the previous test case.
+			name:     "untrusted Release",
+			location: mirror,
+			keyring:  openpgp.EntityList{otherKey},
+			wantErr:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.setup != nil {
+				tc.setup(t)
+			}
+
+			r := NewRepo(tc.location, tc.suites, tc.keyring)
+			sources, err := r.DiscoverRepo()
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("DiscoverRepo() expected error, got %d sources", len(sources))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error while running DiscoverRepo(): %v", err)
+			}
+
+			want := wantArchives(tc.location, digests, r.join)
+			if diff := cmp.Diff(want, toArchives(t, sources), cmp.AllowUnexported(Archive{}), cmpopts.IgnoreFields(Archive{}, "client")); diff != "" {
+				t.Errorf("DiscoverRepo() unexpected diff (-want/+got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPreprocess(t *testing.T) {
+	key, err := openpgp.NewEntity("hashr", "", "hashr@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror, _ := testMirror(t, key)
+	server := httptest.NewServer(http.FileServer(http.Dir(mirror)))
+	defer server.Close()
+
+	sources, err := NewRepo(server.URL, "stable", openpgp.EntityList{key}).DiscoverRepo()
+	if err != nil {
+		t.Fatalf("unexpected error while running DiscoverRepo(): %v", err)
+	}
+	archives := toArchives(t, sources)
+
+	extractionDir, err := archives[0].Preprocess()
+	if err != nil {
+		t.Fatalf("unexpected Preprocess() error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(extractionDir, "file.01")); err != nil {
+		t.Errorf("file.01 was not extracted: %v", err)
+	}
+	if got := archives[0].Package(); got == nil || got.Package != "hashr-testdata" {
+		t.Errorf("Package() = %+v, want hashr-testdata package", got)
+	}
+	if hash, _ := archives[0].QuickSHA256Hash(); hash != archives[0].sha256 {
+		t.Errorf("QuickSHA256Hash() = %s, want %s", hash, archives[0].sha256)
+	}
+
+	// Package doesn't match the Packages index.
+	archives[1].sha256 = archives[0].sha256
+	if _, err := archives[1].Preprocess(); err == nil {
+		t.Error("Preprocess() of a package not matching its SHA256 expected error, got nil")
+	}
+}
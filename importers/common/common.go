@@ -17,36 +17,62 @@ package common
 
 import (
 	"archive/tar"
+	"bytes"
+	"compress/bzip2"
 	"compress/gzip"
+	"crypto"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/golang/glog"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"golang.org/x/crypto/openpgp"
+
+	hcommon "github.com/google/hashr/common"
+
+	// Register hash functions used for digests of package files.
+	_ "crypto/md5"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
 )
 
 // ExtractTarGz extracts tar.gz file to given output folder. If directory does not exist, it will
 // be created.
 func ExtractTarGz(tarGzPath, outputFolder string) error {
+	_, err := ExtractTarGzMetadata(tarGzPath, outputFolder)
+	return err
+}
+
+// ExtractTarGzMetadata extracts tar.gz file to given output folder, same as ExtractTarGz, and
+// returns metadata of extracted files and symbolic links recorded in tar headers, keyed by path
+// relative to the output folder.
+func ExtractTarGzMetadata(tarGzPath, outputFolder string) (map[string]*hcommon.FileMetadata, error) {
+	metadata := make(map[string]*hcommon.FileMetadata)
+
 	if _, err := os.Stat(outputFolder); os.IsNotExist(err) {
 		if err2 := os.MkdirAll(outputFolder, 0755); err2 != nil {
-			return fmt.Errorf("error while creating target directory: %v", err2)
+			return nil, fmt.Errorf("error while creating target directory: %v", err2)
 		}
 	}
 
 	gzFile, err := os.Open(tarGzPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer gzFile.Close()
 
 	gzReader, err := gzip.NewReader(gzFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	tarReader := tar.NewReader(gzReader)
@@ -58,9 +84,9 @@ func ExtractTarGz(tarGzPath, outputFolder string) error {
 
 		switch {
 		case err == io.EOF:
-			return nil
+			return metadata, nil
 		case err != nil:
-			return err
+			return nil, err
 		}
 
 		if containsDotDot(header.Name) {
@@ -73,28 +99,158 @@ func ExtractTarGz(tarGzPath, outputFolder string) error {
 		case tar.TypeDir:
 			if _, err := os.Stat(destEntry); os.IsNotExist(err) {
 				if err := os.MkdirAll(destEntry, 0755); err != nil {
-					return fmt.Errorf("error while creating destination directory: %v", err)
+					return nil, fmt.Errorf("error while creating destination directory: %v", err)
 				}
 			}
 		case tar.TypeReg:
 			if _, err := os.Stat(filepath.Dir(destEntry)); os.IsNotExist(err) {
 				if err := os.MkdirAll(filepath.Dir(destEntry), 0755); err != nil {
-					return fmt.Errorf("error while creating destination directory: %v", err)
+					return nil, fmt.Errorf("error while creating destination directory: %v", err)
 				}
 			}
 			
 			destFile, err := os.Create(destEntry)
 			if err != nil {
-				return fmt.Errorf("error while creating destination file: %v", err)
+				return nil, fmt.Errorf("error while creating destination file: %v", err)
 			}
 
 			_, err = io.Copy(destFile, tarReader)
 			if err != nil {
-				return fmt.Errorf("error while extracting destination file: %v", err)
+				return nil, fmt.Errorf("error while extracting destination file: %v", err)
 			}
 			destFile.Close()
+			metadata[TarEntryPath(header.Name)] = TarHeaderMetadata(header)
+		case tar.TypeSymlink:
+			metadata[TarEntryPath(header.Name)] = TarHeaderMetadata(header)
+		}
+	}
+}
+
+// Decompress returns decompressed contents of a file compressed with gzip, zstd, xz or bzip2,
+// based on the extension of its name, e.g. .tar.zst or .tgz.
+func Decompress(name string, r io.Reader) (io.ReadCloser, error) {
+	switch path.Ext(name) {
+	case ".gz", ".tgz":
+		return gzip.NewReader(r)
+	case ".zst", ".tzst":
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case ".xz", ".txz":
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(xr), nil
+	case ".bz2", ".tbz2":
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	}
+
+	return nil, fmt.Errorf("unsupported compression of %s", name)
+}
+
+// TarEntryPath returns path of a tar entry relative to the extraction root, e.g. ./usr/bin/ls is
+// returned as usr/bin/ls.
+func TarEntryPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// TarHeaderMetadata returns file metadata recorded in a tar header.
+func TarHeaderMetadata(header *tar.Header) *hcommon.FileMetadata {
+	return &hcommon.FileMetadata{
+		Mode:       hcommon.UnixMode(header.FileInfo().Mode()),
+		UID:        header.Uid,
+		GID:        header.Gid,
+		User:       header.Uname,
+		Group:      header.Gname,
+		Mtime:      header.ModTime.Unix(),
+		LinkTarget: header.Linkname,
+	}
+}
+
+// MetadataPath returns path of the file holding metadata of files extracted to a given directory.
+func MetadataPath(extractionDir string) string {
+	return filepath.Join(filepath.Dir(filepath.Clean(extractionDir)), hcommon.MetadataFile)
+}
+
+// VerifyDigests compares files extracted to a directory with digests recorded in a package, keyed
+// by path relative to the directory. It returns the number of matching files and sorted paths of
+// files that don't match or are missing.
+func VerifyDigests(extractionDir string, hash crypto.Hash, digests map[string]string) (int, []string, error) {
+	if !hash.Available() {
+		return 0, nil, fmt.Errorf("hash function %v is not available", hash)
+	}
+
+	var verified int
+	var mismatched []string
+	for name, digest := range digests {
+		name = TarEntryPath(name)
+		match, err := fileMatches(filepath.Join(extractionDir, name), hash, digest)
+		if err != nil {
+			glog.Warningf("could not verify %s: %v", name, err)
+		}
+		if !match {
+			mismatched = append(mismatched, name)
+			continue
+		}
+		verified++
+	}
+	sort.Strings(mismatched)
+
+	return verified, mismatched, nil
+}
+
+// fileMatches returns true if a regular file has a given hex encoded digest.
+func fileMatches(filePath string, hash crypto.Hash, digest string) (bool, error) {
+	fi, err := os.Lstat(filePath)
+	if err != nil {
+		return false, err
+	}
+	if !fi.Mode().IsRegular() {
+		return false, fmt.Errorf("not a regular file")
+	}
+
+	fileDigest, err := FileDigest(filePath, hash)
+	if err != nil {
+		return false, err
+	}
+
+	return fileDigest == strings.ToLower(digest), nil
+}
+
+// FileDigest returns hex encoded digest of a file.
+func FileDigest(filePath string, hash crypto.Hash) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := hash.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// ReadKeyring reads OpenPGP public keys from a file, either ASCII armored or binary.
+func ReadKeyring(keyringPath string) (openpgp.EntityList, error) {
+	data, err := ioutil.ReadFile(keyringPath)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading keyring %s: %v", keyringPath, err)
+	}
+
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		if keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("error while parsing keyring %s: %v", keyringPath, err)
 		}
 	}
+
+	return keyring, nil
 }
 
 func containsDotDot(v string) bool {
@@ -149,3 +305,51 @@ func CopyToLocal(remotePath, sourceID string) (string, error) {
 	glog.Infof("Done copying %s", sourceID)
 	return destPath, nil
 }
+
+// IsURL returns true if a repository location is HTTP(S) URL rather than a local path.
+func IsURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// Open returns contents of a local file or of a file served over HTTP. Missing files return an
+// error satisfying os.IsNotExist in both cases.
+func Open(client *http.Client, location string) (io.ReadCloser, error) {
+	if !IsURL(location) {
+		return os.Open(location)
+	}
+
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, &os.PathError{Op: "get", Path: location, Err: os.ErrNotExist}
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected response while getting %s: %s", location, resp.Status)
+	}
+}
+
+// Download copies a local file or a file served over HTTP to a given path.
+func Download(client *http.Client, location, destPath string) error {
+	rc, err := Open(client, location)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	destFile, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(destFile, rc); err != nil {
+		destFile.Close()
+		return err
+	}
+
+	return destFile.Close()
+}
@@ -15,14 +15,24 @@
 package common
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto"
 	"crypto/sha256"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+
+	hcommon "github.com/google/hashr/common"
 )
 
 func TestExtractTarGz(t *testing.T) {
@@ -277,3 +287,126 @@ func TestCopyToLocal(t *testing.T) {
 	}
 
 }
+
+func TestExtractTarGzMetadata(t *testing.T) {
+	tempDir := t.TempDir()
+	tarGzPath := filepath.Join(tempDir, "archive.tar.gz")
+
+	f, err := os.Create(tarGzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	mtime := time.Unix(1600000000, 0)
+	for _, header := range []*tar.Header{
+		{Typeflag: tar.TypeDir, Name: "./usr/bin/", Mode: 0755, ModTime: mtime},
+		{Typeflag: tar.TypeReg, Name: "./usr/bin/passwd", Mode: 04755, Uid: 0, Gid: 0, Uname: "root", Gname: "root", Size: 6, ModTime: mtime},
+		{Typeflag: tar.TypeSymlink, Name: "./usr/bin/chfn", Linkname: "passwd", Mode: 0777, Uid: 0, Gid: 0, ModTime: mtime},
+	} {
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Size > 0 {
+			if _, err := tw.Write([]byte("passwd")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, c := range []io.Closer{tw, gw, f} {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := ExtractTarGzMetadata(tarGzPath, filepath.Join(tempDir, "extracted"))
+	if err != nil {
+		t.Fatalf("unexpected error while running ExtractTarGzMetadata(): %v", err)
+	}
+
+	want := map[string]*hcommon.FileMetadata{
+		"usr/bin/passwd": {Mode: 0104755, UID: 0, GID: 0, User: "root", Group: "root", Mtime: 1600000000},
+		"usr/bin/chfn":   {Mode: 0120777, UID: 0, GID: 0, Mtime: 1600000000, LinkTarget: "passwd"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ExtractTarGzMetadata() unexpected diff (-want/+got):\n%s", diff)
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, "extracted", "usr", "bin", "passwd")); err != nil {
+		t.Errorf("ExtractTarGzMetadata() did not extract usr/bin/passwd: %v", err)
+	}
+}
+
+func TestVerifyDigests(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"usr/bin/hello": "hello\n", "etc/hello.conf": "changed\n"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("hello", filepath.Join(dir, "usr/bin/hi")); err != nil {
+		t.Fatal(err)
+	}
+
+	digests := map[string]string{
+		"usr/bin/hello":    "B1946AC92492D2347C6235B4D2611184",
+		"./etc/hello.conf": "b1946ac92492d2347c6235b4d2611184",
+		"usr/bin/missing":  "b1946ac92492d2347c6235b4d2611184",
+		// Links are not followed.
+		"usr/bin/hi": "b1946ac92492d2347c6235b4d2611184",
+	}
+	verified, mismatched, err := VerifyDigests(dir, crypto.MD5, digests)
+	if err != nil {
+		t.Fatalf("unexpected error while running VerifyDigests(): %v", err)
+	}
+	if verified != 1 {
+		t.Errorf("VerifyDigests() verified %d files; want = 1", verified)
+	}
+	if diff := cmp.Diff([]string{"etc/hello.conf", "usr/bin/hi", "usr/bin/missing"}, mismatched); diff != "" {
+		t.Errorf("VerifyDigests() unexpected diff (-want/+got):\n%s", diff)
+	}
+
+	if _, _, err := VerifyDigests(dir, crypto.MD4, digests); err == nil {
+		t.Error("VerifyDigests() expected error for an unavailable hash function")
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	want := "hashr test data\n"
+	compressors := map[string]func(w io.Writer) (io.WriteCloser, error){
+		"data.tar.gz":  func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		"data.tgz":     func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		"data.tar.zst": func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) },
+		"data.tar.xz":  func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) },
+	}
+	for name, compressor := range compressors {
+		var buf bytes.Buffer
+		w, err := compressor(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, want); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		rc, err := Decompress(name, &buf)
+		if err != nil {
+			t.Fatalf("unexpected error while running Decompress(%s): %v", name, err)
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(got) != want {
+			t.Errorf("Decompress(%s) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+
+	if _, err := Decompress("data.tar.lz4", bytes.NewReader(nil)); err == nil {
+		t.Error("Decompress() expected error for unsupported compression")
+	}
+}
@@ -17,15 +17,19 @@ package deb
 
 import (
 	"archive/tar"
+	"bufio"
+	"crypto"
 	"crypto/sha256"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
 	"github.com/golang/glog"
 
+	hashrcommon "github.com/google/hashr/common"
 	"github.com/google/hashr/core/hashr"
 	"github.com/google/hashr/importers/common"
 
@@ -36,6 +40,8 @@ const (
 	// RepoName contains the repository name.
 	RepoName  = "deb"
 	chunkSize = 1024 * 1024 * 10 // 10MB
+	// packageManager is the manager recorded in package metadata.
+	packageManager = "dpkg"
 )
 
 // Archive holds data related to deb archive.
@@ -45,6 +51,9 @@ type Archive struct {
 	localPath       string
 	quickSha256hash string
 	repoPath        string
+	// pkg holds control metadata, it's set during preprocessing.
+	pkg         *hashrcommon.PackageInfo
+	description string
 }
 
 func isSubElem(parent, sub string) (bool, error) {
@@ -61,7 +70,7 @@ func isSubElem(parent, sub string) (bool, error) {
 	return false, nil
 }
 
-func extractTar(tarfile *tar.Reader, outputFolder string) error {
+func extractTar(tarfile *tar.Reader, outputFolder string, metadata map[string]*hashrcommon.FileMetadata) error {
 	for {
 		header, err := tarfile.Next()
 
@@ -77,6 +86,7 @@ func extractTar(tarfile *tar.Reader, outputFolder string) error {
 
 		switch header.Typeflag {
 		case tar.TypeSymlink:
+			metadata[common.TarEntryPath(name)] = common.TarHeaderMetadata(header)
 			continue
 
 		case tar.TypeDir:
@@ -106,6 +116,7 @@ func extractTar(tarfile *tar.Reader, outputFolder string) error {
 			if err != nil {
 				return fmt.Errorf("error while writing to destination file: %v", err)
 			}
+			metadata[common.TarEntryPath(name)] = common.TarHeaderMetadata(header)
 
 		default:
 			fmt.Printf("Unknown tar entry type: %c in file %s\n", header.Typeflag, name)
@@ -115,30 +126,110 @@ func extractTar(tarfile *tar.Reader, outputFolder string) error {
 	return nil
 }
 
-func extractDeb(debPath, outputFolder string) error {
+// Extract extracts data of a .deb file and returns its control metadata. Extracted files are
+// checked against MD5 sums from the control archive.
+func Extract(debPath, outputFolder string) (*hashrcommon.PackageInfo, string, error) {
 	if _, err := os.Stat(outputFolder); os.IsNotExist(err) {
 		if err2 := os.MkdirAll(outputFolder, 0755); err2 != nil {
-			return fmt.Errorf("error while creating target directory: %v", err2)
+			return nil, "", fmt.Errorf("error while creating target directory: %v", err2)
 		}
 	}
 
 	fd, err := os.Open(debPath)
 	if err != nil {
-		return fmt.Errorf("failed to open deb file: %v", err)
+		return nil, "", fmt.Errorf("failed to open deb file: %v", err)
 	}
 	defer fd.Close()
 
 	debFile, err := deb.Load(fd, debPath)
 	if err != nil {
-		return fmt.Errorf("failed to parse deb file: %v", err)
+		return nil, "", fmt.Errorf("failed to parse deb file: %v", err)
 	}
+	defer debFile.Close()
+
+	pkg := &hashrcommon.PackageInfo{
+		Manager:      packageManager,
+		Package:      debFile.Control.Package,
+		Version:      debFile.Control.Version.String(),
+		Architecture: debFile.Control.Architecture.String(),
+		Maintainer:   debFile.Control.Maintainer,
+		Source:       debFile.Control.Source,
+		Homepage:     debFile.Control.Homepage,
+	}
+	// Synopsis is the first line of the description.
+	synopsis := strings.SplitN(debFile.Control.Description, "\n", 2)[0]
+	description := fmt.Sprintf("%s_%s_%s: %s", pkg.Package, pkg.Version, pkg.Architecture, strings.TrimSpace(synopsis))
 
-	err = extractTar(debFile.Data, outputFolder)
+	md5sums, err := readMD5Sums(debFile)
 	if err != nil {
-		return err
+		return nil, "", fmt.Errorf("error while reading md5sums: %v", err)
 	}
 
-	return nil
+	metadata := make(map[string]*hashrcommon.FileMetadata)
+	err = extractTar(debFile.Data, outputFolder, metadata)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := hashrcommon.WriteMetadata(common.MetadataPath(outputFolder), metadata); err != nil {
+		return nil, "", fmt.Errorf("error while saving file metadata: %v", err)
+	}
+
+	pkg.VerifiedFiles, pkg.MismatchedFiles, err = common.VerifyDigests(outputFolder, crypto.MD5, md5sums)
+	if err != nil {
+		return nil, "", fmt.Errorf("error while verifying MD5 sums: %v", err)
+	}
+	if len(pkg.MismatchedFiles) > 0 {
+		glog.Warningf("%d files extracted from %s don't match md5sums: %s", len(pkg.MismatchedFiles), debPath, strings.Join(pkg.MismatchedFiles, ", "))
+	}
+
+	return pkg, description, nil
+}
+
+// readMD5Sums returns MD5 sums of data files recorded in the md5sums file of the control archive,
+// keyed by path. Packages without md5sums (e.g. built without dh_md5sums) return no sums.
+func readMD5Sums(debFile *deb.Deb) (map[string]string, error) {
+	md5sums := make(map[string]string)
+	for name, member := range debFile.ArContent {
+		if !strings.HasPrefix(name, "control.tar") {
+			continue
+		}
+
+		// The control member was already read while loading the package.
+		entry := *member
+		entry.Data = io.NewSectionReader(member.Data, 0, member.Data.Size())
+		tarReader, closer, err := entry.Tarfile()
+		if err != nil {
+			return nil, err
+		}
+		defer closer.Close()
+
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				return md5sums, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if path.Clean(header.Name) != "md5sums" {
+				continue
+			}
+
+			scanner := bufio.NewScanner(tarReader)
+			for scanner.Scan() {
+				// Lines hold the sum and the path separated by two spaces, paths may contain spaces.
+				fields := strings.SplitN(strings.TrimSpace(scanner.Text()), " ", 2)
+				if len(fields) != 2 {
+					continue
+				}
+				md5sums[strings.TrimLeft(fields[1], " ")] = fields[0]
+			}
+			return md5sums, scanner.Err()
+		}
+	}
+
+	return md5sums, nil
 }
 
 // Preprocess extracts the contents of a .deb file.
@@ -152,7 +243,8 @@ func (a *Archive) Preprocess() (string, error) {
 	baseDir, _ := filepath.Split(a.localPath)
 	extractionDir := filepath.Join(baseDir, "extracted")
 
-	if err := extractDeb(a.localPath, extractionDir); err != nil {
+	a.pkg, a.description, err = Extract(a.localPath, extractionDir)
+	if err != nil {
 		return "", err
 	}
 
@@ -184,9 +276,15 @@ func (a *Archive) RemotePath() string {
 	return a.remotePath
 }
 
-// Description provides additional description for a .deb file.
+// Description provides additional description for a .deb file: name, version and architecture of
+// the package followed by its synopsis. It's empty before the file is preprocessed.
 func (a *Archive) Description() string {
-	return ""
+	return a.description
+}
+
+// Package returns metadata from the control file of a .deb file, nil before it's preprocessed.
+func (a *Archive) Package() *hashrcommon.PackageInfo {
+	return a.pkg
 }
 
 // QuickSHA256Hash calculates sha256 hash of .deb file.
@@ -15,15 +15,21 @@
 package deb
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+
+	hashrcommon "github.com/google/hashr/common"
 )
 
 func sha256sum(path string) ([32]byte, error) {
@@ -432,3 +438,107 @@ func TestRepoFunctions(t *testing.T) {
 		t.Errorf("RepoPath() = %s; want = %s", repo.RepoPath(), repoPath)
 	}
 }
+
+// tarGz returns a tar.gz archive holding given files.
+func tarGz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(files[name]))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// writeDeb writes a .deb file with given control archive and data archive files.
+func writeDeb(t *testing.T, path string, control, data map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.WriteString("!<arch>\n")
+	for _, member := range []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", tarGz(t, control)},
+		{"data.tar.gz", tarGz(t, data)},
+	} {
+		fmt.Fprintf(&buf, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", member.name, 0, 0, 0, "100644", len(member.data))
+		buf.Write(member.data)
+		if len(member.data)%2 == 1 {
+			buf.WriteByte('\n')
+		}
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPreprocessPackage(t *testing.T) {
+	debPath := filepath.Join(t.TempDir(), "hello_2.10-2_amd64.deb")
+	writeDeb(t, debPath, map[string]string{
+		"./control": `Package: hello
+Version: 2.10-2
+Architecture: amd64
+Maintainer: Santiago Vila <sanvila@debian.org>
+Installed-Size: 280
+Section: devel
+Priority: optional
+Homepage: http://www.gnu.org/software/hello/
+Description: example package based on GNU hello
+ The GNU hello program produces a familiar, friendly greeting.
+`,
+		"./md5sums": "b1946ac92492d2347c6235b4d2611184  usr/bin/hello\n" +
+			"b1946ac92492d2347c6235b4d2611184  usr/share/doc/hello/copy right\n" +
+			"b1946ac92492d2347c6235b4d2611184  usr/share/doc/hello/changelog.gz\n" +
+			"b1946ac92492d2347c6235b4d2611184  usr/share/man/man1/hello.1.gz\n",
+	}, map[string]string{
+		"./usr/bin/hello":                  "hello\n",
+		"./usr/share/doc/hello/copy right": "hello\n",
+		// Modified after the package was built.
+		"./usr/share/doc/hello/changelog.gz": "changed\n",
+	})
+
+	archive := &Archive{filename: filepath.Base(debPath), remotePath: debPath}
+	if archive.Package() != nil || archive.Description() != "" {
+		t.Errorf("Package() = %v, Description() = %q before Preprocess(); want empty", archive.Package(), archive.Description())
+	}
+	if _, err := archive.Preprocess(); err != nil {
+		t.Fatalf("unexpected Preprocess() error: %v", err)
+	}
+
+	want := &hashrcommon.PackageInfo{
+		Manager:         "dpkg",
+		Package:         "hello",
+		Version:         "2.10-2",
+		Architecture:    "amd64",
+		Maintainer:      "Santiago Vila <sanvila@debian.org>",
+		Homepage:        "http://www.gnu.org/software/hello/",
+		VerifiedFiles:   2,
+		MismatchedFiles: []string{"usr/share/doc/hello/changelog.gz", "usr/share/man/man1/hello.1.gz"},
+	}
+	if diff := cmp.Diff(want, archive.Package()); diff != "" {
+		t.Errorf("Package() unexpected diff (-want/+got):\n%s", diff)
+	}
+	if want := "hello_2.10-2_amd64: example package based on GNU hello"; archive.Description() != want {
+		t.Errorf("Description() = %q; want = %q", archive.Description(), want)
+	}
+}
@@ -0,0 +1,491 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pacman implements Arch Linux pacman package importer.
+package pacman
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+
+	hashrcommon "github.com/google/hashr/common"
+	"github.com/google/hashr/core/hashr"
+	"github.com/google/hashr/importers/common"
+)
+
+const (
+	// RepoName contains the repository name.
+	RepoName  = "pacman"
+	chunkSize = 1024 * 1024 * 10 // 10MB
+	// packageManager is the manager recorded in package metadata.
+	packageManager = "pacman"
+)
+
+// extensions are the extensions of pacman packages.
+var extensions = []string{".pkg.tar.zst", ".pkg.tar.xz"}
+
+// metadataFiles are files in the root of a package that describe it and aren't installed.
+var metadataFiles = map[string]bool{
+	".PKGINFO":   true,
+	".BUILDINFO": true,
+	".MTREE":     true,
+	".INSTALL":   true,
+	".CHANGELOG": true,
+}
+
+// Archive holds data related to pacman package.
+type Archive struct {
+	filename        string
+	remotePath      string
+	localPath       string
+	quickSha256hash string
+	repoPath        string
+	// pkg holds .PKGINFO metadata, it's set during preprocessing.
+	pkg         *hashrcommon.PackageInfo
+	description string
+}
+
+// extract extracts data of a pacman package and returns its .PKGINFO metadata and description.
+// Extracted files are checked against SHA256 digests from .MTREE.
+func extract(pkgPath, outputFolder string) (*hashrcommon.PackageInfo, string, error) {
+	if _, err := os.Stat(outputFolder); os.IsNotExist(err) {
+		if err2 := os.MkdirAll(outputFolder, 0755); err2 != nil {
+			return nil, "", fmt.Errorf("error while creating target directory: %v", err2)
+		}
+	}
+
+	fd, err := os.Open(pkgPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open pacman package: %v", err)
+	}
+	defer fd.Close()
+
+	rc, err := common.Decompress(pkgPath, fd)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decompress pacman package: %v", err)
+	}
+	defer rc.Close()
+
+	var pkginfo, mtree []byte
+	metadata := make(map[string]*hashrcommon.FileMetadata)
+	tarReader := tar.NewReader(rc)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("error while unpacking pacman package: %v", err)
+		}
+
+		name := common.TarEntryPath(header.Name)
+		if metadataFiles[name] {
+			switch name {
+			case ".PKGINFO":
+				pkginfo, err = ioutil.ReadAll(tarReader)
+			case ".MTREE":
+				mtree, err = ioutil.ReadAll(tarReader)
+			}
+			if err != nil {
+				return nil, "", fmt.Errorf("error while reading %s: %v", name, err)
+			}
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeSymlink:
+			metadata[name] = common.TarHeaderMetadata(header)
+
+		case tar.TypeDir:
+			continue
+
+		case tar.TypeLink:
+			unpackPath := filepath.Join(outputFolder, name)
+			if err := os.MkdirAll(filepath.Dir(unpackPath), 0755); err != nil {
+				return nil, "", fmt.Errorf("error while creating target directory: %v", err)
+			}
+			if err := os.Link(filepath.Join(outputFolder, common.TarEntryPath(header.Linkname)), unpackPath); err != nil {
+				return nil, "", fmt.Errorf("error while creating hard link: %v", err)
+			}
+			metadata[name] = common.TarHeaderMetadata(header)
+
+		case tar.TypeReg, tar.TypeRegA:
+			unpackPath := filepath.Join(outputFolder, name)
+			if err := os.MkdirAll(filepath.Dir(unpackPath), 0755); err != nil {
+				return nil, "", fmt.Errorf("error while creating target directory: %v", err)
+			}
+
+			unpackFileHandle, err := os.Create(unpackPath)
+			if err != nil {
+				return nil, "", fmt.Errorf("error while creating destination file: %v", err)
+			}
+			_, err = io.Copy(unpackFileHandle, tarReader)
+			unpackFileHandle.Close()
+			if err != nil {
+				return nil, "", fmt.Errorf("error while writing to destination file: %v", err)
+			}
+			metadata[name] = common.TarHeaderMetadata(header)
+
+		default:
+			glog.Warningf("Unknown tar entry type: %c in file %s", header.Typeflag, name)
+		}
+	}
+	if pkginfo == nil {
+		return nil, "", errors.New("pacman package doesn't contain .PKGINFO")
+	}
+
+	pkg, description := packageInfo(readPkgInfo(pkginfo))
+
+	if err := hashrcommon.WriteMetadata(common.MetadataPath(outputFolder), metadata); err != nil {
+		return nil, "", fmt.Errorf("error while saving file metadata: %v", err)
+	}
+
+	// Packages built by old versions of makepkg don't have .MTREE.
+	if mtree == nil {
+		glog.Warningf("%s doesn't contain .MTREE, extracted files are not verified", pkgPath)
+		return pkg, description, nil
+	}
+	digests, err := readMtree(mtree)
+	if err != nil {
+		return nil, "", fmt.Errorf("error while reading .MTREE: %v", err)
+	}
+
+	pkg.VerifiedFiles, pkg.MismatchedFiles, err = common.VerifyDigests(outputFolder, crypto.SHA256, digests)
+	if err != nil {
+		return nil, "", fmt.Errorf("error while verifying .MTREE digests: %v", err)
+	}
+	if len(pkg.MismatchedFiles) > 0 {
+		glog.Warningf("%d files extracted from %s don't match .MTREE: %s", len(pkg.MismatchedFiles), pkgPath, strings.Join(pkg.MismatchedFiles, ", "))
+	}
+
+	return pkg, description, nil
+}
+
+// readPkgInfo returns fields of .PKGINFO, lines holding a key and a value separated by " = ".
+// Fields that can be repeated, e.g. depend, keep the last value.
+func readPkgInfo(data []byte) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		fields[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return fields
+}
+
+// packageInfo returns package metadata and description from fields of .PKGINFO. The description
+// is the package name, version and architecture followed by its description, e.g.
+// "bash-5.2.026-2-x86_64: The GNU Bourne Again shell".
+func packageInfo(fields map[string]string) (*hashrcommon.PackageInfo, string) {
+	pkg := &hashrcommon.PackageInfo{
+		Manager:      packageManager,
+		Package:      fields["pkgname"],
+		Version:      fields["pkgver"],
+		Architecture: fields["arch"],
+		Source:       fields["pkgbase"],
+		Homepage:     fields["url"],
+		Packager:     fields["packager"],
+	}
+	if buildTime, err := strconv.ParseInt(fields["builddate"], 10, 64); err == nil {
+		pkg.BuildTime = buildTime
+	}
+
+	return pkg, fmt.Sprintf("%s-%s-%s: %s", pkg.Package, pkg.Version, pkg.Architecture, fields["pkgdesc"])
+}
+
+// readMtree returns SHA256 digests of regular files listed in gzip compressed .MTREE, keyed by
+// path. Entries are a path followed by keyword=value pairs, defaults for following entries are
+// set by /set and cleared by /unset.
+func readMtree(data []byte) (map[string]string, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	digests := make(map[string]string)
+	defaults := make(map[string]string)
+	var line string
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// Long lines are continued with a backslash.
+		line += scanner.Text()
+		if strings.HasSuffix(line, "\\") {
+			line = strings.TrimSuffix(line, "\\")
+			continue
+		}
+		fields := strings.Fields(line)
+		line = ""
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch fields[0] {
+		case "/set":
+			for _, keyword := range fields[1:] {
+				kv := strings.SplitN(keyword, "=", 2)
+				if len(kv) == 2 {
+					defaults[kv[0]] = kv[1]
+				}
+			}
+			continue
+		case "/unset":
+			for _, keyword := range fields[1:] {
+				if keyword == "all" {
+					defaults = make(map[string]string)
+				}
+				delete(defaults, keyword)
+			}
+			continue
+		}
+
+		keywords := make(map[string]string)
+		for k, v := range defaults {
+			keywords[k] = v
+		}
+		for _, keyword := range fields[1:] {
+			kv := strings.SplitN(keyword, "=", 2)
+			if len(kv) == 2 {
+				keywords[kv[0]] = kv[1]
+			}
+		}
+		if keywords["type"] != "file" || keywords["sha256digest"] == "" {
+			continue
+		}
+
+		name, err := unvis(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		if name = common.TarEntryPath(name); !metadataFiles[name] {
+			digests[name] = keywords["sha256digest"]
+		}
+	}
+
+	return digests, scanner.Err()
+}
+
+// unvis decodes a path encoded by vis(3) as used in mtree, e.g. \040 is a space.
+func unvis(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 < len(s) && s[i+1] >= '0' && s[i+1] <= '3' {
+			c, err := strconv.ParseUint(s[i+1:i+4], 8, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid escape sequence in %q: %v", s, err)
+			}
+			b.WriteByte(byte(c))
+			i += 3
+			continue
+		}
+		if i+1 < len(s) {
+			i++
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String(), nil
+}
+
+// Preprocess extracts the contents of a pacman package.
+func (a *Archive) Preprocess() (string, error) {
+	var err error
+	a.localPath, err = common.CopyToLocal(a.remotePath, a.ID())
+	if err != nil {
+		return "", fmt.Errorf("error while copying %s to local file system: %v", a.remotePath, err)
+	}
+
+	baseDir, _ := filepath.Split(a.localPath)
+	extractionDir := filepath.Join(baseDir, "extracted")
+
+	a.pkg, a.description, err = extract(a.localPath, extractionDir)
+	if err != nil {
+		return "", err
+	}
+
+	return extractionDir, nil
+}
+
+// ID returns non-unique pacman Archive ID.
+func (a *Archive) ID() string {
+	return a.filename
+}
+
+// RepoName returns repository name.
+func (a *Archive) RepoName() string {
+	return RepoName
+}
+
+// RepoPath returns repository path.
+func (a *Archive) RepoPath() string {
+	return a.repoPath
+}
+
+// LocalPath returns local path to a pacman Archive package file.
+func (a *Archive) LocalPath() string {
+	return a.localPath
+}
+
+// RemotePath returns non-local path to a pacman Archive package file.
+func (a *Archive) RemotePath() string {
+	return a.remotePath
+}
+
+// Description provides additional description for a pacman package: name, version and
+// architecture of the package followed by its description. It's empty before the file is
+// preprocessed.
+func (a *Archive) Description() string {
+	return a.description
+}
+
+// Package returns metadata from the .PKGINFO file of a pacman package, nil before it's
+// preprocessed.
+func (a *Archive) Package() *hashrcommon.PackageInfo {
+	return a.pkg
+}
+
+// QuickSHA256Hash calculates sha256 hash of pacman package file.
+func (a *Archive) QuickSHA256Hash() (string, error) {
+	// Check if the quick hash was already calculated.
+	if a.quickSha256hash != "" {
+		return a.quickSha256hash, nil
+	}
+
+	f, err := os.Open(a.remotePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	// Check if the file is smaller than 20MB, if so hash the whole file.
+	if fileInfo.Size() < int64(chunkSize*2) {
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+		a.quickSha256hash = fmt.Sprintf("%x", h.Sum(nil))
+		return a.quickSha256hash, nil
+	}
+
+	header := make([]byte, chunkSize)
+	_, err = f.Read(header)
+	if err != nil {
+		return "", err
+	}
+
+	footer := make([]byte, chunkSize)
+	_, err = f.ReadAt(footer, fileInfo.Size()-int64(chunkSize))
+	if err != nil {
+		return "", err
+	}
+
+	a.quickSha256hash = fmt.Sprintf("%x", sha256.Sum256(append(header, footer...)))
+	return a.quickSha256hash, nil
+}
+
+// NewRepo returns new instance of pacman repository.
+func NewRepo(path string) *Repo {
+	return &Repo{location: path}
+}
+
+// Repo holds data related to a pacman repository.
+type Repo struct {
+	location string
+	files    []string
+	Archives []*Archive
+}
+
+// RepoName returns repository name.
+func (r *Repo) RepoName() string {
+	return RepoName
+}
+
+// RepoPath returns repository path.
+func (r *Repo) RepoPath() string {
+	return r.location
+}
+
+// DiscoverRepo traverses the repository and looks for .pkg.tar.zst and .pkg.tar.xz packages.
+func (r *Repo) DiscoverRepo() ([]hashr.Source, error) {
+	r.files = nil
+	r.Archives = nil
+	if err := filepath.Walk(r.location, walk(&r.files)); err != nil {
+		return nil, err
+	}
+
+	for _, file := range r.files {
+		r.Archives = append(r.Archives, &Archive{filename: filepath.Base(file), remotePath: file, repoPath: r.location})
+	}
+
+	var sources []hashr.Source
+	for _, Archive := range r.Archives {
+		sources = append(sources, Archive)
+	}
+
+	return sources, nil
+}
+
+func walk(files *[]string) filepath.WalkFunc {
+	return func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			glog.Errorf("Could not open %s: %v", path, err)
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		for _, ext := range extensions {
+			if strings.HasSuffix(info.Name(), ext) {
+				*files = append(*files, path)
+			}
+		}
+
+		return nil
+	}
+}
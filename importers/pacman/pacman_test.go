@@ -0,0 +1,222 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pacman
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+
+	hashrcommon "github.com/google/hashr/common"
+)
+
+// testPackage returns a pacman package compressed with zstd or xz, based on the extension. The
+// digest of usr/bin/tampered in .MTREE doesn't match its content.
+func testPackage(t *testing.T, name, ext string) []byte {
+	t.Helper()
+	sha256hex := func(s string) string { return fmt.Sprintf("%x", sha256.Sum256([]byte(s))) }
+
+	pkginfo := fmt.Sprintf("# Generated by makepkg 6.0.2\npkgname = %s\npkgbase = %s-base\npkgver = 1:1.0-1\npkgdesc = %s test package\nurl = https://example.com/%s\nbuilddate = 1700000000\npackager = hashr <hashr@example.com>\narch = x86_64\ndepend = glibc\n", name, name, name, name)
+	files := map[string]string{
+		"usr/bin/" + name:          name,
+		"usr/bin/tampered":         "tampered",
+		"usr/share/doc/read me.md": "readme",
+	}
+
+	var mtree bytes.Buffer
+	gw := gzip.NewWriter(&mtree)
+	fmt.Fprintf(gw, "#mtree\n/set type=file uid=0 gid=0 mode=644\n./.BUILDINFO time=1700000000.0 size=10 sha256digest=%s\n./.PKGINFO time=1700000000.0 size=%d \\\n    sha256digest=%s\n", sha256hex(""), len(pkginfo), sha256hex(pkginfo))
+	fmt.Fprintf(gw, "./usr time=1700000000.0 mode=755 type=dir\n./usr/bin time=1700000000.0 mode=755 type=dir\n")
+	fmt.Fprintf(gw, "./usr/bin/%s time=1700000000.0 mode=755 size=%d md5digest=0 sha256digest=%s\n", name, len(name), sha256hex(name))
+	fmt.Fprintf(gw, "./usr/bin/tampered time=1700000000.0 size=8 sha256digest=%s\n", sha256hex("original"))
+	fmt.Fprintf(gw, "./usr/share/doc/read\\040me.md time=1700000000.0 size=6 sha256digest=%s\n", sha256hex("readme"))
+	fmt.Fprintf(gw, "./usr/bin/sh time=1700000000.0 mode=777 type=link link=%s\n", name)
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch ext {
+	case ".pkg.tar.zst":
+		w, err = zstd.NewWriter(&buf)
+	case ".pkg.tar.xz":
+		w, err = xz.NewWriter(&buf)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tw := tar.NewWriter(w)
+	write := func(header *tar.Header, content []byte) {
+		header.Size = int64(len(content))
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(&tar.Header{Typeflag: tar.TypeReg, Name: ".BUILDINFO", Mode: 0644}, nil)
+	write(&tar.Header{Typeflag: tar.TypeReg, Name: ".MTREE", Mode: 0644}, mtree.Bytes())
+	write(&tar.Header{Typeflag: tar.TypeReg, Name: ".PKGINFO", Mode: 0644}, []byte(pkginfo))
+	write(&tar.Header{Typeflag: tar.TypeDir, Name: "usr/bin/", Mode: 0755}, nil)
+	for _, file := range []string{"usr/bin/" + name, "usr/bin/tampered", "usr/share/doc/read me.md"} {
+		write(&tar.Header{Typeflag: tar.TypeReg, Name: file, Mode: 0755, Uname: "root", Gname: "root"}, []byte(files[file]))
+	}
+	write(&tar.Header{Typeflag: tar.TypeSymlink, Name: "usr/bin/sh", Linkname: name, Mode: 0777}, nil)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func testRepo(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, data := range map[string][]byte{
+		"core/bash-1.0-1-x86_64.pkg.tar.zst":     testPackage(t, "bash", ".pkg.tar.zst"),
+		"core/bash-1.0-1-x86_64.pkg.tar.zst.sig": []byte("signature"),
+		"extra/zsh-1.0-1-x86_64.pkg.tar.xz":      testPackage(t, "zsh", ".pkg.tar.xz"),
+		"extra/zsh-1.0-1-x86_64.tar.gz":          []byte("not a package"),
+	} {
+		if err := os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+func testArchives(t *testing.T, root string) []*Archive {
+	t.Helper()
+	sources, err := NewRepo(root).DiscoverRepo()
+	if err != nil {
+		t.Fatalf("unexpected error while running DiscoverRepo(): %v", err)
+	}
+
+	var archives []*Archive
+	for _, source := range sources {
+		archive, ok := source.(*Archive)
+		if !ok {
+			t.Fatalf("unexpected source type %T", source)
+		}
+		archives = append(archives, archive)
+	}
+
+	return archives
+}
+
+func TestDiscover(t *testing.T) {
+	root := testRepo(t)
+
+	want := []*Archive{
+		{
+			filename:   "bash-1.0-1-x86_64.pkg.tar.zst",
+			remotePath: filepath.Join(root, "core/bash-1.0-1-x86_64.pkg.tar.zst"),
+			repoPath:   root,
+		},
+		{
+			filename:   "zsh-1.0-1-x86_64.pkg.tar.xz",
+			remotePath: filepath.Join(root, "extra/zsh-1.0-1-x86_64.pkg.tar.xz"),
+			repoPath:   root,
+		},
+	}
+	if diff := cmp.Diff(want, testArchives(t, root), cmp.AllowUnexported(Archive{})); diff != "" {
+		t.Errorf("DiscoverRepo() unexpected diff (-want/+got):\n%s", diff)
+	}
+}
+
+func TestPreprocess(t *testing.T) {
+	for _, archive := range testArchives(t, testRepo(t)) {
+		t.Run(archive.filename, func(t *testing.T) {
+			extractionDir, err := archive.Preprocess()
+			if err != nil {
+				t.Fatalf("unexpected Preprocess() error: %v", err)
+			}
+
+			name := strings.SplitN(archive.filename, "-", 2)[0]
+			for _, file := range []string{"usr/bin/" + name, "usr/share/doc/read me.md"} {
+				if _, err := os.Stat(filepath.Join(extractionDir, file)); err != nil {
+					t.Errorf("%s was not extracted: %v", file, err)
+				}
+			}
+			for _, file := range []string{".PKGINFO", ".MTREE", ".BUILDINFO"} {
+				if _, err := os.Stat(filepath.Join(extractionDir, file)); !os.IsNotExist(err) {
+					t.Errorf("%s was extracted", file)
+				}
+			}
+
+			wantPkg := &hashrcommon.PackageInfo{
+				Manager:         "pacman",
+				Package:         name,
+				Version:         "1:1.0-1",
+				Architecture:    "x86_64",
+				Source:          name + "-base",
+				Homepage:        "https://example.com/" + name,
+				Packager:        "hashr <hashr@example.com>",
+				BuildTime:       1700000000,
+				VerifiedFiles:   2,
+				MismatchedFiles: []string{"usr/bin/tampered"},
+			}
+			if diff := cmp.Diff(wantPkg, archive.Package()); diff != "" {
+				t.Errorf("Package() unexpected diff (-want/+got):\n%s", diff)
+			}
+			if want := fmt.Sprintf("%s-1:1.0-1-x86_64: %s test package", name, name); archive.Description() != want {
+				t.Errorf("Description() = %q, want %q", archive.Description(), want)
+			}
+
+			metadata, err := hashrcommon.ReadMetadata(filepath.Join(filepath.Dir(extractionDir), hashrcommon.MetadataFile))
+			if err != nil {
+				t.Fatalf("unexpected error while reading metadata: %v", err)
+			}
+			if got := metadata["usr/bin/sh"]; got == nil || got.LinkTarget != name {
+				t.Errorf("metadata of usr/bin/sh = %+v, want link to %s", got, name)
+			}
+		})
+	}
+}
+
+func TestUnvis(t *testing.T) {
+	for in, want := range map[string]string{
+		"./usr/bin/ls":              "./usr/bin/ls",
+		"./usr/share/a\\040b":       "./usr/share/a b",
+		"./usr/share/caf\\303\\251": "./usr/share/café",
+		"./usr/share/back\\\\slash": "./usr/share/back\\slash",
+	} {
+		if got, err := unvis(in); err != nil || got != want {
+			t.Errorf("unvis(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+}
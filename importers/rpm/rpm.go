@@ -16,15 +16,20 @@
 package rpm
 
 import (
+	"crypto"
 	"crypto/sha256"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/golang/glog"
+	"golang.org/x/crypto/openpgp"
 
+	hashrcommon "github.com/google/hashr/common"
 	"github.com/google/hashr/core/hashr"
 	"github.com/google/hashr/importers/common"
 
@@ -33,10 +38,23 @@ import (
 
 const (
 	// RepoName contains the repository name.
-	RepoName  = "rpm"
-	chunkSize = 1024 * 1024 * 10 // 10MB
+	RepoName       = "rpm"
+	chunkSize      = 1024 * 1024 * 10 // 10MB
+	packageManager = "rpm"
+	// fileGhost is set in RPMTAG_FILEFLAGS of files that are not part of the payload.
+	fileGhost = 1 << 6
 )
 
+// digestAlgorithms maps values of RPMTAG_FILEDIGESTALGO to hash algorithms.
+var digestAlgorithms = map[int]crypto.Hash{
+	1:  crypto.MD5,
+	2:  crypto.SHA1,
+	8:  crypto.SHA256,
+	9:  crypto.SHA384,
+	10: crypto.SHA512,
+	11: crypto.SHA224,
+}
+
 // Archive holds data related to rpm archive.
 type Archive struct {
 	filename        string
@@ -44,32 +62,178 @@ type Archive struct {
 	localPath       string
 	quickSha256hash string
 	repoPath        string
+	keyring         openpgp.EntityList
+	// pkg holds header metadata, it's set during preprocessing.
+	pkg         *hashrcommon.PackageInfo
+	description string
 }
 
-func extractRPM(rpmPath, outputFolder string) error {
+// Extract extracts the payload of a .rpm file and returns metadata from its header. Extracted
+// files are checked against file digests from the header, the signature is verified with keys
+// from the keyring, if it's not nil.
+func Extract(rpmPath, outputFolder string, keyring openpgp.EntityList) (*hashrcommon.PackageInfo, string, error) {
 	if _, err := os.Stat(outputFolder); os.IsNotExist(err) {
 		if err2 := os.MkdirAll(outputFolder, 0755); err2 != nil {
-			return fmt.Errorf("error while creating target directory: %v", err2)
+			return nil, "", fmt.Errorf("error while creating target directory: %v", err2)
 		}
 	}
 
 	fd, err := os.Open(rpmPath)
 	if err != nil {
-		return fmt.Errorf("failed to open rpm file: %v", err)
+		return nil, "", fmt.Errorf("failed to open rpm file: %v", err)
 	}
 	defer fd.Close()
 
+	signerKeyID, trusted, sigErr := verifySignature(fd, keyring)
+	if sigErr != nil {
+		glog.Warningf("Could not verify signature of %s: %v", rpmPath, sigErr)
+	}
+	if _, err := fd.Seek(0, io.SeekStart); err != nil {
+		return nil, "", fmt.Errorf("failed to rewind rpm file: %v", err)
+	}
+
 	rpmFile, err := rpmutils.ReadRpm(fd)
 	if err != nil {
-		return fmt.Errorf("failed to parse rpm file: %v", err)
+		return nil, "", fmt.Errorf("failed to parse rpm file: %v", err)
+	}
+
+	pkg, err := packageInfo(rpmFile.Header)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read rpm header: %v", err)
+	}
+	pkg.SignerKeyID = signerKeyID
+	pkg.Trusted = trusted
+	if sigErr != nil {
+		pkg.SignatureError = sigErr.Error()
+	}
+
+	version := pkg.Version + "-" + pkg.Release
+	if pkg.Epoch != 0 {
+		version = fmt.Sprintf("%d:%s", pkg.Epoch, version)
 	}
+	summary, _ := rpmFile.Header.GetString(rpmutils.SUMMARY)
+	description := fmt.Sprintf("%s-%s.%s: %s", pkg.Package, version, pkg.Architecture, strings.TrimSpace(summary))
 
 	err = rpmFile.ExpandPayload(outputFolder)
 	if err != nil {
-		return fmt.Errorf("failed to extract rpm file: %v", err)
+		return nil, "", fmt.Errorf("failed to extract rpm file: %v", err)
+	}
+
+	files, err := rpmFile.Header.GetFiles()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read rpm file list: %v", err)
+	}
+
+	if err := hashrcommon.WriteMetadata(common.MetadataPath(outputFolder), fileMetadata(files)); err != nil {
+		return nil, "", fmt.Errorf("error while saving file metadata: %v", err)
+	}
+
+	// Packages without digest algorithm use MD5.
+	hash := crypto.MD5
+	if algos, err := rpmFile.Header.GetInts(rpmutils.FILEDIGESTALGO); err == nil && len(algos) > 0 {
+		var ok bool
+		if hash, ok = digestAlgorithms[algos[0]]; !ok {
+			glog.Warningf("Unknown file digest algorithm of %s: %d", rpmPath, algos[0])
+			return pkg, description, nil
+		}
+	}
+
+	pkg.VerifiedFiles, pkg.MismatchedFiles, err = common.VerifyDigests(outputFolder, hash, fileDigests(files))
+	if err != nil {
+		return nil, "", fmt.Errorf("error while verifying extracted files: %v", err)
+	}
+	if len(pkg.MismatchedFiles) > 0 {
+		glog.Warningf("%d files extracted from %s don't match rpm header digests: %s", len(pkg.MismatchedFiles), rpmPath, strings.Join(pkg.MismatchedFiles, ", "))
+	}
+
+	return pkg, description, nil
+}
+
+// verifySignature checks digests and OpenPGP signatures of a rpm file. It returns ID of the key
+// that signed the package, which is known even if the keyring doesn't hold the key, and whether the
+// signature was verified with a key from the keyring.
+func verifySignature(f io.ReadSeeker, keyring openpgp.EntityList) (string, bool, error) {
+	_, sigs, err := rpmutils.Verify(f, nil)
+	if err != nil {
+		return "", false, err
+	}
+	if len(sigs) == 0 {
+		return "", false, nil
+	}
+
+	keyID := fmt.Sprintf("%016x", sigs[0].KeyId)
+	if keyring == nil {
+		return keyID, false, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return keyID, false, err
+	}
+	if _, _, err := rpmutils.Verify(f, keyring); err != nil {
+		return keyID, false, err
+	}
+
+	return keyID, true, nil
+}
+
+// packageInfo returns metadata recorded in the rpm header.
+func packageInfo(header *rpmutils.RpmHeader) (*hashrcommon.PackageInfo, error) {
+	nevra, err := header.GetNEVRA()
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := &hashrcommon.PackageInfo{
+		Manager:      packageManager,
+		Package:      nevra.Name,
+		Version:      nevra.Version,
+		Release:      nevra.Release,
+		Architecture: nevra.Arch,
+	}
+	pkg.Epoch, _ = strconv.Atoi(nevra.Epoch)
+	// Optional tags are left empty if they're missing.
+	pkg.Vendor, _ = header.GetString(rpmutils.VENDOR)
+	pkg.Packager, _ = header.GetString(rpmutils.PACKAGER)
+	pkg.BuildHost, _ = header.GetString(rpmutils.BUILDHOST)
+	if buildTime, err := header.GetUint32s(rpmutils.BUILDTIME); err == nil && len(buildTime) > 0 {
+		pkg.BuildTime = int64(buildTime[0])
+	}
+
+	return pkg, nil
+}
+
+// fileDigests returns digests of regular files recorded in the rpm header, keyed by path.
+// Directories, symbolic links and other special files have no digest, ghost files are not part of
+// the payload.
+func fileDigests(files []rpmutils.FileInfo) map[string]string {
+	digests := make(map[string]string)
+	for _, file := range files {
+		if file.Digest() == "" || file.Flags()&fileGhost != 0 {
+			continue
+		}
+		digests[file.Name()] = file.Digest()
+	}
+
+	return digests
+}
+
+// fileMetadata returns metadata of files recorded in the rpm header. RPM headers only hold user
+// and group names, so UID and GID are unknown.
+func fileMetadata(files []rpmutils.FileInfo) map[string]*hashrcommon.FileMetadata {
+	metadata := make(map[string]*hashrcommon.FileMetadata)
+	for _, file := range files {
+		metadata[strings.TrimPrefix(path.Clean("/"+file.Name()), "/")] = &hashrcommon.FileMetadata{
+			Mode:       uint32(file.Mode()),
+			UID:        -1,
+			GID:        -1,
+			User:       file.UserName(),
+			Group:      file.GroupName(),
+			Mtime:      int64(file.Mtime()),
+			LinkTarget: file.Linkname(),
+		}
 	}
 
-	return nil
+	return metadata
 }
 
 // Preprocess extracts the contents of a .rpm file.
@@ -83,7 +247,8 @@ func (a *Archive) Preprocess() (string, error) {
 	baseDir, _ := filepath.Split(a.localPath)
 	extractionDir := filepath.Join(baseDir, "extracted")
 
-	if err := extractRPM(a.localPath, extractionDir); err != nil {
+	a.pkg, a.description, err = Extract(a.localPath, extractionDir, a.keyring)
+	if err != nil {
 		return "", err
 	}
 
@@ -115,9 +280,15 @@ func (a *Archive) RemotePath() string {
 	return a.remotePath
 }
 
-// Description provides additional description for a .rpm file.
+// Description provides additional description for a .rpm file: name, version and architecture of
+// the package followed by its summary. It's empty before the file is preprocessed.
 func (a *Archive) Description() string {
-	return ""
+	return a.description
+}
+
+// Package returns metadata from the header of a .rpm file, nil before it's preprocessed.
+func (a *Archive) Package() *hashrcommon.PackageInfo {
+	return a.pkg
 }
 
 // QuickSHA256Hash calculates sha256 hash of .rpm file.
@@ -164,14 +335,16 @@ func (a *Archive) QuickSHA256Hash() (string, error) {
 	return a.quickSha256hash, nil
 }
 
-// NewRepo returns new instance of rpm repository.
-func NewRepo(path string) *Repo {
-	return &Repo{location: path}
+// NewRepo returns new instance of rpm repository. Signatures of packages are verified with keys
+// from the keyring, if it's not nil.
+func NewRepo(path string, keyring openpgp.EntityList) *Repo {
+	return &Repo{location: path, keyring: keyring}
 }
 
 // Repo holds data related to a rpm repository.
 type Repo struct {
 	location string
+	keyring  openpgp.EntityList
 	files    []string
 	Archives []*Archive
 }
@@ -197,7 +370,7 @@ func (r *Repo) DiscoverRepo() ([]hashr.Source, error) {
 		_, filename := filepath.Split(file)
 
 		if strings.HasSuffix(filename, ".rpm") {
-			r.Archives = append(r.Archives, &Archive{filename: filename, remotePath: file, repoPath: r.location})
+			r.Archives = append(r.Archives, &Archive{filename: filename, remotePath: file, repoPath: r.location, keyring: r.keyring})
 		}
 	}
 
@@ -24,6 +24,11 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/openpgp"
+
+	hashrcommon "github.com/google/hashr/common"
+
+	rpmutils "github.com/sassoftware/go-rpmutils"
 )
 
 func sha256sum(path string) ([32]byte, error) {
@@ -36,7 +41,7 @@ func sha256sum(path string) ([32]byte, error) {
 }
 
 func testImages() ([]*Archive, error) {
-	rpmRepo := NewRepo("testdata")
+	rpmRepo := NewRepo("testdata", nil)
 	gotSources, err := rpmRepo.DiscoverRepo()
 	if err != nil {
 		return nil, fmt.Errorf("unexpected error while discovering repo: %v", err)
@@ -422,7 +427,7 @@ func TestImageFunctions(t *testing.T) {
 
 func TestRepoFunctions(t *testing.T) {
 	repoPath := "/tmp/rpm-repo"
-	repo := NewRepo(repoPath)
+	repo := NewRepo(repoPath, nil)
 
 	if repo.RepoName() != RepoName {
 		t.Errorf("RepoName() = %s; want = %s", repo.RepoName(), RepoName)
@@ -432,3 +437,96 @@ func TestRepoFunctions(t *testing.T) {
 		t.Errorf("RepoPath() = %s; want = %s", repo.RepoPath(), repoPath)
 	}
 }
+
+// signRPM writes a copy of a rpm file signed with a given key.
+func signRPM(t *testing.T, rpmPath, outputPath string, key *openpgp.Entity) {
+	t.Helper()
+	f, err := os.Open(rpmPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := rpmutils.SignRpmFile(f, outputPath, key.PrivateKey, nil); err != nil {
+		t.Fatalf("unexpected error while signing %s: %v", rpmPath, err)
+	}
+}
+
+func TestPreprocessPackage(t *testing.T) {
+	signer, err := openpgp.NewEntity("hashr", "", "hashr@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := openpgp.NewEntity("other", "", "other@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signerKeyID := fmt.Sprintf("%016x", signer.PrimaryKey.KeyId)
+
+	rpmPath := "testdata/20200106.00.00/ubuntu-desktop.rpm"
+	signedPath := filepath.Join(t.TempDir(), "ubuntu-desktop.rpm")
+	signRPM(t, rpmPath, signedPath, signer)
+
+	wantPkg := hashrcommon.PackageInfo{
+		Manager:       "rpm",
+		Package:       "testdata",
+		Version:       "1.0",
+		Release:       "1",
+		Architecture:  "noarch",
+		BuildHost:     "ubuntu",
+		BuildTime:     1668818256,
+		VerifiedFiles: 10,
+	}
+
+	for _, tc := range []struct {
+		name    string
+		path    string
+		keyring openpgp.EntityList
+		want    func(pkg *hashrcommon.PackageInfo)
+	}{
+		{
+			name: "unsigned",
+			path: rpmPath,
+			want: func(pkg *hashrcommon.PackageInfo) {},
+		},
+		{
+			name: "signed without keyring",
+			path: signedPath,
+			want: func(pkg *hashrcommon.PackageInfo) { pkg.SignerKeyID = signerKeyID },
+		},
+		{
+			name:    "trusted",
+			path:    signedPath,
+			keyring: openpgp.EntityList{signer},
+			want: func(pkg *hashrcommon.PackageInfo) {
+				pkg.SignerKeyID = signerKeyID
+				pkg.Trusted = true
+			},
+		},
+		{
+			name:    "untrusted",
+			path:    signedPath,
+			keyring: openpgp.EntityList{other},
+			want: func(pkg *hashrcommon.PackageInfo) {
+				pkg.SignerKeyID = signerKeyID
+				pkg.SignatureError = fmt.Sprintf("keyid %x not found", signer.PrimaryKey.KeyId)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			archive := &Archive{filename: "ubuntu-desktop.rpm", remotePath: tc.path, repoPath: "testdata", keyring: tc.keyring}
+			if _, err := archive.Preprocess(); err != nil {
+				t.Fatalf("unexpected Preprocess() error: %v", err)
+			}
+
+			want := wantPkg
+			tc.want(&want)
+			if diff := cmp.Diff(&want, archive.Package()); diff != "" {
+				t.Errorf("Package() unexpected diff (-want/+got):\n%s", diff)
+			}
+			if got, want := archive.Description(), "testdata-1.0-1.noarch: Test data"; got != want {
+				t.Errorf("Description() = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -25,6 +25,7 @@ import (
 
 	"github.com/golang/glog"
 
+	hashrcommon "github.com/google/hashr/common"
 	"github.com/google/hashr/core/hashr"
 	"github.com/google/hashr/importers/common"
 )
@@ -55,10 +56,15 @@ func (a *Archive) Preprocess() (string, error) {
 	baseDir, _ := filepath.Split(a.localPath)
 	extractionDir := filepath.Join(baseDir, "extracted")
 
-	if err := common.ExtractTarGz(a.localPath, extractionDir); err != nil {
+	metadata, err := common.ExtractTarGzMetadata(a.localPath, extractionDir)
+	if err != nil {
 		return "", err
 	}
 
+	if err := hashrcommon.WriteMetadata(common.MetadataPath(extractionDir), metadata); err != nil {
+		return "", fmt.Errorf("error while saving file metadata: %v", err)
+	}
+
 	return extractionDir, nil
 }
 
@@ -0,0 +1,392 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package yum implements YUM/DNF repository importer, which imports .rpm packages listed in
+// repodata of a local mirror or of a repository served over HTTP.
+package yum
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+
+	hashrcommon "github.com/google/hashr/common"
+	"github.com/google/hashr/core/hashr"
+	"github.com/google/hashr/importers/common"
+	"github.com/google/hashr/importers/rpm"
+)
+
+const (
+	// RepoName contains the repository name.
+	RepoName = "yum"
+	// maxMetadataSize limits the size of repodata files read into memory.
+	maxMetadataSize = 512 << 20
+)
+
+// checksumTypes maps checksum types used in repodata to hash algorithms.
+var checksumTypes = map[string]crypto.Hash{
+	"md5":    crypto.MD5,
+	"sha":    crypto.SHA1,
+	"sha1":   crypto.SHA1,
+	"sha224": crypto.SHA224,
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+// Archive holds data related to a .rpm package listed in repodata.
+type Archive struct {
+	filename   string
+	remotePath string
+	localPath  string
+	// hash and checksum are the digest of the package recorded in repodata.
+	hash        crypto.Hash
+	checksum    string
+	repoPath    string
+	description string
+	client      *http.Client
+	// pkg holds header metadata, it's set during preprocessing.
+	pkg *hashrcommon.PackageInfo
+}
+
+// Preprocess downloads a .rpm package, checks it against the checksum from repodata and extracts
+// its contents.
+func (a *Archive) Preprocess() (string, error) {
+	tempDir, err := common.LocalTempDir(a.ID())
+	if err != nil {
+		return "", fmt.Errorf("error while creating temp directory: %v", err)
+	}
+	a.localPath = filepath.Join(tempDir, a.filename)
+
+	glog.Infof("Copying %s to %s", a.remotePath, a.localPath)
+	if err := common.Download(a.client, a.remotePath, a.localPath); err != nil {
+		return "", fmt.Errorf("error while copying %s to local file system: %v", a.remotePath, err)
+	}
+
+	sum, err := common.FileDigest(a.localPath, a.hash)
+	if err != nil {
+		return "", err
+	}
+	if sum != a.checksum {
+		return "", fmt.Errorf("checksum of %s is %s, repodata lists %s", a.remotePath, sum, a.checksum)
+	}
+
+	extractionDir := filepath.Join(tempDir, "extracted")
+	a.pkg, _, err = rpm.Extract(a.localPath, extractionDir, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return extractionDir, nil
+}
+
+// ID returns non-unique rpm Archive ID.
+func (a *Archive) ID() string {
+	return a.filename
+}
+
+// RepoName returns repository name.
+func (a *Archive) RepoName() string {
+	return RepoName
+}
+
+// RepoPath returns repository path.
+func (a *Archive) RepoPath() string {
+	return a.repoPath
+}
+
+// LocalPath returns local path to a rpm Archive .rpm file.
+func (a *Archive) LocalPath() string {
+	return a.localPath
+}
+
+// RemotePath returns path or URL of a rpm Archive .rpm file in the repository.
+func (a *Archive) RemotePath() string {
+	return a.remotePath
+}
+
+// Description provides additional description for a .rpm file: name, version, release and
+// architecture of the package followed by its summary, as listed in repodata.
+func (a *Archive) Description() string {
+	return a.description
+}
+
+// Package returns metadata from the header of a .rpm file, nil before it's preprocessed.
+func (a *Archive) Package() *hashrcommon.PackageInfo {
+	return a.pkg
+}
+
+// QuickSHA256Hash returns the checksum of a .rpm file recorded in repodata, so the file doesn't
+// need to be read. Repositories using other checksum types than SHA256 get SHA256 of the type and
+// the checksum.
+func (a *Archive) QuickSHA256Hash() (string, error) {
+	if a.hash == crypto.SHA256 {
+		return a.checksum, nil
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("%v:%s", a.hash, a.checksum)))), nil
+}
+
+// NewRepo returns new instance of YUM repository. Location is either a local path or HTTP(S) URL
+// of the directory holding repodata/. Archs and names are comma-separated lists of architectures
+// and glob patterns of package names to import, all packages are imported if they're empty.
+func NewRepo(location, archs, names string) *Repo {
+	r := &Repo{location: location, archs: make(map[string]bool), client: http.DefaultClient}
+	for _, arch := range strings.Split(archs, ",") {
+		if arch = strings.TrimSpace(arch); arch != "" {
+			r.archs[arch] = true
+		}
+	}
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			r.names = append(r.names, name)
+		}
+	}
+
+	return r
+}
+
+// Repo holds data related to a YUM repository.
+type Repo struct {
+	location string
+	archs    map[string]bool
+	names    []string
+	client   *http.Client
+	Archives []*Archive
+}
+
+// RepoName returns repository name.
+func (r *Repo) RepoName() string {
+	return RepoName
+}
+
+// RepoPath returns repository path.
+func (r *Repo) RepoPath() string {
+	return r.location
+}
+
+type checksum struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type location struct {
+	Href string `xml:"href,attr"`
+	// Base is set for packages stored outside of the repository (xml:base).
+	Base string `xml:"base,attr"`
+}
+
+// repomd is the index of repodata files (repomd.xml).
+type repomd struct {
+	Data []struct {
+		Type     string   `xml:"type,attr"`
+		Checksum checksum `xml:"checksum"`
+		Location location `xml:"location"`
+	} `xml:"data"`
+}
+
+// primaryPackage is a package element of primary.xml.
+type primaryPackage struct {
+	Type    string `xml:"type,attr"`
+	Name    string `xml:"name"`
+	Arch    string `xml:"arch"`
+	Version struct {
+		Epoch string `xml:"epoch,attr"`
+		Ver   string `xml:"ver,attr"`
+		Rel   string `xml:"rel,attr"`
+	} `xml:"version"`
+	Checksum checksum `xml:"checksum"`
+	Summary  string   `xml:"summary"`
+	Location location `xml:"location"`
+}
+
+// DiscoverRepo reads primary.xml listed in repomd.xml and returns packages matching the
+// architecture and name filters.
+func (r *Repo) DiscoverRepo() ([]hashr.Source, error) {
+	data, err := r.readFile("repodata/repomd.xml")
+	if err != nil {
+		return nil, fmt.Errorf("error while reading repomd.xml: %v", err)
+	}
+	var md repomd
+	if err := xml.Unmarshal(data, &md); err != nil {
+		return nil, fmt.Errorf("error while parsing repomd.xml: %v", err)
+	}
+
+	var primary string
+	var sum checksum
+	for _, d := range md.Data {
+		if d.Type == "primary" {
+			primary, sum = common.TarEntryPath(d.Location.Href), d.Checksum
+			break
+		}
+	}
+	if primary == "" {
+		return nil, errors.New("repomd.xml doesn't list primary metadata")
+	}
+
+	r.Archives = nil
+	if err := r.readPrimary(primary, sum); err != nil {
+		return nil, fmt.Errorf("error while reading %s: %v", primary, err)
+	}
+
+	var sources []hashr.Source
+	for _, archive := range r.Archives {
+		sources = append(sources, archive)
+	}
+
+	return sources, nil
+}
+
+// readPrimary reads primary.xml, checks it against the checksum from repomd.xml and adds packages
+// it lists to the repository.
+func (r *Repo) readPrimary(name string, sum checksum) error {
+	hash, ok := checksumTypes[sum.Type]
+	if !ok {
+		return fmt.Errorf("unknown checksum type %q", sum.Type)
+	}
+	data, err := r.readFile(name)
+	if err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write(data)
+	if got := fmt.Sprintf("%x", h.Sum(nil)); got != strings.ToLower(strings.TrimSpace(sum.Value)) {
+		return fmt.Errorf("checksum is %s, repomd.xml lists %s", got, sum.Value)
+	}
+
+	rc, err := decompress(name, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	decoder := xml.NewDecoder(io.LimitReader(rc, maxMetadataSize))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "package" {
+			continue
+		}
+
+		var pkg primaryPackage
+		if err := decoder.DecodeElement(&pkg, &start); err != nil {
+			return err
+		}
+		if archive := r.archive(&pkg); archive != nil {
+			r.Archives = append(r.Archives, archive)
+		}
+	}
+}
+
+// archive returns Archive of a package listed in primary.xml, nil if it doesn't match the filters.
+func (r *Repo) archive(pkg *primaryPackage) *Archive {
+	if pkg.Type != "" && pkg.Type != "rpm" {
+		return nil
+	}
+	if len(r.archs) > 0 && !r.archs[pkg.Arch] {
+		return nil
+	}
+	if len(r.names) > 0 {
+		var match bool
+		for _, pattern := range r.names {
+			if match, _ = path.Match(pattern, pkg.Name); match {
+				break
+			}
+		}
+		if !match {
+			return nil
+		}
+	}
+
+	hash, ok := checksumTypes[pkg.Checksum.Type]
+	if !ok || pkg.Location.Href == "" {
+		glog.Warningf("Skipping %s: unknown checksum type %q or missing location", pkg.Name, pkg.Checksum.Type)
+		return nil
+	}
+
+	name := common.TarEntryPath(pkg.Location.Href)
+	remotePath := r.join(name)
+	if common.IsURL(pkg.Location.Base) {
+		remotePath = strings.TrimSuffix(pkg.Location.Base, "/") + "/" + name
+	}
+
+	version := pkg.Version.Ver + "-" + pkg.Version.Rel
+	if pkg.Version.Epoch != "" && pkg.Version.Epoch != "0" {
+		version = pkg.Version.Epoch + ":" + version
+	}
+
+	return &Archive{
+		filename:    path.Base(name),
+		remotePath:  remotePath,
+		hash:        hash,
+		checksum:    strings.ToLower(strings.TrimSpace(pkg.Checksum.Value)),
+		repoPath:    r.location,
+		description: fmt.Sprintf("%s-%s.%s: %s", pkg.Name, version, pkg.Arch, strings.TrimSpace(pkg.Summary)),
+		client:      r.client,
+	}
+}
+
+// decompress returns decompressed contents of a repodata file, based on its extension.
+func decompress(name string, r io.Reader) (io.ReadCloser, error) {
+	if path.Ext(name) == ".xml" {
+		return ioutil.NopCloser(r), nil
+	}
+
+	return common.Decompress(name, r)
+}
+
+// readFile returns contents of a file relative to the repository root.
+func (r *Repo) readFile(name string) ([]byte, error) {
+	rc, err := common.Open(r.client, r.join(name))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(rc, maxMetadataSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxMetadataSize {
+		return nil, fmt.Errorf("%s is bigger than %d bytes", name, maxMetadataSize)
+	}
+
+	return data, nil
+}
+
+// join returns path or URL of a file relative to the repository root.
+func (r *Repo) join(name string) string {
+	if common.IsURL(r.location) {
+		return strings.TrimSuffix(r.location, "/") + "/" + name
+	}
+
+	return filepath.Join(r.location, filepath.FromSlash(name))
+}
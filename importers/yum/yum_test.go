@@ -0,0 +1,279 @@
+// Copyright 2022 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package yum
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/google/hashr/core/hashr"
+)
+
+var testPackages = []string{"ubuntu-desktop", "ubuntu-laptop", "ubuntu-server"}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func compress(t *testing.T, ext string, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	switch ext {
+	case ".gz":
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	case ".zst":
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// testRepo creates a YUM repository with primary metadata compressed with a given extension.
+// Packages are x86_64, except for ubuntu-server, which is noarch.
+func testRepo(t *testing.T, ext string) (string, map[string]string) {
+	t.Helper()
+	root := t.TempDir()
+
+	digests := make(map[string]string)
+	var primary bytes.Buffer
+	fmt.Fprintf(&primary, `<?xml version="1.0" encoding="UTF-8"?>
+<metadata xmlns="http://linux.duke.edu/metadata/common" xmlns:rpm="http://linux.duke.edu/metadata/rpm" packages="%d">
+`, len(testPackages))
+	for _, name := range testPackages {
+		data, err := os.ReadFile(filepath.Join("../rpm/testdata/20200106.00.00", name+".rpm"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		arch := "x86_64"
+		if name == "ubuntu-server" {
+			arch = "noarch"
+		}
+		href := fmt.Sprintf("Packages/%s-1.0-1.%s.rpm", name, arch)
+		writeFile(t, filepath.Join(root, href), data)
+		digests[name] = fmt.Sprintf("%x", sha256.Sum256(data))
+		fmt.Fprintf(&primary, `<package type="rpm">
+  <name>%s</name>
+  <arch>%s</arch>
+  <version epoch="0" ver="1.0" rel="1"/>
+  <checksum type="sha256" pkgid="YES">%s</checksum>
+  <summary>%s test package</summary>
+  <location href="%s"/>
+</package>
+`, name, arch, digests[name], name, href)
+	}
+	primary.WriteString("</metadata>\n")
+
+	compressed := compress(t, ext, primary.Bytes())
+	primaryName := fmt.Sprintf("repodata/%x-primary.xml%s", sha256.Sum256(compressed), ext)
+	writeFile(t, filepath.Join(root, primaryName), compressed)
+	writeFile(t, filepath.Join(root, "repodata/repomd.xml"), []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo" xmlns:rpm="http://linux.duke.edu/metadata/rpm">
+  <revision>1668818256</revision>
+  <data type="primary_zck">
+    <checksum type="sha256">%x</checksum>
+    <location href="repodata/missing-primary.xml.zck"/>
+  </data>
+  <data type="primary">
+    <checksum type="sha256">%x</checksum>
+    <open-checksum type="sha256">%x</open-checksum>
+    <location href="%s"/>
+  </data>
+</repomd>
+`, sha256.Sum256(nil), sha256.Sum256(compressed), sha256.Sum256(primary.Bytes()), primaryName)))
+
+	return root, digests
+}
+
+func toArchives(t *testing.T, sources []hashr.Source) []*Archive {
+	t.Helper()
+	var archives []*Archive
+	for _, source := range sources {
+		archive, ok := source.(*Archive)
+		if !ok {
+			t.Fatalf("unexpected source type %T", source)
+		}
+		archives = append(archives, archive)
+	}
+
+	return archives
+}
+
+func TestDiscover(t *testing.T) {
+	gzRepo, digests := testRepo(t, ".gz")
+	zstRepo, _ := testRepo(t, ".zst")
+	server := httptest.NewServer(http.FileServer(http.Dir(zstRepo)))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name     string
+		location string
+		archs    string
+		names    string
+		want     []string
+	}{
+		{
+			name:     "local gzip",
+			location: gzRepo,
+			want:     testPackages,
+		},
+		{
+			name:     "http zstd",
+			location: server.URL,
+			want:     testPackages,
+		},
+		{
+			name:     "arch filter",
+			location: gzRepo,
+			archs:    "noarch, aarch64",
+			want:     []string{"ubuntu-server"},
+		},
+		{
+			name:     "name filter",
+			location: server.URL + "/",
+			names:    "*-laptop,ubuntu-s*",
+			want:     []string{"ubuntu-laptop", "ubuntu-server"},
+		},
+		{
+			name:     "no match",
+			location: gzRepo,
+			archs:    "x86_64",
+			names:    "ubuntu-server",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewRepo(tc.location, tc.archs, tc.names)
+			sources, err := r.DiscoverRepo()
+			if err != nil {
+				t.Fatalf("unexpected error while running DiscoverRepo(): %v", err)
+			}
+
+			var want []*Archive
+			for _, name := range tc.want {
+				arch := "x86_64"
+				if name == "ubuntu-server" {
+					arch = "noarch"
+				}
+				filename := fmt.Sprintf("%s-1.0-1.%s.rpm", name, arch)
+				want = append(want, &Archive{
+					filename:    filename,
+					remotePath:  r.join("Packages/" + filename),
+					hash:        crypto.SHA256,
+					checksum:    digests[name],
+					repoPath:    tc.location,
+					description: fmt.Sprintf("%s-1.0-1.%s: %s test package", name, arch, name),
+				})
+			}
+			if diff := cmp.Diff(want, toArchives(t, sources), cmp.AllowUnexported(Archive{}), cmpopts.IgnoreFields(Archive{}, "client")); diff != "" {
+				t.Errorf("DiscoverRepo() unexpected diff (-want/+got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDiscoverChecksumMismatch(t *testing.T) {
+	root, _ := testRepo(t, ".gz")
+	matches, err := filepath.Glob(filepath.Join(root, "repodata/*-primary.xml.gz"))
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("primary.xml.gz not found: %v", err)
+	}
+	writeFile(t, matches[0], compress(t, ".gz", []byte("<metadata/>")))
+
+	if sources, err := NewRepo(root, "", "").DiscoverRepo(); err == nil {
+		t.Errorf("DiscoverRepo() expected error, got %d sources", len(sources))
+	}
+}
+
+func TestPreprocess(t *testing.T) {
+	root, _ := testRepo(t, ".zst")
+	server := httptest.NewServer(http.FileServer(http.Dir(root)))
+	defer server.Close()
+
+	sources, err := NewRepo(server.URL, "", "").DiscoverRepo()
+	if err != nil {
+		t.Fatalf("unexpected error while running DiscoverRepo(): %v", err)
+	}
+	archives := toArchives(t, sources)
+
+	extractionDir, err := archives[0].Preprocess()
+	if err != nil {
+		t.Fatalf("unexpected Preprocess() error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(extractionDir, "file.01")); err != nil {
+		t.Errorf("file.01 was not extracted: %v", err)
+	}
+	if got := archives[0].Package(); got == nil || got.Package != "testdata" || got.VerifiedFiles != 10 {
+		t.Errorf("Package() = %+v, want testdata package with 10 verified files", got)
+	}
+
+	// Package doesn't match the checksum from repodata.
+	archives[1].checksum = archives[0].checksum
+	if _, err := archives[1].Preprocess(); err == nil {
+		t.Error("Preprocess() of a package not matching its checksum expected error, got nil")
+	}
+}
+
+func TestQuickHash(t *testing.T) {
+	digest := "0604d35c2c910c6cc7118354d26fdbcf8b3bae9e430b0ffe7927ea728dc57b38"
+	for _, tc := range []struct {
+		archive *Archive
+		want    string
+	}{
+		{
+			archive: &Archive{hash: crypto.SHA256, checksum: digest},
+			want:    digest,
+		},
+		{
+			archive: &Archive{hash: crypto.SHA1, checksum: "da39a3ee5e6b4b0d3255bfef95601890afd80709"},
+			want:    fmt.Sprintf("%x", sha256.Sum256([]byte("SHA-1:da39a3ee5e6b4b0d3255bfef95601890afd80709"))),
+		},
+	} {
+		if got, err := tc.archive.QuickSHA256Hash(); err != nil || got != tc.want {
+			t.Errorf("QuickSHA256Hash() = %s, %v; want %s", got, err, tc.want)
+		}
+	}
+}
@@ -18,10 +18,13 @@ package local
 import (
 	"bytes"
 	"fmt"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"syscall"
 
 	"github.com/golang/glog"
+	"github.com/google/hashr/common"
 )
 
 var execute = func(name string, args ...string) *exec.Cmd {
@@ -73,9 +76,59 @@ func (p *Processor) ImageExport(sourcePath string) (string, error) {
 		return "", fmt.Errorf("error while running image_export: %v", err)
 	}
 
+	// Importers that extract packages or archives save metadata from their headers, other
+	// directories are walked to record metadata of the extracted files. Files exported from disk
+	// images (and WIM files) don't get metadata: image_export doesn't preserve their mode, owner,
+	// mtime or symbolic links, so walking its output would record the values of the export.
+	metadataPath := filepath.Join(baseDir, common.MetadataFile)
+	if fi, err := os.Stat(sourcePath); err == nil && fi.IsDir() {
+		if _, err := os.Stat(metadataPath); os.IsNotExist(err) {
+			if err := walkMetadata(sourcePath, metadataPath); err != nil {
+				glog.Warningf("could not record metadata of files in %s: %v", sourcePath, err)
+			}
+		}
+	}
+
 	return exportDir, nil
 }
 
+// walkMetadata saves metadata of all regular files and symbolic links in root to metadataPath.
+func walkMetadata(root, metadataPath string) error {
+	metadata := make(map[string]*common.FileMetadata)
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() && fi.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		md := &common.FileMetadata{Mode: common.UnixMode(fi.Mode()), UID: -1, GID: -1, Mtime: fi.ModTime().Unix()}
+		if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+			md.UID = int(stat.Uid)
+			md.GID = int(stat.Gid)
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if md.LinkTarget, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		metadata[filepath.ToSlash(relPath)] = md
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return common.WriteMetadata(metadataPath, metadata)
+}
+
 func inDockerContainer() bool {
 	_, err := shellCommand("which", "image_export.py")
 	return err == nil
@@ -22,6 +22,8 @@ import (
 	"os/exec"
 	"path/filepath"
 	"testing"
+
+	"github.com/google/hashr/common"
 )
 
 func TestExecute(t *testing.T) {
@@ -92,3 +94,36 @@ func TestHelperProcess(t *testing.T) {
 	fmt.Fprint(os.Stdout, os.Getenv("STDOUT"))
 	os.Exit(0)
 }
+
+func TestWalkMetadata(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "bin", "ls"), []byte("ls"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("ls", filepath.Join(root, "bin", "dir")); err != nil {
+		t.Fatal(err)
+	}
+
+	metadataPath := filepath.Join(t.TempDir(), "metadata.json")
+	if err := walkMetadata(root, metadataPath); err != nil {
+		t.Fatalf("unexpected error while running walkMetadata(): %v", err)
+	}
+
+	metadata, err := common.ReadMetadata(metadataPath)
+	if err != nil {
+		t.Fatalf("unexpected error while reading metadata: %v", err)
+	}
+
+	if len(metadata) != 2 {
+		t.Fatalf("walkMetadata() recorded %d files; want = 2", len(metadata))
+	}
+	if md := metadata["bin/ls"]; md == nil || md.Mode != 0100755 || md.UID != os.Getuid() || md.LinkTarget != "" {
+		t.Errorf("walkMetadata() unexpected metadata of bin/ls: %+v", md)
+	}
+	if md := metadata["bin/dir"]; md == nil || md.Mode&0170000 != 0120000 || md.LinkTarget != "ls" {
+		t.Errorf("walkMetadata() unexpected metadata of bin/dir: %+v", md)
+	}
+}
@@ -27,4 +27,16 @@ CREATE TABLE jobs (
           export_duration INT,
           files_extracted INT,
           files_exported INT
-);
\ No newline at end of file
+);
+
+CREATE TABLE exports (
+          quick_sha256 VARCHAR(100) NOT NULL,
+          exporter VARCHAR(100) NOT NULL,
+          sha256 VARCHAR(100),
+          status VARCHAR(50),
+          error text,
+          exported_at INT,
+          export_duration INT,
+          files_exported INT,
+          PRIMARY KEY (quick_sha256, exporter)
+);
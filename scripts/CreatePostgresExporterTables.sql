@@ -30,12 +30,59 @@ CREATE TABLE sources (
         sourcePath  text,
         sourceDescription text,
         repoName text,
-        repoPath text
+        repoPath text,
+        os_family text,
+        os_id text,
+        os_name text,
+        os_version text,
+        os_codename text,
+        kernel_version text,
+        architecture text,
+        package_metadata jsonb
 );
 
+CREATE INDEX sources_os ON sources (os_id, os_version, architecture);
+
 CREATE TABLE samples_sources (
         sample_sha256 VARCHAR(100) REFERENCES samples(sha256) NOT NULL,
         source_sha256 VARCHAR(100) REFERENCES sources(sha256) NOT NULL,
         sample_paths text[],
+        sample_metadata jsonb,
+        sample_packages jsonb,
         PRIMARY KEY (sample_sha256, source_sha256)
-);
\ No newline at end of file
+);
+
+CREATE TABLE executables (
+        sha256 VARCHAR(100) PRIMARY KEY REFERENCES samples(sha256),
+        format text,
+        architectures text[],
+        bits INT,
+        build_id text,
+        soname text,
+        needed text[],
+        stripped boolean,
+        compile_timestamp BIGINT,
+        imphash text,
+        version_info jsonb,
+        uuids text[]
+);
+
+CREATE INDEX executables_build_id ON executables (build_id);
+
+CREATE INDEX executables_imphash ON executables (imphash);
+
+CREATE TABLE signatures (
+        sha256 VARCHAR(100) REFERENCES samples(sha256) NOT NULL,
+        signature_index INT NOT NULL,
+        catalog text,
+        digest_algorithm text,
+        digest text,
+        verified boolean,
+        error text,
+        signing_time BIGINT,
+        signer text,
+        certificates jsonb,
+        PRIMARY KEY (sha256, signature_index)
+);
+
+CREATE INDEX signatures_signer ON signatures (signer);
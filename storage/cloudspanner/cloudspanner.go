@@ -25,6 +25,7 @@ import (
 	"cloud.google.com/go/spanner"
 
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
 )
 
 // Storage allows to interact with cloud spanner.
@@ -106,3 +107,106 @@ func (s *Storage) FetchJobs(ctx context.Context) (map[string]string, error) {
 	}
 	return processed, nil
 }
+
+// FetchJob fetches a single processing job from cloud spanner.
+func (s *Storage) FetchJob(ctx context.Context, qHash string) (*hashr.ProcessingSource, error) {
+	row, err := s.spannerClient.Single().ReadRow(ctx, "jobs", spanner.Key{qHash},
+		[]string{"imported_at", "id", "repo", "repo_path", "location", "sha256", "status", "error"})
+	if spanner.ErrCode(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var importedAt time.Time
+	var id, repo, repoPath, location, sha256, status, jobError spanner.NullString
+	if err := row.Columns(&importedAt, &id, &repo, &repoPath, &location, &sha256, &status, &jobError); err != nil {
+		return nil, err
+	}
+
+	return &hashr.ProcessingSource{
+		ImportedAt:       importedAt.Unix(),
+		ID:               id.StringVal,
+		Repo:             repo.StringVal,
+		RepoPath:         repoPath.StringVal,
+		RemoteSourcePath: location.StringVal,
+		Sha256:           sha256.StringVal,
+		Status:           hashr.Status(status.StringVal),
+		Error:            jobError.StringVal,
+	}, nil
+}
+
+// UpdateExport updates the status of exporting a given source with a given exporter.
+func (s *Storage) UpdateExport(ctx context.Context, qHash string, e *hashr.ExportStatus) error {
+	_, err := s.spannerClient.Apply(ctx, []*spanner.Mutation{
+		spanner.InsertOrUpdate("exports",
+			[]string{
+				"quick_sha256",
+				"exporter",
+				"sha256",
+				"status",
+				"error",
+				"exported_at",
+				"export_duration",
+				"files_exported"},
+			[]interface{}{
+				qHash,
+				e.Exporter,
+				e.Sha256,
+				string(e.Status),
+				e.Error,
+				time.Unix(e.ExportedAt, 0),
+				int64(e.Duration.Seconds()),
+				int64(e.SampleCount),
+			})})
+	if err != nil {
+		return fmt.Errorf("failed to insert data %v", err)
+	}
+
+	return nil
+}
+
+// FetchExports fetches export statuses of all sources from cloud spanner.
+func (s *Storage) FetchExports(ctx context.Context) (map[string]map[string]*hashr.ExportStatus, error) {
+	exports := make(map[string]map[string]*hashr.ExportStatus)
+	iter := s.spannerClient.Single().Read(ctx, "exports", spanner.AllKeys(),
+		[]string{"quick_sha256", "exporter", "sha256", "status", "error", "exported_at", "export_duration", "files_exported"})
+	defer iter.Stop()
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var quickSha256, exporter string
+		var sha256, status, exportError spanner.NullString
+		var exportedAt spanner.NullTime
+		var duration, samples spanner.NullInt64
+		if err := row.Columns(&quickSha256, &exporter, &sha256, &status, &exportError, &exportedAt, &duration, &samples); err != nil {
+			return nil, err
+		}
+
+		e := &hashr.ExportStatus{
+			Exporter:    exporter,
+			Sha256:      sha256.StringVal,
+			Status:      hashr.Status(status.StringVal),
+			Error:       exportError.StringVal,
+			Duration:    time.Duration(duration.Int64) * time.Second,
+			SampleCount: int(samples.Int64),
+		}
+		if exportedAt.Valid {
+			e.ExportedAt = exportedAt.Time.Unix()
+		}
+
+		if _, ok := exports[quickSha256]; !ok {
+			exports[quickSha256] = make(map[string]*hashr.ExportStatus)
+		}
+		exports[quickSha256][exporter] = e
+	}
+
+	return exports, nil
+}
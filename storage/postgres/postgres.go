@@ -19,6 +19,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/google/hashr/core/hashr"
 
@@ -62,6 +63,30 @@ func NewStorage(sqlDB *sql.DB) (*Storage, error) {
 		}
 	}
 
+	// Check if the "exports" table exists.
+	exists, err = tableExists(sqlDB, "exports")
+	if err != nil {
+		return nil, fmt.Errorf("error while checking if exports table exists: %v", err)
+	}
+
+	if !exists {
+		sql := `CREATE TABLE exports (
+		quick_sha256 VARCHAR(100) NOT NULL,
+		exporter VARCHAR(100) NOT NULL,
+		sha256 VARCHAR(100),
+		status VARCHAR(50),
+		error text,
+		exported_at INT,
+		export_duration INT,
+		files_exported INT,
+		PRIMARY KEY (quick_sha256, exporter)
+	  )`
+		_, err = sqlDB.Exec(sql)
+		if err != nil {
+			return nil, fmt.Errorf("error while creating exports table: %v", err)
+		}
+	}
+
 	return &Storage{sqlDB: sqlDB}, nil
 }
 
@@ -129,6 +154,79 @@ func (s *Storage) FetchJobs(ctx context.Context) (map[string]string, error) {
 	return processed, nil
 }
 
+// FetchJob fetches a single processing job.
+func (s *Storage) FetchJob(ctx context.Context, qHash string) (*hashr.ProcessingSource, error) {
+	sqlStatement := `SELECT imported_at, id, repo, repo_path, location, sha256, status, error FROM jobs WHERE quick_sha256=$1`
+
+	var p hashr.ProcessingSource
+	var id, repo, repoPath, location, sha256, status, jobError sql.NullString
+	row := s.sqlDB.QueryRowContext(ctx, sqlStatement, qHash)
+	switch err := row.Scan(&p.ImportedAt, &id, &repo, &repoPath, &location, &sha256, &status, &jobError); err {
+	case sql.ErrNoRows:
+		return nil, nil
+	case nil:
+	default:
+		return nil, err
+	}
+
+	p.ID = id.String
+	p.Repo = repo.String
+	p.RepoPath = repoPath.String
+	p.RemoteSourcePath = location.String
+	p.Sha256 = sha256.String
+	p.Status = hashr.Status(status.String)
+	p.Error = jobError.String
+
+	return &p, nil
+}
+
+// UpdateExport updates the status of exporting a given source with a given exporter.
+func (s *Storage) UpdateExport(ctx context.Context, qHash string, e *hashr.ExportStatus) error {
+	sql := `
+INSERT INTO exports (quick_sha256, exporter, sha256, status, error, exported_at, export_duration, files_exported)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (quick_sha256, exporter) DO UPDATE SET sha256 = EXCLUDED.sha256, status = EXCLUDED.status, error = EXCLUDED.error, exported_at = EXCLUDED.exported_at, export_duration = EXCLUDED.export_duration, files_exported = EXCLUDED.files_exported`
+
+	_, err := s.sqlDB.ExecContext(ctx, sql, qHash, e.Exporter, e.Sha256, e.Status, e.Error, e.ExportedAt, int(e.Duration.Seconds()), e.SampleCount)
+	return err
+}
+
+// FetchExports fetches export statuses of all sources.
+func (s *Storage) FetchExports(ctx context.Context) (map[string]map[string]*hashr.ExportStatus, error) {
+	exports := make(map[string]map[string]*hashr.ExportStatus)
+
+	rows, err := s.sqlDB.QueryContext(ctx, "SELECT quick_sha256, exporter, sha256, status, error, exported_at, export_duration, files_exported FROM exports")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var quickSha256 string
+		var sha256, status, exportError sql.NullString
+		var exportedAt, duration, samples sql.NullInt64
+		e := &hashr.ExportStatus{}
+		if err := rows.Scan(&quickSha256, &e.Exporter, &sha256, &status, &exportError, &exportedAt, &duration, &samples); err != nil {
+			return nil, err
+		}
+		e.Sha256 = sha256.String
+		e.Status = hashr.Status(status.String)
+		e.Error = exportError.String
+		e.ExportedAt = exportedAt.Int64
+		e.Duration = time.Duration(duration.Int64) * time.Second
+		e.SampleCount = int(samples.Int64)
+
+		if _, ok := exports[quickSha256]; !ok {
+			exports[quickSha256] = make(map[string]*hashr.ExportStatus)
+		}
+		exports[quickSha256][e.Exporter] = e
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return exports, nil
+}
+
 func tableExists(db *sql.DB, tableName string) (bool, error) {
 	// Query to check if the table exists in PostgreSQL
 	query := `